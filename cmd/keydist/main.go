@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"overlord/pkg/types"
+	"overlord/proxy"
+	"overlord/proxy/keydist"
+	"overlord/version"
+)
+
+var (
+	clusterConfFile string
+	clusterName     string
+	keysFile        string
+	rdbFile         string
+	genKeys         int
+	genPrefix       string
+	servers         string
+	jsonOutput      bool
+)
+
+var usage = func() {
+	fmt.Fprintf(os.Stderr, "Usage of keydist: simulate the key distribution of cluster hash ring.\n")
+	flag.PrintDefaults()
+}
+
+func init() {
+	flag.Usage = usage
+	flag.StringVar(&clusterConfFile, "cluster", "", "conf file of backend cluster.")
+	flag.StringVar(&clusterName, "name", "", "name of cluster in conf file, required if more than one cluster.")
+	flag.StringVar(&keysFile, "keys", "", "file of keys sample, one key per line.")
+	flag.StringVar(&rdbFile, "rdb", "", "redis rdb file of keys sample.")
+	flag.IntVar(&genKeys, "gen", 0, "number of generated keys sample.")
+	flag.StringVar(&genPrefix, "prefix", "_overlord", "prefix of generated keys.")
+	flag.StringVar(&servers, "servers", "", "proposed servers separated by comma, eg: \"127.0.0.1:6379:1 node1,127.0.0.1:6380:1 node2\".")
+	flag.BoolVar(&jsonOutput, "json", false, "output report as json.")
+}
+
+func main() {
+	flag.Parse()
+	if version.ShowVersion() {
+		os.Exit(0)
+	}
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "keydist fail due %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	cc, err := loadCluster()
+	if err != nil {
+		return err
+	}
+	cur, err := proxy.NewLocator(cc, nil)
+	if err != nil {
+		return err
+	}
+	var next *proxy.Locator
+	if servers != "" {
+		if next, err = proxy.NewLocator(cc, strings.Split(servers, ",")); err != nil {
+			return err
+		}
+	}
+	s := keydist.New(cur, next)
+	switch {
+	case keysFile != "":
+		f, err := os.Open(keysFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		err = keydist.ReadKeys(f, s.Add)
+	case rdbFile != "":
+		f, err := os.Open(rdbFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		err = keydist.ReadRDB(f, s.Add)
+	case genKeys > 0:
+		err = keydist.GenerateKeys(genPrefix, genKeys, s.Add)
+	default:
+		return fmt.Errorf("one of -keys, -rdb or -gen is required")
+	}
+	if err != nil {
+		return err
+	}
+	r := s.Report()
+	if jsonOutput {
+		return json.NewEncoder(os.Stdout).Encode(r)
+	}
+	r.Print(os.Stdout)
+	return nil
+}
+
+func loadCluster() (*proxy.ClusterConfig, error) {
+	if clusterConfFile == "" {
+		return nil, fmt.Errorf("-cluster is required")
+	}
+	ccs, err := proxy.LoadClusterConf(clusterConfFile)
+	if err != nil {
+		return nil, err
+	}
+	for _, cc := range ccs {
+		if cc.Name != clusterName && (clusterName != "" || len(ccs) != 1) {
+			continue
+		}
+		if cc.CacheType == types.CacheTypeRedisCluster {
+			return nil, fmt.Errorf("cluster %q of redis_cluster is located by slots", cc.Name)
+		}
+		return cc, nil
+	}
+	return nil, fmt.Errorf("cluster %q is not found in %s", clusterName, clusterConfFile)
+}
@@ -9,25 +9,31 @@ import (
 	"os/signal"
 	"strings"
 	"syscall"
+	"time"
 
 	"overlord/pkg/log"
 	"overlord/pkg/prom"
 	"overlord/proxy"
+	"overlord/proxy/accesslog"
+	"overlord/proxy/nearcache"
 	"overlord/proxy/slowlog"
 	"overlord/version"
 )
 
 var (
-	check              bool
-	stat               string
-	metrics            bool
-	confFile           string
-	clusterConfFile    string
-	reload             bool
-	slowlogFile        string
-	slowlogSlowerThan  int
-	slowlogMaxBytes    int
-	slowlogBackupCount int
+	check                bool
+	stat                 string
+	metrics              bool
+	confFile             string
+	clusterConfFile      string
+	reload               bool
+	slowlogFile          string
+	slowlogSlowerThan    int
+	slowlogMaxBytes      int
+	slowlogBackupCount   int
+	accessLogFile        string
+	accessLogMaxBytes    int
+	accessLogBackupCount int
 )
 
 type clustersFlag []string
@@ -58,6 +64,9 @@ func init() {
 	flag.IntVar(&slowlogSlowerThan, "slower-than", 0, "slower-than is the microseconds which slowlog must slower than.")
 	flag.IntVar(&slowlogMaxBytes, "slower-max-bytes", 500000000, "slower-max-bytes is maximum size of slow log file.")
 	flag.IntVar(&slowlogBackupCount, "slower-backup-count", 7, "slower-backup-count is maximum backup count of slow log file.")
+	flag.StringVar(&accessLogFile, "accesslog", "", "accesslog is the file where access log output as json lines.")
+	flag.IntVar(&accessLogMaxBytes, "accesslog-max-bytes", 500000000, "accesslog-max-bytes is maximum size of access log file.")
+	flag.IntVar(&accessLogBackupCount, "accesslog-backup-count", 7, "accesslog-backup-count is maximum backup count of access log file.")
 }
 
 func main() {
@@ -79,7 +88,34 @@ func main() {
 	if err != nil {
 		log.Errorf("fail to init slowlog due %s", err)
 	}
+	if err = slowlog.InitSinks(c.SlowlogSinks); err != nil {
+		log.Errorf("fail to init slowlog sinks due %s", err)
+	}
+	defer slowlog.Close()
 
+	// init access log if need
+	if accessLogFile != "" {
+		sink, err := accesslog.NewFileSink(accessLogFile, accessLogMaxBytes, accessLogBackupCount)
+		if err != nil {
+			log.Errorf("fail to init access log due %s", err)
+		} else {
+			accesslog.Init(sink)
+			defer accesslog.Close()
+		}
+	}
+	nearcache.Init()
+	// NOTE: init metrics before serving, the ring membership of nodes is set on forwarder init.
+	if c.Stat != "" {
+		if c.Proxy.UseMetrics {
+			prom.Init(&prom.Config{
+				Buckets:  c.Proxy.MetricsBuckets,
+				MaxCmds:  c.Proxy.MetricsMaxCmds,
+				MaxNodes: c.Proxy.MetricsMaxNodes,
+			})
+		} else {
+			prom.On = false
+		}
+	}
 	// new proxy
 	p, err := proxy.New(c)
 	if err != nil {
@@ -92,16 +128,26 @@ func main() {
 	}
 	// pprof
 	if c.Stat != "" {
-		go http.ListenAndServe(c.Stat, nil)
-		if c.Proxy.UseMetrics {
-			prom.Init()
+		http.HandleFunc("/clients", p.ListClients)
+		http.HandleFunc("/clients/kill", p.KillClients)
+		http.HandleFunc("/nodes", p.ListNodes)
+		// NOTE: listen by proxy for the stat listener is inherited by upgrade too.
+		if l, err := proxy.Listen("tcp", c.Stat); err != nil {
+			log.Errorf("fail to listen stat addr %s due %v", c.Stat, err)
 		} else {
-			prom.On = false
+			go http.Serve(l, nil)
 		}
 	}
 	prom.VersionState(version.Str())
 	// hanlde signal
-	signalHandler()
+	signalHandler(p, shutdownTimeout(c))
+}
+
+func shutdownTimeout(c *proxy.Config) time.Duration {
+	if c.Proxy.ShutdownTimeout <= 0 {
+		return proxy.DefaultShutdownTimeout
+	}
+	return time.Duration(c.Proxy.ShutdownTimeout) * time.Millisecond
 }
 
 func parseConfig() (c *proxy.Config, ccs []*proxy.ClusterConfig) {
@@ -137,15 +183,29 @@ func parseConfig() (c *proxy.Config, ccs []*proxy.ClusterConfig) {
 	return
 }
 
-func signalHandler() {
+// signalHandler handles signals: SIGTERM and SIGINT shutdown gracefully, SIGQUIT exits at once,
+// SIGUSR2 starts a new process with the listeners inherited and then shutdown gracefully.
+func signalHandler(p *proxy.Proxy, timeout time.Duration) {
 	var ch = make(chan os.Signal, 1)
-	signal.Notify(ch, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT)
+	signal.Notify(ch, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT, syscall.SIGUSR2)
 	for {
 		log.Infof("overlord proxy version[%s] start serving", version.Str())
 		si := <-ch
 		log.Infof("overlord proxy version[%s] signal(%s) stop the process", version.Str(), si.String())
 		switch si {
-		case syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT:
+		case syscall.SIGTERM, syscall.SIGINT:
+			p.Shutdown(timeout)
+			log.Infof("overlord proxy version[%s] exited gracefully", version.Str())
+			return
+		case syscall.SIGUSR2:
+			if _, err := proxy.Upgrade(); err != nil {
+				log.Errorf("overlord proxy version[%s] fail to upgrade due %v", version.Str(), err)
+				continue
+			}
+			p.Shutdown(timeout)
+			log.Infof("overlord proxy version[%s] exited by upgrade", version.Str())
+			return
+		case syscall.SIGQUIT:
 			log.Infof("overlord proxy version[%s] exited", version.Str())
 			return
 		case syscall.SIGHUP:
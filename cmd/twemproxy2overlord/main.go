@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strconv"
+
+	"overlord/proxy"
+	"overlord/proxy/twemproxy"
+	"overlord/version"
+)
+
+var (
+	confFile   string
+	outFile    string
+	keysFile   string
+	verifyKeys int
+)
+
+var usage = func() {
+	fmt.Fprintf(os.Stderr, "Usage of twemproxy2overlord: convert twemproxy(nutcracker) yaml into overlord cluster toml.\n")
+	flag.PrintDefaults()
+}
+
+func init() {
+	flag.Usage = usage
+	flag.StringVar(&confFile, "conf", "", "conf file of twemproxy(nutcracker) pools.")
+	flag.StringVar(&outFile, "out", "", "output file of overlord cluster configs, stdout if empty.")
+	flag.IntVar(&verifyKeys, "verify", 0, "verify the number of random keys are located to the same servers as twemproxy.")
+	flag.StringVar(&keysFile, "keys", "", "verify the keys in file(one key per line) instead of random keys.")
+}
+
+func main() {
+	flag.Parse()
+	if version.ShowVersion() {
+		os.Exit(0)
+	}
+	if confFile == "" {
+		usage()
+		os.Exit(1)
+	}
+	pools, err := twemproxy.LoadPools(confFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fail to load twemproxy conf due %v\n", err)
+		os.Exit(1)
+	}
+	ccs, warns, err := pools.Convert()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fail to convert twemproxy conf due %v\n", err)
+		os.Exit(1)
+	}
+	for _, warn := range warns {
+		fmt.Fprintf(os.Stderr, "WARN: %s\n", warn)
+	}
+	if verifyKeys > 0 || keysFile != "" {
+		if !verify(pools, ccs) {
+			os.Exit(1)
+		}
+	}
+	var w io.Writer = os.Stdout
+	if outFile != "" {
+		f, err := os.Create(outFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fail to create %s due %v\n", outFile, err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		w = f
+	}
+	if err = twemproxy.EncodeClusterConfigs(w, ccs); err != nil {
+		fmt.Fprintf(os.Stderr, "fail to write cluster conf due %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func verify(pools twemproxy.Pools, ccs []*proxy.ClusterConfig) (ok bool) {
+	keys, err := loadKeys()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fail to load keys due %v\n", err)
+		return false
+	}
+	ok = true
+	for idx, name := range pools.Names() {
+		mismatches, err := twemproxy.Verify(pools[name], ccs[idx], keys)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "pool(%s) skip verifying due %v\n", name, err)
+			continue
+		}
+		if len(mismatches) == 0 {
+			fmt.Fprintf(os.Stderr, "pool(%s) all %d keys are located to the same servers\n", name, len(keys))
+			continue
+		}
+		ok = false
+		fmt.Fprintf(os.Stderr, "pool(%s) %d of %d keys are located to different servers\n", name, len(mismatches), len(keys))
+		for i, m := range mismatches {
+			if i >= 10 {
+				break
+			}
+			fmt.Fprintf(os.Stderr, "  %s\n", m)
+		}
+	}
+	return
+}
+
+func loadKeys() (keys [][]byte, err error) {
+	if keysFile == "" {
+		for i := 0; i < verifyKeys; i++ {
+			keys = append(keys, []byte("overlord:"+strconv.FormatUint(rand.Uint64(), 36)))
+		}
+		return
+	}
+	f, err := os.Open(keysFile)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		keys = append(keys, append([]byte(nil), sc.Bytes()...))
+	}
+	err = sc.Err()
+	return
+}
@@ -87,6 +87,12 @@ func (e *Etcd) Set(ctx context.Context, k, v string) (err error) {
 	return err
 }
 
+// SetTTL set key with ttl.
+func (e *Etcd) SetTTL(ctx context.Context, k, v string, ttl time.Duration) (err error) {
+	_, err = e.kapi.Set(ctx, k, v, &cli.SetOptions{TTL: ttl})
+	return
+}
+
 // Refresh refresh key ttl.
 func (e *Etcd) Refresh(ctx context.Context, k string, ttl time.Duration) (err error) {
 	_, err = e.kapi.Set(ctx, k, "", &cli.SetOptions{
@@ -0,0 +1,246 @@
+package hashkit
+
+import (
+	"math"
+	"math/rand"
+)
+
+// hash distributions
+const (
+	HashDistributionKetama     = "ketama"
+	HashDistributionModula     = "modula"
+	HashDistributionRandom     = "random"
+	HashDistributionJump       = "jump"
+	HashDistributionRendezvous = "rendezvous"
+	HashDistributionMaglev     = "maglev"
+)
+
+const (
+	// maglevTableSize is the prime size of maglev lookup table.
+	maglevTableSize = 65537
+)
+
+// IsDistribution check the distribution is supported or not.
+func IsDistribution(des string) bool {
+	switch des {
+	case HashDistributionKetama, HashDistributionModula, HashDistributionRandom,
+		HashDistributionJump, HashDistributionRendezvous, HashDistributionMaglev:
+		return true
+	}
+	return false
+}
+
+// locator locates the node by the hash value of key.
+type locator interface {
+	locate(hash uint) (string, bool)
+}
+
+func newLocator(des, compat string, nodes []string, spots []int) locator {
+	switch des {
+	case HashDistributionModula:
+		return newModula(nodes, spots)
+	case HashDistributionRandom:
+		return &random{modula: newModula(nodes, spots)}
+	case HashDistributionJump:
+		return newJump(nodes, spots)
+	case HashDistributionRendezvous:
+		return newRendezvous(nodes, spots)
+	case HashDistributionMaglev:
+		return newMaglev(nodes, spots)
+	default:
+		return newKetama(nodes, spots, compat == HashCompatTwemproxy)
+	}
+}
+
+// mix64 is the finalizer of splitmix64 which spreads the bits of hash value.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+func nodeSeed(node string) uint64 {
+	var hash uint64 = offset64
+	for i := 0; i < len(node); i++ {
+		hash ^= uint64(node[i])
+		hash *= prime64
+	}
+	return hash
+}
+
+// modula is the continuum which every node takes weight points in order,
+// key is located by hash % len(points) just as twemproxy.
+type modula struct {
+	points []string
+}
+
+func newModula(nodes []string, spots []int) *modula {
+	m := &modula{}
+	for idx, node := range nodes {
+		for i := 0; i < spots[idx]; i++ {
+			m.points = append(m.points, node)
+		}
+	}
+	return m
+}
+
+func (m *modula) locate(hash uint) (string, bool) {
+	if len(m.points) == 0 {
+		return "", false
+	}
+	return m.points[hash%uint(len(m.points))], true
+}
+
+// random locates random node of modula continuum ignoring the key.
+type random struct {
+	*modula
+}
+
+func (r *random) locate(uint) (string, bool) {
+	if len(r.points) == 0 {
+		return "", false
+	}
+	return r.points[rand.Intn(len(r.points))], true
+}
+
+// jump is the jump consistent hash by Lamping and Veach.
+// Every node takes weight buckets in order, so that appending node
+// only moves keys into the new buckets.
+type jump struct {
+	buckets []string
+}
+
+func newJump(nodes []string, spots []int) *jump {
+	j := &jump{}
+	for idx, node := range nodes {
+		for i := 0; i < spots[idx]; i++ {
+			j.buckets = append(j.buckets, node)
+		}
+	}
+	return j
+}
+
+func (j *jump) locate(hash uint) (string, bool) {
+	if len(j.buckets) == 0 {
+		return "", false
+	}
+	return j.buckets[jumpHash(mix64(uint64(hash)), len(j.buckets))], true
+}
+
+func jumpHash(key uint64, buckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
+// rendezvous is the weighted highest random weight hashing.
+type rendezvous struct {
+	nodes   []string
+	seeds   []uint64
+	weights []float64
+}
+
+func newRendezvous(nodes []string, spots []int) *rendezvous {
+	r := &rendezvous{
+		nodes:   nodes,
+		seeds:   make([]uint64, len(nodes)),
+		weights: make([]float64, len(nodes)),
+	}
+	for idx, node := range nodes {
+		r.seeds[idx] = nodeSeed(node)
+		r.weights[idx] = float64(spots[idx])
+	}
+	return r
+}
+
+func (r *rendezvous) locate(hash uint) (string, bool) {
+	var (
+		found bool
+		node  string
+		max   = math.Inf(-1)
+	)
+	for idx, seed := range r.seeds {
+		if r.weights[idx] <= 0 {
+			continue
+		}
+		// NOTE: uniform in (0, 1) by the top 53 bits
+		u := (float64(mix64(seed^uint64(hash))>>11) + 0.5) / (1 << 53)
+		score := -r.weights[idx] / math.Log(u)
+		if score > max {
+			max, node, found = score, r.nodes[idx], true
+		}
+	}
+	return node, found
+}
+
+// maglev is the lookup table of Google maglev consistent hashing.
+// Nodes take turns to fill the table by their own permutation,
+// the turns of node are proportional to its weight.
+type maglev struct {
+	table []string
+}
+
+func newMaglev(nodes []string, spots []int) *maglev {
+	m := &maglev{}
+	if len(nodes) == 0 {
+		return m
+	}
+	var (
+		offsets = make([]uint64, len(nodes))
+		skips   = make([]uint64, len(nodes))
+		nexts   = make([]uint64, len(nodes))
+		credits = make([]float64, len(nodes))
+		entries = make([]int, maglevTableSize)
+		maxW    int
+		filled  int
+	)
+	for idx, node := range nodes {
+		seed := nodeSeed(node)
+		offsets[idx] = mix64(seed) % maglevTableSize
+		skips[idx] = mix64(seed^0x9e3779b97f4a7c15)%(maglevTableSize-1) + 1
+		if spots[idx] > maxW {
+			maxW = spots[idx]
+		}
+	}
+	if maxW <= 0 {
+		return m
+	}
+	for i := range entries {
+		entries[i] = -1
+	}
+	for filled < maglevTableSize {
+		for idx := range nodes {
+			credits[idx] += float64(spots[idx]) / float64(maxW)
+			for credits[idx] >= 1 && filled < maglevTableSize {
+				credits[idx]--
+				c := (offsets[idx] + nexts[idx]*skips[idx]) % maglevTableSize
+				for entries[c] >= 0 {
+					nexts[idx]++
+					c = (offsets[idx] + nexts[idx]*skips[idx]) % maglevTableSize
+				}
+				entries[c] = idx
+				nexts[idx]++
+				filled++
+			}
+		}
+	}
+	m.table = make([]string, maglevTableSize)
+	for i, idx := range entries {
+		m.table[i] = nodes[idx]
+	}
+	return m
+}
+
+func (m *maglev) locate(hash uint) (string, bool) {
+	if len(m.table) == 0 {
+		return "", false
+	}
+	return m.table[mix64(uint64(hash))%maglevTableSize], true
+}
@@ -0,0 +1,243 @@
+package hashkit
+
+import (
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	distNodes = []string{
+		"127.0.0.1:7001",
+		"127.0.0.1:7002",
+		"127.0.0.1:7003",
+		"127.0.0.1:7004",
+		"127.0.0.1:7005",
+	}
+	distKeys = 100000
+)
+
+func distKey(i int) []byte {
+	return []byte("dist:key:" + strconv.Itoa(i))
+}
+
+func distRing(des string, nodes []string, spots []int) *HashRing {
+	ring := NewRing(des, HashMethodFnv1a64)
+	ring.Init(nodes, spots)
+	return ring
+}
+
+func distLocate(ring *HashRing) []string {
+	located := make([]string, distKeys)
+	for i := 0; i < distKeys; i++ {
+		located[i], _ = ring.GetNode(distKey(i))
+	}
+	return located
+}
+
+func distShare(located []string) map[string]float64 {
+	share := make(map[string]float64)
+	for _, node := range located {
+		share[node] += 1 / float64(len(located))
+	}
+	return share
+}
+
+func distMoved(before, after []string) float64 {
+	var moved int
+	for i := range before {
+		if before[i] != after[i] {
+			moved++
+		}
+	}
+	return float64(moved) / float64(len(before))
+}
+
+func TestDistributionUnknownFallback(t *testing.T) {
+	ring := NewRing("redis_cluster", HashMethodCRC16)
+	assert.Equal(t, HashDistributionKetama, ring.des)
+	assert.True(t, IsDistribution(HashDistributionMaglev))
+	assert.False(t, IsDistribution("redis_cluster"))
+}
+
+func TestDistributionEmpty(t *testing.T) {
+	for _, des := range []string{HashDistributionKetama, HashDistributionModula, HashDistributionRandom,
+		HashDistributionJump, HashDistributionRendezvous, HashDistributionMaglev} {
+		ring := distRing(des, []string{}, []int{})
+		_, ok := ring.GetNode([]byte("key"))
+		assert.False(t, ok, des)
+	}
+}
+
+func TestDistributionBalance(t *testing.T) {
+	spots := []int{1, 1, 1, 1, 1}
+	for _, tc := range []struct {
+		des   string
+		delta float64
+	}{
+		{HashDistributionRandom, 0.02},
+		{HashDistributionJump, 0.02},
+		{HashDistributionRendezvous, 0.02},
+		{HashDistributionMaglev, 0.02},
+		// modula depends on the low bits of hash method
+		{HashDistributionModula, 0.05},
+	} {
+		share := distShare(distLocate(distRing(tc.des, distNodes, spots)))
+		assert.Len(t, share, len(distNodes), tc.des)
+		for node, pct := range share {
+			assert.InDelta(t, 0.2, pct, tc.delta, "%s %s", tc.des, node)
+		}
+	}
+}
+
+func TestDistributionWeight(t *testing.T) {
+	spots := []int{1, 1, 2, 2, 4}
+	for _, des := range []string{HashDistributionModula, HashDistributionJump,
+		HashDistributionRendezvous, HashDistributionMaglev} {
+		share := distShare(distLocate(distRing(des, distNodes, spots)))
+		for idx, node := range distNodes {
+			assert.InDelta(t, float64(spots[idx])/10, share[node], 0.02, "%s %s", des, node)
+		}
+	}
+}
+
+func TestDistributionStable(t *testing.T) {
+	for _, des := range []string{HashDistributionJump, HashDistributionRendezvous, HashDistributionMaglev} {
+		ring1 := distRing(des, distNodes, []int{1, 1, 1, 1, 1})
+		ring2 := distRing(des, distNodes, []int{1, 1, 1, 1, 1})
+		assert.Equal(t, 0.0, distMoved(distLocate(ring1), distLocate(ring2)), des)
+	}
+}
+
+// TestDistributionMoveOnAdd checks the keys moved when a node is added,
+// the ideal fraction is 1/6 since every node owns 1/6 after adding.
+func TestDistributionMoveOnAdd(t *testing.T) {
+	var (
+		spots = []int{1, 1, 1, 1, 1}
+		ideal = 1.0 / 6
+	)
+	for _, tc := range []struct {
+		des string
+		max float64
+	}{
+		{HashDistributionKetama, ideal * 1.3},
+		{HashDistributionJump, ideal * 1.1},
+		{HashDistributionRendezvous, ideal * 1.1},
+		{HashDistributionMaglev, ideal * 1.3},
+		// modula remaps almost all keys
+		{HashDistributionModula, 1},
+	} {
+		ring := distRing(tc.des, distNodes, spots)
+		before := distLocate(ring)
+		ring.AddNode("127.0.0.1:7006", 1)
+		moved := distMoved(before, distLocate(ring))
+		t.Logf("distribution %s add node moved %.2f%% keys", tc.des, moved*100)
+		assert.True(t, moved <= tc.max, "%s moved %f", tc.des, moved)
+		assert.True(t, moved >= ideal*0.8, "%s moved %f", tc.des, moved)
+	}
+}
+
+// TestDistributionMoveOnDel checks the keys moved when a node is ejected,
+// the ideal fraction is 1/5 which is the share of the ejected node.
+func TestDistributionMoveOnDel(t *testing.T) {
+	var (
+		spots = []int{1, 1, 1, 1, 1}
+		ideal = 1.0 / 5
+	)
+	for _, tc := range []struct {
+		des  string
+		node string
+		max  float64
+	}{
+		{HashDistributionKetama, distNodes[2], ideal * 1.1},
+		{HashDistributionRendezvous, distNodes[2], ideal * 1.1},
+		{HashDistributionMaglev, distNodes[2], ideal * 1.3},
+		// jump only keeps minimal movement when the last node is removed
+		{HashDistributionJump, distNodes[4], ideal * 1.1},
+		{HashDistributionJump, distNodes[2], 1},
+		{HashDistributionModula, distNodes[2], 1},
+	} {
+		ring := distRing(tc.des, distNodes, spots)
+		before := distLocate(ring)
+		ring.DelNode(tc.node)
+		after := distLocate(ring)
+		for _, node := range after {
+			assert.NotEqual(t, tc.node, node, tc.des)
+		}
+		moved := distMoved(before, after)
+		t.Logf("distribution %s del node %s moved %.2f%% keys", tc.des, tc.node, moved*100)
+		assert.True(t, moved <= tc.max, "%s moved %f", tc.des, moved)
+		assert.True(t, moved >= ideal*0.8, "%s moved %f", tc.des, moved)
+	}
+}
+
+func TestDistributionRendezvousOnlyEjected(t *testing.T) {
+	ring := distRing(HashDistributionRendezvous, distNodes, []int{1, 1, 1, 1, 1})
+	before := distLocate(ring)
+	ring.DelNode(distNodes[1])
+	after := distLocate(ring)
+	for i := range before {
+		if before[i] != distNodes[1] {
+			assert.Equal(t, before[i], after[i])
+		}
+	}
+}
+
+func TestJumpHash(t *testing.T) {
+	// NOTE: growing buckets only moves keys into the new bucket
+	assert.Equal(t, 0, jumpHash(0, 1))
+	for buckets := 1; buckets < 100; buckets++ {
+		b := jumpHash(math.MaxUint64, buckets)
+		assert.True(t, b >= 0 && b < buckets)
+	}
+	for key := uint64(0); key < 1000; key++ {
+		b1 := jumpHash(key, 10)
+		b2 := jumpHash(key, 11)
+		assert.True(t, b1 == b2 || b2 == 10)
+	}
+}
+
+func benchmarkDistribution(b *testing.B, des string) {
+	ring := distRing(des, distNodes, []int{1, 1, 2, 2, 4})
+	keys := make([][]byte, 1024)
+	for i := range keys {
+		keys[i] = distKey(i)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ring.GetNode(keys[i&1023])
+	}
+}
+
+func BenchmarkDistributionKetama(b *testing.B) {
+	benchmarkDistribution(b, HashDistributionKetama)
+}
+
+func BenchmarkDistributionModula(b *testing.B) {
+	benchmarkDistribution(b, HashDistributionModula)
+}
+
+func BenchmarkDistributionRandom(b *testing.B) {
+	benchmarkDistribution(b, HashDistributionRandom)
+}
+
+func BenchmarkDistributionJump(b *testing.B) {
+	benchmarkDistribution(b, HashDistributionJump)
+}
+
+func BenchmarkDistributionRendezvous(b *testing.B) {
+	benchmarkDistribution(b, HashDistributionRendezvous)
+}
+
+func BenchmarkDistributionMaglev(b *testing.B) {
+	benchmarkDistribution(b, HashDistributionMaglev)
+}
+
+func BenchmarkDistributionMaglevInit(b *testing.B) {
+	spots := []int{1, 1, 2, 2, 4}
+	for i := 0; i < b.N; i++ {
+		newMaglev(distNodes, spots)
+	}
+}
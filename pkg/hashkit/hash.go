@@ -1,5 +1,7 @@
 package hashkit
 
+import "bytes"
+
 // constants defines
 const (
 	HashMethodFnv1a64 = "fnv1a_64"
@@ -17,10 +19,42 @@ const (
 	HashMethodMurmur    = "murmur"
 )
 
+// HashCompatTwemproxy places the ketama points and trims the empty hash tag just as twemproxy,
+// the keys may be located into other nodes than the default ring of overlord.
+const HashCompatTwemproxy = "twemproxy"
+
 // NewRing will create new and need init method.
+// des is the distribution and ketama is used if it's not supported.
 func NewRing(des, method string) *HashRing {
+	return newRingWithHash(des, NewHash(method))
+}
+
+// NewRingCompat new a ring just as NewRing, the ketama points are placed compatible with compat.
+func NewRingCompat(des, method, compat string) *HashRing {
+	h := NewRing(des, method)
+	h.compat = compat
+	return h
+}
+
+// HashTag return the part of key in the hash tag, or the whole key if no tag or the tag is empty
+// just as redis cluster and twemproxy.
+func HashTag(tag, key []byte) []byte {
+	if len(tag) != 2 {
+		return key
+	}
+	bidx := bytes.IndexByte(key, tag[0])
+	if bidx == -1 {
+		return key
+	}
+	eidx := bytes.IndexByte(key[bidx+1:], tag[1])
+	if eidx <= 0 {
+		return key
+	}
+	return key[bidx+1 : bidx+1+eidx]
+}
 
-	var hash func([]byte) uint
+// NewHash return the hash func of method, fnv1a_64 is used if it's not supported.
+func NewHash(method string) (hash func([]byte) uint) {
 	switch method {
 
 	case HashMethodFnv1a64: // fnv family
@@ -50,5 +84,5 @@ func NewRing(des, method string) *HashRing {
 	default:
 		hash = hashFnv1a64
 	}
-	return newRingWithHash(hash)
+	return
 }
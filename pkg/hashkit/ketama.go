@@ -3,6 +3,7 @@ package hashkit
 import (
 	"crypto/md5"
 	"fmt"
+	"math"
 	"sort"
 	"sync"
 	"sync/atomic"
@@ -13,6 +14,8 @@ import (
 const (
 	_pointsPerServer = 160
 	_maxHostLen      = 64
+	// _maxHostLenTw is the length of host[KETAMA_MAX_HOSTLEN] of twemproxy without the trailing zero.
+	_maxHostLenTw = 85
 )
 
 type nodeHash struct {
@@ -30,13 +33,26 @@ func (p *tickArray) Less(i, j int) bool { return p.nodes[i].hash < p.nodes[j].ha
 func (p *tickArray) Swap(i, j int)      { p.nodes[i], p.nodes[j] = p.nodes[j], p.nodes[i] }
 func (p *tickArray) Sort()              { sort.Sort(p) }
 
-// HashRing ketama hash ring.
+func (p *tickArray) locate(hash uint) (string, bool) {
+	if p.length == 0 {
+		return "", false
+	}
+	i := sort.Search(p.length, func(i int) bool { return p.nodes[i].hash >= hash })
+	if i == p.length {
+		i = 0
+	}
+	return p.nodes[i].node, true
+}
+
+// HashRing hash ring of nodes by the distribution.
 type HashRing struct {
-	nodes []string
-	spots []int
-	ticks atomic.Value
-	lock  sync.Mutex
-	hash  func([]byte) uint
+	nodes  []string
+	spots  []int
+	ticks  atomic.Value
+	lock   sync.Mutex
+	hash   func([]byte) uint
+	des    string
+	compat string
 }
 
 // Ketama new a hash ring with ketama consistency.
@@ -44,13 +60,17 @@ type HashRing struct {
 func Ketama() (h *HashRing) {
 	h = new(HashRing)
 	h.hash = hashFnv1a64
+	h.des = HashDistributionKetama
 	return
 }
 
 // newRingWithHash new a hash ring with a hash func.
-func newRingWithHash(hash func([]byte) uint) (h *HashRing) {
+func newRingWithHash(des string, hash func([]byte) uint) (h *HashRing) {
 	h = Ketama()
 	h.hash = hash
+	if IsDistribution(des) {
+		h.des = des
+	}
 	return
 }
 
@@ -68,6 +88,11 @@ func (h *HashRing) init(nodes []string, spots []int) {
 	}
 	h.nodes = nodes
 	h.spots = spots
+	h.ticks.Store(newLocator(h.des, h.compat, nodes, spots))
+}
+
+// newKetama new ketama points of nodes, the points are placed just as twemproxy if tw.
+func newKetama(nodes []string, spots []int, tw bool) *tickArray {
 	var (
 		ticks          []nodeHash
 		svrn           = len(nodes)
@@ -79,16 +104,27 @@ func (h *HashRing) init(nodes []string, spots []int) {
 	for _, sp := range spots {
 		totalw += sp
 	}
+	maxHostLen := _maxHostLen
+	if tw {
+		maxHostLen = _maxHostLenTw
+	}
 	for idx, node := range nodes {
-		pct := float64(spots[idx]) / float64(totalw)
-		pointerPerSvr = int((pct*_pointsPerServer/4*float64(svrn) + 0.0000000001) * 4)
+		if tw {
+			// NOTE: float32 just as twemproxy, or the points may differ when weights are not even.
+			pct := float32(spots[idx]) / float32(totalw)
+			points := float32(float32(pct*_pointsPerServer/4) * float32(svrn))
+			pointerPerSvr = int(math.Floor(float64(float32(float64(points)+0.0000000001)))) * 4
+		} else {
+			pct := float64(spots[idx]) / float64(totalw)
+			pointerPerSvr = int((pct*_pointsPerServer/4*float64(svrn) + 0.0000000001) * 4)
+		}
 		for pidx := 1; pidx <= pointerPerSvr/pointerPerHash; pidx++ {
 			host := fmt.Sprintf("%s-%d", node, pidx-1)
-			if len(host) > _maxHostLen {
-				host = host[:_maxHostLen]
+			if len(host) > maxHostLen {
+				host = host[:maxHostLen]
 			}
 			for x := 0; x < pointerPerHash; x++ {
-				value := h.ketamaHash(host, len(host), x)
+				value := ketamaHash(host, len(host), x)
 				n := &nodeHash{
 					node: node,
 					hash: value,
@@ -100,10 +136,10 @@ func (h *HashRing) init(nodes []string, spots []int) {
 	}
 	ts := &tickArray{nodes: ticks, length: len(ticks)}
 	ts.Sort()
-	h.ticks.Store(ts)
+	return ts
 }
 
-func (h *HashRing) ketamaHash(key string, kl, alignment int) (v uint) {
+func ketamaHash(key string, kl, alignment int) (v uint) {
 	hs := md5.New()
 	_, _ = hs.Write([]byte(key))
 	bs := hs.Sum(nil)
@@ -158,7 +194,7 @@ func (h *HashRing) DelNode(n string) {
 			tmpSpot = append(tmpSpot, h.spots[i])
 		} else {
 			del = true
-			log.Info("ketama del node ", n)
+			log.Infof("%s del node %s", h.des, n)
 		}
 	}
 	if del {
@@ -168,15 +204,9 @@ func (h *HashRing) DelNode(n string) {
 
 // GetNode returns result node by given key.
 func (h *HashRing) GetNode(key []byte) (string, bool) {
-	ts, ok := h.ticks.Load().(*tickArray)
-	if !ok || ts.length == 0 {
+	l, ok := h.ticks.Load().(locator)
+	if !ok {
 		return "", false
 	}
-	value := h.hash(key)
-
-	i := sort.Search(ts.length, func(i int) bool { return ts.nodes[i].hash >= value })
-	if i == ts.length {
-		i = 0
-	}
-	return ts.nodes[i].node, true
+	return l.locate(h.hash(key))
 }
@@ -2,7 +2,9 @@ package hashkit
 
 import (
 	"bytes"
+	"fmt"
 	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -80,3 +82,57 @@ func BenchmarkHash(b *testing.B) {
 		ring.GetNode([]byte(s))
 	}
 }
+
+// NOTE: the vectors are captured from the ring before hash_compat was added,
+// the default ring must never move the keys of the existing clusters.
+var ketamaGolden = map[string][][2]string{
+	"fnv1a_64": {{"key:0", "01"}, {"key:7919", "04"}, {"key:15838", "15"}, {"key:23757", "07"}, {"key:31676", "15"}, {"key:39595", "02"}, {"key:47514", "00"}, {"key:55433", "01"}, {"key:63352", "01"}, {"key:71271", "14"}, {"key:79190", "12"}, {"key:87109", "13"}, {"key:95028", "02"}, {"key:102947", "13"}, {"key:110866", "03"}, {"key:118785", "05"}},
+	"md5":      {{"key:0", "06"}, {"key:7919", "18"}, {"key:15838", "10"}, {"key:23757", "07"}, {"key:31676", "18"}, {"key:39595", "19"}, {"key:47514", "01"}, {"key:55433", "20"}, {"key:63352", "03"}, {"key:71271", "03"}, {"key:79190", "13"}, {"key:87109", "24"}, {"key:95028", "09"}, {"key:102947", "00"}, {"key:110866", "02"}, {"key:118785", "00"}},
+	"murmur":   {{"key:0", "16"}, {"key:7919", "19"}, {"key:15838", "16"}, {"key:23757", "03"}, {"key:31676", "01"}, {"key:39595", "15"}, {"key:47514", "18"}, {"key:55433", "12"}, {"key:63352", "01"}, {"key:71271", "24"}, {"key:79190", "02"}, {"key:87109", "21"}, {"key:95028", "22"}, {"key:102947", "23"}, {"key:110866", "23"}, {"key:118785", "16"}},
+}
+
+func goldenNodes() ([]string, []int) {
+	var (
+		nodes []string
+		spots []int
+	)
+	for i := 0; i < 25; i++ {
+		nodes = append(nodes, fmt.Sprintf("redis-%02d-%s:6379", i, strings.Repeat("x", 60)))
+		spots = append(spots, 1+i%3)
+	}
+	return nodes, spots
+}
+
+func TestKetamaGolden(t *testing.T) {
+	nodes, spots := goldenNodes()
+	for method, vectors := range ketamaGolden {
+		r := NewRing("ketama", method)
+		r.Init(nodes, spots)
+		for _, v := range vectors {
+			n, ok := r.GetNode([]byte(v[0]))
+			if !ok || n[6:8] != v[1] {
+				t.Errorf("%s: key %s expect node %s but got %s", method, v[0], v[1], n)
+			}
+		}
+	}
+}
+
+func TestKetamaCompatTwemproxy(t *testing.T) {
+	nodes, spots := goldenNodes()
+	r := NewRingCompat("ketama", "fnv1a_64", HashCompatTwemproxy)
+	r.Init(nodes, spots)
+	d := NewRing("ketama", "fnv1a_64")
+	d.Init(nodes, spots)
+	moved := 0
+	for i := 0; i < 10000; i++ {
+		key := []byte(fmt.Sprintf("key:%d", i))
+		n1, _ := r.GetNode(key)
+		n2, _ := d.GetNode(key)
+		if n1 != n2 {
+			moved++
+		}
+	}
+	if moved == 0 {
+		t.Error("the twemproxy compatible ring must differ from the default ring with long host names")
+	}
+}
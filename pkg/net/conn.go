@@ -3,6 +3,7 @@ package net
 import (
 	"errors"
 	"net"
+	"sync/atomic"
 	"time"
 )
 
@@ -21,7 +22,10 @@ type Conn struct {
 	readTimeout  time.Duration
 	writeTimeout time.Duration
 
-	closed bool
+	stat func(read, write int)
+
+	// closed is set atomically as the conn may be closed while reading.
+	closed int32
 }
 
 // DialWithTimeout will create new auto timeout Conn
@@ -39,11 +43,23 @@ func NewConn(sock net.Conn, readTimeout, writeTimeout time.Duration) (c *Conn) {
 
 // Dup will re-dial to the given addr by using timeouts stored in itself.
 func (c *Conn) Dup() *Conn {
-	return DialWithTimeout(c.addr, c.dialTimeout, c.readTimeout, c.writeTimeout)
+	nc := DialWithTimeout(c.addr, c.dialTimeout, c.readTimeout, c.writeTimeout)
+	nc.stat = c.stat
+	return nc
+}
+
+// SetReadTimeout sets the timeout of each read, zero means no timeout.
+func (c *Conn) SetReadTimeout(timeout time.Duration) {
+	c.readTimeout = timeout
+}
+
+// WithStat sets the func called with bytes count of each read and write.
+func (c *Conn) WithStat(stat func(read, write int)) {
+	c.stat = stat
 }
 
 func (c *Conn) Read(b []byte) (n int, err error) {
-	if c.closed || c.Conn == nil {
+	if c.isClosed() || c.Conn == nil {
 		return 0, ErrConnClosed
 	}
 	if timeout := c.readTimeout; timeout != 0 {
@@ -52,11 +68,14 @@ func (c *Conn) Read(b []byte) (n int, err error) {
 		}
 	}
 	n, err = c.Conn.Read(b)
+	if c.stat != nil && n > 0 {
+		c.stat(n, 0)
+	}
 	return
 }
 
 func (c *Conn) Write(b []byte) (n int, err error) {
-	if c.closed || c.Conn == nil {
+	if c.isClosed() || c.Conn == nil {
 		return 0, ErrConnClosed
 	}
 	if timeout := c.writeTimeout; timeout != 0 {
@@ -65,23 +84,32 @@ func (c *Conn) Write(b []byte) (n int, err error) {
 		}
 	}
 	n, err = c.Conn.Write(b)
+	if c.stat != nil && n > 0 {
+		c.stat(0, n)
+	}
 	return
 }
 
 // Close close conn.
 func (c *Conn) Close() error {
-	if c.Conn != nil && !c.closed {
-		c.closed = true
+	if c.Conn != nil && atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
 		return c.Conn.Close()
 	}
 	return nil
 }
 
+func (c *Conn) isClosed() bool {
+	return atomic.LoadInt32(&c.closed) == 1
+}
+
 // Writev impl the net.buffersWriter to support writev
 func (c *Conn) Writev(buf *net.Buffers) (int64, error) {
-	if c.closed || c.Conn == nil {
+	if c.isClosed() || c.Conn == nil {
 		return 0, ErrConnClosed
 	}
 	n, err := buf.WriteTo(c.Conn)
+	if c.stat != nil && n > 0 {
+		c.stat(0, int(n))
+	}
 	return n, err
 }
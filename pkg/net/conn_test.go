@@ -128,3 +128,20 @@ func TestConnNoConn(t *testing.T) {
 	assert.Equal(t, int64(0), n64)
 	assert.Equal(t, ErrConnClosed, err)
 }
+
+func TestConnWithStat(t *testing.T) {
+	data := []byte("Bilibili 干杯 - ( ゜- ゜)つロ")
+	conn := NewConn(mockconn.CreateConn(data, 1), time.Second, time.Second)
+	var read, write int
+	conn.WithStat(func(r, w int) {
+		read += r
+		write += w
+	})
+	recv := make([]byte, len(data))
+	_, err := conn.Read(recv)
+	assert.NoError(t, err)
+	_, err = conn.Write(data[:4])
+	assert.NoError(t, err)
+	assert.Equal(t, len(data), read)
+	assert.Equal(t, 4, write)
+}
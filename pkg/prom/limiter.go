@@ -0,0 +1,55 @@
+package prom
+
+import "sync"
+
+// limiter limits the cardinality of label values, the values beyond max are folded into otherLabel.
+type limiter struct {
+	max  int
+	lock sync.RWMutex
+	seen map[string]struct{}
+}
+
+func newLimiter(max int) *limiter {
+	return &limiter{max: max, seen: make(map[string]struct{})}
+}
+
+func (l *limiter) label(v string) string {
+	if l == nil {
+		return v
+	}
+	l.lock.RLock()
+	_, ok := l.seen[v]
+	l.lock.RUnlock()
+	if ok {
+		return v
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if _, ok = l.seen[v]; ok {
+		return v
+	}
+	if len(l.seen) >= l.max {
+		return otherLabel
+	}
+	l.seen[v] = struct{}{}
+	return v
+}
+
+// knownCmds is the set of the command names which are allowed as the cmd label.
+var knownCmds = make(map[string]struct{})
+
+// RegisterCmds registers the command names of protocol as the cmd label values,
+// it must be called in init of the protocol package.
+func RegisterCmds(names ...string) {
+	for _, name := range names {
+		knownCmds[name] = struct{}{}
+	}
+}
+
+// cmdLabel folds the unknown command into otherLabel before the limiter sees it.
+func cmdLabel(cmd string) string {
+	if _, ok := knownCmds[cmd]; !ok {
+		return otherLabel
+	}
+	return cmdLimiter.label(cmd)
+}
@@ -0,0 +1,33 @@
+package prom
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiterFold(t *testing.T) {
+	l := newLimiter(2)
+	assert.Equal(t, "get", l.label("get"))
+	assert.Equal(t, "set", l.label("set"))
+	assert.Equal(t, otherLabel, l.label("del"))
+	assert.Equal(t, "get", l.label("get"))
+	assert.Equal(t, otherLabel, l.label("mget"))
+}
+
+func TestLimiterNil(t *testing.T) {
+	var l *limiter
+	for i := 0; i < 10; i++ {
+		v := fmt.Sprintf("node%d", i)
+		assert.Equal(t, v, l.label(v))
+	}
+}
+
+func TestCmdLabelUnknown(t *testing.T) {
+	RegisterCmds("GET", "get")
+	assert.Equal(t, "GET", cmdLabel("GET"))
+	assert.Equal(t, "get", cmdLabel("get"))
+	assert.Equal(t, otherLabel, cmdLabel("GETX"))
+	assert.Equal(t, otherLabel, cmdLabel("\x00\xff"))
+}
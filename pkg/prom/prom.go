@@ -14,26 +14,117 @@ const (
 
 	statProxyTimer   = "overlord_proxy_timer"
 	statHandlerTimer = "overlord_proxy_handler_timer"
+
+	statStageTimer = "overlord_proxy_stage_timer"
+
+	statCoalesced      = "overlord_proxy_coalesced"
+	statNearCache      = "overlord_proxy_nearcache"
+	statNearCacheBytes = "overlord_proxy_nearcache_bytes"
+
+	statNodeBytes = "overlord_proxy_node_bytes"
+	statHits      = "overlord_proxy_hits"
+	statPipeDepth = "overlord_proxy_pipe_depth"
+	statOverflow  = "overlord_proxy_pipe_overflow"
+	statRingNode  = "overlord_proxy_ring_node"
+	statRedirect  = "overlord_proxy_redirect"
+
+	statNodeState       = "overlord_proxy_node_state"
+	statNodeStateChange = "overlord_proxy_node_state_change"
+
+	statSlowlogSink = "overlord_proxy_slowlog_sink"
+)
+
+// stages of message.
+const (
+	// StageInput is the duration of message waiting in the input chan of node pipe.
+	StageInput = "input"
+	// StageWaitWrite is the duration from message decoded to written to node.
+	StageWaitWrite = "wait_write"
+	// StageRemote is the duration from message written to reply read.
+	StageRemote = "remote"
+	// StagePipe is the duration from message forwarded to reply encoded.
+	StagePipe = "pipe"
+)
+
+const (
+	defaultMaxCmds  = 256
+	defaultMaxNodes = 1024
+	// otherLabel is the label value which the values beyond the cardinality limit are folded into.
+	otherLabel = "other"
 )
 
+// DefaultBuckets is the default buckets of timers in microsecond.
+var DefaultBuckets = []float64{250, 500, 1000, 2000, 4000, 10000, 50000, 200000}
+
+// Config is the config of metrics.
+type Config struct {
+	// Buckets of timers in microsecond.
+	Buckets []float64
+	// MaxCmds is the limit of distinct cmd label values, the others are folded into "other".
+	MaxCmds int
+	// MaxNodes is the limit of distinct node label values, the others are folded into "other".
+	MaxNodes int
+}
+
 var (
 	conns        *prometheus.GaugeVec
 	versions     *prometheus.GaugeVec
 	gerr         *prometheus.GaugeVec
 	proxyTimer   *prometheus.HistogramVec
 	handlerTimer *prometheus.HistogramVec
+	coalesced    *prometheus.CounterVec
+	nearCache    *prometheus.CounterVec
+	nearBytes    *prometheus.GaugeVec
+	stageTimer   *prometheus.HistogramVec
+	nodeBytes    *prometheus.CounterVec
+	hits         *prometheus.CounterVec
+	pipeDepth    *prometheus.GaugeVec
+	overflow     *prometheus.CounterVec
+	ringNode     *prometheus.GaugeVec
+	slowlogSink  *prometheus.CounterVec
+	nodeState    *prometheus.GaugeVec
+	nodeChange   *prometheus.CounterVec
+	redirect     *prometheus.CounterVec
+
+	cmdLimiter  *limiter
+	nodeLimiter *limiter
 
-	clusterLabels        = []string{"cluster"}
-	clusterNodeErrLabels = []string{"cluster", "node", "cmd", "error"}
-	clusterCmdLabels     = []string{"cluster", "cmd"}
-	clusterNodeCmdLabels = []string{"cluster", "node", "cmd"}
-	versionLabels        = []string{"version"}
+	clusterLabels             = []string{"cluster"}
+	clusterNodeErrLabels      = []string{"cluster", "node", "cmd", "error"}
+	clusterCmdLabels          = []string{"cluster", "cmd"}
+	clusterNodeCmdLabels      = []string{"cluster", "node", "cmd"}
+	clusterNodeLabels         = []string{"cluster", "node"}
+	clusterResultLabels       = []string{"cluster", "result"}
+	clusterCmdResultLabels    = []string{"cluster", "cmd", "result"}
+	clusterNodeCmdStageLabels = []string{"cluster", "node", "cmd", "stage"}
+	clusterNodeDirLabels      = []string{"cluster", "node", "direction"}
+	clusterNodeStateLabels    = []string{"cluster", "node", "state"}
+	clusterNodeResultLabels   = []string{"cluster", "node", "result"}
+	clusterNodeTypeLabels     = []string{"cluster", "node", "type"}
+	versionLabels             = []string{"version"}
+	sinkResultLabels          = []string{"sink", "result"}
 	// On Prom switch
 	On = true
 )
 
-// Init init prometheus.
-func Init() {
+// Init init prometheus, default config is used if c is nil.
+func Init(c *Config) {
+	if c == nil {
+		c = &Config{}
+	}
+	buckets := c.Buckets
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	maxCmds, maxNodes := c.MaxCmds, c.MaxNodes
+	if maxCmds <= 0 {
+		maxCmds = defaultMaxCmds
+	}
+	if maxNodes <= 0 {
+		maxNodes = defaultMaxNodes
+	}
+	cmdLimiter = newLimiter(maxCmds)
+	nodeLimiter = newLimiter(maxNodes)
 	conns = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: statConns,
@@ -56,7 +147,7 @@ func Init() {
 		prometheus.HistogramOpts{
 			Name:    statProxyTimer,
 			Help:    statProxyTimer,
-			Buckets: []float64{1000, 2000, 4000, 10000},
+			Buckets: buckets,
 		}, clusterCmdLabels)
 
 	prometheus.MustRegister(proxyTimer)
@@ -64,9 +155,88 @@ func Init() {
 		prometheus.HistogramOpts{
 			Name:    statHandlerTimer,
 			Help:    statHandlerTimer,
-			Buckets: []float64{1000, 2000, 4000, 10000},
+			Buckets: buckets,
 		}, clusterNodeCmdLabels)
 	prometheus.MustRegister(handlerTimer)
+	coalesced = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: statCoalesced,
+			Help: statCoalesced,
+		}, clusterNodeLabels)
+	prometheus.MustRegister(coalesced)
+	nearCache = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: statNearCache,
+			Help: statNearCache,
+		}, clusterResultLabels)
+	prometheus.MustRegister(nearCache)
+	nearBytes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: statNearCacheBytes,
+			Help: statNearCacheBytes,
+		}, clusterLabels)
+	prometheus.MustRegister(nearBytes)
+	stageTimer = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    statStageTimer,
+			Help:    statStageTimer,
+			Buckets: buckets,
+		}, clusterNodeCmdStageLabels)
+	prometheus.MustRegister(stageTimer)
+	nodeBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: statNodeBytes,
+			Help: statNodeBytes,
+		}, clusterNodeDirLabels)
+	prometheus.MustRegister(nodeBytes)
+	hits = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: statHits,
+			Help: statHits,
+		}, clusterCmdResultLabels)
+	prometheus.MustRegister(hits)
+	pipeDepth = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: statPipeDepth,
+			Help: statPipeDepth,
+		}, clusterNodeLabels)
+	prometheus.MustRegister(pipeDepth)
+	overflow = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: statOverflow,
+			Help: statOverflow,
+		}, clusterNodeResultLabels)
+	prometheus.MustRegister(overflow)
+	ringNode = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: statRingNode,
+			Help: statRingNode,
+		}, clusterNodeLabels)
+	prometheus.MustRegister(ringNode)
+	slowlogSink = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: statSlowlogSink,
+			Help: statSlowlogSink,
+		}, sinkResultLabels)
+	prometheus.MustRegister(slowlogSink)
+	nodeState = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: statNodeState,
+			Help: statNodeState,
+		}, clusterNodeLabels)
+	prometheus.MustRegister(nodeState)
+	nodeChange = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: statNodeStateChange,
+			Help: statNodeStateChange,
+		}, clusterNodeStateLabels)
+	prometheus.MustRegister(nodeChange)
+	redirect = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: statRedirect,
+			Help: statRedirect,
+		}, clusterNodeTypeLabels)
+	prometheus.MustRegister(redirect)
 	// metrics
 	metrics()
 }
@@ -78,22 +248,28 @@ func metrics() {
 	})
 }
 
-// ProxyTime log timing information (in milliseconds).
-func ProxyTime(cluster, node string, ts int64) {
+// ProxyTime log timing information (in microseconds).
+func ProxyTime(cluster, cmd string, ts int64) {
 	if proxyTimer == nil {
 		return
 	}
-	node = ""
-	proxyTimer.WithLabelValues(cluster, node).Observe(float64(ts))
+	proxyTimer.WithLabelValues(cluster, cmdLabel(cmd)).Observe(float64(ts))
 }
 
-// HandleTime log timing information (in milliseconds).
+// HandleTime log timing information (in microseconds).
 func HandleTime(cluster, node, cmd string, ts int64) {
 	if handlerTimer == nil {
 		return
 	}
-	cmd = ""
-	handlerTimer.WithLabelValues(cluster, node, cmd).Observe(float64(ts))
+	handlerTimer.WithLabelValues(cluster, nodeLimiter.label(node), cmdLabel(cmd)).Observe(float64(ts))
+}
+
+// StageTime log timing information of the stage of message (in microseconds).
+func StageTime(cluster, node, cmd, stage string, ts int64) {
+	if stageTimer == nil {
+		return
+	}
+	stageTimer.WithLabelValues(cluster, nodeLimiter.label(node), cmdLabel(cmd), stage).Observe(float64(ts))
 }
 
 // ErrIncr increments one stat error counter.
@@ -101,8 +277,7 @@ func ErrIncr(cluster, node, cmd, err string) {
 	if gerr == nil {
 		return
 	}
-	cmd = ""
-	gerr.WithLabelValues(cluster, node, cmd, err).Inc()
+	gerr.WithLabelValues(cluster, nodeLimiter.label(node), cmdLabel(cmd), err).Inc()
 }
 
 // VersionState set current versioin state.
@@ -128,3 +303,111 @@ func ConnDecr(cluster string) {
 	}
 	conns.WithLabelValues(cluster).Dec()
 }
+
+// CoalesceIncr increments the count of requests coalesced into in-flight ones.
+func CoalesceIncr(cluster, node string) {
+	if coalesced == nil {
+		return
+	}
+	coalesced.WithLabelValues(cluster, node).Inc()
+}
+
+// NearCacheIncr increments near cache counter by result of hit, miss or evict.
+func NearCacheIncr(cluster, result string) {
+	if nearCache == nil {
+		return
+	}
+	nearCache.WithLabelValues(cluster, result).Inc()
+}
+
+// NearCacheBytes set current memory usage of near cache.
+func NearCacheBytes(cluster string, bytes int64) {
+	if nearBytes == nil {
+		return
+	}
+	nearBytes.WithLabelValues(cluster).Set(float64(bytes))
+}
+
+// NodeBytes adds the bytes of requests written into and replies read from the node.
+func NodeBytes(cluster, node string, read, write int) {
+	if nodeBytes == nil {
+		return
+	}
+	node = nodeLimiter.label(node)
+	if read > 0 {
+		nodeBytes.WithLabelValues(cluster, node, "reply").Add(float64(read))
+	}
+	if write > 0 {
+		nodeBytes.WithLabelValues(cluster, node, "request").Add(float64(write))
+	}
+}
+
+// HitIncr adds the hit and miss count of keys by get-style command.
+func HitIncr(cluster, cmd string, hit, miss int) {
+	if hits == nil {
+		return
+	}
+	cmd = cmdLabel(cmd)
+	if hit > 0 {
+		hits.WithLabelValues(cluster, cmd, "hit").Add(float64(hit))
+	}
+	if miss > 0 {
+		hits.WithLabelValues(cluster, cmd, "miss").Add(float64(miss))
+	}
+}
+
+// PipeDepth set the count of messages waiting in the pipe of node.
+func PipeDepth(cluster, node string, depth int) {
+	if pipeDepth == nil {
+		return
+	}
+	pipeDepth.WithLabelValues(cluster, nodeLimiter.label(node)).Set(float64(depth))
+}
+
+// PipeOverflowIncr increments the count of messages pushed when the pipe of node is full,
+// by result of the overflow policy: spill, block or shed.
+func PipeOverflowIncr(cluster, node, result string) {
+	if overflow == nil {
+		return
+	}
+	overflow.WithLabelValues(cluster, nodeLimiter.label(node), result).Inc()
+}
+
+// RingNode set whether the node is in the hash ring or slots of cluster.
+func RingNode(cluster, node string, in bool) {
+	if ringNode == nil {
+		return
+	}
+	var v float64
+	if in {
+		v = 1
+	}
+	ringNode.WithLabelValues(cluster, nodeLimiter.label(node)).Set(v)
+}
+
+// SlowlogSinkIncr adds the count of slowlog entries by result of sent or dropped,
+// or the count of failed writes by result of error.
+func SlowlogSinkIncr(sink, result string, n int) {
+	if slowlogSink == nil {
+		return
+	}
+	slowlogSink.WithLabelValues(sink, result).Add(float64(n))
+}
+
+// NodeState set the health state code of node and increments the count of changes into state.
+func NodeState(cluster, node, state string, code int) {
+	if nodeState == nil {
+		return
+	}
+	node = nodeLimiter.label(node)
+	nodeState.WithLabelValues(cluster, node).Set(float64(code))
+	nodeChange.WithLabelValues(cluster, node, state).Inc()
+}
+
+// RedirectIncr increments the count of requests redirected from node by type: moved, ask or max.
+func RedirectIncr(cluster, node, typ string) {
+	if redirect == nil {
+		return
+	}
+	redirect.WithLabelValues(cluster, nodeLimiter.label(node), typ).Inc()
+}
@@ -0,0 +1,224 @@
+// Package accesslog records the sampled client requests of clusters into a sink asynchronously.
+package accesslog
+
+import (
+	"encoding/hex"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"overlord/pkg/log"
+	"overlord/proxy/proto"
+)
+
+// key modes of entry.
+const (
+	KeyCollapse = "collapse"
+	KeyHash     = "hash"
+	KeyNone     = "none"
+)
+
+// status of entry.
+const (
+	StatusOK    = "ok"
+	StatusError = "error"
+)
+
+// Entry is the access record of one client request.
+type Entry struct {
+	Time       time.Time `json:"time"`
+	Cluster    string    `json:"cluster"`
+	Client     string    `json:"client"`
+	ConnID     uint64    `json:"conn_id"`
+	Cmd        string    `json:"cmd"`
+	Key        string    `json:"key,omitempty"`
+	Keys       int       `json:"keys"`
+	Node       string    `json:"node,omitempty"` // NOTE: nodes of batch are joined by comma
+	Status     string    `json:"status"`
+	Err        string    `json:"error,omitempty"`
+	ReqBytes   int       `json:"req_bytes"`
+	ReplyBytes int       `json:"reply_bytes"`
+	// Latency is in microsecond.
+	Latency int64 `json:"latency"`
+}
+
+// Config is the access log config of cluster.
+type Config struct {
+	// SampleRate is the rate of requests recorded in (0, 1].
+	SampleRate float64
+	// Key is how the key is recorded: collapse, hash or none.
+	Key string
+}
+
+// IsKeyMode returns whether the key mode is supported, empty means collapse.
+func IsKeyMode(mode string) bool {
+	switch mode {
+	case "", KeyCollapse, KeyHash, KeyNone:
+		return true
+	}
+	return false
+}
+
+// Logger is the access logger of cluster.
+type Logger struct {
+	cluster string
+	rate    float64
+	key     string
+}
+
+// Recorder records the requests of one client conn.
+// NOTE: it is not goroutine safe and must be used by the handler of conn only.
+type Recorder struct {
+	l       *Logger
+	client  string
+	connID  uint64
+	acc     float64
+	pending []pending
+}
+
+type pending struct {
+	msg      *proto.Message
+	reqBytes int
+}
+
+// NewRecorder new a recorder of client conn.
+func (l *Logger) NewRecorder(client string, connID uint64) *Recorder {
+	return &Recorder{l: l, client: client, connID: connID}
+}
+
+// Sample samples the messages by rate and counts their request bytes.
+// NOTE: must be called before the messages are forwarded.
+func (r *Recorder) Sample(msgs []*proto.Message) {
+	r.pending = r.pending[:0]
+	for _, msg := range msgs {
+		// NOTE: accumulates the rate for sampling exactly without random.
+		if r.acc += r.l.rate; r.acc < 1 {
+			continue
+		}
+		r.acc--
+		p := pending{msg: msg}
+		for _, req := range msg.Requests() {
+			if s, ok := req.(proto.Sizer); ok {
+				p.reqBytes += s.RequestSize()
+			}
+		}
+		r.pending = append(r.pending, p)
+	}
+}
+
+// Record records the sampled messages into sink.
+// NOTE: must be called after the messages are replied and before they are reset.
+func (r *Recorder) Record() {
+	for i, p := range r.pending {
+		r.l.record(r.entry(p))
+		r.pending[i].msg = nil
+	}
+	r.pending = r.pending[:0]
+}
+
+func (r *Recorder) entry(p pending) *Entry {
+	msg := p.msg
+	tl := msg.Timeline()
+	e := &Entry{
+		Time:     tl.Start,
+		Cluster:  r.l.cluster,
+		Client:   r.client,
+		ConnID:   r.connID,
+		Status:   StatusOK,
+		ReqBytes: p.reqBytes,
+		Latency:  int64(tl.End.Sub(tl.Start) / time.Microsecond),
+	}
+	reqs := msg.Requests()
+	e.Keys = len(reqs)
+	if len(reqs) > 0 {
+		e.Cmd = reqs[0].CmdString()
+		e.Key = r.l.formatKey(reqs[0].Key())
+	}
+	for _, req := range reqs {
+		if s, ok := req.(proto.Sizer); ok {
+			e.ReplyBytes += s.ReplySize()
+		}
+	}
+	if subs := msg.Subs(); subs != nil {
+		// NOTE: batch may be forwarded to multi nodes which are joined by comma.
+		for _, sub := range subs {
+			if addr := sub.Addr(); addr != "" && !containsNode(e.Node, addr) {
+				if e.Node != "" {
+					e.Node += ","
+				}
+				e.Node += addr
+			}
+		}
+	} else {
+		e.Node = msg.Addr()
+	}
+	if err := msg.Err(); err != nil {
+		e.Status = StatusError
+		e.Err = err.Error()
+	}
+	return e
+}
+
+func containsNode(nodes, addr string) bool {
+	for _, node := range strings.Split(nodes, ",") {
+		if node == addr {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *Logger) formatKey(key []byte) string {
+	switch l.key {
+	case KeyNone:
+		return ""
+	case KeyHash:
+		h := fnv.New64a()
+		_, _ = h.Write(key)
+		return hex.EncodeToString(h.Sum(nil))
+	default:
+		return string(proto.CollapseBody(key))
+	}
+}
+
+func (l *Logger) record(e *Entry) {
+	w := getWriter()
+	if w == nil {
+		return
+	}
+	select {
+	case w.entries <- e:
+	default:
+		atomic.AddUint64(&w.dropped, 1)
+	}
+}
+
+var (
+	loggerMap  = map[string]*Logger{}
+	loggerLock sync.RWMutex
+)
+
+// Register registers the access logger of cluster.
+func Register(name string, conf *Config) *Logger {
+	l := &Logger{cluster: name, rate: conf.SampleRate, key: conf.Key}
+	if l.rate > 1 {
+		l.rate = 1
+	}
+	if getWriter() == nil {
+		log.Warnf("access log of cluster %s is enabled but the sink is not set", name)
+	}
+	loggerLock.Lock()
+	loggerMap[name] = l
+	loggerLock.Unlock()
+	return l
+}
+
+// Get return the access logger of cluster, nil if not registered.
+func Get(name string) *Logger {
+	loggerLock.RLock()
+	l := loggerMap[name]
+	loggerLock.RUnlock()
+	return l
+}
@@ -0,0 +1,152 @@
+package accesslog
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"overlord/proxy/proto"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeReq struct {
+	key string
+}
+
+func (r *fakeReq) CmdString() string            { return "get" }
+func (r *fakeReq) Cmd() []byte                  { return []byte("get") }
+func (r *fakeReq) Key() []byte                  { return []byte(r.key) }
+func (r *fakeReq) Put()                         {}
+func (r *fakeReq) Merge([]proto.Request) error  { return nil }
+func (r *fakeReq) Slowlog() *proto.SlowlogEntry { return nil }
+func (r *fakeReq) RequestSize() int             { return 6 + len(r.key) }
+func (r *fakeReq) ReplySize() int               { return 5 }
+
+type memSink struct {
+	lock    sync.Mutex
+	entries []*Entry
+	block   chan struct{}
+}
+
+func (s *memSink) Write(e *Entry) error {
+	if s.block != nil {
+		<-s.block
+	}
+	s.lock.Lock()
+	s.entries = append(s.entries, e)
+	s.lock.Unlock()
+	return nil
+}
+func (s *memSink) Flush() error { return nil }
+func (s *memSink) Close() error { return nil }
+
+func _msg(key, addr string, err error) *proto.Message {
+	m := proto.NewMessage()
+	m.WithRequest(&fakeReq{key: key})
+	m.MarkStart()
+	m.MarkAddr(addr)
+	m.WithError(err)
+	m.MarkEnd()
+	return m
+}
+
+func TestRecorderSample(t *testing.T) {
+	sink := &memSink{}
+	Init(sink)
+	l := Register("test", &Config{SampleRate: 0.25, Key: KeyHash})
+	assert.Equal(t, l, Get("test"))
+	r := l.NewRecorder("127.0.0.1:10000", 3)
+	for i := 0; i < 10; i++ {
+		msgs := []*proto.Message{
+			_msg("a", "127.0.0.1:6379", nil),
+			_msg("b", "127.0.0.1:6380", errors.New("timeout")),
+		}
+		r.Sample(msgs)
+		r.Record()
+	}
+	Close()
+	assert.Len(t, sink.entries, 5)
+	e := sink.entries[0]
+	assert.Equal(t, "test", e.Cluster)
+	assert.Equal(t, "127.0.0.1:10000", e.Client)
+	assert.Equal(t, uint64(3), e.ConnID)
+	assert.Equal(t, "get", e.Cmd)
+	assert.Len(t, e.Key, 16)
+	assert.Equal(t, 1, e.Keys)
+	assert.Equal(t, 7, e.ReqBytes)
+	assert.Equal(t, 5, e.ReplyBytes)
+	for _, e := range sink.entries {
+		if e.Node == "127.0.0.1:6380" {
+			assert.Equal(t, StatusError, e.Status)
+			assert.Equal(t, "timeout", e.Err)
+		} else {
+			assert.Equal(t, StatusOK, e.Status)
+		}
+	}
+}
+
+func TestFormatKey(t *testing.T) {
+	long := make([]byte, 1024)
+	assert.Len(t, (&Logger{}).formatKey(long), 256)
+	assert.Equal(t, "abc", (&Logger{key: KeyCollapse}).formatKey([]byte("abc")))
+	assert.Equal(t, "", (&Logger{key: KeyNone}).formatKey([]byte("abc")))
+	assert.True(t, IsKeyMode(""))
+	assert.False(t, IsKeyMode("raw"))
+}
+
+func TestSlowSinkNotBlock(t *testing.T) {
+	sink := &memSink{block: make(chan struct{})}
+	Init(sink)
+	l := Register("slow", &Config{SampleRate: 1})
+	r := l.NewRecorder("127.0.0.1:10000", 1)
+	msgs := []*proto.Message{_msg("a", "127.0.0.1:6379", nil)}
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < defaultQueueSize+100; i++ {
+			r.Sample(msgs)
+			r.Record()
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("record is blocked by slow sink")
+	}
+	assert.True(t, Dropped() > 0)
+	close(sink.block)
+	Close()
+}
+
+func TestFileSinkRotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "accesslog")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "access.log")
+	sink, err := NewFileSink(name, 100, 2)
+	assert.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, sink.Write(&Entry{Cluster: "test", Cmd: "get", Key: "abcdefghijklmnopqrstuvwxyz"}))
+		assert.NoError(t, sink.Flush())
+	}
+	assert.NoError(t, sink.Close())
+	for _, fn := range []string{name, name + ".1", name + ".2"} {
+		_, err := os.Stat(fn)
+		assert.NoError(t, err, fn)
+	}
+	f, err := os.Open(name + ".1")
+	assert.NoError(t, err)
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	assert.True(t, sc.Scan())
+	e := &Entry{}
+	assert.NoError(t, json.Unmarshal(sc.Bytes(), e))
+	assert.Equal(t, "test", e.Cluster)
+}
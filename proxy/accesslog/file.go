@@ -0,0 +1,89 @@
+package accesslog
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// fileSink writes entries as JSON lines into file, rotates the file when it is larger than maxBytes.
+type fileSink struct {
+	fd      *os.File
+	wr      *bufio.Writer
+	encoder *json.Encoder
+
+	fileName    string
+	maxBytes    int
+	backupCount int
+}
+
+// NewFileSink new a JSON lines file sink, file is rotated into fileName.1 ... fileName.{backupCount}
+// when it is larger than maxBytes, and never rotated if maxBytes is not positive.
+func NewFileSink(fileName string, maxBytes, backupCount int) (Sink, error) {
+	f := &fileSink{
+		fileName:    fileName,
+		maxBytes:    maxBytes,
+		backupCount: backupCount,
+	}
+	if err := f.openFile(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *fileSink) openFile() (err error) {
+	if f.fd, err = os.OpenFile(f.fileName, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644); err != nil {
+		return errors.WithStack(err)
+	}
+	f.wr = bufio.NewWriterSize(f.fd, 40960)
+	f.encoder = json.NewEncoder(f.wr)
+	return nil
+}
+
+func (f *fileSink) Write(e *Entry) error {
+	return f.encoder.Encode(e)
+}
+
+func (f *fileSink) Flush() error {
+	if f.wr.Buffered() > 0 {
+		if err := f.wr.Flush(); err != nil {
+			return err
+		}
+	}
+	return f.rotate()
+}
+
+func (f *fileSink) rotate() error {
+	if f.maxBytes <= 0 {
+		return nil
+	}
+	fdStat, err := f.fd.Stat()
+	if err != nil {
+		return err
+	}
+	if fdStat.Size() < int64(f.maxBytes) {
+		return nil
+	}
+	_ = f.fd.Close()
+	if f.backupCount > 0 {
+		for i := f.backupCount - 1; i > 0; i-- {
+			sfn := fmt.Sprintf("%s.%d", f.fileName, i)
+			dfn := fmt.Sprintf("%s.%d", f.fileName, i+1)
+			_ = os.Rename(sfn, dfn)
+		}
+		_ = os.Rename(f.fileName, fmt.Sprintf("%s.1", f.fileName))
+	} else {
+		_ = os.Remove(f.fileName)
+	}
+	return f.openFile()
+}
+
+func (f *fileSink) Close() error {
+	if f.wr.Buffered() > 0 {
+		_ = f.wr.Flush()
+	}
+	return f.fd.Close()
+}
@@ -0,0 +1,103 @@
+package accesslog
+
+import (
+	"sync/atomic"
+	"time"
+
+	"overlord/pkg/log"
+)
+
+const (
+	defaultQueueSize     = 8192
+	defaultFlushInterval = time.Second
+)
+
+// Sink is where the entries are written into.
+// NOTE: it is called by one goroutine only.
+type Sink interface {
+	Write(e *Entry) error
+	// Flush is called periodically.
+	Flush() error
+	Close() error
+}
+
+// writer writes entries into sink asynchronously, entries are dropped if the sink is slow.
+type writer struct {
+	sink          Sink
+	entries       chan *Entry
+	flushInterval time.Duration
+	dropped       uint64
+
+	quit chan struct{}
+	done chan struct{}
+}
+
+var w atomic.Value // *writer
+
+func getWriter() *writer {
+	wr, _ := w.Load().(*writer)
+	return wr
+}
+
+// Init init the access log with sink, the entries are written into it asynchronously.
+func Init(sink Sink) {
+	wr := &writer{
+		sink:          sink,
+		entries:       make(chan *Entry, defaultQueueSize),
+		flushInterval: defaultFlushInterval,
+		quit:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	w.Store(wr)
+	go wr.run()
+}
+
+// Dropped returns the count of entries dropped due to the slow sink.
+func Dropped() uint64 {
+	if wr := getWriter(); wr != nil {
+		return atomic.LoadUint64(&wr.dropped)
+	}
+	return 0
+}
+
+// Close writes the queued entries and closes the sink.
+func Close() {
+	if wr := getWriter(); wr != nil {
+		close(wr.quit)
+		<-wr.done
+	}
+}
+
+func (wr *writer) run() {
+	defer close(wr.done)
+	ticker := time.NewTicker(wr.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case e := <-wr.entries:
+			wr.write(e)
+		case <-ticker.C:
+			if err := wr.sink.Flush(); err != nil {
+				log.Errorf("fail to flush access log due %s", err)
+			}
+		case <-wr.quit:
+			for len(wr.entries) > 0 {
+				wr.write(<-wr.entries)
+			}
+			if err := wr.sink.Flush(); err != nil {
+				log.Errorf("fail to flush access log due %s", err)
+			}
+			_ = wr.sink.Close()
+			return
+		}
+	}
+}
+
+func (wr *writer) write(e *Entry) {
+	if err := wr.sink.Write(e); err != nil {
+		atomic.AddUint64(&wr.dropped, 1)
+		if log.V(2) {
+			log.Errorf("fail to write access log due %s", err)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"sync"
+
+	"overlord/proxy/proto"
+)
+
+// defaults of batch size.
+const (
+	defaultBatchMin = 2
+	defaultBatchMax = 1024
+	// batchShrinkAfter is the count of under-used batches in a row which the batch shrinks after.
+	batchShrinkAfter = 16
+)
+
+// batcher sizes the messages decoded at once from client by the observed pipeline depth.
+// The batch doubles when it's filled up, and halves when at most a quarter of it is used
+// for batchShrinkAfter batches in a row, between min and max.
+type batcher struct {
+	min, max int
+	wg       *sync.WaitGroup
+	// NOTE: the slice is reused and only the messages beyond the size are returned to pool.
+	msgs []*proto.Message
+	idle int
+}
+
+func newBatcher(min, max int, wg *sync.WaitGroup) *batcher {
+	if min <= 0 {
+		min = defaultBatchMin
+	}
+	if max < min {
+		max = defaultBatchMax
+		if max < min {
+			max = min
+		}
+	}
+	b := &batcher{min: min, max: max, wg: wg}
+	b.resize(min)
+	return b
+}
+
+// observe adjusts the batch size by the count of messages decoded in the last batch.
+func (b *batcher) observe(n int) {
+	size := len(b.msgs)
+	switch {
+	case n >= size && size < b.max:
+		b.idle = 0
+		b.resize(size * 2)
+	case n <= size/4 && size > b.min:
+		if b.idle++; b.idle >= batchShrinkAfter {
+			b.idle = 0
+			b.resize(size / 2)
+		}
+	default:
+		b.idle = 0
+	}
+}
+
+func (b *batcher) resize(size int) {
+	if size > b.max {
+		size = b.max
+	} else if size < b.min {
+		size = b.min
+	}
+	n := len(b.msgs)
+	if size < n {
+		proto.PutMsgs(b.msgs[size:])
+		for i := size; i < n; i++ {
+			b.msgs[i] = nil
+		}
+		b.msgs = b.msgs[:size]
+		return
+	}
+	for _, msg := range proto.GetMsgs(size - n) {
+		msg.WithWaitGroup(b.wg)
+		b.msgs = append(b.msgs, msg)
+	}
+}
+
+// release returns all messages to pool.
+func (b *batcher) release() {
+	proto.PutMsgs(b.msgs)
+	b.msgs = nil
+}
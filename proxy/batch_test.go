@@ -0,0 +1,176 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"overlord/pkg/types"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatcher(t *testing.T) {
+	b := newBatcher(2, 16, &sync.WaitGroup{})
+	assert.Len(t, b.msgs, 2)
+	first := b.msgs[0]
+	for _, n := range []int{2, 4, 8, 16, 16} {
+		b.observe(n)
+	}
+	assert.Len(t, b.msgs, 16)
+	assert.Equal(t, first, b.msgs[0])
+
+	// NOTE: the batch keeps the size if half used.
+	for i := 0; i < batchShrinkAfter*2; i++ {
+		b.observe(8)
+	}
+	assert.Len(t, b.msgs, 16)
+	for i := 0; i < batchShrinkAfter-1; i++ {
+		b.observe(1)
+	}
+	assert.Len(t, b.msgs, 16)
+	b.observe(1)
+	assert.Len(t, b.msgs, 8)
+	assert.Equal(t, first, b.msgs[0])
+	assert.Equal(t, 16, cap(b.msgs))
+	for i := 0; i < batchShrinkAfter*10; i++ {
+		b.observe(1)
+	}
+	assert.Len(t, b.msgs, 2)
+
+	b.release()
+	assert.Len(t, b.msgs, 0)
+
+	b = newBatcher(0, 0, &sync.WaitGroup{})
+	assert.Equal(t, defaultBatchMin, b.min)
+	assert.Equal(t, defaultBatchMax, b.max)
+}
+
+// _redisServer replies +OK to every command.
+func _redisServer(tb testing.TB) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(tb, err)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				var (
+					br = bufio.NewReader(conn)
+					bw = bufio.NewWriter(conn)
+				)
+				for {
+					line, err := br.ReadSlice('\n')
+					if err != nil {
+						return
+					}
+					n, _ := strconv.Atoi(string(line[1 : len(line)-2]))
+					for i := 0; i < n*2; i++ {
+						if _, err = br.ReadSlice('\n'); err != nil {
+							return
+						}
+					}
+					bw.WriteString("+OK\r\n")
+					if br.Buffered() == 0 {
+						bw.Flush()
+					}
+				}
+			}(conn)
+		}
+	}()
+	return l
+}
+
+func _benchProxy(b *testing.B, batchMin, batchMax int) (string, func()) {
+	backend := _redisServer(b)
+	cc := &ClusterConfig{
+		Name:        "bench-batch",
+		CacheType:   types.CacheTypeRedis,
+		ListenProto: "tcp",
+		ListenAddr:  "127.0.0.1:0",
+		Servers:     []string{backend.Addr().String() + ":1"},
+		BatchMin:    batchMin,
+		BatchMax:    batchMax,
+	}
+	cc.SetDefault()
+	p := &Proxy{c: &Config{}, clients: newClients()}
+	p.Serve([]*ClusterConfig{cc})
+	return p.listeners[cc.Name].Addr().String(), func() {
+		p.Close()
+		backend.Close()
+	}
+}
+
+// benchmarkClients runs clients which send depth SET commands at once and wait the replies,
+// the p99 latency of batches is logged.
+func benchmarkClients(b *testing.B, depth, batchMin, batchMax int) {
+	addr, closeFn := _benchProxy(b, batchMin, batchMax)
+	defer closeFn()
+	const clients = 8
+	cmd := []byte("*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n")
+	req := bytes.Repeat(cmd, depth)
+	var (
+		lock sync.Mutex
+		lats []time.Duration
+		wg   sync.WaitGroup
+	)
+	b.SetBytes(int64(len(req)))
+	start := time.Now()
+	b.ResetTimer()
+	for c := 0; c < clients; c++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				b.Error(err)
+				return
+			}
+			defer conn.Close()
+			reply := make([]byte, len("+OK\r\n")*depth)
+			ls := make([]time.Duration, 0, n)
+			for i := 0; i < n; i++ {
+				start := time.Now()
+				if _, err = conn.Write(req); err != nil {
+					b.Error(err)
+					return
+				}
+				if _, err = io.ReadFull(conn, reply); err != nil {
+					b.Error(err)
+					return
+				}
+				ls = append(ls, time.Since(start))
+			}
+			lock.Lock()
+			lats = append(lats, ls...)
+			lock.Unlock()
+		}(b.N/clients + 1)
+	}
+	wg.Wait()
+	b.StopTimer()
+	if len(lats) == 0 {
+		return
+	}
+	sort.Slice(lats, func(i, j int) bool { return lats[i] < lats[j] })
+	b.Logf("N:%d depth:%d cmds/s:%.0f p99:%v", b.N, depth, float64(b.N*depth)/time.Since(start).Seconds(), lats[len(lats)*99/100])
+}
+
+func BenchmarkBatchNoPipeline(b *testing.B) {
+	b.Run("adaptive", func(b *testing.B) { benchmarkClients(b, 1, defaultBatchMin, defaultBatchMax) })
+	b.Run("fixed", func(b *testing.B) { benchmarkClients(b, 1, defaultBatchMax, defaultBatchMax) })
+}
+
+func BenchmarkBatchPipeline(b *testing.B) {
+	b.Run("adaptive", func(b *testing.B) { benchmarkClients(b, 64, defaultBatchMin, defaultBatchMax) })
+	b.Run("fixed-small", func(b *testing.B) { benchmarkClients(b, 64, defaultBatchMin, defaultBatchMin) })
+	b.Run("fixed", func(b *testing.B) { benchmarkClients(b, 64, defaultBatchMax, defaultBatchMax) })
+}
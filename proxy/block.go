@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"sync"
+	"syscall"
+	"time"
+
+	"overlord/proxy/proto"
+)
+
+// blocking binds the context of client to the blocking commands, false if none.
+func (h *Handler) blocking(msgs []*proto.Message) (ok bool) {
+	for _, msg := range msgs {
+		if req, is := msg.Request().(proto.Blocker); is {
+			if _, is = req.Blocking(); is {
+				msg.WithContext(h.ctx)
+				ok = true
+			}
+		}
+	}
+	return
+}
+
+// wait waits the messages forwarded, and watches the client closed while blocking,
+// so the blocking commands are canceled and the leased connections are closed.
+func (h *Handler) wait(wg *sync.WaitGroup, blocking bool) {
+	if !blocking {
+		wg.Wait()
+		return
+	}
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		if h.watch() {
+			h.cancel()
+		}
+	}()
+	wg.Wait()
+	// NOTE: interrupt the watch and restore the deadline of next read.
+	_ = h.conn.SetReadDeadline(time.Now())
+	<-exited
+	_ = h.conn.SetReadDeadline(time.Time{})
+}
+
+// watch peeks the client conn until readable, returns true if closed by client.
+// NOTE: the pipelined commands are left in conn and stop watching.
+func (h *Handler) watch() (closed bool) {
+	sc, ok := h.conn.Conn.(syscall.Conn)
+	if !ok {
+		return
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return
+	}
+	b := make([]byte, 1)
+	_ = rc.Read(func(fd uintptr) bool {
+		n, _, err := syscall.Recvfrom(int(fd), b, syscall.MSG_PEEK|syscall.MSG_DONTWAIT)
+		if err == syscall.EAGAIN || err == syscall.EINTR {
+			return false
+		}
+		closed = n == 0 || err != nil
+		return true
+	})
+	return
+}
@@ -0,0 +1,46 @@
+package proxy
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	libnet "overlord/pkg/net"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandlerWatch(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer l.Close()
+	watch := func(client func(net.Conn)) bool {
+		c, err := net.Dial("tcp", l.Addr().String())
+		assert.NoError(t, err)
+		defer c.Close()
+		conn, err := l.Accept()
+		assert.NoError(t, err)
+		defer conn.Close()
+		h := &Handler{conn: libnet.NewConn(conn, 0, 0)}
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			client(c)
+		}()
+		return h.watch()
+	}
+	assert.True(t, watch(func(c net.Conn) { c.Close() }), "closed while blocking")
+	assert.False(t, watch(func(c net.Conn) { c.Write([]byte("PING\r\n")) }), "pipelined command is left")
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	assert.NoError(t, err)
+	defer c.Close()
+	conn, err := l.Accept()
+	assert.NoError(t, err)
+	defer conn.Close()
+	h := &Handler{conn: libnet.NewConn(conn, 0, 0)}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		_ = h.conn.SetReadDeadline(time.Now())
+	}()
+	assert.False(t, h.watch(), "interrupted after replied")
+}
@@ -0,0 +1,320 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"overlord/proxy/proto"
+)
+
+var (
+	respOKBytes   = []byte("+OK\r\n")
+	respNullBytes = []byte("$-1\r\n")
+)
+
+// ClientInfo is the introspection of client connection.
+type ClientInfo struct {
+	ID      uint64 `json:"id"`
+	Addr    string `json:"addr"`
+	Cluster string `json:"cluster"`
+	Name    string `json:"name"`
+	// Age and Idle are in second.
+	Age    int64  `json:"age"`
+	Idle   int64  `json:"idle"`
+	Cmd    string `json:"cmd"`
+	Ops    uint64 `json:"ops"`
+	NetIn  uint64 `json:"net_in"`
+	NetOut uint64 `json:"net_out"`
+}
+
+// clientFilter matches clients by cluster, id and addr, the empty fields match all.
+type clientFilter struct {
+	cluster string
+	id      uint64
+	addr    string
+}
+
+func (f *clientFilter) match(h *Handler) bool {
+	if f.cluster != "" && f.cluster != h.cc.Name {
+		return false
+	}
+	if f.id != 0 && f.id != h.id {
+		return false
+	}
+	if f.addr != "" && f.addr != h.addr {
+		return false
+	}
+	return true
+}
+
+// clients is the registry of live handlers.
+type clients struct {
+	lock sync.RWMutex
+	hs   map[uint64]*Handler
+}
+
+func newClients() *clients {
+	return &clients{hs: map[uint64]*Handler{}}
+}
+
+func (cs *clients) add(h *Handler) {
+	cs.lock.Lock()
+	cs.hs[h.id] = h
+	cs.lock.Unlock()
+}
+
+func (cs *clients) del(h *Handler) {
+	cs.lock.Lock()
+	delete(cs.hs, h.id)
+	cs.lock.Unlock()
+}
+
+// find return the handlers matched by filter sorted by id.
+func (cs *clients) find(f *clientFilter) []*Handler {
+	cs.lock.RLock()
+	hs := make([]*Handler, 0, len(cs.hs))
+	for _, h := range cs.hs {
+		if f.match(h) {
+			hs = append(hs, h)
+		}
+	}
+	cs.lock.RUnlock()
+	sort.Slice(hs, func(i, j int) bool { return hs[i].id < hs[j].id })
+	return hs
+}
+
+func (cs *clients) count() int {
+	cs.lock.RLock()
+	n := len(cs.hs)
+	cs.lock.RUnlock()
+	return n
+}
+
+func (cs *clients) list(f *clientFilter) []*ClientInfo {
+	now := time.Now()
+	hs := cs.find(f)
+	infos := make([]*ClientInfo, len(hs))
+	for i, h := range hs {
+		infos[i] = h.info(now)
+	}
+	return infos
+}
+
+// kill closes the handlers matched by filter and return the count of them.
+func (cs *clients) kill(f *clientFilter) int {
+	hs := cs.find(f)
+	for _, h := range hs {
+		h.closeWithError(ErrProxyClientKilled)
+	}
+	return len(hs)
+}
+
+// ListClients is the http handler which shows clients filtered by cluster, id and addr.
+func (p *Proxy) ListClients(w http.ResponseWriter, req *http.Request) {
+	f, err := parseClientFilter(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err = json.NewEncoder(w).Encode(p.clients.list(f)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// KillClients is the http handler which closes clients filtered by cluster, id and addr.
+func (p *Proxy) KillClients(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	f, err := parseClientFilter(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if f.id == 0 && f.addr == "" {
+		http.Error(w, "id or addr is required", http.StatusBadRequest)
+		return
+	}
+	fmt.Fprintf(w, "%d\n", p.clients.kill(f))
+}
+
+func parseClientFilter(req *http.Request) (*clientFilter, error) {
+	params := req.URL.Query()
+	f := &clientFilter{
+		cluster: params.Get("cluster"),
+		addr:    params.Get("addr"),
+	}
+	if v := params.Get("id"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("id %s is invalid", v)
+		}
+		f.id = id
+	}
+	return f, nil
+}
+
+// stat counts the bytes read from and written into client.
+func (h *Handler) stat(read, write int) {
+	if read > 0 {
+		atomic.AddUint64(&h.netIn, uint64(read))
+	}
+	if write > 0 {
+		atomic.AddUint64(&h.netOut, uint64(write))
+	}
+}
+
+// touch records the ops and the last command of decoded messages.
+func (h *Handler) touch(msgs []*proto.Message) {
+	if len(msgs) == 0 {
+		return
+	}
+	atomic.AddUint64(&h.ops, uint64(len(msgs)))
+	atomic.StoreInt64(&h.atime, time.Now().UnixNano())
+	h.lastCmd.Store(msgs[len(msgs)-1].Request().CmdString())
+}
+
+func (h *Handler) info(now time.Time) *ClientInfo {
+	ci := &ClientInfo{
+		ID:      h.id,
+		Addr:    h.addr,
+		Cluster: h.cc.Name,
+		Age:     int64(now.Sub(h.ctime) / time.Second),
+		Ops:     atomic.LoadUint64(&h.ops),
+		NetIn:   atomic.LoadUint64(&h.netIn),
+		NetOut:  atomic.LoadUint64(&h.netOut),
+	}
+	ci.Name, _ = h.name.Load().(string)
+	ci.Cmd, _ = h.lastCmd.Load().(string)
+	if atime := atomic.LoadInt64(&h.atime); atime > 0 {
+		ci.Idle = int64(now.Sub(time.Unix(0, atime)) / time.Second)
+	} else {
+		ci.Idle = ci.Age
+	}
+	return ci
+}
+
+// Client impl the redis.Clienter, clients are listed and killed within the cluster.
+func (h *Handler) Client(args [][]byte) []byte {
+	if len(args) == 0 {
+		return respError("ERR wrong number of arguments for 'client' command")
+	}
+	switch sub := strings.ToUpper(string(args[0])); sub {
+	case "LIST":
+		if len(args) != 1 {
+			return respError("ERR syntax error")
+		}
+		buf := &bytes.Buffer{}
+		for _, ci := range h.p.clients.list(&clientFilter{cluster: h.cc.Name}) {
+			fmt.Fprintf(buf, "id=%d addr=%s name=%s age=%d idle=%d cmd=%s tot-cmds=%d tot-net-in=%d tot-net-out=%d\n",
+				ci.ID, ci.Addr, ci.Name, ci.Age, ci.Idle, strings.ToLower(ci.Cmd), ci.Ops, ci.NetIn, ci.NetOut)
+		}
+		return respBulk(buf.Bytes())
+	case "ID":
+		return respInt(int64(h.id))
+	case "GETNAME":
+		if name, _ := h.name.Load().(string); name != "" {
+			return respBulk([]byte(name))
+		}
+		return respNullBytes
+	case "SETNAME":
+		if len(args) != 2 {
+			return respError("ERR wrong number of arguments for 'client|setname' command")
+		}
+		for _, c := range args[1] {
+			if c <= ' ' || c > '~' {
+				return respError("ERR Client names cannot contain spaces, newlines or special characters.")
+			}
+		}
+		h.name.Store(string(args[1]))
+		return respOKBytes
+	case "KILL":
+		return h.clientKill(args[1:])
+	default:
+		return respError(fmt.Sprintf("ERR unknown subcommand '%s'", sub))
+	}
+}
+
+// clientKill kills clients by "CLIENT KILL addr" or "CLIENT KILL [ID id] [ADDR addr] [SKIPME yes|no]".
+func (h *Handler) clientKill(args [][]byte) []byte {
+	f := &clientFilter{cluster: h.cc.Name}
+	if len(args) == 1 {
+		f.addr = string(args[0])
+		if h.kill(f, false) == 0 {
+			return respError("ERR No such client")
+		}
+		return respOKBytes
+	}
+	if len(args) == 0 || len(args)%2 != 0 {
+		return respError("ERR syntax error")
+	}
+	skipMe := true
+	for i := 0; i < len(args); i += 2 {
+		v := string(args[i+1])
+		switch strings.ToUpper(string(args[i])) {
+		case "ID":
+			id, err := strconv.ParseUint(v, 10, 64)
+			if err != nil || id == 0 {
+				return respError("ERR client-id should be greater than 0")
+			}
+			f.id = id
+		case "ADDR":
+			f.addr = v
+		case "SKIPME":
+			switch strings.ToLower(v) {
+			case "yes":
+				skipMe = true
+			case "no":
+				skipMe = false
+			default:
+				return respError("ERR syntax error")
+			}
+		default:
+			return respError("ERR syntax error")
+		}
+	}
+	return respInt(int64(h.kill(f, skipMe)))
+}
+
+// kill closes the clients matched by filter, the handler itself is closed after the reply flushed.
+func (h *Handler) kill(f *clientFilter, skipMe bool) (n int) {
+	for _, hh := range h.p.clients.find(f) {
+		if hh == h {
+			if skipMe {
+				continue
+			}
+			h.killed = true
+		} else {
+			hh.closeWithError(ErrProxyClientKilled)
+		}
+		n++
+	}
+	return
+}
+
+func respBulk(data []byte) []byte {
+	b := make([]byte, 0, len(data)+16)
+	b = append(b, '$')
+	b = strconv.AppendInt(b, int64(len(data)), 10)
+	b = append(b, "\r\n"...)
+	b = append(b, data...)
+	return append(b, "\r\n"...)
+}
+
+func respInt(n int64) []byte {
+	b := append([]byte{':'}, strconv.FormatInt(n, 10)...)
+	return append(b, "\r\n"...)
+}
+
+func respError(msg string) []byte {
+	return []byte("-" + msg + "\r\n")
+}
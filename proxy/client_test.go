@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	libnet "overlord/pkg/net"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func _handler(p *Proxy, cluster string, id uint64, addr string) *Handler {
+	conn, _ := net.Pipe()
+	h := &Handler{
+		p:     p,
+		cc:    &ClusterConfig{Name: cluster},
+		id:    id,
+		addr:  addr,
+		ctime: time.Now().Add(-time.Minute),
+		conn:  libnet.NewConn(conn, 0, 0),
+	}
+	h.ctx, h.cancel = context.WithCancel(context.Background())
+	p.clients.add(h)
+	return h
+}
+
+func TestClientCommand(t *testing.T) {
+	p := &Proxy{clients: newClients()}
+	h1 := _handler(p, "test", 1, "127.0.0.1:5001")
+	h2 := _handler(p, "test", 2, "127.0.0.1:5002")
+	_handler(p, "other", 3, "127.0.0.1:5003")
+	h2.stat(10, 20)
+	h2.lastCmd.Store("GET")
+
+	reply := func(h *Handler, args ...string) string {
+		bs := make([][]byte, len(args))
+		for i, arg := range args {
+			bs[i] = []byte(arg)
+		}
+		return string(h.Client(bs))
+	}
+	assert.Equal(t, ":1\r\n", reply(h1, "id"))
+	assert.Equal(t, "$-1\r\n", reply(h1, "GETNAME"))
+	assert.Equal(t, "+OK\r\n", reply(h1, "setname", "svc-a"))
+	assert.Equal(t, "$5\r\nsvc-a\r\n", reply(h1, "getname"))
+	assert.True(t, strings.HasPrefix(reply(h1, "setname", "svc a"), "-ERR"))
+
+	list := reply(h1, "list")
+	assert.True(t, strings.HasPrefix(list, "$"))
+	lines := strings.Split(strings.TrimSpace(list[strings.Index(list, "\r\n")+2:]), "\n")
+	assert.Len(t, lines, 2)
+	assert.Contains(t, lines[0], "id=1 addr=127.0.0.1:5001 name=svc-a age=60 idle=60")
+	assert.Contains(t, lines[1], "cmd=get tot-cmds=0 tot-net-in=10 tot-net-out=20")
+
+	assert.True(t, strings.HasPrefix(reply(h1, "kill", "127.0.0.1:5003"), "-ERR No such client"))
+	assert.True(t, strings.HasPrefix(reply(h1, "kill", "id", "x"), "-ERR"))
+	assert.True(t, strings.HasPrefix(reply(h1, "unknown"), "-ERR unknown subcommand"))
+	// NOTE: SKIPME is yes by default
+	assert.Equal(t, ":0\r\n", reply(h1, "kill", "id", "1"))
+	assert.False(t, h1.killed)
+	assert.Equal(t, ":1\r\n", reply(h1, "kill", "addr", "127.0.0.1:5002"))
+	assert.Equal(t, handlerClosed, h2.closed)
+	assert.Len(t, p.clients.list(&clientFilter{}), 2)
+
+	assert.Equal(t, "+OK\r\n", reply(h1, "kill", "127.0.0.1:5001"))
+	assert.True(t, h1.killed)
+}
+
+func TestClientsHTTP(t *testing.T) {
+	p := &Proxy{clients: newClients()}
+	_handler(p, "a", 1, "127.0.0.1:5001")
+	_handler(p, "b", 2, "127.0.0.1:5002")
+
+	w := httptest.NewRecorder()
+	p.ListClients(w, httptest.NewRequest(http.MethodGet, "/clients?cluster=b", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"id":2,"addr":"127.0.0.1:5002","cluster":"b"`)
+	assert.NotContains(t, w.Body.String(), `"id":1`)
+
+	w = httptest.NewRecorder()
+	p.KillClients(w, httptest.NewRequest(http.MethodGet, "/clients/kill?id=1", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	w = httptest.NewRecorder()
+	p.KillClients(w, httptest.NewRequest(http.MethodPost, "/clients/kill", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = httptest.NewRecorder()
+	p.KillClients(w, httptest.NewRequest(http.MethodPost, "/clients/kill?id=1", nil))
+	assert.Equal(t, "1\n", w.Body.String())
+	assert.Len(t, p.clients.list(&clientFilter{}), 1)
+}
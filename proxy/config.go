@@ -3,12 +3,19 @@ package proxy
 import (
 	errs "errors"
 	"fmt"
+	"net"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
+	"overlord/pkg/hashkit"
 	"overlord/pkg/log"
 	"overlord/pkg/types"
+	"overlord/proxy/accesslog"
+	"overlord/proxy/proto"
+	"overlord/proxy/slowlog"
+	"overlord/proxy/trace"
 
 	"github.com/BurntSushi/toml"
 	"github.com/Pallinder/go-randomdata"
@@ -19,6 +26,7 @@ import (
 var (
 	ErrClusterConfInvalid   = errs.New("cluster config is invalid")
 	ErrClusterConfDuplicate = errs.New("cluster config is duplicate")
+	ErrProxyConfInvalid     = errs.New("proxy config is invalid")
 )
 
 // Config proxy config.
@@ -29,8 +37,23 @@ type Config struct {
 		ReadTimeout    int   `toml:"read_timeout"`
 		WriteTimeout   int   `toml:"write_timeout"`
 		MaxConnections int32 `toml:"max_connections"`
-		UseMetrics     bool  `toml:"use_metrics"`
+		// ShutdownTimeout is in millisecond that we wait busy clients in graceful shutdown.
+		ShutdownTimeout int  `toml:"shutdown_timeout"`
+		UseMetrics      bool `toml:"use_metrics"`
+		// MetricsBuckets is the buckets of timers in microsecond.
+		MetricsBuckets  []float64 `toml:"metrics_buckets"`
+		MetricsMaxCmds  int       `toml:"metrics_max_cmds"`
+		MetricsMaxNodes int       `toml:"metrics_max_nodes"`
+		// TraceEndpoint is the url of OTLP/HTTP traces receiver, tracing is disabled if empty.
+		TraceEndpoint    string  `toml:"trace_endpoint"`
+		TraceServiceName string  `toml:"trace_service_name"`
+		TraceSampler     string  `toml:"trace_sampler"`
+		TraceSampleRatio float64 `toml:"trace_sample_ratio"`
+		// TraceSlowerThan is in microsecond, the requests slower than it are always traced.
+		TraceSlowerThan int `toml:"trace_slower_than"`
 	}
+	// SlowlogSinks is the remote sinks of slowlog, which are selected by clusters by name.
+	SlowlogSinks []*slowlog.SinkConfig `toml:"slowlog_sink"`
 }
 
 // DefaultConfig new config by defalut string.
@@ -57,6 +80,21 @@ func (c *Config) LoadFromFile(path string) error {
 // Validate validate config field value.
 func (c *Config) Validate() error {
 	// TODO(felix): complete validates
+	for i := 1; i < len(c.Proxy.MetricsBuckets); i++ {
+		if c.Proxy.MetricsBuckets[i] <= c.Proxy.MetricsBuckets[i-1] {
+			return errors.Wrapf(ErrProxyConfInvalid, "metrics buckets %v must be in increasing order", c.Proxy.MetricsBuckets)
+		}
+	}
+	if c.Proxy.TraceEndpoint != "" {
+		if _, err := trace.NewSampler(c.Proxy.TraceSampler, c.Proxy.TraceSampleRatio, 0); err != nil {
+			return errors.Wrapf(ErrProxyConfInvalid, "%v", err)
+		}
+	}
+	for _, sc := range c.SlowlogSinks {
+		if err := sc.Validate(); err != nil {
+			return errors.Wrapf(ErrProxyConfInvalid, "%v", err)
+		}
+	}
 	return nil
 }
 
@@ -66,6 +104,7 @@ type ClusterConfig struct {
 	HashMethod        string          `toml:"hash_method"`
 	HashDistribution  string          `toml:"hash_distribution"`
 	HashTag           string          `toml:"hash_tag"`
+	HashCompat        string          `toml:"hash_compat"`
 	CacheType         types.CacheType `toml:"cache_type"`
 	ListenProto       string          `toml:"listen_proto"`
 	ListenAddr        string          `toml:"listen_addr"`
@@ -75,10 +114,43 @@ type ClusterConfig struct {
 	WriteTimeout      int             `toml:"write_timeout"`
 	NodeConnections   int32           `toml:"node_connections"`
 	NodePipeCount     int             `toml:"node_pipe_count"`
+	BatchMin          int             `toml:"batch_min"`
+	BatchMax          int             `toml:"batch_max"`
 	PingFailLimit     int             `toml:"ping_fail_limit"`
 	PingAutoEject     bool            `toml:"ping_auto_eject"`
 	SlowlogSlowerThan int             `toml:"slowlog_slower_than"`
+	SlowlogMaxEntries int             `toml:"slowlog_max_entries"`
+	SlowlogSinks      []string        `toml:"slowlog_sinks"`
+	CoalesceReads     bool            `toml:"coalesce_reads"`
 	Servers           []string        `toml:"servers"`
+
+	NearCacheTTL          int      `toml:"near_cache_ttl"`
+	NearCacheMaxKeys      int      `toml:"near_cache_max_keys"`
+	NearCacheMaxBytes     int64    `toml:"near_cache_max_bytes"`
+	NearCacheKeys         []string `toml:"near_cache_keys"`
+	NearCacheHotThreshold int      `toml:"near_cache_hot_threshold"`
+
+	// FakePeers and the proxies heartbeating into FakePeersEtcd share slots in faked topology of redis_cluster.
+	FakePeers     []string `toml:"fake_peers"`
+	FakePeersEtcd string   `toml:"fake_peers_etcd"`
+	// FakePeersTTL is in second.
+	FakePeersTTL int `toml:"fake_peers_ttl"`
+	// Passthrough listens one port in PassthroughPorts like "27100-27199" for each master of redis_cluster.
+	Passthrough      bool   `toml:"passthrough"`
+	PassthroughPorts string `toml:"passthrough_ports"`
+
+	// NodeBlockingConnections is the max count of blocking commands waiting on dedicated connections of each node.
+	NodeBlockingConnections int `toml:"node_blocking_connections"`
+
+	NodeOverflow string `toml:"node_overflow"`
+	// NodeOverflowTimeout is in millisecond.
+	NodeOverflowTimeout int `toml:"node_overflow_timeout"`
+	// NodeBackoffBase and NodeBackoffMax are in millisecond.
+	NodeBackoffBase int `toml:"node_backoff_base"`
+	NodeBackoffMax  int `toml:"node_backoff_max"`
+
+	AccessLogSampleRate float64 `toml:"access_log_sample_rate"`
+	AccessLogKey        string  `toml:"access_log_key"`
 }
 
 // ValidateStandalone validate redis/memcache address is valid or not
@@ -116,12 +188,65 @@ func ValidateStandalone(servers []string) (err error) {
 // Validate validate config field value.
 func (cc *ClusterConfig) Validate() error {
 	// TODO(felix): complete validates
+	if cc.NearCacheTTL > 0 && cc.CacheType != types.CacheTypeRedis && cc.CacheType != types.CacheTypeRedisCluster {
+		return errors.Wrapf(ErrClusterConfInvalid, "near cache is only supported by redis but cluster:%s is %s", cc.Name, cc.CacheType)
+	}
+	if cc.HashDistribution != "" && !hashkit.IsDistribution(cc.HashDistribution) {
+		return errors.Wrapf(ErrClusterConfInvalid, "hash distribution %s of cluster:%s is not supported", cc.HashDistribution, cc.Name)
+	}
+	switch cc.HashDistribution {
+	case hashkit.HashDistributionModula, hashkit.HashDistributionRandom:
+		// NOTE: they were located by ketama before all distributions are supported.
+		log.Warnf("cluster(%s) hash distribution %s is not ketama any more, the keys are located into other nodes than ketama", cc.Name, cc.HashDistribution)
+	case hashkit.HashDistributionJump:
+		// NOTE: the buckets after the ejected node are renumbered, which moves almost all keys.
+		if cc.PingAutoEject {
+			return errors.Wrapf(ErrClusterConfInvalid, "ping auto eject of cluster:%s is not supported by hash distribution jump", cc.Name)
+		}
+	}
+	if cc.HashCompat != "" && cc.HashCompat != hashkit.HashCompatTwemproxy {
+		return errors.Wrapf(ErrClusterConfInvalid, "hash compat %s of cluster:%s is not supported", cc.HashCompat, cc.Name)
+	}
+	if cc.AccessLogSampleRate < 0 || cc.AccessLogSampleRate > 1 {
+		return errors.Wrapf(ErrClusterConfInvalid, "access log sample rate %v of cluster:%s must be in [0, 1]", cc.AccessLogSampleRate, cc.Name)
+	}
+	for _, peer := range cc.FakePeers {
+		if _, _, err := net.SplitHostPort(peer); err != nil {
+			return errors.Wrapf(ErrClusterConfInvalid, "fake peer %s of cluster:%s is invalid", peer, cc.Name)
+		}
+	}
+	if (len(cc.FakePeers) > 0 || cc.FakePeersEtcd != "") && cc.CacheType != types.CacheTypeRedisCluster {
+		return errors.Wrapf(ErrClusterConfInvalid, "fake peers is only supported by redis_cluster but cluster:%s is %s", cc.Name, cc.CacheType)
+	}
+	if cc.Passthrough {
+		if cc.CacheType != types.CacheTypeRedisCluster || cc.ListenProto == "unix" {
+			return errors.Wrapf(ErrClusterConfInvalid, "passthrough is only supported by redis_cluster listening tcp but cluster:%s is %s", cc.Name, cc.CacheType)
+		}
+		if _, _, err := cc.passthroughPorts(); err != nil {
+			return errors.Wrapf(ErrClusterConfInvalid, "passthrough ports %s of cluster:%s is invalid", cc.PassthroughPorts, cc.Name)
+		}
+	}
+	if cc.NodeOverflow != "" && !proto.IsOverflowPolicy(cc.NodeOverflow) {
+		return errors.Wrapf(ErrClusterConfInvalid, "node overflow %s of cluster:%s is not supported", cc.NodeOverflow, cc.Name)
+	}
+	if cc.NodeBackoffBase < 0 || cc.NodeBackoffMax < 0 || (cc.NodeBackoffMax > 0 && cc.NodeBackoffMax < cc.NodeBackoffBase) {
+		return errors.Wrapf(ErrClusterConfInvalid, "node backoff [%d, %d] of cluster:%s is invalid", cc.NodeBackoffBase, cc.NodeBackoffMax, cc.Name)
+	}
+	if cc.BatchMin < 0 || (cc.BatchMax > 0 && cc.BatchMax < cc.BatchMin) {
+		return errors.Wrapf(ErrClusterConfInvalid, "batch size [%d, %d] of cluster:%s is invalid", cc.BatchMin, cc.BatchMax, cc.Name)
+	}
+	if !accesslog.IsKeyMode(cc.AccessLogKey) {
+		return errors.Wrapf(ErrClusterConfInvalid, "access log key %s of cluster:%s is not supported", cc.AccessLogKey, cc.Name)
+	}
 	if cc.CacheType != types.CacheTypeRedisCluster {
 		return ValidateStandalone(cc.Servers)
 	}
 	return nil
 }
 
+// defaultNodeBlockingConnections is the default max count of blocked clients of each node.
+const defaultNodeBlockingConnections = 64
+
 // SetDefault config content with cluster config
 func (cc *ClusterConfig) SetDefault() {
 	if len(cc.Servers) == 0 {
@@ -155,6 +280,22 @@ func (cc *ClusterConfig) SetDefault() {
 		cc.NodePipeCount = 32
 	}
 
+	if cc.NodeBlockingConnections == 0 {
+		cc.NodeBlockingConnections = defaultNodeBlockingConnections
+	}
+
+	if cc.BatchMin == 0 {
+		cc.BatchMin = defaultBatchMin
+	}
+
+	if cc.BatchMax == 0 {
+		cc.BatchMax = defaultBatchMax
+	}
+
+	if cc.NearCacheTTL > 0 && cc.NearCacheMaxKeys == 0 && cc.NearCacheMaxBytes == 0 {
+		cc.NearCacheMaxKeys = 10000
+	}
+
 	if len(cc.ListenAddr) == 0 {
 		fmt.Fprint(os.Stderr, "checking out ListenAddr may only using for [anzi] from\n")
 	} else if !strings.Contains(cc.ListenAddr, ":") {
@@ -164,6 +305,41 @@ func (cc *ClusterConfig) SetDefault() {
 	}
 }
 
+// overflow return the behaviour of node pipes when full.
+func (cc *ClusterConfig) overflow() proto.Overflow {
+	return proto.Overflow{
+		Policy:  cc.NodeOverflow,
+		Timeout: time.Duration(cc.NodeOverflowTimeout) * time.Millisecond,
+	}
+}
+
+// backoff return the backoff of node pipes before reconnecting.
+func (cc *ClusterConfig) backoff() proto.Backoff {
+	return proto.Backoff{
+		Base: time.Duration(cc.NodeBackoffBase) * time.Millisecond,
+		Max:  time.Duration(cc.NodeBackoffMax) * time.Millisecond,
+	}
+}
+
+// passthroughPorts return the range of passthrough ports.
+func (cc *ClusterConfig) passthroughPorts() (min, max int, err error) {
+	ports := strings.SplitN(cc.PassthroughPorts, "-", 2)
+	if len(ports) != 2 {
+		err = errs.New("ports must be like min-max")
+		return
+	}
+	if min, err = strconv.Atoi(strings.TrimSpace(ports[0])); err != nil {
+		return
+	}
+	if max, err = strconv.Atoi(strings.TrimSpace(ports[1])); err != nil {
+		return
+	}
+	if min <= 0 || max > 65535 || min > max {
+		err = errs.New("ports out of range")
+	}
+	return
+}
+
 // ClusterConfigs cluster configs.
 type ClusterConfigs struct {
 	Clusters []*ClusterConfig
@@ -4,6 +4,9 @@ import (
 	"os"
 	"testing"
 
+	"overlord/pkg/hashkit"
+	"overlord/pkg/types"
+
 	"github.com/stretchr/testify/assert"
 )
 
@@ -130,3 +133,18 @@ func TestClusterConfigLoadFromFile(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Len(t, ccs.Clusters, 3)
 }
+
+func TestClusterConfigValidateDistribution(t *testing.T) {
+	cc := &ClusterConfig{
+		Name:             "jump",
+		CacheType:        types.CacheTypeRedis,
+		HashDistribution: hashkit.HashDistributionJump,
+		PingAutoEject:    true,
+		Servers:          []string{"127.0.0.1:7000:1", "127.0.0.1:7001:1"},
+	}
+	assert.Error(t, cc.Validate(), "jump with auto eject")
+	cc.PingAutoEject = false
+	assert.NoError(t, cc.Validate())
+	cc.HashDistribution, cc.PingAutoEject = hashkit.HashDistributionRendezvous, true
+	assert.NoError(t, cc.Validate())
+}
@@ -52,14 +52,43 @@ func NewForwarder(cc *ClusterConfig) proto.Forwarder {
 		return newDefaultForwarder(cc)
 	}
 	if cc.CacheType == types.CacheTypeRedisCluster {
-		dto := time.Duration(cc.DialTimeout) * time.Millisecond
-		rto := time.Duration(cc.ReadTimeout) * time.Millisecond
-		wto := time.Duration(cc.WriteTimeout) * time.Millisecond
-		return rclstr.NewForwarder(cc.Name, cc.ListenAddr, cc.Servers, cc.NodeConnections, cc.NodePipeCount, dto, rto, wto, []byte(cc.HashTag))
+		return newClusterForwarder(cc, nil)
 	}
 	panic("unsupported protocol")
 }
 
+// timeoutUpdater is the forwarder whose timeouts of node connections are reloaded with servers.
+type timeoutUpdater interface {
+	UpdateTimeout(dto, rto, wto time.Duration)
+}
+
+// newClusterForwarder new the forwarder of redis cluster, the topology mirrors the real slots by passthrough if not nil.
+func newClusterForwarder(cc *ClusterConfig, passthrough func(masters []string) map[string]string) proto.Forwarder {
+	dto := time.Duration(cc.DialTimeout) * time.Millisecond
+	rto := time.Duration(cc.ReadTimeout) * time.Millisecond
+	wto := time.Duration(cc.WriteTimeout) * time.Millisecond
+	topo := newTopology(cc)
+	topo.Passthrough = passthrough
+	return rclstr.NewForwarder(cc.Name, cc.ListenAddr, cc.Servers, cc.NodeConnections, cc.NodePipeCount, dto, rto, wto, []byte(cc.HashTag), cc.CoalesceReads, cc.overflow(), cc.backoff(), cc.NodeBlockingConnections, topo)
+}
+
+// newTopology return the topology of proxies faked by redis cluster.
+func newTopology(cc *ClusterConfig) *rclstr.Topology {
+	topo := &rclstr.Topology{
+		Peers: cc.FakePeers,
+		TTL:   time.Duration(cc.FakePeersTTL) * time.Second,
+	}
+	if cc.FakePeersEtcd != "" {
+		registry, err := rclstr.NewEtcdRegistry(cc.FakePeersEtcd, cc.Name)
+		if err != nil {
+			log.Errorf("fail to discover proxies of cluster %s from etcd %s due %v", cc.Name, cc.FakePeersEtcd, err)
+		} else {
+			topo.Registry = registry
+		}
+	}
+	return topo
+}
+
 // defaultForwarder implement the default hashring router and msgbatch.
 type defaultForwarder struct {
 	cc      *ClusterConfig
@@ -94,6 +123,9 @@ func (f *defaultForwarder) Forward(msgs []*proto.Message) error {
 		return ErrConnectionNotExist
 	}
 	for _, m := range msgs {
+		if f.broadcast(conns, m) {
+			continue
+		}
 		if m.IsBatch() {
 			ctxMap := make(map[string]*nodeConnPipeContext)
 			for _, subm := range m.Batch() {
@@ -111,6 +143,10 @@ func (f *defaultForwarder) Forward(msgs []*proto.Message) error {
 			}
 			f.batchPush(ctxMap)
 		} else {
+			if err := f.crossNode(conns, m.Request()); err != nil {
+				m.WithError(err)
+				continue
+			}
 			key := m.Request().Key()
 			ncp, ok := conns.getPipes(f.trimHashTag(key))
 			if !ok {
@@ -124,6 +160,37 @@ func (f *defaultForwarder) Forward(msgs []*proto.Message) error {
 	return nil
 }
 
+// crossNode return the error naming the keys of redis request located to different nodes.
+func (f *defaultForwarder) crossNode(conns *connections, req proto.Request) error {
+	rreq, ok := req.(*redis.Request)
+	if !ok {
+		return nil
+	}
+	keys := rreq.Keys()
+	if len(keys) < 2 {
+		return nil
+	}
+	first, _ := conns.locate(f.trimHashTag(keys[0]))
+	for _, key := range keys[1:] {
+		if addr, _ := conns.locate(f.trimHashTag(key)); addr != first {
+			return errors.Errorf("CROSSNODE Keys in request don't hash to the same node: %s %s", keys[0], key)
+		}
+	}
+	return nil
+}
+
+// broadcast pushes m to all nodes if it is redis SCRIPT LOAD, EXISTS or FLUSH.
+func (f *defaultForwarder) broadcast(conns *connections, m *proto.Message) bool {
+	if req, ok := m.Request().(*redis.Request); !ok || !req.IsBroadcast() {
+		return false
+	}
+	ncps := make([]*proto.NodeConnPipe, 0, len(conns.addrs))
+	for _, addr := range conns.addrs {
+		ncps = append(ncps, conns.nodePipe[addr])
+	}
+	return redis.Broadcast(m, ncps)
+}
+
 func (f *defaultForwarder) Update(servers []string) error {
 	addrs, ws, ans, alias, err := parseServers(servers)
 	if err != nil {
@@ -145,6 +212,9 @@ func (f *defaultForwarder) Update(servers []string) error {
 		}
 		log.Infof("connection to node:%s is not used anymore, just close it", addr)
 		conn.Close()
+		if prom.On {
+			prom.RingNode(f.cc.Name, addr, false)
+		}
 	}
 	return nil
 }
@@ -174,7 +244,11 @@ func (f *defaultForwarder) batchPush(ctxMap map[string]*nodeConnPipeContext) {
 			reqs = append(reqs, ctx.msgs[i].Request())
 		}
 		if err := mainMsg.Request().Merge(reqs); err != nil {
-			// todo report error
+			// NOTE: pushed one by one if not mergeable, like the split XREAD.
+			for _, m := range ctx.msgs {
+				ctx.ncp.Push(m)
+			}
+			continue
 		}
 
 		ctx.ncp.Push(mainMsg)
@@ -182,56 +256,90 @@ func (f *defaultForwarder) batchPush(ctxMap map[string]*nodeConnPipeContext) {
 }
 
 func (f *defaultForwarder) trimHashTag(key []byte) []byte {
-	if len(f.hashTag) != 2 {
+	return trimHashTag(f.hashTag, key, f.cc.HashCompat)
+}
+
+func trimHashTag(hashTag, key []byte, compat string) []byte {
+	if compat == hashkit.HashCompatTwemproxy {
+		// NOTE: empty hash tag hashes the whole key just as twemproxy.
+		return hashkit.HashTag(hashTag, key)
+	}
+	if len(hashTag) != 2 {
 		return key
 	}
-	bidx := bytes.IndexByte(key, f.hashTag[0])
+	bidx := bytes.IndexByte(key, hashTag[0])
 	if bidx == -1 {
 		return key
 	}
-	eidx := bytes.IndexByte(key[bidx+1:], f.hashTag[1])
+	eidx := bytes.IndexByte(key[bidx+1:], hashTag[1])
 	if eidx == -1 {
 		return key
 	}
 	return key[bidx+1 : bidx+1+eidx]
 }
 
+// nodeRing is the hash ring of backend nodes, alias is hashed instead of addr if configured.
+type nodeRing struct {
+	// recording alias to real node
+	alias    bool
+	aliasMap map[string]string
+	ring     *hashkit.HashRing
+}
+
+func newNodeRing(cc *ClusterConfig) *nodeRing {
+	return &nodeRing{
+		aliasMap: make(map[string]string),
+		ring:     hashkit.NewRingCompat(cc.HashDistribution, cc.HashMethod, cc.HashCompat),
+	}
+}
+
+func (r *nodeRing) init(addrs, ans []string, ws []int, alias bool) {
+	r.alias = alias
+	if alias {
+		for idx, aname := range ans {
+			r.aliasMap[aname] = addrs[idx]
+		}
+		r.ring.Init(ans, ws)
+	} else {
+		r.ring.Init(addrs, ws)
+	}
+}
+
+func (r *nodeRing) locate(key []byte) (addr string, ok bool) {
+	if addr, ok = r.ring.GetNode(key); !ok {
+		return
+	}
+	if r.alias {
+		addr, ok = r.aliasMap[addr]
+	}
+	return
+}
+
 type connections struct {
+	*nodeRing
 	ctx    context.Context
 	cancel context.CancelFunc
-	// recording alias to real node
+
 	cc         *ClusterConfig
-	alias      bool
 	addrs, ans []string
 	ws         []int
-	aliasMap   map[string]string
 	nodePipe   map[string]*proto.NodeConnPipe
-	ring       *hashkit.HashRing
 }
 
 func newConnections(cc *ClusterConfig) *connections {
 	c := &connections{}
 	c.cc = cc
-	c.aliasMap = make(map[string]string)
+	c.nodeRing = newNodeRing(cc)
 	c.nodePipe = make(map[string]*proto.NodeConnPipe)
-	c.ring = hashkit.NewRing(cc.HashDistribution, cc.HashMethod)
 	c.ctx, c.cancel = context.WithCancel(context.Background())
 	return c
 }
 
 func (c *connections) init(addrs, ans []string, ws []int, alias bool, oldNcps map[string]*proto.NodeConnPipe) map[string]bool {
-	c.alias = alias
 	c.addrs = addrs
 	c.ans = ans
 	c.ws = ws
-	if alias {
-		for idx, aname := range ans {
-			c.aliasMap[aname] = addrs[idx]
-		}
-		c.ring.Init(ans, ws)
-	} else {
-		c.ring.Init(addrs, ws)
-	}
+	c.nodeRing.init(addrs, ans, ws, alias)
 	copyed := make(map[string]bool)
 	// start nbc
 	for _, addr := range addrs {
@@ -241,9 +349,21 @@ func (c *connections) init(addrs, ans []string, ws []int, alias bool, oldNcps ma
 			c.nodePipe[toAddr] = cnn
 			copyed[toAddr] = true
 		} else {
-			c.nodePipe[toAddr] = proto.NewNodeConnPipe(c.cc.NodeConnections, c.cc.NodePipeCount, func() proto.NodeConn {
+			ncp := proto.NewNodeConnPipe(c.cc.NodeConnections, c.cc.NodePipeCount, func() proto.NodeConn {
 				return newNodeConn(c.cc, toAddr)
 			})
+			ncp.WithOverflow(c.cc.overflow())
+			ncp.WithBackoff(c.cc.backoff())
+			if c.cc.CacheType == types.CacheTypeRedis {
+				ncp.EnableBlocking(c.cc.NodeBlockingConnections)
+			}
+			if c.cc.CoalesceReads && c.cc.CacheType != types.CacheTypeMemcacheBinary {
+				ncp.EnableCoalesce(c.cc.Name, toAddr)
+			}
+			c.nodePipe[toAddr] = ncp
+		}
+		if prom.On {
+			prom.RingNode(c.cc.Name, toAddr, true)
 		}
 	}
 	return copyed
@@ -257,28 +377,18 @@ type nodeConnPipeContext struct {
 
 func (c *connections) getPipes(key []byte) (ncp *proto.NodeConnPipe, ok bool) {
 	var addr string
-	if addr, ok = c.ring.GetNode(key); !ok {
+	if addr, ok = c.locate(key); !ok {
 		return
 	}
-	if c.alias {
-		if addr, ok = c.aliasMap[addr]; !ok {
-			return
-		}
-	}
 	ncp, ok = c.nodePipe[addr]
 	return
 }
 
 func (c *connections) getPipesContext(key []byte) (ctx *nodeConnPipeContext, ok bool) {
 	var addr string
-	if addr, ok = c.ring.GetNode(key); !ok {
+	if addr, ok = c.locate(key); !ok {
 		return
 	}
-	if c.alias {
-		if addr, ok = c.aliasMap[addr]; !ok {
-			return
-		}
-	}
 	ncp, ok := c.nodePipe[addr]
 	if !ok {
 		return
@@ -295,7 +405,7 @@ func (c *connections) startPinger() {
 		return
 	}
 	for idx, addr := range c.addrs {
-		p := &pinger{cc: c.cc, addr: addr, alias: addr, weight: c.ws[idx]}
+		p := &pinger{cc: c.cc, addr: addr, alias: addr, weight: c.ws[idx], health: c.nodePipe[addr].Health()}
 		if c.alias {
 			p.alias = c.ans[idx]
 		}
@@ -326,10 +436,14 @@ func (c *connections) processPing(p *pinger) {
 		default:
 			err = p.ping.Ping()
 			if err == nil {
+				p.health.Success()
 				p.failure = 0
 				if del {
 					del = false
 					c.ring.AddNode(p.alias, p.weight)
+					if prom.On {
+						prom.RingNode(c.cc.Name, p.addr, true)
+					}
 					if log.V(4) {
 						log.Infof("node ping node:%s addr:%s success and readd", p.alias, p.addr)
 					}
@@ -338,6 +452,7 @@ func (c *connections) processPing(p *pinger) {
 				continue
 			} else {
 				_ = p.ping.Close()
+				p.health.Failure(err)
 				if prom.On {
 					prom.ErrIncr(c.cc.Name, p.addr, "ping", "network err")
 				}
@@ -356,6 +471,7 @@ func (c *connections) processPing(p *pinger) {
 				c.ring.DelNode(p.alias)
 				if prom.On {
 					prom.ErrIncr(c.cc.Name, p.addr, "ping", "del node")
+					prom.RingNode(c.cc.Name, p.addr, false)
 				}
 				del = true
 				if log.V(2) {
@@ -376,6 +492,7 @@ type pinger struct {
 	addr   string
 	alias  string // NOTE: default is addr
 	weight int
+	health *proto.NodeHealth
 
 	failure int
 }
@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"overlord/pkg/mockconn"
+	libnet "overlord/pkg/net"
+	"overlord/pkg/types"
+	"overlord/proxy/proto"
+	"overlord/proxy/proto/redis"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForwarderCrossNode(t *testing.T) {
+	cc := &ClusterConfig{
+		Name:      "cross-node",
+		HashTag:   "{}",
+		CacheType: types.CacheTypeRedis,
+		Servers:   []string{"127.0.0.1:1:1", "127.0.0.1:2:1"},
+	}
+	cc.SetDefault()
+	f := newDefaultForwarder(cc).(*defaultForwarder)
+	defer f.Close()
+	conns := f.conns.Load().(*connections)
+	// NOTE: find the keys located to different nodes.
+	a, _ := conns.locate([]byte("a"))
+	b := "b"
+	for i := 0; ; i++ {
+		b = fmt.Sprintf("b%d", i)
+		if addr, _ := conns.locate([]byte(b)); addr != a {
+			break
+		}
+	}
+	bulk := func(args ...string) string {
+		s := fmt.Sprintf("*%d\r\n", len(args))
+		for _, arg := range args {
+			s += fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg)
+		}
+		return s
+	}
+	decode := func(data string) *proto.Message {
+		pc := redis.NewProxyConn(libnet.NewConn(mockconn.CreateConn([]byte(data), 1), time.Second, time.Second), true)
+		msgs, err := pc.Decode(proto.GetMsgs(1))
+		assert.NoError(t, err)
+		return msgs[0]
+	}
+	for _, tc := range []struct {
+		args        []string
+		first, diff string
+	}{
+		{[]string{"BITOP", "AND", "a", b}, "a", b},
+		{[]string{"ZUNION", "2", "a", b}, "a", b},
+		{[]string{"ZINTERSTORE", "a", "2", "a", b}, "a", b},
+		{[]string{"SUNIONSTORE", b, "a"}, b, "a"},
+		{[]string{"RPOPLPUSH", "a", b}, "a", b},
+		{[]string{"COPY", "a", b}, "a", b},
+		{[]string{"LMOVE", "a", b, "LEFT", "RIGHT"}, "a", b},
+		{[]string{"ZRANGESTORE", "a", b, "0", "-1"}, "a", b},
+		{[]string{"GEOSEARCHSTORE", "a", b, "FROMMEMBER", "m", "BYRADIUS", "1", "km"}, "a", b},
+		{[]string{"GEORADIUS", "a", "1", "2", "3", "km", "STORE", b}, "a", b},
+		{[]string{"SORT", "a", "STORE", b}, "a", b},
+	} {
+		m := decode(bulk(tc.args...))
+		assert.NoError(t, f.Forward([]*proto.Message{m}))
+		assert.EqualError(t, m.Err(), fmt.Sprintf("CROSSNODE Keys in request don't hash to the same node: %s %s", tc.first, tc.diff), tc.args[0])
+	}
+	m := decode(bulk("BITOP", "AND", "{"+b+"}:d", "{"+b+"}:s", b))
+	assert.NoError(t, f.crossNode(conns, m.Request()), "same node by hash tag")
+}
+
+func TestTrimHashTag(t *testing.T) {
+	for _, tc := range []struct {
+		key, compat, expect string
+	}{
+		{"{a}b", "", "a"},
+		{"{}b", "", ""},
+		{"{b", "", "{b"},
+		{"{a}b", "twemproxy", "a"},
+		{"{}b", "twemproxy", "{}b"},
+		{"{b", "twemproxy", "{b"},
+	} {
+		assert.Equal(t, tc.expect, string(trimHashTag([]byte("{}"), []byte(tc.key), tc.compat)), tc.key+" "+tc.compat)
+	}
+}
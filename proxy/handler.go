@@ -1,6 +1,7 @@
 package proxy
 
 import (
+	"context"
 	"io"
 	"net"
 	"sync"
@@ -11,12 +12,15 @@ import (
 	libnet "overlord/pkg/net"
 	"overlord/pkg/prom"
 	"overlord/pkg/types"
+	"overlord/proxy/accesslog"
+	"overlord/proxy/nearcache"
 	"overlord/proxy/proto"
 	"overlord/proxy/proto/memcache"
 	mcbin "overlord/proxy/proto/memcache/binary"
 	"overlord/proxy/proto/redis"
 	rclstr "overlord/proxy/proto/redis/cluster"
 	"overlord/proxy/slowlog"
+	"overlord/proxy/trace"
 
 	"github.com/pkg/errors"
 )
@@ -26,26 +30,51 @@ const (
 	handlerClosed  = int32(1)
 )
 
-// variables need to change
-var (
-	// TODO: config and reduce to small
-	concurrent    = 2
-	maxConcurrent = 1024
+// states of handler in shutdown.
+const (
+	handlerIdle    = int32(0)
+	handlerBusy    = int32(1)
+	handlerClosing = int32(2)
 )
 
 // Handler handle conn.
 type Handler struct {
-	p  *Proxy
-	cc *ClusterConfig
+	p    *Proxy
+	cc   *ClusterConfig
+	id   uint64
+	addr string
+
+	// stats of client
+	name    atomic.Value // string
+	lastCmd atomic.Value // string
+	ctime   time.Time
+	atime   int64 // unix nano of last command
+	ops     uint64
+	netIn   uint64
+	netOut  uint64
+	// killed is set by CLIENT KILL of itself and the handler is closed after reply flushed.
+	killed bool
 
 	slog       slowlog.Handler
 	slowerThan time.Duration
 
+	ncache *nearcache.Cache
+	fmsgs  []*proto.Message
+
+	tracer *trace.Tracer
+	spans  []*trace.Span
+
+	alog *accesslog.Recorder
+
 	forwarder proto.Forwarder
+	// ctx is canceled when the client is closed, so are the blocking commands of it.
+	ctx    context.Context
+	cancel context.CancelFunc
 
 	conn *libnet.Conn
 	pc   proto.ProxyConn
 
+	state  int32
 	closed int32
 	err    error
 }
@@ -55,15 +84,29 @@ func NewHandler(p *Proxy, cc *ClusterConfig, conn net.Conn, forwarder proto.Forw
 	h = &Handler{
 		p:         p,
 		cc:        cc,
+		id:        atomic.AddUint64(&p.connID, 1),
+		addr:      conn.RemoteAddr().String(),
+		ctime:     time.Now(),
 		forwarder: forwarder,
+		tracer:    p.tracer,
 	}
+	h.ctx, h.cancel = context.WithCancel(context.Background())
 
 	if cc.SlowlogSlowerThan != 0 {
 		h.slowerThan = time.Duration(cc.SlowlogSlowerThan) * time.Microsecond
 		h.slog = slowlog.Get(cc.Name)
 	}
+	if cc.NearCacheTTL > 0 {
+		h.ncache = nearcache.Get(cc.Name)
+	}
 
 	h.conn = libnet.NewConn(conn, time.Second*time.Duration(h.p.c.Proxy.ReadTimeout), time.Second*time.Duration(h.p.c.Proxy.WriteTimeout))
+	h.conn.WithStat(h.stat)
+	if cc.AccessLogSampleRate > 0 {
+		if l := accesslog.Get(cc.Name); l != nil {
+			h.alog = l.NewRecorder(h.addr, h.id)
+		}
+	}
 	// cache type
 	switch cc.CacheType {
 	case types.CacheTypeMemcache:
@@ -77,6 +120,13 @@ func NewHandler(p *Proxy, cc *ClusterConfig, conn net.Conn, forwarder proto.Forw
 	default:
 		panic(types.ErrNoSupportCacheType)
 	}
+	if pc, ok := h.pc.(interface{ WithClienter(redis.Clienter) }); ok {
+		pc.WithClienter(h)
+	}
+	if pc, ok := h.pc.(interface{ WithScripts(*redis.ScriptCache) }); ok {
+		pc.WithScripts(redis.Scripts(cc.Name))
+	}
+	p.clients.add(h)
 	prom.ConnIncr(cc.Name)
 	return
 }
@@ -89,36 +139,62 @@ func (h *Handler) Handle() {
 
 func (h *Handler) handle() {
 	var (
-		messages []*proto.Message
-		msgs     []*proto.Message
-		wg       = &sync.WaitGroup{}
-		err      error
+		msgs []*proto.Message
+		wg   = &sync.WaitGroup{}
+		b    = newBatcher(h.cc.BatchMin, h.cc.BatchMax, wg)
+		err  error
 	)
-	messages = h.allocMaxConcurrent(wg, messages, len(msgs))
 	for {
 		// 1. read until limit or error
-		if msgs, err = h.pc.Decode(messages); err != nil {
-			h.deferHandle(messages, err)
+		if msgs, err = h.pc.Decode(b.msgs); err != nil {
+			h.deferHandle(b, err)
+			return
+		}
+		if !atomic.CompareAndSwapInt32(&h.state, handlerIdle, handlerBusy) {
+			// NOTE: closed as idle by shutdown
+			h.deferHandle(b, ErrProxyShutdown)
+			return
+		}
+		if h.p.isDraining() {
+			h.reject(msgs, ErrProxyShutdown)
+			h.deferHandle(b, ErrProxyShutdown)
 			return
 		}
+		h.touch(msgs)
 		// 2. send to cluster
-		h.forwarder.Forward(msgs)
-		wg.Wait()
+		if h.alog != nil {
+			h.alog.Sample(msgs)
+		}
+		blocking := h.blocking(msgs)
+		if h.ncache != nil {
+			h.fmsgs = h.ncache.Lookup(msgs, h.fmsgs[:0])
+			h.forwarder.Forward(h.fmsgs)
+			h.wait(wg, blocking)
+			h.ncache.Store(h.fmsgs)
+		} else {
+			h.forwarder.Forward(msgs)
+			h.wait(wg, blocking)
+		}
 		// 3. encode
 		for _, msg := range msgs {
 			msg.MarkEndPipe()
 			if err = h.pc.Encode(msg); err != nil {
 				h.pc.Flush()
-				h.deferHandle(messages, err)
+				h.deferHandle(b, err)
 				return
 			}
 			msg.MarkEnd()
 			if prom.On {
 				prom.ProxyTime(h.cc.Name, msg.Request().CmdString(), int64(msg.TotalDur()/time.Microsecond))
+				proto.MetricsPipe(h.cc.Name, msg)
 			}
 		}
 		if err = h.pc.Flush(); err != nil {
-			h.deferHandle(messages, err)
+			h.deferHandle(b, err)
+			return
+		}
+		if h.killed {
+			h.deferHandle(b, ErrProxyClientKilled)
 			return
 		}
 
@@ -130,35 +206,52 @@ func (h *Handler) handle() {
 				}
 			}
 		}
+		if h.tracer != nil {
+			h.trace(msgs)
+		}
+		if h.alog != nil {
+			h.alog.Record()
+		}
 
 		for _, msg := range msgs {
 			msg.ResetSubs()
 			msg.Reset()
 		}
-		// 5. alloc MaxConcurrent
-		messages = h.allocMaxConcurrent(wg, messages, len(msgs))
+		// NOTE: the batch is finished and close self if shutdown.
+		atomic.StoreInt32(&h.state, handlerIdle)
+		if h.p.isDraining() {
+			h.deferHandle(b, ErrProxyShutdown)
+			return
+		}
+		// 5. resize batch by pipeline depth
+		b.observe(len(msgs))
 	}
 }
 
-func (h *Handler) allocMaxConcurrent(wg *sync.WaitGroup, msgs []*proto.Message, lastCount int) []*proto.Message {
-	var alloc int
-	if msgsLength := len(msgs); msgsLength == 0 {
-		alloc = concurrent
-	} else if msgsLength < maxConcurrent && msgsLength == lastCount {
-		alloc = msgsLength * concurrent
-	}
-	if alloc > 0 {
-		proto.PutMsgs(msgs)
-		msgs = proto.GetMsgs(alloc) // TODO: change the msgs by lastCount trending
-		for _, msg := range msgs {
-			msg.WithWaitGroup(wg)
+// reject replies the messages with err without forwarding.
+func (h *Handler) reject(msgs []*proto.Message, err error) {
+	for _, msg := range msgs {
+		msg.WithError(err)
+		if h.pc.Encode(msg) != nil {
+			break
 		}
 	}
-	return msgs
+	_ = h.pc.Flush()
+	for _, msg := range msgs {
+		msg.ResetSubs()
+		msg.Reset()
+	}
+}
+
+// closeIdle closes the handler if it is waiting commands from client.
+func (h *Handler) closeIdle() {
+	if atomic.CompareAndSwapInt32(&h.state, handlerIdle, handlerClosing) {
+		h.closeWithError(ErrProxyShutdown)
+	}
 }
 
-func (h *Handler) deferHandle(msgs []*proto.Message, err error) {
-	proto.PutMsgs(msgs)
+func (h *Handler) deferHandle(b *batcher, err error) {
+	b.release()
 	h.closeWithError(err)
 	return
 }
@@ -166,7 +259,9 @@ func (h *Handler) deferHandle(msgs []*proto.Message, err error) {
 func (h *Handler) closeWithError(err error) {
 	if atomic.CompareAndSwapInt32(&h.closed, handlerOpening, handlerClosed) {
 		h.err = err
+		h.cancel()
 		_ = h.conn.Close()
+		h.p.clients.del(h)
 		atomic.AddInt32(&h.p.conns, -1) // NOTE: decr!!!
 		if err == proto.ErrQuit {
 			return
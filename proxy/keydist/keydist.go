@@ -0,0 +1,130 @@
+// Package keydist simulates how keys are distributed by the hash ring of cluster.
+package keydist
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"text/tabwriter"
+
+	"overlord/proxy"
+)
+
+// NodeShare is the keys located into the node.
+type NodeShare struct {
+	Addr   string  `json:"addr"`
+	Weight int     `json:"weight"`
+	Keys   int     `json:"keys"`
+	Share  float64 `json:"share"`
+	Expect float64 `json:"expect"`
+}
+
+// Report is the result of simulation.
+type Report struct {
+	Keys   int          `json:"keys"`
+	Nodes  []*NodeShare `json:"nodes"`
+	Stddev float64      `json:"stddev"`
+
+	Proposed       []*NodeShare `json:"proposed,omitempty"`
+	ProposedStddev float64      `json:"proposed_stddev,omitempty"`
+	Moved          int          `json:"moved,omitempty"`
+	MovedPercent   float64      `json:"moved_percent,omitempty"`
+}
+
+// Simulator hashes keys by the current locator and the proposed one.
+type Simulator struct {
+	cur, next   *proxy.Locator
+	curKeys     map[string]int
+	nextKeys    map[string]int
+	keys, moved int
+}
+
+// New new a simulator, next is the locator of proposed servers and may be nil.
+func New(cur, next *proxy.Locator) *Simulator {
+	return &Simulator{
+		cur:      cur,
+		next:     next,
+		curKeys:  make(map[string]int),
+		nextKeys: make(map[string]int),
+	}
+}
+
+// Add hashes the key.
+func (s *Simulator) Add(key []byte) {
+	s.keys++
+	addr, _ := s.cur.Locate(key)
+	s.curKeys[addr]++
+	if s.next == nil {
+		return
+	}
+	naddr, _ := s.next.Locate(key)
+	s.nextKeys[naddr]++
+	if naddr != addr {
+		s.moved++
+	}
+}
+
+// Report reports the share of nodes and the moved keys.
+func (s *Simulator) Report() *Report {
+	r := &Report{Keys: s.keys}
+	r.Nodes, r.Stddev = shares(s.cur, s.curKeys, s.keys)
+	if s.next != nil {
+		r.Proposed, r.ProposedStddev = shares(s.next, s.nextKeys, s.keys)
+		r.Moved = s.moved
+		r.MovedPercent = percent(s.moved, s.keys)
+	}
+	return r
+}
+
+// shares return the shares of nodes and the standard deviation of the shares from the expected ones by weight.
+func shares(l *proxy.Locator, counts map[string]int, keys int) (nodes []*NodeShare, stddev float64) {
+	var total int
+	for _, w := range l.Weights() {
+		total += w
+	}
+	var sum float64
+	for idx, addr := range l.Addrs() {
+		ns := &NodeShare{
+			Addr:   addr,
+			Weight: l.Weights()[idx],
+			Keys:   counts[addr],
+			Share:  percent(counts[addr], keys),
+			Expect: percent(l.Weights()[idx], total),
+		}
+		sum += (ns.Share - ns.Expect) * (ns.Share - ns.Expect)
+		nodes = append(nodes, ns)
+	}
+	if len(nodes) > 0 {
+		stddev = math.Sqrt(sum / float64(len(nodes)))
+	}
+	return
+}
+
+func percent(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) * 100 / float64(total)
+}
+
+// Print prints the report as tables.
+func (r *Report) Print(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	printShares(tw, "current", r.Nodes, r.Stddev)
+	if r.Proposed != nil {
+		fmt.Fprintln(tw)
+		printShares(tw, "proposed", r.Proposed, r.ProposedStddev)
+		fmt.Fprintln(tw)
+		fmt.Fprintf(tw, "moved keys: %d/%d (%.2f%%)\n", r.Moved, r.Keys, r.MovedPercent)
+	}
+	tw.Flush()
+}
+
+func printShares(w io.Writer, title string, nodes []*NodeShare, stddev float64) {
+	fmt.Fprintf(w, "%s servers:\n", title)
+	fmt.Fprintln(w, "ADDR\tWEIGHT\tKEYS\tSHARE\tEXPECT\t")
+	for _, ns := range nodes {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%.2f%%\t%.2f%%\t\n", ns.Addr, ns.Weight, ns.Keys, ns.Share, ns.Expect)
+	}
+	fmt.Fprintf(w, "stddev of share: %.4f%%\n", stddev)
+}
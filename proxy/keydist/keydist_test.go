@@ -0,0 +1,102 @@
+package keydist
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"overlord/proxy"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func _cc() *proxy.ClusterConfig {
+	cc := &proxy.ClusterConfig{
+		Name:             "test",
+		HashMethod:       "fnv1a_64",
+		HashDistribution: "ketama",
+		HashTag:          "{}",
+		Servers: []string{
+			"127.0.0.1:7001:1 node1",
+			"127.0.0.1:7002:1 node2",
+			"127.0.0.1:7003:1 node3",
+			"127.0.0.1:7004:1 node4",
+		},
+	}
+	return cc
+}
+
+func TestSimulatorBalance(t *testing.T) {
+	cur, err := proxy.NewLocator(_cc(), nil)
+	assert.NoError(t, err)
+	s := New(cur, nil)
+	assert.NoError(t, GenerateKeys("test", 100000, s.Add))
+	r := s.Report()
+	assert.Equal(t, 100000, r.Keys)
+	assert.Len(t, r.Nodes, 4)
+	var keys int
+	for _, ns := range r.Nodes {
+		keys += ns.Keys
+		assert.Equal(t, 25.0, ns.Expect)
+		assert.InDelta(t, 25.0, ns.Share, 5.0)
+	}
+	assert.Equal(t, 100000, keys)
+	assert.True(t, r.Stddev < 5.0)
+	assert.Nil(t, r.Proposed)
+}
+
+func TestSimulatorAddNode(t *testing.T) {
+	cc := _cc()
+	cur, err := proxy.NewLocator(cc, nil)
+	assert.NoError(t, err)
+	next, err := proxy.NewLocator(cc, append(cc.Servers, "127.0.0.1:7005:1 node5"))
+	assert.NoError(t, err)
+	s := New(cur, next)
+	assert.NoError(t, GenerateKeys("test", 100000, s.Add))
+	r := s.Report()
+	assert.Len(t, r.Proposed, 5)
+	// NOTE: about 1/5 keys move into the new node only
+	assert.InDelta(t, 20.0, r.MovedPercent, 3.0)
+	assert.Equal(t, r.Moved, r.Proposed[4].Keys)
+
+	buf := &bytes.Buffer{}
+	r.Print(buf)
+	assert.Contains(t, buf.String(), "proposed servers:")
+	assert.Contains(t, buf.String(), "moved keys:")
+}
+
+func TestSimulatorReplaceAlias(t *testing.T) {
+	cc := _cc()
+	cur, err := proxy.NewLocator(cc, nil)
+	assert.NoError(t, err)
+	servers := append([]string{}, cc.Servers...)
+	// NOTE: keys are hashed by alias, replacing the addr moves no key
+	servers[0] = "127.0.0.2:7001:1 node1"
+	next, err := proxy.NewLocator(cc, servers)
+	assert.NoError(t, err)
+	s := New(cur, next)
+	assert.NoError(t, ReadKeys(strings.NewReader("a\nb\r\n\nc{tag}\nd{tag}\n"), s.Add))
+	r := s.Report()
+	assert.Equal(t, 4, r.Keys)
+	assert.Equal(t, r.Nodes[0].Keys, r.Moved)
+}
+
+func TestReadRDB(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		keys int
+	}{
+		{"regular_set", 1},
+		{"keys_with_expiry", 1},
+		{"empty_database", 0},
+		{"integer_keys", 6},
+	} {
+		f, err := os.Open("../../anzi/dumps/" + tc.name + ".rdb")
+		assert.NoError(t, err)
+		var keys []string
+		assert.NoError(t, ReadRDB(f, func(key []byte) { keys = append(keys, string(key)) }), tc.name)
+		f.Close()
+		assert.Len(t, keys, tc.keys, tc.name)
+	}
+}
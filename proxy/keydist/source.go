@@ -0,0 +1,74 @@
+package keydist
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+
+	"overlord/anzi"
+
+	"github.com/pkg/errors"
+)
+
+// ReadKeys reads keys one per line.
+func ReadKeys(r io.Reader, fn func(key []byte)) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		if key := bytes.TrimRight(sc.Bytes(), "\r"); len(key) > 0 {
+			fn(key)
+		}
+	}
+	return errors.WithStack(sc.Err())
+}
+
+// GenerateKeys generates n keys as "{prefix}-{random hex}-{seq}".
+func GenerateKeys(prefix string, n int, fn func(key []byte)) error {
+	rd := make([]byte, 8)
+	for i := 0; i < n; i++ {
+		if _, err := rand.Read(rd); err != nil {
+			return errors.WithStack(err)
+		}
+		fn([]byte(fmt.Sprintf("%s-%s-%010d", prefix, hex.EncodeToString(rd), i)))
+	}
+	return nil
+}
+
+// ReadRDB reads keys from the redis rdb file.
+func ReadRDB(r io.Reader, fn func(key []byte)) error {
+	cb := &rdbKeys{fn: fn}
+	_, err := anzi.NewRDB(bufio.NewReader(r), cb).Sync()
+	return errors.WithStack(err)
+}
+
+// rdbKeys calls fn once for each key of rdb, the elements of one key are called in sequence.
+type rdbKeys struct {
+	fn   func(key []byte)
+	last []byte
+}
+
+func (r *rdbKeys) key(key []byte) {
+	if r.last != nil && bytes.Equal(r.last, key) {
+		return
+	}
+	r.last = append(r.last[:0], key...)
+	r.fn(key)
+}
+
+func (r *rdbKeys) SelectDB(dbnum uint64)       {}
+func (r *rdbKeys) AuxField(key, data []byte)   {}
+func (r *rdbKeys) ResizeDB(size, esize uint64) {}
+func (r *rdbKeys) EndOfRDB()                   {}
+func (r *rdbKeys) GetConn() net.Conn           { return nil }
+
+func (r *rdbKeys) CmdSet(key, val []byte, expire uint64)         { r.key(key) }
+func (r *rdbKeys) CmdRPush(key, val []byte)                      { r.key(key) }
+func (r *rdbKeys) CmdSAdd(key, val []byte)                       { r.key(key) }
+func (r *rdbKeys) CmdZAdd(key []byte, score float64, val []byte) { r.key(key) }
+func (r *rdbKeys) CmdHSet(key, field, value []byte)              { r.key(key) }
+func (r *rdbKeys) CmdHSetInt(key, field []byte, value int64)     { r.key(key) }
+func (r *rdbKeys) ExpireAt(key []byte, expiry uint64)            {}
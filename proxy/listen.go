@@ -3,19 +3,70 @@ package proxy
 import (
 	"net"
 	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"overlord/pkg/log"
 
 	"github.com/pkg/errors"
 )
 
-// Listen listen.
-func Listen(proto string, addr string) (net.Listener, error) {
-	switch proto {
-	case "tcp":
-		return listenTCP(addr)
-	case "unix":
-		return listenUnix(addr)
+// EnvListeners is the env of listeners inherited from the parent process,
+// which are "proto://addr" separated by comma in order of fds from 3.
+const EnvListeners = "OVERLORD_LISTENERS"
+
+// listenFdStart is the first fd of ExtraFiles in the child process.
+const listenFdStart = 3
+
+type listener struct {
+	key string
+	l   net.Listener
+}
+
+var (
+	listeners   []*listener
+	inherited   map[string]*os.File
+	listenLock  sync.Mutex
+	inheritOnce sync.Once
+)
+
+// Listen listen, the listener inherited from the parent process is used if exists.
+func Listen(proto string, addr string) (l net.Listener, err error) {
+	key := proto + "://" + addr
+	if l, err = inheritListener(key); err != nil {
+		return
+	}
+	if l == nil {
+		switch proto {
+		case "tcp":
+			l, err = listenTCP(addr)
+		case "unix":
+			l, err = listenUnix(addr)
+		default:
+			return nil, errors.New("no support proto")
+		}
+		if err != nil {
+			return
+		}
+	}
+	listenLock.Lock()
+	listeners = append(listeners, &listener{key: key, l: l})
+	listenLock.Unlock()
+	return
+}
+
+// closeListener closes l and removes it from the listeners inherited by upgrade.
+func closeListener(l net.Listener) error {
+	listenLock.Lock()
+	for i, ln := range listeners {
+		if ln.l == l {
+			listeners = append(listeners[:i], listeners[i+1:]...)
+			break
+		}
 	}
-	return nil, errors.New("no support proto")
+	listenLock.Unlock()
+	return l.Close()
 }
 
 func listenTCP(addr string) (net.Listener, error) {
@@ -37,3 +88,86 @@ func listenUnix(addr string) (net.Listener, error) {
 	}
 	return net.ListenUnix("unix", unixAddr)
 }
+
+// inheritListener return the listener inherited by key, nil if not inherited.
+func inheritListener(key string) (net.Listener, error) {
+	inheritOnce.Do(func() {
+		inherited = map[string]*os.File{}
+		env := os.Getenv(EnvListeners)
+		if env == "" {
+			return
+		}
+		for i, k := range strings.Split(env, ",") {
+			inherited[k] = os.NewFile(uintptr(listenFdStart+i), k)
+		}
+	})
+	listenLock.Lock()
+	f, ok := inherited[key]
+	delete(inherited, key)
+	listenLock.Unlock()
+	if !ok {
+		return nil, nil
+	}
+	defer f.Close()
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Proxy inherit listener %s", key)
+	}
+	if ul, ok := l.(*net.UnixListener); ok {
+		// NOTE: the socket file is created by the parent process and removed by whom closes it at last.
+		ul.SetUnlinkOnClose(true)
+	}
+	log.Infof("overlord proxy inherit listener %s", key)
+	return l, nil
+}
+
+// Upgrade starts a new process of the same executable and args,
+// which inherits all listeners by ExtraFiles and serves them at the same time.
+func Upgrade() (*os.Process, error) {
+	listenLock.Lock()
+	defer listenLock.Unlock()
+	var (
+		keys  = make([]string, 0, len(listeners))
+		files = make([]*os.File, 0, len(listeners))
+	)
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, ln := range listeners {
+		var (
+			f   *os.File
+			err error
+		)
+		switch l := ln.l.(type) {
+		case *net.TCPListener:
+			f, err = l.File()
+		case *net.UnixListener:
+			// NOTE: the socket file is still used by the new process after closed.
+			l.SetUnlinkOnClose(false)
+			f, err = l.File()
+		default:
+			err = errors.Errorf("listener %s can't be inherited", ln.key)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "Proxy upgrade listener %s", ln.key)
+		}
+		keys = append(keys, ln.key)
+		files = append(files, f)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(os.Environ(), EnvListeners+"="+strings.Join(keys, ","))
+	if err = cmd.Start(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	log.Infof("overlord proxy upgrade to process %d with listeners %v", cmd.Process.Pid, keys)
+	return cmd.Process, nil
+}
@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListenInherit(t *testing.T) {
+	l0, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := l0.Addr().String()
+	f, err := l0.(*net.TCPListener).File()
+	assert.NoError(t, err)
+	l0.Close()
+
+	inheritOnce.Do(func() { inherited = map[string]*os.File{} })
+	listenLock.Lock()
+	inherited["tcp://"+addr] = f
+	listenLock.Unlock()
+
+	l, err := Listen("tcp", addr)
+	assert.NoError(t, err)
+	defer l.Close()
+	assert.Equal(t, addr, l.Addr().String())
+	go func() {
+		if conn, err := l.Accept(); err == nil {
+			conn.Close()
+		}
+	}()
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	assert.NoError(t, err)
+	conn.Close()
+
+	// NOTE: the inherited listener is used once.
+	listenLock.Lock()
+	assert.Len(t, inherited, 0)
+	listenLock.Unlock()
+}
+
+func TestShutdown(t *testing.T) {
+	p := &Proxy{clients: newClients(), listeners: map[string]net.Listener{}}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	p.listeners["test"] = l
+
+	idle := _handler(p, "test", 1, "127.0.0.1:5001")
+	busy := _handler(p, "test", 2, "127.0.0.1:5002")
+	busy.state = handlerBusy
+	done := _handler(p, "test", 3, "127.0.0.1:5003")
+	done.state = handlerBusy
+	go func() {
+		// NOTE: the batch is finished and the handler closes itself.
+		time.Sleep(20 * time.Millisecond)
+		done.closeWithError(ErrProxyShutdown)
+	}()
+
+	start := time.Now()
+	p.Shutdown(100 * time.Millisecond)
+	assert.True(t, time.Since(start) >= 100*time.Millisecond)
+	assert.True(t, p.isDraining())
+	assert.True(t, p.closed)
+	assert.Equal(t, handlerClosing, idle.state)
+	assert.Equal(t, ErrProxyShutdown, idle.err)
+	assert.Equal(t, ErrProxyClientKilled, busy.err)
+	assert.Equal(t, ErrProxyShutdown, done.err)
+	assert.Equal(t, 0, p.clients.count())
+
+	_, err = l.Accept()
+	assert.Error(t, err)
+}
@@ -0,0 +1,46 @@
+package proxy
+
+// Locator locates keys into backend addrs by the hash ring of cluster config,
+// which is built just as the forwarder of memcache and redis does.
+type Locator struct {
+	*nodeRing
+	hashTag []byte
+	compat  string
+	addrs   []string
+	ws      []int
+}
+
+// NewLocator new a locator of cluster config, servers will replace the servers of config if not empty.
+func NewLocator(cc *ClusterConfig, servers []string) (*Locator, error) {
+	if len(servers) == 0 {
+		servers = cc.Servers
+	}
+	addrs, ws, ans, alias, err := parseServers(servers)
+	if err != nil {
+		return nil, err
+	}
+	l := &Locator{
+		nodeRing: newNodeRing(cc),
+		hashTag:  []byte(cc.HashTag),
+		compat:   cc.HashCompat,
+		addrs:    addrs,
+		ws:       ws,
+	}
+	l.init(addrs, ans, ws, alias)
+	return l, nil
+}
+
+// Locate return the backend addr of key.
+func (l *Locator) Locate(key []byte) (string, bool) {
+	return l.locate(trimHashTag(l.hashTag, key, l.compat))
+}
+
+// Addrs return the backend addrs in order of servers.
+func (l *Locator) Addrs() []string {
+	return l.addrs
+}
+
+// Weights return the weights of backend addrs.
+func (l *Locator) Weights() []int {
+	return l.ws
+}
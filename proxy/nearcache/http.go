@@ -0,0 +1,51 @@
+package nearcache
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// showStats will show near cache stats of all clusters to http
+func showStats(w http.ResponseWriter, _req *http.Request) {
+	cacheLock.RLock()
+	var stats = make([]*Stats, 0, len(cacheMap))
+	for _, c := range cacheMap {
+		stats = append(stats, c.Stats())
+	}
+	cacheLock.RUnlock()
+
+	encoder := json.NewEncoder(w)
+	if err := encoder.Encode(stats); err != nil {
+		http.Error(w, fmt.Sprintf("%s", err), http.StatusInternalServerError)
+	}
+}
+
+// flush will flush the near cache of the given cluster or all clusters
+func flush(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := req.URL.Query().Get("cluster")
+	cacheLock.RLock()
+	defer cacheLock.RUnlock()
+	if name == "" {
+		for _, c := range cacheMap {
+			c.Flush()
+		}
+		return
+	}
+	c, ok := cacheMap[name]
+	if !ok {
+		http.Error(w, fmt.Sprintf("near cache of cluster %s not found", name), http.StatusNotFound)
+		return
+	}
+	c.Flush()
+}
+
+// Init will register near cache by /nearcache and /nearcache/flush
+func Init() {
+	http.HandleFunc("/nearcache", showStats)
+	http.HandleFunc("/nearcache/flush", flush)
+}
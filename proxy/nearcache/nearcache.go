@@ -0,0 +1,431 @@
+package nearcache
+
+import (
+	"bytes"
+	"container/list"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"overlord/pkg/log"
+	"overlord/pkg/prom"
+	"overlord/proxy/proto"
+	"overlord/proxy/proto/redis"
+)
+
+const (
+	resultHit   = "hit"
+	resultMiss  = "miss"
+	resultEvict = "evict"
+
+	hotWindow     = time.Second
+	hotMaxTracked = 65536
+)
+
+var (
+	cmdGetBytes  = []byte("3\r\nGET")
+	cmdMGetBytes = []byte("4\r\nMGET")
+	cmdHGetBytes = []byte("4\r\nHGET")
+)
+
+// Config is the near cache config of one cluster.
+type Config struct {
+	TTL          time.Duration
+	MaxKeys      int
+	MaxBytes     int64
+	Patterns     []string
+	HotThreshold int
+}
+
+// Stats is the snapshot of near cache counters.
+type Stats struct {
+	Cluster string `json:"cluster"`
+	Keys    int    `json:"keys"`
+	Bytes   int64  `json:"bytes"`
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+	Evicts  uint64 `json:"evicts"`
+}
+
+// Cache is a bounded in-memory LRU cache of redis read replies.
+// Entries are grouped by redis key so that any write of the key
+// through the proxy invalidates all cached replies of it.
+type Cache struct {
+	name string
+	conf *Config
+
+	lock  sync.Mutex
+	lru   *list.List
+	items map[string]*list.Element
+	bytes int64
+
+	hot *hotDetector
+
+	hits, misses, evicts uint64
+}
+
+type entry struct {
+	key  string
+	vals map[string]*value
+	size int64
+}
+
+type value struct {
+	reply  *redis.RESP
+	size   int64
+	expire time.Time
+}
+
+// New new a near cache by config.
+func New(name string, conf *Config) *Cache {
+	c := &Cache{
+		name:  name,
+		conf:  conf,
+		lru:   list.New(),
+		items: make(map[string]*list.Element),
+	}
+	if conf.HotThreshold > 0 {
+		c.hot = newHotDetector(conf.HotThreshold)
+	}
+	return c
+}
+
+// Lookup serves messages whose replies are all cached and appends the
+// remaining ones into dst which need to be forwarded to backend.
+func (c *Cache) Lookup(msgs []*proto.Message, dst []*proto.Message) []*proto.Message {
+	now := time.Now()
+	for _, m := range msgs {
+		if c.lookup(m, now) {
+			continue
+		}
+		dst = append(dst, m)
+	}
+	return dst
+}
+
+func (c *Cache) lookup(m *proto.Message, now time.Time) bool {
+	reqs := m.Requests()
+	if len(reqs) == 0 {
+		return false
+	}
+	var (
+		replies = make([]*redis.RESP, len(reqs))
+		hit     = true
+	)
+	c.lock.Lock()
+	for i, r := range reqs {
+		req, ok := r.(*redis.Request)
+		if !ok {
+			c.lock.Unlock()
+			return false
+		}
+		if req.IsWrite() {
+			c.invalidateWritten(req)
+			hit = false
+			continue
+		}
+		sub, ok := cacheKey(req)
+		if !ok {
+			hit = false
+			continue
+		}
+		key := string(req.Key())
+		if c.hot != nil {
+			c.hot.touch(key, now)
+		}
+		if !hit {
+			continue
+		}
+		if replies[i] = c.get(key, sub, now); replies[i] == nil {
+			hit = false
+		}
+	}
+	c.lock.Unlock()
+	if !hit {
+		atomic.AddUint64(&c.misses, 1)
+		if prom.On {
+			prom.NearCacheIncr(c.name, resultMiss)
+		}
+		return false
+	}
+	for i, r := range reqs {
+		r.(*redis.Request).Reply().Copy(replies[i])
+	}
+	if m.IsBatch() {
+		m.Batch() // NOTE: make subs for encode and reset
+	}
+	atomic.AddUint64(&c.hits, 1)
+	if prom.On {
+		prom.NearCacheIncr(c.name, resultHit)
+	}
+	return true
+}
+
+// Store caches the read replies of forwarded messages and invalidates keys
+// written by them.
+func (c *Cache) Store(msgs []*proto.Message) {
+	now := time.Now()
+	c.lock.Lock()
+	for _, m := range msgs {
+		if m.Err() != nil {
+			continue
+		}
+		for _, r := range m.Requests() {
+			req, ok := r.(*redis.Request)
+			if !ok || req.Merged() {
+				continue
+			}
+			c.store(req, now)
+		}
+	}
+	c.evict()
+	c.lock.Unlock()
+}
+
+func (c *Cache) store(req *redis.Request, now time.Time) {
+	if req.IsWrite() {
+		c.invalidateWritten(req)
+		return
+	}
+	sub, ok := cacheKey(req)
+	if !ok {
+		return
+	}
+	reply := req.Reply()
+	args := req.RESP().Array()
+	if bytes.Equal(args[0].Data(), cmdMGetBytes) {
+		// NOTE: sub requests of MGET may be merged into one by forwarder
+		if reply.Type() != '*' || len(reply.Array()) != len(args)-1 {
+			return
+		}
+		for i, rp := range reply.Array() {
+			key := string(args[i+1].ArgData())
+			if c.admit(key, now) {
+				c.set(key, sub, rp, now)
+			}
+		}
+		return
+	}
+	if reply.Type() == '-' || reply.Type() == '0' {
+		return
+	}
+	key := string(req.Key())
+	if c.admit(key, now) {
+		c.set(key, sub, reply, now)
+	}
+}
+
+func (c *Cache) admit(key string, now time.Time) bool {
+	for _, pattern := range c.conf.Patterns {
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+	}
+	return c.hot != nil && c.hot.isHot(key, now)
+}
+
+func (c *Cache) get(key, sub string, now time.Time) *redis.RESP {
+	elem, ok := c.items[key]
+	if !ok {
+		return nil
+	}
+	e := elem.Value.(*entry)
+	v, ok := e.vals[sub]
+	if !ok {
+		return nil
+	}
+	if now.After(v.expire) {
+		delete(e.vals, sub)
+		e.size -= v.size
+		c.bytes -= v.size
+		if len(e.vals) == 0 {
+			c.remove(elem)
+		}
+		return nil
+	}
+	c.lru.MoveToFront(elem)
+	return v.reply
+}
+
+func (c *Cache) set(key, sub string, reply *redis.RESP, now time.Time) {
+	v := &value{reply: &redis.RESP{}, expire: now.Add(c.conf.TTL)}
+	v.reply.Copy(reply)
+	v.size = int64(len(sub)) + respSize(reply)
+	var e *entry
+	if elem, ok := c.items[key]; ok {
+		e = elem.Value.(*entry)
+		c.lru.MoveToFront(elem)
+	} else {
+		e = &entry{key: key, vals: make(map[string]*value), size: int64(len(key))}
+		c.items[key] = c.lru.PushFront(e)
+		c.bytes += e.size
+	}
+	if old, ok := e.vals[sub]; ok {
+		e.size -= old.size
+		c.bytes -= old.size
+	}
+	e.vals[sub] = v
+	e.size += v.size
+	c.bytes += v.size
+}
+
+func (c *Cache) invalidate(key string) {
+	if elem, ok := c.items[key]; ok {
+		c.remove(elem)
+	}
+}
+
+// invalidateWritten invalidates all keys of write request, like the destination of STORE option.
+func (c *Cache) invalidateWritten(req *redis.Request) {
+	c.invalidate(string(req.Key()))
+	for _, key := range req.Keys() {
+		c.invalidate(string(key))
+	}
+}
+
+func (c *Cache) remove(elem *list.Element) {
+	e := elem.Value.(*entry)
+	c.lru.Remove(elem)
+	delete(c.items, e.key)
+	c.bytes -= e.size
+}
+
+func (c *Cache) evict() {
+	for c.lru.Len() > 0 {
+		if (c.conf.MaxKeys <= 0 || c.lru.Len() <= c.conf.MaxKeys) && (c.conf.MaxBytes <= 0 || c.bytes <= c.conf.MaxBytes) {
+			break
+		}
+		c.remove(c.lru.Back())
+		atomic.AddUint64(&c.evicts, 1)
+		if prom.On {
+			prom.NearCacheIncr(c.name, resultEvict)
+		}
+	}
+	if prom.On {
+		prom.NearCacheBytes(c.name, c.bytes)
+	}
+}
+
+// Flush drops all cached entries.
+func (c *Cache) Flush() {
+	c.lock.Lock()
+	c.lru.Init()
+	c.items = make(map[string]*list.Element)
+	c.bytes = 0
+	c.lock.Unlock()
+	if prom.On {
+		prom.NearCacheBytes(c.name, 0)
+	}
+	log.Infof("cluster(%s) near cache is flushed", c.name)
+}
+
+// Stats return the snapshot of cache counters.
+func (c *Cache) Stats() *Stats {
+	c.lock.Lock()
+	st := &Stats{Cluster: c.name, Keys: c.lru.Len(), Bytes: c.bytes}
+	c.lock.Unlock()
+	st.Hits = atomic.LoadUint64(&c.hits)
+	st.Misses = atomic.LoadUint64(&c.misses)
+	st.Evicts = atomic.LoadUint64(&c.evicts)
+	return st
+}
+
+// cacheKey return the sub key of cacheable read command under the redis key.
+func cacheKey(req *redis.Request) (string, bool) {
+	args := req.RESP().Array()
+	if len(args) < 2 {
+		return "", false
+	}
+	cmd := args[0].Data()
+	switch {
+	case bytes.Equal(cmd, cmdGetBytes) && len(args) == 2:
+		return "GET", true
+	case bytes.Equal(cmd, cmdMGetBytes):
+		// NOTE: MGET replies nil for the key of other types which GET replies WRONGTYPE.
+		return "MGET", true
+	case bytes.Equal(cmd, cmdHGetBytes) && len(args) == 3:
+		return "HGET " + string(args[2].ArgData()), true
+	}
+	return "", false
+}
+
+func respSize(r *redis.RESP) (size int64) {
+	size = int64(len(r.Data())) + 1
+	for _, sub := range r.Array() {
+		size += respSize(sub)
+	}
+	return
+}
+
+// hotDetector flags keys read at least threshold times in one window.
+type hotDetector struct {
+	threshold int
+	start     time.Time
+	counts    map[string]int
+	hots      map[string]struct{}
+	lastHots  map[string]struct{}
+}
+
+func newHotDetector(threshold int) *hotDetector {
+	return &hotDetector{
+		threshold: threshold,
+		counts:    make(map[string]int),
+		hots:      make(map[string]struct{}),
+		lastHots:  make(map[string]struct{}),
+	}
+}
+
+func (h *hotDetector) roll(now time.Time) {
+	if now.Sub(h.start) < hotWindow {
+		return
+	}
+	h.start = now
+	h.lastHots = h.hots
+	h.hots = make(map[string]struct{})
+	h.counts = make(map[string]int)
+}
+
+func (h *hotDetector) touch(key string, now time.Time) {
+	h.roll(now)
+	cnt, ok := h.counts[key]
+	if !ok && len(h.counts) >= hotMaxTracked {
+		return
+	}
+	cnt++
+	h.counts[key] = cnt
+	if cnt >= h.threshold {
+		h.hots[key] = struct{}{}
+	}
+}
+
+func (h *hotDetector) isHot(key string, now time.Time) bool {
+	h.roll(now)
+	if _, ok := h.hots[key]; ok {
+		return true
+	}
+	_, ok := h.lastHots[key]
+	return ok
+}
+
+var (
+	cacheMap  = map[string]*Cache{}
+	cacheLock sync.RWMutex
+)
+
+// Register create the near cache of cluster and replace the exists one.
+func Register(name string, conf *Config) *Cache {
+	c := New(name, conf)
+	cacheLock.Lock()
+	cacheMap[name] = c
+	cacheLock.Unlock()
+	return c
+}
+
+// Get return the near cache of cluster, nil if not registered.
+func Get(name string) *Cache {
+	cacheLock.RLock()
+	c := cacheMap[name]
+	cacheLock.RUnlock()
+	return c
+}
@@ -0,0 +1,152 @@
+package nearcache
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"overlord/pkg/bufio"
+	"overlord/pkg/mockconn"
+	libnet "overlord/pkg/net"
+	"overlord/proxy/proto"
+	"overlord/proxy/proto/redis"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func _decode(t *testing.T, data string) []*proto.Message {
+	conn := libnet.NewConn(mockconn.CreateConn([]byte(data), 1), time.Second, time.Second)
+	pc := redis.NewProxyConn(conn, true)
+	msgs, err := pc.Decode(proto.GetMsgs(4))
+	assert.NoError(t, err)
+	return msgs
+}
+
+func _reply(t *testing.T, req proto.Request, data string) {
+	br := bufio.NewReader(bytes.NewReader([]byte(data)), bufio.Get(1024))
+	assert.NoError(t, br.Read())
+	assert.NoError(t, req.(*redis.Request).Reply().Decode(br))
+}
+
+func _encode(t *testing.T, m *proto.Message) string {
+	conn, buf := mockconn.CreateDownStreamConn()
+	pc := redis.NewProxyConn(libnet.NewConn(conn, time.Second, time.Second), true)
+	assert.NoError(t, pc.Encode(m))
+	assert.NoError(t, pc.Flush())
+	return buf.String()
+}
+
+func TestCacheGetHitAndInvalidate(t *testing.T) {
+	c := New("test", &Config{TTL: time.Minute, MaxKeys: 16, Patterns: []string{"hot:*"}})
+	msgs := _decode(t, "*2\r\n$3\r\nGET\r\n$5\r\nhot:a\r\n")
+	fmsgs := c.Lookup(msgs, nil)
+	assert.Len(t, fmsgs, 1)
+	_reply(t, msgs[0].Request(), "$3\r\nabc\r\n")
+	c.Store(fmsgs)
+
+	msgs = _decode(t, "*2\r\n$3\r\nGET\r\n$5\r\nhot:a\r\n")
+	fmsgs = c.Lookup(msgs, nil)
+	assert.Len(t, fmsgs, 0)
+	assert.Equal(t, "$3\r\nabc\r\n", _encode(t, msgs[0]))
+
+	msgs = _decode(t, "*3\r\n$3\r\nSET\r\n$5\r\nhot:a\r\n$1\r\nb\r\n")
+	fmsgs = c.Lookup(msgs, nil)
+	assert.Len(t, fmsgs, 1)
+
+	msgs = _decode(t, "*2\r\n$3\r\nGET\r\n$5\r\nhot:a\r\n")
+	fmsgs = c.Lookup(msgs, nil)
+	assert.Len(t, fmsgs, 1)
+	st := c.Stats()
+	assert.Equal(t, uint64(1), st.Hits)
+	assert.Equal(t, uint64(3), st.Misses)
+}
+
+func TestCacheNotAllowed(t *testing.T) {
+	c := New("test", &Config{TTL: time.Minute, MaxKeys: 16, Patterns: []string{"hot:*"}})
+	msgs := _decode(t, "*2\r\n$3\r\nGET\r\n$4\r\ncold\r\n")
+	_reply(t, msgs[0].Request(), "$3\r\nabc\r\n")
+	c.Store(msgs)
+	assert.Equal(t, 0, c.Stats().Keys)
+}
+
+func TestCacheMGetMerged(t *testing.T) {
+	c := New("test", &Config{TTL: time.Minute, MaxKeys: 16, Patterns: []string{"*"}})
+	msgs := _decode(t, "*3\r\n$4\r\nMGET\r\n$1\r\na\r\n$1\r\nb\r\n")
+	fmsgs := c.Lookup(msgs, nil)
+	assert.Len(t, fmsgs, 1)
+	subs := fmsgs[0].Batch()
+	assert.NoError(t, subs[0].Request().Merge([]proto.Request{subs[1].Request()}))
+	_reply(t, subs[0].Request(), "*2\r\n$1\r\n1\r\n$-1\r\n")
+	c.Store(fmsgs)
+	assert.Equal(t, 2, c.Stats().Keys)
+
+	msgs = _decode(t, "*3\r\n$4\r\nMGET\r\n$1\r\nb\r\n$1\r\na\r\n")
+	fmsgs = c.Lookup(msgs, nil)
+	assert.Len(t, fmsgs, 0)
+	assert.Equal(t, "*2\r\n$-1\r\n$1\r\n1\r\n", _encode(t, msgs[0]))
+
+	// NOTE: the nil of MGET is not the reply of GET, which may be WRONGTYPE.
+	msgs = _decode(t, "*2\r\n$3\r\nGET\r\n$1\r\nb\r\n")
+	assert.Len(t, c.Lookup(msgs, nil), 1)
+}
+
+func TestCacheHGetAndExpire(t *testing.T) {
+	c := New("test", &Config{TTL: time.Millisecond, MaxKeys: 16, Patterns: []string{"*"}})
+	msgs := _decode(t, "*3\r\n$4\r\nHGET\r\n$1\r\nh\r\n$1\r\nf\r\n")
+	_reply(t, msgs[0].Request(), "$1\r\nv\r\n")
+	c.Store(msgs)
+	msgs = _decode(t, "*3\r\n$4\r\nHGET\r\n$1\r\nh\r\n$1\r\ng\r\n")
+	assert.Len(t, c.Lookup(msgs, nil), 1)
+	time.Sleep(5 * time.Millisecond)
+	msgs = _decode(t, "*3\r\n$4\r\nHGET\r\n$1\r\nh\r\n$1\r\nf\r\n")
+	assert.Len(t, c.Lookup(msgs, nil), 1)
+	assert.Equal(t, 0, c.Stats().Keys)
+}
+
+func TestCacheEvictAndFlush(t *testing.T) {
+	c := New("test", &Config{TTL: time.Minute, MaxKeys: 2, Patterns: []string{"*"}})
+	for _, key := range []string{"a", "b", "c"} {
+		msgs := _decode(t, "*2\r\n$3\r\nGET\r\n$1\r\n"+key+"\r\n")
+		_reply(t, msgs[0].Request(), "$1\r\nv\r\n")
+		c.Store(msgs)
+	}
+	st := c.Stats()
+	assert.Equal(t, 2, st.Keys)
+	assert.Equal(t, uint64(1), st.Evicts)
+	msgs := _decode(t, "*2\r\n$3\r\nGET\r\n$1\r\na\r\n")
+	assert.Len(t, c.Lookup(msgs, nil), 1)
+
+	c.Flush()
+	assert.Equal(t, 0, c.Stats().Keys)
+	assert.Equal(t, int64(0), c.Stats().Bytes)
+}
+
+func TestCacheHotKey(t *testing.T) {
+	c := New("test", &Config{TTL: time.Minute, MaxKeys: 16, HotThreshold: 3})
+	for i := 0; i < 3; i++ {
+		msgs := _decode(t, "*2\r\n$3\r\nGET\r\n$1\r\nk\r\n")
+		fmsgs := c.Lookup(msgs, nil)
+		assert.Len(t, fmsgs, 1)
+		_reply(t, msgs[0].Request(), "$1\r\nv\r\n")
+		c.Store(fmsgs)
+	}
+	msgs := _decode(t, "*2\r\n$3\r\nGET\r\n$1\r\nk\r\n")
+	assert.Len(t, c.Lookup(msgs, nil), 0)
+}
+
+func TestCacheInvalidateStore(t *testing.T) {
+	c := New("test", &Config{TTL: time.Minute, MaxKeys: 16, Patterns: []string{"hot:*"}})
+	msgs := _decode(t, "*2\r\n$3\r\nGET\r\n$5\r\nhot:d\r\n")
+	fmsgs := c.Lookup(msgs, nil)
+	_reply(t, msgs[0].Request(), "$3\r\nabc\r\n")
+	c.Store(fmsgs)
+
+	// NOTE: the destination is the second key.
+	msgs = _decode(t, "*3\r\n$4\r\nCOPY\r\n$5\r\nhot:s\r\n$5\r\nhot:d\r\n")
+	fmsgs = c.Lookup(msgs, nil)
+	assert.Len(t, fmsgs, 1)
+
+	msgs = _decode(t, "*2\r\n$3\r\nGET\r\n$5\r\nhot:d\r\n")
+	fmsgs = c.Lookup(msgs, nil)
+	assert.Len(t, fmsgs, 1)
+}
@@ -0,0 +1,35 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"overlord/proxy/proto"
+)
+
+// ListNodes is the http handler which shows the health of nodes filtered by cluster and state.
+func (p *Proxy) ListNodes(w http.ResponseWriter, req *http.Request) {
+	params := req.URL.Query()
+	state := params.Get("state")
+	if state != "" {
+		if _, ok := proto.ParseNodeState(state); !ok {
+			http.Error(w, fmt.Sprintf("state %s is invalid", state), http.StatusBadRequest)
+			return
+		}
+	}
+	sts := proto.NodeStats(params.Get("cluster"))
+	if state != "" {
+		n := 0
+		for _, st := range sts {
+			if st.State == state {
+				sts[n] = st
+				n++
+			}
+		}
+		sts = sts[:n]
+	}
+	if err := json.NewEncoder(w).Encode(sts); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
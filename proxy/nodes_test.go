@@ -0,0 +1,32 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"overlord/proxy/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListNodes(t *testing.T) {
+	p := &Proxy{}
+	ncp := proto.NewNodeConnPipe(1, 1, func() proto.NodeConn {
+		return newNodeConn(&ClusterConfig{Name: "nodes", CacheType: "redis"}, "127.0.0.1:1")
+	})
+	defer ncp.Close()
+
+	w := httptest.NewRecorder()
+	p.ListNodes(w, httptest.NewRequest(http.MethodGet, "/nodes?cluster=nodes", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"cluster":"nodes","addr":"127.0.0.1:1","state":"connecting"`)
+
+	w = httptest.NewRecorder()
+	p.ListNodes(w, httptest.NewRequest(http.MethodGet, "/nodes?cluster=nodes&state=down", nil))
+	assert.Equal(t, "[]\n", w.Body.String())
+
+	w = httptest.NewRecorder()
+	p.ListNodes(w, httptest.NewRequest(http.MethodGet, "/nodes?state=unknown", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
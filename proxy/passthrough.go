@@ -0,0 +1,164 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+
+	"overlord/pkg/log"
+	"overlord/proxy/proto"
+
+	"github.com/pkg/errors"
+)
+
+// passthrough listens one port for each master of redis cluster,
+// so cluster clients connect the proxy port of the master which owns the slot and are rarely MOVED.
+type passthrough struct {
+	p        *Proxy
+	cc       *ClusterConfig
+	host     string
+	min, max int
+
+	lock      sync.Mutex
+	ports     map[string]int // master -> port
+	listeners map[int]net.Listener
+
+	forwarder proto.Forwarder
+	ready     chan struct{}
+}
+
+func newPassthrough(p *Proxy, cc *ClusterConfig) *passthrough {
+	host, _, err := net.SplitHostPort(cc.ListenAddr)
+	if err != nil {
+		panic(err)
+	}
+	min, max, err := cc.passthroughPorts()
+	if err != nil {
+		panic(err)
+	}
+	return &passthrough{
+		p:         p,
+		cc:        cc,
+		host:      host,
+		min:       min,
+		max:       max,
+		ports:     map[string]int{},
+		listeners: map[int]net.Listener{},
+		ready:     make(chan struct{}),
+	}
+}
+
+// bind starts serving the accepted conns by forwarder.
+func (pt *passthrough) bind(forwarder proto.Forwarder) {
+	pt.forwarder = forwarder
+	close(pt.ready)
+}
+
+// Map listens the ports of new masters, closes the ones of gone masters and return the ports by master.
+func (pt *passthrough) Map(masters []string) map[string]string {
+	pt.lock.Lock()
+	defer pt.lock.Unlock()
+	alive := make(map[string]struct{}, len(masters))
+	for _, m := range masters {
+		alive[m] = struct{}{}
+	}
+	// NOTE: close the gone first so their ports can be reused.
+	for m, port := range pt.ports {
+		if _, ok := alive[m]; !ok {
+			pt.unlisten(port)
+			delete(pt.ports, m)
+			log.Infof("cluster(%s) passthrough master(%s) is gone and port(%d) closed", pt.cc.Name, m, port)
+		}
+	}
+	// NOTE: sorted so proxies of the same cluster map the same ports mostly.
+	sorted := append([]string{}, masters...)
+	sort.Strings(sorted)
+	for _, m := range sorted {
+		if _, ok := pt.ports[m]; ok {
+			continue
+		}
+		port, err := pt.listen()
+		if err != nil {
+			log.Errorf("cluster(%s) passthrough fail to listen master(%s) error:%v", pt.cc.Name, m, err)
+			continue
+		}
+		pt.ports[m] = port
+		log.Infof("cluster(%s) passthrough master(%s) by port(%d)", pt.cc.Name, m, port)
+	}
+	ports := make(map[string]string, len(pt.ports))
+	for m, port := range pt.ports {
+		ports[m] = strconv.Itoa(port)
+	}
+	return ports
+}
+
+// listen listens the lowest free port in range.
+// NOTE: must be called with lock.
+func (pt *passthrough) listen() (int, error) {
+	for port := pt.min; port <= pt.max; port++ {
+		if _, ok := pt.listeners[port]; ok {
+			continue
+		}
+		l, err := Listen("tcp", net.JoinHostPort(pt.host, strconv.Itoa(port)))
+		if err != nil {
+			// NOTE: the port is used by others.
+			continue
+		}
+		pt.listeners[port] = l
+		pt.p.lock.Lock()
+		if pt.p.listeners != nil {
+			pt.p.listeners[pt.key(port)] = l
+		}
+		pt.p.lock.Unlock()
+		go pt.accept(port, l)
+		return port, nil
+	}
+	return 0, errors.Errorf("no free port in %d-%d", pt.min, pt.max)
+}
+
+// unlisten closes the listener of port.
+// NOTE: must be called with lock.
+func (pt *passthrough) unlisten(port int) {
+	l, ok := pt.listeners[port]
+	if !ok {
+		return
+	}
+	delete(pt.listeners, port)
+	pt.p.lock.Lock()
+	if pt.p.listeners != nil {
+		delete(pt.p.listeners, pt.key(port))
+	}
+	pt.p.lock.Unlock()
+	_ = closeListener(l)
+}
+
+func (pt *passthrough) key(port int) string {
+	return fmt.Sprintf("%s:passthrough:%d", pt.cc.Name, port)
+}
+
+func (pt *passthrough) accept(port int, l net.Listener) {
+	<-pt.ready
+	for {
+		if pt.p.closed {
+			return
+		}
+		conn, err := l.Accept()
+		if err != nil {
+			if conn != nil {
+				_ = conn.Close()
+			}
+			pt.lock.Lock()
+			gone := pt.listeners[port] != l
+			pt.lock.Unlock()
+			if gone || pt.p.isDraining() {
+				log.Infof("cluster(%s) passthrough port(%d) stop listen", pt.cc.Name, port)
+				return
+			}
+			log.Errorf("cluster(%s) passthrough port(%d) accept connection error:%+v", pt.cc.Name, port, err)
+			continue
+		}
+		pt.p.handle(pt.cc, conn, pt.forwarder)
+	}
+}
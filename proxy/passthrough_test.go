@@ -0,0 +1,29 @@
+package proxy
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPassthroughMap(t *testing.T) {
+	used, err := net.Listen("tcp", "127.0.0.1:27150")
+	assert.NoError(t, err)
+	defer used.Close()
+
+	p := &Proxy{listeners: map[string]net.Listener{}}
+	pt := newPassthrough(p, &ClusterConfig{Name: "pt", ListenAddr: "127.0.0.1:26379", PassthroughPorts: "27150-27152"})
+	ports := pt.Map([]string{"10.0.0.2:7000", "10.0.0.1:7000"})
+	assert.Equal(t, map[string]string{"10.0.0.1:7000": "27151", "10.0.0.2:7000": "27152"}, ports)
+	assert.Len(t, p.listeners, 2)
+
+	ports = pt.Map([]string{"10.0.0.2:7000", "10.0.0.3:7000", "10.0.0.4:7000"})
+	assert.Equal(t, map[string]string{"10.0.0.2:7000": "27152", "10.0.0.3:7000": "27151"}, ports)
+	assert.Len(t, p.listeners, 2)
+
+	pt.Map(nil)
+	assert.Len(t, p.listeners, 0)
+	_, err = net.Dial("tcp", "127.0.0.1:27151")
+	assert.Error(t, err)
+}
@@ -0,0 +1,180 @@
+package proto
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"overlord/pkg/prom"
+)
+
+// ErrBlockingFull is the error of blocking commands rejected due to too many blocked clients of node.
+var ErrBlockingFull = errors.New("too many blocked clients of node")
+
+// Blocker is the request of blocking command which waits on a leased connection instead of the pipeline.
+type Blocker interface {
+	// Blocking returns the timeout of blocking command and true if blocking, zero timeout waits forever.
+	Blocking() (timeout time.Duration, ok bool)
+}
+
+// BlockNodeConn is the NodeConn which waits the reply of blocking command until the timeout.
+type BlockNodeConn interface {
+	NodeConn
+	Block(timeout time.Duration)
+}
+
+// leases is the connections of node leased by blocking commands, at most max are leased at once.
+type leases struct {
+	newNc func() NodeConn
+	max   int
+
+	lock   sync.Mutex
+	leased int
+	idle   []NodeConn
+	closed bool
+}
+
+func newLeases(max int, newNc func() NodeConn) *leases {
+	return &leases{newNc: newNc, max: max}
+}
+
+// lease returns an idle connection or dials a new one, reused is true if the connection is idle before.
+func (l *leases) lease() (nc NodeConn, reused bool, err error) {
+	l.lock.Lock()
+	if l.closed {
+		l.lock.Unlock()
+		return nil, false, errPipeClosed
+	}
+	if l.leased >= l.max {
+		l.lock.Unlock()
+		return nil, false, ErrBlockingFull
+	}
+	l.leased++
+	if n := len(l.idle); n > 0 {
+		nc = l.idle[n-1]
+		l.idle = l.idle[:n-1]
+		l.lock.Unlock()
+		return nc, true, nil
+	}
+	l.lock.Unlock()
+	return l.newNc(), false, nil
+}
+
+// release returns the connection, which is closed if broken or leases closed.
+func (l *leases) release(nc NodeConn, broken bool) {
+	l.lock.Lock()
+	l.leased--
+	if !broken && !l.closed {
+		l.idle = append(l.idle, nc)
+		l.lock.Unlock()
+		return
+	}
+	l.lock.Unlock()
+	_ = nc.Close()
+}
+
+// renew closes the broken connection and dials a new one in the same lease.
+func (l *leases) renew(nc NodeConn) NodeConn {
+	_ = nc.Close()
+	return l.newNc()
+}
+
+func (l *leases) close() {
+	l.lock.Lock()
+	l.closed = true
+	idle := l.idle
+	l.idle = nil
+	l.lock.Unlock()
+	for _, nc := range idle {
+		_ = nc.Close()
+	}
+}
+
+// EnableBlocking executes blocking commands on at most max connections leased from the node,
+// they are rejected with ErrBlockingFull if all are leased.
+// NOTE: must be called before any message pushed.
+func (ncp *NodeConnPipe) EnableBlocking(max int) {
+	ncp.leases = newLeases(max, ncp.newNc)
+}
+
+// blocking returns the timeout of m if it's blocking command.
+func (ncp *NodeConnPipe) blocking(m *Message) (time.Duration, bool) {
+	if ncp.leases == nil {
+		return 0, false
+	}
+	req, ok := m.Request().(Blocker)
+	if !ok {
+		return 0, false
+	}
+	return req.Blocking()
+}
+
+// block executes m on a leased connection and returns the lease after replied.
+// The connection is closed if the context of m is done while blocking.
+func (ncp *NodeConnPipe) block(m *Message, timeout time.Duration) {
+	m.MarkStartInput()
+	m.MarkEndInput()
+	nc, reused, err := ncp.leases.lease()
+	if err == nil {
+		if err = ncp.exchange(nc, m, timeout); err != nil && reused && !m.canceled() {
+			// NOTE: the idle connection may be closed by node, retry once by a new one.
+			nc = ncp.leases.renew(nc)
+			err = ncp.exchange(nc, m, timeout)
+		}
+		ncp.leases.release(nc, err != nil)
+	}
+	if requeue := m.requeue; requeue != nil && err == nil {
+		// NOTE: redirected, done by the node pipe requeued into.
+		m.requeue = nil
+		requeue(m)
+		m.Done()
+		return
+	}
+	if prom.On {
+		cmd := m.Request().CmdString()
+		if err != nil {
+			prom.ErrIncr(ncp.health.cluster, ncp.health.addr, cmd, "blocking err")
+		} else {
+			metricsRead(ncp.health.cluster, ncp.health.addr, cmd, m)
+		}
+	}
+	m.WithError(err)
+	m.Done()
+}
+
+// exchange writes m and reads the reply by nc, nc is closed if the context of m is done.
+func (ncp *NodeConnPipe) exchange(nc NodeConn, m *Message, timeout time.Duration) (err error) {
+	if bnc, ok := nc.(BlockNodeConn); ok {
+		bnc.Block(timeout)
+	}
+	if ctx := m.ctx; ctx != nil {
+		stop, exited := make(chan struct{}), make(chan struct{})
+		go func() {
+			defer close(exited)
+			select {
+			case <-ctx.Done():
+				_ = nc.Close()
+			case <-stop:
+			}
+		}()
+		defer func() {
+			close(stop)
+			<-exited
+			if err == nil {
+				// NOTE: closed by the client gone even if replied.
+				err = ctx.Err()
+			}
+		}()
+	}
+	m.MarkWrite()
+	if err = nc.Write(m); err != nil {
+		return
+	}
+	if err = nc.Flush(); err != nil {
+		return
+	}
+	err = nc.Read(m)
+	m.MarkRead()
+	m.MarkAddr(nc.Addr())
+	return
+}
@@ -0,0 +1,108 @@
+package proto
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type leaseNodeConn struct {
+	mockNodeConn
+	release chan struct{}
+	closed  chan struct{}
+	once    sync.Once
+	timeout int64
+}
+
+func (n *leaseNodeConn) Block(timeout time.Duration) { atomic.StoreInt64(&n.timeout, int64(timeout)) }
+
+func (n *leaseNodeConn) Read(*Message) error {
+	select {
+	case <-n.release:
+		return nil
+	case <-n.closed:
+		return errors.New("closed")
+	}
+}
+
+func (n *leaseNodeConn) Close() error {
+	n.once.Do(func() { close(n.closed) })
+	return nil
+}
+
+type blockingRequest struct {
+	mockRequest
+}
+
+func (*blockingRequest) Blocking() (time.Duration, bool) { return time.Second, true }
+
+func TestPipeBlocking(t *testing.T) {
+	var (
+		dials   int32
+		release = make(chan struct{})
+		ncs     = make(chan *leaseNodeConn, 8)
+	)
+	ncp := NewNodeConnPipe(1, 1, func() NodeConn {
+		atomic.AddInt32(&dials, 1)
+		nc := &leaseNodeConn{release: release, closed: make(chan struct{})}
+		ncs <- nc
+		return nc
+	})
+	defer ncp.Close()
+	ncp.EnableBlocking(2)
+	<-ncs // NOTE: the conn of pipeline.
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	msgs := GetMsgs(3)
+	for _, m := range msgs {
+		m.WithRequest(&blockingRequest{})
+		m.WithWaitGroup(wg)
+	}
+	msgs[0].WithContext(ctx)
+	ncp.Push(msgs[0])
+	ncp.Push(msgs[1])
+	nc0, nc1 := <-ncs, <-ncs
+	full := &sync.WaitGroup{}
+	msgs[2].WithWaitGroup(full)
+	ncp.Push(msgs[2])
+	full.Wait()
+	assert.Equal(t, ErrBlockingFull, msgs[2].Err(), "at most 2 blocked")
+
+	// NOTE: the client of msgs[0] is closed while blocking.
+	cancel()
+	time.Sleep(10 * time.Millisecond)
+	closed := 0
+	for _, nc := range []*leaseNodeConn{nc0, nc1} {
+		select {
+		case <-nc.closed:
+			closed++
+		default:
+		}
+	}
+	assert.Equal(t, 1, closed)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int64(time.Second), atomic.LoadInt64(&nc1.timeout))
+	errs := 0
+	for _, m := range msgs[:2] {
+		if m.Err() != nil {
+			errs++
+		}
+	}
+	assert.Equal(t, 1, errs)
+
+	// NOTE: the lease of msgs[1] is returned and reused.
+	m := GetMsgs(1)[0]
+	m.WithRequest(&blockingRequest{})
+	m.WithWaitGroup(wg)
+	ncp.Push(m)
+	wg.Wait()
+	assert.NoError(t, m.Err())
+	assert.Equal(t, int32(3), atomic.LoadInt32(&dials))
+}
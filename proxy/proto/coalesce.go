@@ -0,0 +1,131 @@
+package proto
+
+import (
+	"sync"
+
+	"overlord/pkg/prom"
+)
+
+// flight is the in-flight read request which identical requests wait for.
+type flight struct {
+	key       string
+	ckey      string
+	leader    *Message
+	followers []*Message
+}
+
+// coalescer merges identical in-flight read requests of one node into one backend request.
+type coalescer struct {
+	cluster string
+	addr    string
+
+	lock sync.Mutex
+	// NOTE: flights of key by coalesce key, so the write of key closes all reads of it.
+	flights map[string]map[string]*flight
+}
+
+func newCoalescer(cluster, addr string) *coalescer {
+	return &coalescer{
+		cluster: cluster,
+		addr:    addr,
+		flights: make(map[string]map[string]*flight),
+	}
+}
+
+// join returns true if m waits for an identical in-flight request,
+// otherwise m becomes the leader of a new flight when it can be coalesced.
+// NOTE: the request can't be coalesced closes the flights of its keys, so the reads
+// pushed after the write never share the reply read before it.
+func (c *coalescer) join(m *Message) bool {
+	req, ok := m.Request().(Coalescer)
+	if !ok {
+		return false
+	}
+	ckey := req.CoalesceKey()
+	if len(ckey) == 0 {
+		c.invalidate(m)
+		return false
+	}
+	key := string(m.Request().Key())
+	c.lock.Lock()
+	fs, ok := c.flights[key]
+	if !ok {
+		fs = make(map[string]*flight)
+		c.flights[key] = fs
+	}
+	if f, ok := fs[string(ckey)]; ok {
+		m.Add()
+		m.MarkStartInput()
+		f.followers = append(f.followers, m)
+		c.lock.Unlock()
+		if prom.On {
+			prom.CoalesceIncr(c.cluster, c.addr)
+		}
+		return true
+	}
+	m.flight = &flight{key: key, ckey: string(ckey), leader: m}
+	fs[m.flight.ckey] = m.flight
+	c.lock.Unlock()
+	return false
+}
+
+// invalidate closes the flights of keys of m, the followers already joined still wait for their leaders.
+func (c *coalescer) invalidate(m *Message) {
+	req := m.Request()
+	if req == nil {
+		return
+	}
+	var keys [][]byte
+	if mk, ok := req.(interface{ Keys() [][]byte }); ok {
+		keys = mk.Keys()
+	}
+	if len(keys) == 0 {
+		keys = [][]byte{req.Key()}
+	}
+	c.lock.Lock()
+	for _, key := range keys {
+		delete(c.flights, string(key))
+	}
+	c.lock.Unlock()
+}
+
+// remove removes the flight of leader and return the followers.
+func (c *coalescer) remove(leader *Message) []*Message {
+	f := leader.flight
+	leader.flight = nil
+	if f == nil || f.leader != leader {
+		return nil
+	}
+	c.lock.Lock()
+	if fs, ok := c.flights[f.key]; ok && fs[f.ckey] == f {
+		delete(fs, f.ckey)
+		if len(fs) == 0 {
+			delete(c.flights, f.key)
+		}
+	}
+	followers := f.followers
+	c.lock.Unlock()
+	return followers
+}
+
+// abandon removes the flight of leader and return the followers, which are forwarded by themselves then.
+// NOTE: must be called before leader is done.
+func (c *coalescer) abandon(leader *Message) []*Message {
+	return c.remove(leader)
+}
+
+// land fans out the reply of leader to all followers.
+// NOTE: must be called before leader is done.
+func (c *coalescer) land(leader *Message, err error) {
+	for _, m := range c.remove(leader) {
+		// NOTE: the failed copy of one follower doesn't fail the others.
+		ferr := err
+		if ferr == nil {
+			ferr = m.Request().(Coalescer).CopyReply(leader.Request())
+		}
+		m.wt, m.rt, m.eit = leader.wt, leader.rt, leader.eit
+		m.addr = leader.addr
+		m.WithError(ferr)
+		m.Done()
+	}
+}
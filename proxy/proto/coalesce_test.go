@@ -0,0 +1,132 @@
+package proto
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type blockNodeConn struct {
+	mockNodeConn
+	release chan struct{}
+	writes  int32
+}
+
+func (n *blockNodeConn) Write(*Message) error {
+	atomic.AddInt32(&n.writes, 1)
+	return nil
+}
+
+func (n *blockNodeConn) Read(m *Message) error {
+	<-n.release
+	m.Request().(*coalesceRequest).reply = "value"
+	return nil
+}
+
+type coalesceRequest struct {
+	mockRequest
+	key   string
+	reply string
+	write bool
+}
+
+func (r *coalesceRequest) Key() []byte { return []byte(r.key) }
+func (r *coalesceRequest) CoalesceKey() []byte {
+	if r.write {
+		return nil
+	}
+	return []byte("GET " + r.key)
+}
+func (r *coalesceRequest) CopyReply(req Request) error {
+	if r.reply == "bad" {
+		return errors.New("copy failed")
+	}
+	r.reply = req.(*coalesceRequest).reply
+	return nil
+}
+
+func TestPipeCoalesce(t *testing.T) {
+	nc := &blockNodeConn{release: make(chan struct{})}
+	ncp := NewNodeConnPipe(1, 32, func() NodeConn {
+		return nc
+	})
+	ncp.EnableCoalesce("mock", "mock")
+	wg := &sync.WaitGroup{}
+	var reqs []*coalesceRequest
+	for i := 0; i < 10; i++ {
+		req := &coalesceRequest{key: "same"}
+		m := getMsg()
+		m.WithRequest(req)
+		m.WithWaitGroup(wg)
+		ncp.Push(m)
+		reqs = append(reqs, req)
+	}
+	close(nc.release)
+	wg.Wait()
+	ncp.Close()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&nc.writes))
+	for _, req := range reqs {
+		assert.Equal(t, "value", req.reply)
+	}
+
+	// NOTE: a new flight starts after the leader is done
+	m := getMsg()
+	m.WithRequest(&coalesceRequest{key: "same"})
+	assert.False(t, ncp.coalescer.join(m))
+	assert.Equal(t, "GET same", m.flight.ckey)
+}
+
+func TestCoalesceLandCopyError(t *testing.T) {
+	c := newCoalescer("mock", "mock")
+	wg := &sync.WaitGroup{}
+	leader := getMsg()
+	leader.WithRequest(&coalesceRequest{key: "same", reply: "value"})
+	assert.False(t, c.join(leader))
+	var followers []*Message
+	for _, reply := range []string{"", "bad", ""} {
+		m := getMsg()
+		m.WithRequest(&coalesceRequest{key: "same", reply: reply})
+		m.WithWaitGroup(wg)
+		assert.True(t, c.join(m))
+		followers = append(followers, m)
+	}
+	c.land(leader, nil)
+	wg.Wait()
+	assert.NoError(t, followers[0].Err())
+	assert.Error(t, followers[1].Err())
+	assert.NoError(t, followers[2].Err(), "not failed by the copy of other follower")
+	assert.Equal(t, "value", followers[2].Request().(*coalesceRequest).reply)
+}
+
+func TestPipeCoalesceAfterWrite(t *testing.T) {
+	nc := &blockNodeConn{release: make(chan struct{})}
+	ncp := NewNodeConnPipe(1, 32, func() NodeConn {
+		return nc
+	})
+	ncp.EnableCoalesce("mock", "mock")
+	wg := &sync.WaitGroup{}
+	push := func(req *coalesceRequest) *Message {
+		m := getMsg()
+		m.WithRequest(req)
+		m.WithWaitGroup(wg)
+		ncp.Push(m)
+		return m
+	}
+	leader := push(&coalesceRequest{key: "same"})
+	follower := push(&coalesceRequest{key: "same"})
+	assert.Nil(t, follower.flight, "joins before the write")
+	// NOTE: the pipelined SET then GET of one client must read its own write.
+	push(&coalesceRequest{key: "same", write: true})
+	get := push(&coalesceRequest{key: "same"})
+	assert.NotNil(t, get.flight, "leads a new flight after the write")
+	assert.True(t, leader.flight != get.flight)
+	close(nc.release)
+	wg.Wait()
+	ncp.Close()
+	assert.Equal(t, int32(3), atomic.LoadInt32(&nc.writes))
+	assert.Equal(t, "value", follower.Request().(*coalesceRequest).reply, "follower joined before is still served")
+	assert.Equal(t, "value", get.Request().(*coalesceRequest).reply)
+}
@@ -0,0 +1,257 @@
+package proto
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"overlord/pkg/log"
+	"overlord/pkg/prom"
+)
+
+// NodeState is the health state of node.
+type NodeState int32
+
+// states of node.
+const (
+	// NodeConnecting is the state of node before the first reply, or reconnecting after down.
+	NodeConnecting NodeState = iota
+	// NodeHealthy is the state of node which replies successfully.
+	NodeHealthy
+	// NodeDegraded is the state of node which fails less than NodeDownFailures times in a row.
+	NodeDegraded
+	// NodeDown is the state of node which fails NodeDownFailures times in a row.
+	NodeDown
+)
+
+var nodeStateNames = []string{"connecting", "healthy", "degraded", "down"}
+
+func (s NodeState) String() string {
+	if s < 0 || int(s) >= len(nodeStateNames) {
+		return "unknown"
+	}
+	return nodeStateNames[s]
+}
+
+// ParseNodeState return the state by name.
+func ParseNodeState(name string) (NodeState, bool) {
+	for i, n := range nodeStateNames {
+		if n == name {
+			return NodeState(i), true
+		}
+	}
+	return 0, false
+}
+
+// defaults of node health.
+var (
+	// NodeDownFailures is the count of failures in a row which the node is down after.
+	NodeDownFailures = 3
+	// NodeBackoffBase is the default backoff before reconnecting after the first failure.
+	NodeBackoffBase = 100 * time.Millisecond
+	// NodeBackoffMax is the default max backoff before reconnecting.
+	NodeBackoffMax = 5 * time.Second
+)
+
+// Backoff is the base and max backoff before reconnecting to node.
+type Backoff struct {
+	Base time.Duration
+	Max  time.Duration
+}
+
+// NodeStat is the introspection of node health.
+type NodeStat struct {
+	Cluster string `json:"cluster"`
+	Addr    string `json:"addr"`
+	State   string `json:"state"`
+	// Since is the unix second of the last state change.
+	Since     int64  `json:"since"`
+	Failures  int    `json:"failures"`
+	Changes   uint64 `json:"changes"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// NodeHealth is the state machine of node shared by NodeConnPipe and pinger.
+// The failures of one burst, which happen in the backoff window of the previous failure,
+// are counted once, so that all connections and pipelined messages failed together
+// don't take the node down or lengthen the backoff at once.
+type NodeHealth struct {
+	cluster string
+	addr    string
+	state   int32
+
+	lock     sync.Mutex
+	base     time.Duration
+	max      time.Duration
+	failures int
+	retryAt  time.Time
+	since    time.Time
+	changes  uint64
+	lastErr  string
+}
+
+// NewNodeHealth new a NodeHealth in connecting state.
+func NewNodeHealth(cluster, addr string) *NodeHealth {
+	return &NodeHealth{cluster: cluster, addr: addr, state: int32(NodeConnecting), since: time.Now(), base: NodeBackoffBase, max: NodeBackoffMax}
+}
+
+// SetBackoff sets the base and max backoff before reconnecting.
+func (h *NodeHealth) SetBackoff(base, max time.Duration) {
+	h.lock.Lock()
+	h.base, h.max = base, max
+	h.lock.Unlock()
+}
+
+// State return the current state.
+func (h *NodeHealth) State() NodeState {
+	return NodeState(atomic.LoadInt32(&h.state))
+}
+
+// Success marks the node replied successfully.
+func (h *NodeHealth) Success() {
+	if h.State() == NodeHealthy {
+		return
+	}
+	h.lock.Lock()
+	h.failures = 0
+	h.retryAt = time.Time{}
+	h.lastErr = ""
+	h.transit(NodeHealthy, nil)
+	h.lock.Unlock()
+}
+
+// Connecting marks the node is reconnecting, it's down before.
+func (h *NodeHealth) Connecting() {
+	if h.State() != NodeDown {
+		return
+	}
+	h.lock.Lock()
+	if h.State() == NodeDown {
+		h.transit(NodeConnecting, nil)
+	}
+	h.lock.Unlock()
+}
+
+// Failure marks the node failed with err and return the backoff before reconnecting.
+func (h *NodeHealth) Failure(err error) time.Duration {
+	now := time.Now()
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if err != nil {
+		h.lastErr = err.Error()
+	}
+	if now.Before(h.retryAt) {
+		// NOTE: in the same burst.
+		return h.retryAt.Sub(now)
+	}
+	h.failures++
+	wait := backoff(h.base, h.max, h.failures)
+	h.retryAt = now.Add(wait)
+	if h.failures >= NodeDownFailures {
+		h.transit(NodeDown, err)
+	} else {
+		h.transit(NodeDegraded, err)
+	}
+	return wait
+}
+
+// transit changes the state.
+// NOTE: must be called with lock.
+func (h *NodeHealth) transit(to NodeState, err error) {
+	from := h.State()
+	if from == to {
+		return
+	}
+	atomic.StoreInt32(&h.state, int32(to))
+	h.since = time.Now()
+	h.changes++
+	if to == NodeDown || to == NodeDegraded {
+		log.Warnf("node cluster:%s addr:%s state %s -> %s failures:%d err:%v", h.cluster, h.addr, from, to, h.failures, err)
+	} else {
+		log.Infof("node cluster:%s addr:%s state %s -> %s", h.cluster, h.addr, from, to)
+	}
+	if prom.On {
+		prom.NodeState(h.cluster, h.addr, to.String(), int(to))
+	}
+}
+
+// Failures return the count of failures in a row.
+func (h *NodeHealth) Failures() int {
+	h.lock.Lock()
+	n := h.failures
+	h.lock.Unlock()
+	return n
+}
+
+// Stat return the introspection of node health.
+func (h *NodeHealth) Stat() *NodeStat {
+	h.lock.Lock()
+	st := &NodeStat{
+		Cluster:   h.cluster,
+		Addr:      h.addr,
+		State:     h.State().String(),
+		Since:     h.since.Unix(),
+		Failures:  h.failures,
+		Changes:   h.changes,
+		LastError: h.lastErr,
+	}
+	h.lock.Unlock()
+	return st
+}
+
+// backoff return the exponential backoff of failures with jitter in [d/2, d].
+func backoff(base, max time.Duration, failures int) time.Duration {
+	d := base
+	for i := 1; i < failures && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	if half := int64(d / 2); half > 0 {
+		d = time.Duration(half + rand.Int63n(half+1))
+	}
+	return d
+}
+
+var (
+	healthLock sync.RWMutex
+	healthMap  = map[string]*NodeHealth{}
+)
+
+func registerHealth(h *NodeHealth) {
+	healthLock.Lock()
+	healthMap[h.cluster+"|"+h.addr] = h
+	healthLock.Unlock()
+}
+
+func unregisterHealth(h *NodeHealth) {
+	key := h.cluster + "|" + h.addr
+	healthLock.Lock()
+	if healthMap[key] == h {
+		delete(healthMap, key)
+	}
+	healthLock.Unlock()
+}
+
+// NodeStats return the health of nodes of cluster, or all clusters if cluster is empty,
+// sorted by cluster and addr.
+func NodeStats(cluster string) []*NodeStat {
+	healthLock.RLock()
+	sts := make([]*NodeStat, 0, len(healthMap))
+	for _, h := range healthMap {
+		if cluster == "" || cluster == h.cluster {
+			sts = append(sts, h.Stat())
+		}
+	}
+	healthLock.RUnlock()
+	sort.Slice(sts, func(i, j int) bool {
+		if sts[i].Cluster != sts[j].Cluster {
+			return sts[i].Cluster < sts[j].Cluster
+		}
+		return sts[i].Addr < sts[j].Addr
+	})
+	return sts
+}
@@ -0,0 +1,119 @@
+package proto
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNodeHealth(t *testing.T) {
+	h := NewNodeHealth("test", "127.0.0.1:6379")
+	h.SetBackoff(20*time.Millisecond, NodeBackoffMax)
+	assert.Equal(t, NodeConnecting, h.State())
+	h.Success()
+	assert.Equal(t, NodeHealthy, h.State())
+
+	err := errors.New("conn refused")
+	wait := h.Failure(err)
+	assert.Equal(t, NodeDegraded, h.State())
+	assert.True(t, wait >= 10*time.Millisecond && wait <= 20*time.Millisecond)
+	// NOTE: the failures of the same burst are counted once.
+	for i := 0; i < 10; i++ {
+		assert.True(t, h.Failure(err) <= wait)
+	}
+	assert.Equal(t, NodeDegraded, h.State())
+	assert.Equal(t, 1, h.Stat().Failures)
+
+	for i := 1; i < NodeDownFailures; i++ {
+		time.Sleep(h.Failure(err))
+		wait = h.Failure(err)
+	}
+	assert.Equal(t, NodeDown, h.State())
+	assert.True(t, wait >= 40*time.Millisecond)
+
+	h.Connecting()
+	assert.Equal(t, NodeConnecting, h.State())
+	h.Success()
+	st := h.Stat()
+	assert.Equal(t, "healthy", st.State)
+	assert.Equal(t, 0, st.Failures)
+	assert.Equal(t, uint64(5), st.Changes)
+}
+
+func TestBackoff(t *testing.T) {
+	for i := 1; i < 100; i++ {
+		d := backoff(NodeBackoffBase, NodeBackoffMax, i)
+		assert.True(t, d >= NodeBackoffBase/2 && d <= NodeBackoffMax)
+	}
+	assert.True(t, backoff(NodeBackoffBase, NodeBackoffMax, 100) >= NodeBackoffMax/2)
+}
+
+func TestPipeBackoff(t *testing.T) {
+	const base = 20 * time.Millisecond
+	nc := &mockNodeConn{num: 1, err: errors.New("read fail")}
+	var dials int32
+	ncp := NewNodeConnPipe(1, 1, func() NodeConn {
+		atomic.AddInt32(&dials, 1)
+		return nc
+	})
+	defer ncp.Close()
+	ncp.WithBackoff(Backoff{Base: base})
+	assert.Equal(t, NodeBackoffMax, ncp.Health().max, "default max backoff")
+	assert.Contains(t, NodeStats("mock"), ncp.Health().Stat())
+
+	push := func() error {
+		wg := &sync.WaitGroup{}
+		m := getMsg()
+		m.WithRequest(&mockRequest{})
+		m.WithWaitGroup(wg)
+		ncp.Push(m)
+		wg.Wait()
+		return m.Err()
+	}
+	assert.NoError(t, push())
+	assert.Equal(t, NodeHealthy, ncp.Health().State())
+	assert.Error(t, push())
+	assert.Equal(t, NodeDegraded, ncp.Health().State())
+	// NOTE: redial at once on the first failure.
+	assert.Equal(t, int32(2), atomic.LoadInt32(&dials))
+	assert.Error(t, push())
+	// NOTE: fail fast without reconnecting in the backoff after failed in a row.
+	assert.Equal(t, ErrNodeBackoff, push())
+	assert.Equal(t, int32(2), atomic.LoadInt32(&dials))
+
+	time.Sleep(base)
+	nc.reset(10)
+	assert.NoError(t, push())
+	assert.Equal(t, int32(3), atomic.LoadInt32(&dials))
+	assert.Equal(t, NodeHealthy, ncp.Health().State())
+}
+
+func TestPipeBackoffHealthCheck(t *testing.T) {
+	nc := &mockNodeConn{err: errors.New("read fail")}
+	var dials int32
+	ncp := NewNodeConnPipe(1, 1, func() NodeConn {
+		atomic.AddInt32(&dials, 1)
+		return nc
+	})
+	defer ncp.Close()
+	// NOTE: the node failed the health checks in a row.
+	h := ncp.Health()
+	h.SetBackoff(time.Millisecond, time.Millisecond)
+	for h.Failures() < 2 {
+		time.Sleep(h.Failure(nil))
+	}
+	h.SetBackoff(time.Second, time.Second)
+
+	wg := &sync.WaitGroup{}
+	m := getMsg()
+	m.WithRequest(&mockRequest{})
+	m.WithWaitGroup(wg)
+	ncp.Push(m)
+	wg.Wait()
+	assert.Error(t, m.Err())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&dials), "backoff without redial")
+}
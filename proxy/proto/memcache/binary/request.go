@@ -1,10 +1,12 @@
 package binary
 
 import (
+	"encoding/binary"
 	errs "errors"
 	"fmt"
 	"sync"
 
+	"overlord/pkg/prom"
 	"overlord/proxy/proto"
 )
 
@@ -27,6 +29,14 @@ var (
 // RequestType is the protocol-agnostic identifier for the command
 type RequestType byte
 
+func init() {
+	for rt := RequestTypeGet; rt < RequestTypeUnknown; rt++ {
+		if name := rt.String(); name != unknownString {
+			prom.RegisterCmds(name)
+		}
+	}
+}
+
 // all memcache request type
 const (
 	RequestTypeGet      RequestType = 0x00
@@ -373,6 +383,33 @@ func (r *MCRequest) Merge([]proto.Request) (err error) {
 	return
 }
 
+// Hits impl the proto.Hitter and count the retrieval request replied with key not found status as missed.
+func (r *MCRequest) Hits() (hit, miss int) {
+	switch r.respType {
+	case RequestTypeGet, RequestTypeGetQ, RequestTypeGetK, RequestTypeGetKQ, RequestTypeGat, RequestTypeGatQ:
+	default:
+		return
+	}
+	switch binary.BigEndian.Uint16(r.status) {
+	case ResponseStatusNoErr:
+		return 1, 0
+	case ResponseStatusKeyNotFound:
+		return 0, 1
+	}
+	return
+}
+
+// RequestSize impl the proto.Sizer and return the bytes of request written into node.
+// NOTE: must be called before the reply is read.
+func (r *MCRequest) RequestSize() int {
+	return requestHeaderLen + int(binary.BigEndian.Uint32(r.bodyLen))
+}
+
+// ReplySize impl the proto.Sizer and return the bytes of reply read from node.
+func (r *MCRequest) ReplySize() int {
+	return requestHeaderLen + len(r.data)
+}
+
 func (r *MCRequest) String() string {
 	return fmt.Sprintf("type:%s key:%s data:%s", r.respType.String(), r.key, r.data)
 }
@@ -33,6 +33,7 @@ type nodeConn struct {
 // NewNodeConn returns node conn.
 func NewNodeConn(cluster, addr string, dialTimeout, readTimeout, writeTimeout time.Duration) (nc proto.NodeConn) {
 	conn := libnet.DialWithTimeout(addr, dialTimeout, readTimeout, writeTimeout)
+	proto.StatNodeConn(cluster, addr, conn)
 	return NewNodeConnWithLibConn(cluster, addr, conn)
 }
 
@@ -1,8 +1,10 @@
 package memcache
 
 import (
+	"bytes"
 	errs "errors"
 	"fmt"
+	"overlord/pkg/prom"
 	"overlord/pkg/types"
 	"overlord/proxy/proto"
 	"sync"
@@ -19,6 +21,7 @@ var (
 	crlfBytes    = []byte("\r\n")
 	noreplyBytes = []byte("noreply")
 	endBytes     = []byte("END\r\n")
+	valueBytes   = []byte("VALUE ")
 	errorBytes   = []byte("ERROR\r\n")
 
 	setBytes        = []byte("set")
@@ -71,6 +74,12 @@ const (
 // RequestType is the protocol-agnostic identifier for the command
 type RequestType byte
 
+func init() {
+	for rt := RequestTypeSet; rt <= RequestTypeVersion; rt++ {
+		prom.RegisterCmds(rt.String())
+	}
+}
+
 func (rt RequestType) String() string {
 	switch rt {
 	case RequestTypeSet:
@@ -231,6 +240,8 @@ type MCRequest struct {
 	respType RequestType
 	key      []byte
 	data     []byte
+
+	ckey []byte
 }
 
 var msgPool = &sync.Pool{
@@ -276,6 +287,55 @@ func (r *MCRequest) Merge([]proto.Request) (err error) {
 	return
 }
 
+// CoalesceKey impl the proto.Coalescer and return the command with key of retrieval request.
+func (r *MCRequest) CoalesceKey() []byte {
+	if r.respType != RequestTypeGet && r.respType != RequestTypeGets {
+		return nil
+	}
+	r.ckey = append(r.ckey[:0], byte(r.respType))
+	r.ckey = append(r.ckey, r.key...)
+	return r.ckey
+}
+
+// CopyReply impl the proto.Coalescer and copy reply of the identical request.
+func (r *MCRequest) CopyReply(req proto.Request) error {
+	src, ok := req.(*MCRequest)
+	if !ok {
+		return ErrAssertReq
+	}
+	r.data = append(r.data[:0], src.data...)
+	return nil
+}
+
+// Hits impl the proto.Hitter and count the retrieval request replied with END only as missed.
+func (r *MCRequest) Hits() (hit, miss int) {
+	if _, ok := withValueTypes[r.respType]; !ok {
+		return
+	}
+	if bytes.HasPrefix(r.data, valueBytes) {
+		return 1, 0
+	}
+	if bytes.Equal(r.data, endBytes) {
+		return 0, 1
+	}
+	return
+}
+
+// RequestSize impl the proto.Sizer and return the bytes of request written into node.
+// NOTE: must be called before the reply is read into data.
+func (r *MCRequest) RequestSize() int {
+	n := len(r.respType.Bytes()) + len(spaceBytes) + len(r.key) + len(r.data)
+	if r.respType == RequestTypeGat || r.respType == RequestTypeGats {
+		n += len(spaceBytes) + len(crlfBytes)
+	}
+	return n
+}
+
+// ReplySize impl the proto.Sizer and return the bytes of reply read from node.
+func (r *MCRequest) ReplySize() int {
+	return len(r.data)
+}
+
 func (r *MCRequest) String() string {
 	return fmt.Sprintf("type:%s key:%s data:%s", r.respType.Bytes(), r.key, r.data)
 }
@@ -49,3 +49,22 @@ func TestMCRequestFuncsOk(t *testing.T) {
 	assert.Equal(t, []byte{}, req.key)
 	assert.Equal(t, []byte{}, req.data)
 }
+
+func TestMCRequestHits(t *testing.T) {
+	for _, tc := range []struct {
+		rtype     RequestType
+		data      string
+		hit, miss int
+	}{
+		{RequestTypeGet, "VALUE abc 0 1\r\na\r\nEND\r\n", 1, 0},
+		{RequestTypeGats, "VALUE abc 0 1 2\r\na\r\nEND\r\n", 1, 0},
+		{RequestTypeGets, "END\r\n", 0, 1},
+		{RequestTypeGet, "SERVER_ERROR out of memory\r\n", 0, 0},
+		{RequestTypeSet, "STORED\r\n", 0, 0},
+	} {
+		req := &MCRequest{respType: tc.rtype, data: []byte(tc.data)}
+		hit, miss := req.Hits()
+		assert.Equal(t, tc.hit, hit, tc.data)
+		assert.Equal(t, tc.miss, miss, tc.data)
+	}
+}
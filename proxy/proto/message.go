@@ -1,6 +1,7 @@
 package proto
 
 import (
+	"context"
 	"fmt"
 	"sync"
 	"time"
@@ -74,6 +75,15 @@ type Message struct {
 	st, wt, rt, et, spt, ept, sit, eit time.Time
 	addr                               string
 	err                                error
+
+	flight *flight // NOTE: the flight when leader of in-flight requests
+
+	redirects int
+	asking    bool
+	requeue   func(*Message)
+	retry     bool
+
+	ctx context.Context
 }
 
 // NewMessage will create new message object.
@@ -87,7 +97,14 @@ func (m *Message) Reset() {
 	m.Type = types.CacheTypeUnknown
 	m.reqNum = 0
 	m.st, m.wt, m.rt, m.et, m.spt, m.ept, m.sit, m.eit = defaultTime, defaultTime, defaultTime, defaultTime, defaultTime, defaultTime, defaultTime, defaultTime
+	m.addr = ""
 	m.err = nil
+	m.flight = nil
+	m.redirects = 0
+	m.asking = false
+	m.requeue = nil
+	m.retry = false
+	m.ctx = nil
 }
 
 // clear will clean the msg
@@ -133,6 +150,25 @@ func (m *Message) Addr() string {
 	return m.addr
 }
 
+// Timeline is the timestamps of message through the proxy.
+type Timeline struct {
+	Start, StartPipe, StartInput, EndInput, Write, Read, EndPipe, End time.Time
+}
+
+// Timeline returns the timestamps of message.
+func (m *Message) Timeline() Timeline {
+	return Timeline{
+		Start:      m.st,
+		StartPipe:  m.spt,
+		StartInput: m.sit,
+		EndInput:   m.eit,
+		Write:      m.wt,
+		Read:       m.rt,
+		EndPipe:    m.ept,
+		End:        m.et,
+	}
+}
+
 // MarkStart will set the start time of the command to now.
 func (m *Message) MarkStart() {
 	m.st = time.Now()
@@ -158,9 +194,12 @@ func (m *Message) MarkStartPipe() {
 	m.spt = time.Now()
 }
 
-// MarkStartPipe ...
+// MarkEndPipe will set the end pipe time of the command and sub commands to now.
 func (m *Message) MarkEndPipe() {
 	m.ept = time.Now()
+	for _, sub := range m.Subs() {
+		sub.ept = m.ept
+	}
 }
 
 // MarkStartInput ...
@@ -240,6 +279,7 @@ func (m *Message) Batch() []*Message {
 	var min = minInt(len(m.subs), slen)
 	for i := 0; i < min; i++ {
 		m.subs[i].Type = m.Type
+		m.subs[i].st = m.st
 		m.subs[i].setRequest(m.req[i])
 	}
 	delta := slen - len(m.subs)
@@ -254,6 +294,14 @@ func (m *Message) Batch() []*Message {
 	return m.subs[:slen]
 }
 
+// Subs returns the sub messages of batch, nil if not batch.
+func (m *Message) Subs() []*Message {
+	if !m.IsBatch() {
+		return nil
+	}
+	return m.subs[:minInt(len(m.subs), m.reqNum)]
+}
+
 // WithWaitGroup with wait group.
 func (m *Message) WithWaitGroup(wg *sync.WaitGroup) {
 	m.wg = wg
@@ -273,6 +321,38 @@ func (m *Message) Done() {
 	}
 }
 
+// Redirect marks m redirected to other node, requeue pushes m into the node pipe of it
+// after the current one released m. ASKING is sent before the request if asking.
+func (m *Message) Redirect(asking bool, requeue func(*Message)) {
+	m.redirects++
+	m.asking = asking
+	m.requeue = requeue
+}
+
+// Retry marks m pushed into the same node pipe again after the current one released m.
+func (m *Message) Retry() {
+	m.retry = true
+}
+
+// Redirects returns the times of m redirected.
+func (m *Message) Redirects() int {
+	return m.redirects
+}
+
+// Asking returns if ASKING must be sent before the request.
+func (m *Message) Asking() bool {
+	return m.asking
+}
+
+// WithContext sets the context of client, the blocking command is canceled when it's done.
+func (m *Message) WithContext(ctx context.Context) {
+	m.ctx = ctx
+}
+
+func (m *Message) canceled() bool {
+	return m.ctx != nil && m.ctx.Err() != nil
+}
+
 // WithError with error.
 func (m *Message) WithError(err error) {
 	m.err = err
@@ -0,0 +1,55 @@
+package proto
+
+import (
+	"time"
+
+	libnet "overlord/pkg/net"
+	"overlord/pkg/prom"
+)
+
+// StatNodeConn counts the bytes of requests and replies through node conn into metrics.
+func StatNodeConn(cluster, addr string, conn *libnet.Conn) {
+	if !prom.On {
+		return
+	}
+	conn.WithStat(func(read, write int) {
+		prom.NodeBytes(cluster, addr, read, write)
+	})
+}
+
+// metricsRead records the stages and hits of message which reply is read from node.
+func metricsRead(cluster, addr, cmd string, m *Message) {
+	prom.HandleTime(cluster, addr, cmd, durMicro(m.RemoteDur()))
+	prom.StageTime(cluster, addr, cmd, prom.StageInput, durMicro(m.InputDur()))
+	prom.StageTime(cluster, addr, cmd, prom.StageWaitWrite, durMicro(m.WaitWriteDur()))
+	prom.StageTime(cluster, addr, cmd, prom.StageRemote, durMicro(m.RemoteDur()))
+	if h, ok := m.Request().(Hitter); ok {
+		if hit, miss := h.Hits(); hit+miss > 0 {
+			prom.HitIncr(cluster, cmd, hit, miss)
+		}
+	}
+}
+
+// MetricsPipe records the pipe stage of message or sub messages of batch forwarded to node.
+// NOTE: must be called after MarkEndPipe.
+func MetricsPipe(cluster string, m *Message) {
+	if !m.IsBatch() {
+		metricsPipe(cluster, m)
+		return
+	}
+	for _, sub := range m.Subs() {
+		metricsPipe(cluster, sub)
+	}
+}
+
+func metricsPipe(cluster string, m *Message) {
+	if m.addr == "" {
+		// NOTE: not forwarded, eg: hit near cache, merged into other sub message or failed before write.
+		return
+	}
+	prom.StageTime(cluster, m.addr, m.Request().CmdString(), prom.StagePipe, durMicro(m.PipeDur()))
+}
+
+func durMicro(d time.Duration) int64 {
+	return int64(d / time.Microsecond)
+}
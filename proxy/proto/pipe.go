@@ -2,12 +2,12 @@ package proto
 
 import (
 	"errors"
-	"overlord/pkg/prom"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"overlord/pkg/hashkit"
+	"overlord/pkg/prom"
 )
 
 const (
@@ -15,8 +15,36 @@ const (
 	closed = int32(1)
 )
 
+// overflow policies when the input chan of node connection is full.
+const (
+	// OverflowShed fails the message with ErrNodeQueueFull at once.
+	OverflowShed = "shed"
+	// OverflowBlock waits the input chan until the timeout and sheds the message then.
+	OverflowBlock = "block"
+	// OverflowSpill pushes the message into other connections of the same node, sheds it if all are full.
+	OverflowSpill = "spill"
+)
+
+// DefaultOverflowTimeout is the default timeout of OverflowBlock.
+const DefaultOverflowTimeout = 100 * time.Millisecond
+
+// IsOverflowPolicy checks if the overflow policy is supported.
+func IsOverflowPolicy(policy string) bool {
+	return policy == OverflowShed || policy == OverflowBlock || policy == OverflowSpill
+}
+
+// Overflow is the behaviour of NodeConnPipe when the input chan is full.
+type Overflow struct {
+	Policy  string
+	Timeout time.Duration
+}
+
 var (
-	errPipeChanFull = errors.New("pipe chan is full")
+	// ErrNodeQueueFull is the error of messages shed due to the input chan of node is full.
+	ErrNodeQueueFull = errors.New("node queue is full")
+	errPipeClosed    = errors.New("node pipe is closed")
+	// ErrNodeBackoff is the error of messages failed fast before reconnecting to node.
+	ErrNodeBackoff = errors.New("node connection is backing off")
 )
 
 // NodeConnPipe multi MsgPipe for node conns.
@@ -25,11 +53,20 @@ type NodeConnPipe struct {
 	inputs []chan *Message
 	mps    []*msgPipe
 	l      sync.RWMutex
+	// done is closed by Close to wake up the blocked pushes which are counted by blocked.
+	done    chan struct{}
+	blocked sync.WaitGroup
 
 	errCh chan error
 
 	state        int32
 	pipeMaxCount int
+
+	coalescer *coalescer
+	health    *NodeHealth
+	overflow  Overflow
+	leases    *leases
+	newNc     func() NodeConn
 }
 
 // NewNodeConnPipe new NodeConnPipe.
@@ -42,44 +79,184 @@ func NewNodeConnPipe(conns int32, pipeMaxCount int, newNc func() NodeConn) (ncp
 		inputs:       make([]chan *Message, conns),
 		mps:          make([]*msgPipe, conns),
 		errCh:        make(chan error, 1),
+		done:         make(chan struct{}),
 		pipeMaxCount: pipeMaxCount,
+		overflow:     Overflow{Policy: OverflowShed},
+		newNc:        newNc,
 	}
 	for i := int32(0); i < ncp.conns; i++ {
 		ncp.inputs[i] = make(chan *Message, pipeMaxCount*pipeMaxCount*16)
 		ncp.mps[i] = newMsgPipe(pipeMaxCount, ncp.inputs[i], newNc, ncp)
 	}
+	nc := ncp.mps[0].nc.Load().(NodeConn)
+	ncp.health = NewNodeHealth(nc.Cluster(), nc.Addr())
+	registerHealth(ncp.health)
+	for _, mp := range ncp.mps {
+		go mp.pipe()
+	}
 	return
 }
 
+// EnableCoalesce coalesces identical in-flight read requests into one backend request.
+// NOTE: must be called before any message pushed.
+func (ncp *NodeConnPipe) EnableCoalesce(cluster, addr string) {
+	ncp.coalescer = newCoalescer(cluster, addr)
+}
+
+// WithBackoff sets the backoff before reconnecting, NodeBackoffBase and NodeBackoffMax are used if zero.
+func (ncp *NodeConnPipe) WithBackoff(b Backoff) {
+	if b.Base <= 0 {
+		b.Base = NodeBackoffBase
+	}
+	if b.Max <= 0 {
+		b.Max = NodeBackoffMax
+	}
+	if b.Max < b.Base {
+		b.Max = b.Base
+	}
+	ncp.health.SetBackoff(b.Base, b.Max)
+}
+
+// WithOverflow sets the behaviour when the input chan is full, OverflowShed by default.
+// NOTE: must be called before any message pushed.
+func (ncp *NodeConnPipe) WithOverflow(of Overflow) {
+	if !IsOverflowPolicy(of.Policy) {
+		of.Policy = OverflowShed
+	}
+	if of.Timeout <= 0 {
+		of.Timeout = DefaultOverflowTimeout
+	}
+	ncp.overflow = of
+}
+
 // Push push message into input chan.
 func (ncp *NodeConnPipe) Push(m *Message) {
+	if timeout, ok := ncp.blocking(m); ok {
+		if ncp.coalescer != nil {
+			ncp.coalescer.invalidate(m)
+		}
+		m.Add()
+		go ncp.block(m, timeout)
+		return
+	}
+	if ncp.coalescer != nil && ncp.coalescer.join(m) {
+		return
+	}
 	m.Add()
-	var input chan *Message
+	err := errPipeClosed
 	ncp.l.RLock()
 	if ncp.state == opened {
-		if ncp.conns == 1 {
-			input = ncp.inputs[0]
-		} else {
-			req := m.Request()
-			if req != nil {
-				crc := int32(hashkit.Crc16(req.Key()))
-				input = ncp.inputs[crc%ncp.conns]
-			} else {
-				// NOTE: impossible!!!
+		var idx int32
+		if ncp.conns > 1 {
+			if req := m.Request(); req != nil {
+				idx = int32(hashkit.Crc16(req.Key())) % ncp.conns
+			}
+		}
+		if ncp.push(idx, m) {
+			ncp.l.RUnlock()
+			return
+		}
+		if ncp.overflow.Policy == OverflowBlock {
+			// NOTE: block out of the lock, Close waits for the blocked ones before closing the inputs.
+			ncp.blocked.Add(1)
+			ncp.l.RUnlock()
+			if err = ncp.pushWait(idx, m); err == nil {
+				return
 			}
+			ncp.land(m, err)
+			m.WithError(err)
+			m.Done()
+			return
 		}
+		ncp.overflowIncr(OverflowShed)
+		err = ErrNodeQueueFull
 	}
 	ncp.l.RUnlock()
-	if input != nil {
-		select {
-		case input <- m:
-			m.MarkStartInput()
-			return
-		default:
+	ncp.land(m, err)
+	m.WithError(err)
+	m.Done()
+}
+
+// push pushes m into the input chan of idx, or spills into others if full by the overflow policy.
+func (ncp *NodeConnPipe) push(idx int32, m *Message) bool {
+	// NOTE: mark before sent, m is owned by the pipe once received.
+	m.MarkStartInput()
+	select {
+	case ncp.inputs[idx] <- m:
+		return true
+	default:
+	}
+	if ncp.overflow.Policy == OverflowSpill {
+		for i := int32(1); i < ncp.conns; i++ {
+			select {
+			case ncp.inputs[(idx+i)%ncp.conns] <- m:
+				ncp.overflowIncr(OverflowSpill)
+				return true
+			default:
+			}
 		}
 	}
-	m.WithError(errPipeChanFull)
+	return false
+}
+
+// pushWait blocks until m is pushed into the input chan of idx, or the overflow timeout or pipe closed.
+// NOTE: must be called after blocked added under the read lock.
+func (ncp *NodeConnPipe) pushWait(idx int32, m *Message) error {
+	defer ncp.blocked.Done()
+	timer := time.NewTimer(ncp.overflow.Timeout)
+	defer timer.Stop()
+	select {
+	case ncp.inputs[idx] <- m:
+		ncp.overflowIncr(OverflowBlock)
+		return nil
+	case <-ncp.done:
+		return errPipeClosed
+	case <-timer.C:
+	}
+	ncp.overflowIncr(OverflowShed)
+	return ErrNodeQueueFull
+}
+
+func (ncp *NodeConnPipe) overflowIncr(result string) {
+	if prom.On {
+		prom.PipeOverflowIncr(ncp.health.cluster, ncp.health.addr, result)
+		prom.PipeDepth(ncp.health.cluster, ncp.health.addr, ncp.Depth())
+	}
+}
+
+func (ncp *NodeConnPipe) land(m *Message, err error) {
+	if m.flight != nil {
+		ncp.coalescer.land(m, err)
+	}
+}
+
+// requeue pushes the redirected m and its coalesced followers by requeue.
+func (ncp *NodeConnPipe) requeue(m *Message, requeue func(*Message)) {
+	var followers []*Message
+	if m.flight != nil {
+		followers = ncp.coalescer.abandon(m)
+	}
+	redirects, asking := m.redirects, m.asking
+	requeue(m)
 	m.Done()
+	for _, f := range followers {
+		f.redirects, f.asking = redirects, asking
+		requeue(f)
+		f.Done()
+	}
+}
+
+// Depth return the count of messages waiting in input chans.
+func (ncp *NodeConnPipe) Depth() (depth int) {
+	for _, input := range ncp.inputs {
+		depth += len(input)
+	}
+	return
+}
+
+// Health return the health of node shared by all connections.
+func (ncp *NodeConnPipe) Health() *NodeHealth {
+	return ncp.health
 }
 
 // ErrorEvent return error chan.
@@ -92,10 +269,17 @@ func (ncp *NodeConnPipe) Close() {
 	ncp.l.Lock()
 	close(ncp.errCh)
 	ncp.state = closed
+	close(ncp.done)
+	ncp.l.Unlock()
+	// NOTE: no more pushes after closed, close the inputs until the blocked ones return.
+	ncp.blocked.Wait()
 	for _, input := range ncp.inputs {
 		close(input)
 	}
-	ncp.l.Unlock()
+	if ncp.leases != nil {
+		ncp.leases.close()
+	}
+	unregisterHealth(ncp.health)
 }
 
 // msgPipe message pipeline.
@@ -107,6 +291,10 @@ type msgPipe struct {
 	batch        []*Message
 	pipeMaxCount int
 	count        int
+	// failures is the count of failures in a row of the connection.
+	failures int
+	// retryAt is the time to reconnect after failure, zero if connected.
+	retryAt time.Time
 
 	ncp *NodeConnPipe
 }
@@ -121,7 +309,6 @@ func newMsgPipe(pipeMaxCount int, input <-chan *Message, newNc func() NodeConn,
 		pipeMaxCount: pipeMaxCount,
 	}
 	mp.nc.Store(newNc())
-	go mp.pipe()
 	return
 }
 
@@ -148,10 +335,14 @@ func (mp *msgPipe) pipe() {
 					break
 				}
 			}
+			if nc, ok = mp.connect(nc); !ok {
+				mp.reject(nc, m)
+				m = nil
+				continue
+			}
 			mp.batch[mp.count] = m
 			mp.count++
 			m.MarkWrite()
-			nc.Addr()
 			err = nc.Write(m)
 			m = nil
 			if err != nil {
@@ -178,19 +369,36 @@ func (mp *msgPipe) pipe() {
 	MEND:
 		for i := 0; i < mp.count; i++ {
 			msg := mp.batch[i]
+			requeue := msg.requeue
+			if msg.retry {
+				requeue = mp.ncp.Push
+			}
+			if requeue != nil {
+				msg.requeue, msg.retry = nil, false
+				if err == nil {
+					// NOTE: redirected or retried, done by the node pipe requeued into.
+					mp.ncp.requeue(msg, requeue)
+					continue
+				}
+			}
 			msg.WithError(err) // NOTE: maybe err is nil
+			mp.ncp.land(msg, err)
 			if prom.On {
 				cmd := msg.Request().CmdString()
-				duration := msg.RemoteDur()
-				msg.Done()
 				if err != nil {
 					prom.ErrIncr(nc.Cluster(), nc.Addr(), cmd, "network err")
 				} else {
-					prom.HandleTime(nc.Cluster(), nc.Addr(), cmd, int64(duration/time.Microsecond))
+					metricsRead(nc.Cluster(), nc.Addr(), cmd, msg)
 				}
-			} else {
-				msg.Done()
 			}
+			msg.Done()
+		}
+		if prom.On && mp.count > 0 {
+			prom.PipeDepth(nc.Cluster(), nc.Addr(), mp.ncp.Depth())
+		}
+		if err == nil && mp.count > 0 {
+			mp.failures = 0
+			mp.ncp.health.Success()
 		}
 		mp.count = 0
 		if err != nil {
@@ -216,6 +424,40 @@ func (mp *msgPipe) reNewNc(nc NodeConn, err error) NodeConn {
 		mp.ncp.l.Unlock()
 	}
 	nc.Close()
+	mp.failures++
+	wait := mp.ncp.health.Failure(err)
+	if mp.failures == 1 && mp.ncp.health.Failures() <= 1 {
+		// NOTE: redial at once on the first failure, backoff only if failed in a row.
+		return mp.redial()
+	}
+	mp.retryAt = time.Now().Add(wait)
+	return nc
+}
+
+// connect reconnects to node if the backoff is over, return false if still backing off.
+func (mp *msgPipe) connect(nc NodeConn) (NodeConn, bool) {
+	if mp.retryAt.IsZero() {
+		return nc, true
+	}
+	if time.Now().Before(mp.retryAt) {
+		return nc, false
+	}
+	return mp.redial(), true
+}
+
+func (mp *msgPipe) redial() NodeConn {
+	mp.retryAt = time.Time{}
+	mp.ncp.health.Connecting()
 	mp.nc.Store(mp.newNc())
 	return mp.nc.Load().(NodeConn)
 }
+
+// reject fails m fast while backing off.
+func (mp *msgPipe) reject(nc NodeConn, m *Message) {
+	m.WithError(ErrNodeBackoff)
+	mp.ncp.land(m, ErrNodeBackoff)
+	if prom.On {
+		prom.ErrIncr(nc.Cluster(), nc.Addr(), m.Request().CmdString(), "backoff")
+	}
+	m.Done()
+}
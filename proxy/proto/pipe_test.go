@@ -11,6 +11,7 @@ import (
 )
 
 type mockNodeConn struct {
+	lock       sync.Mutex
 	closed     bool
 	count, num int
 	err        error
@@ -26,6 +27,8 @@ func (n *mockNodeConn) Cluster() string {
 
 func (n *mockNodeConn) Write(*Message) error { return nil }
 func (n *mockNodeConn) Read(*Message) error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
 	if n.count == n.num {
 		return n.err
 	}
@@ -34,10 +37,24 @@ func (n *mockNodeConn) Read(*Message) error {
 }
 func (n *mockNodeConn) Flush() error { return nil }
 func (n *mockNodeConn) Close() error {
+	n.lock.Lock()
 	n.closed = true
+	n.lock.Unlock()
 	return nil
 }
 
+func (n *mockNodeConn) isClosed() bool {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return n.closed
+}
+
+func (n *mockNodeConn) reset(num int) {
+	n.lock.Lock()
+	n.count, n.num = 0, num
+	n.lock.Unlock()
+}
+
 type mockRequest struct{}
 
 func (r *mockRequest) Merge([]Request) error {
@@ -75,8 +92,8 @@ func TestPipe(t *testing.T) {
 	ncp1.Close()
 	ncp2.Close()
 	time.Sleep(10 * time.Millisecond)
-	assert.True(t, nc1.closed)
-	assert.True(t, nc2.closed)
+	assert.True(t, nc1.isClosed())
+	assert.True(t, nc2.isClosed())
 
 	const whenErrNum = 3
 	nc3 := &mockNodeConn{}
@@ -101,3 +118,123 @@ func TestPipe(t *testing.T) {
 		assert.EqualError(t, msg.Err(), "some error")
 	}
 }
+
+func TestPipeOverflow(t *testing.T) {
+	push := func(ncp *NodeConnPipe, wg *sync.WaitGroup, n int) (msgs []*Message) {
+		for i := 0; i < n; i++ {
+			m := getMsg()
+			m.WithRequest(&coalesceRequest{key: "key"})
+			m.WithWaitGroup(wg)
+			ncp.Push(m)
+			msgs = append(msgs, m)
+		}
+		return
+	}
+	// NOTE: the error is set by the pipe, only read after all are done.
+	shed := func(msgs []*Message) (n int) {
+		for _, m := range msgs {
+			if m.Err() == ErrNodeQueueFull {
+				n++
+			}
+		}
+		return
+	}
+	newPipe := func(conns int32, of Overflow) (*NodeConnPipe, chan struct{}) {
+		release := make(chan struct{})
+		ncp := NewNodeConnPipe(conns, 1, func() NodeConn {
+			return &blockNodeConn{release: release}
+		})
+		ncp.WithOverflow(of)
+		return ncp, release
+	}
+
+	// NOTE: the input chan of pipe count 1 is 16, and one message is blocked in write.
+	wg := &sync.WaitGroup{}
+	ncp, release := newPipe(1, Overflow{})
+	assert.Equal(t, OverflowShed, ncp.overflow.Policy)
+	push(ncp, wg, 1)
+	time.Sleep(10 * time.Millisecond)
+	full := push(ncp, wg, 16)
+	over := push(ncp, wg, 4)
+	close(release)
+	wg.Wait()
+	ncp.Close()
+	assert.Equal(t, 0, shed(full))
+	assert.Equal(t, 4, shed(over))
+
+	wg = &sync.WaitGroup{}
+	ncp, release = newPipe(2, Overflow{Policy: OverflowSpill})
+	push(ncp, wg, 1)
+	time.Sleep(10 * time.Millisecond)
+	full = push(ncp, wg, 16)
+	// NOTE: spill into the other connection which blocks one message in write too.
+	over = push(ncp, wg, 24)
+	assert.Equal(t, 32, ncp.Depth())
+	close(release)
+	wg.Wait()
+	ncp.Close()
+	assert.Equal(t, 0, shed(full))
+	assert.True(t, shed(over) <= 7)
+
+	wg = &sync.WaitGroup{}
+	ncp, release = newPipe(1, Overflow{Policy: OverflowBlock, Timeout: 50 * time.Millisecond})
+	push(ncp, wg, 1)
+	time.Sleep(10 * time.Millisecond)
+	full = push(ncp, wg, 16)
+	start := time.Now()
+	over = push(ncp, wg, 1)
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(release)
+	}()
+	blocked := push(ncp, wg, 1)
+	wg.Wait()
+	ncp.Close()
+	assert.Equal(t, 0, shed(full))
+	assert.Equal(t, 1, shed(over))
+	assert.Equal(t, 0, shed(blocked))
+}
+
+func TestPipeOverflowBlockClose(t *testing.T) {
+	release := make(chan struct{})
+	ncp := NewNodeConnPipe(1, 1, func() NodeConn {
+		return &blockNodeConn{release: release}
+	})
+	ncp.WithOverflow(Overflow{Policy: OverflowBlock, Timeout: time.Minute})
+	wg := &sync.WaitGroup{}
+	push := func() *Message {
+		m := getMsg()
+		m.WithRequest(&coalesceRequest{key: "key"})
+		m.WithWaitGroup(wg)
+		ncp.Push(m)
+		return m
+	}
+	push()
+	time.Sleep(10 * time.Millisecond)
+	for i := 0; i < 16; i++ {
+		push()
+	}
+	blocked := make(chan *Message, 1)
+	go func() {
+		blocked <- push()
+	}()
+	time.Sleep(10 * time.Millisecond)
+	// NOTE: the blocked push doesn't hold the lock, or the reconnecting pipes stall.
+	locked := make(chan struct{})
+	go func() {
+		ncp.l.Lock()
+		ncp.l.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("lock is held by the blocked push")
+	}
+	ncp.Close()
+	m := <-blocked
+	close(release)
+	wg.Wait()
+	assert.Equal(t, errPipeClosed, m.Err())
+}
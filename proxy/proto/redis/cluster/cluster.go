@@ -1,10 +1,8 @@
 package cluster
 
 import (
-	"bytes"
 	errs "errors"
 	"net"
-	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -13,7 +11,11 @@ import (
 	"overlord/pkg/hashkit"
 	"overlord/pkg/log"
 	libnet "overlord/pkg/net"
+	"overlord/pkg/prom"
 	"overlord/proxy/proto"
+	"overlord/proxy/proto/redis"
+
+	"github.com/pkg/errors"
 )
 
 const (
@@ -26,41 +28,44 @@ const (
 // errors
 var (
 	ErrClusterClosed = errs.New("cluster executor already closed")
-)
-
-const (
-	fakeClusterNodes = "" +
-		"0000000000000000000000000000000000000001 {ADDR} master - 0 0 1 connected 0-5460\n" +
-		"0000000000000000000000000000000000000002 {ADDR} master - 0 0 2 connected 5461-10922\n" +
-		"0000000000000000000000000000000000000003 {ADDR} master - 0 0 3 connected 10923-16383\n"
-
-	fakeClusterSlots = "" +
-		"*3\r\n" +
-		"*3\r\n:0\r\n:5460\r\n*2\r\n${IPLEN}\r\n{IP}\r\n:{PORT}\r\n" +
-		"*3\r\n:5461\r\n:10922\r\n*2\r\n${IPLEN}\r\n{IP}\r\n:{PORT}\r\n" +
-		"*3\r\n:10923\r\n:16383\r\n*2\r\n${IPLEN}\r\n{IP}\r\n:{PORT}\r\n"
+	ErrClusterSeeds  = errs.New("cluster executor no seed nodes")
+	ErrClusterFetch  = errs.New("cluster executor fail to fetch all seed nodes")
 )
 
 type cluster struct {
-	name          string
-	servers       []string
-	conns         int32
+	name    string
+	conns   int32
+	hashTag []byte
+
+	fetchLock sync.Mutex
+	servers   []string
+
+	tlock         sync.RWMutex
 	dto, rto, wto time.Duration
-	hashTag       []byte
+	tgen          uint64 // NOTE: increased by changed timeouts, the pipes of older ones are renewed.
 
 	slotNode atomic.Value
+	snLock   sync.Mutex // NOTE: guards the store of slotNode
 	action   chan struct{}
 
-	fakeNodesBytes []byte
-	fakeSlotsBytes []byte
-	once           sync.Once
+	topo       atomic.Value // *topology
+	topology   *Topology
+	host, port string
+	once       sync.Once
 
 	state     int32
 	pipeCount int
+	coalesce  bool
+	overflow  proto.Overflow
+	backoff   proto.Backoff
+	blocking  int
 }
 
 // NewForwarder new proto Forwarder.
-func NewForwarder(name, listen string, servers []string, conns int32, pipeCount int, dto, rto, wto time.Duration, hashTag []byte) proto.Forwarder {
+func NewForwarder(name, listen string, servers []string, conns int32, pipeCount int, dto, rto, wto time.Duration, hashTag []byte, coalesce bool, overflow proto.Overflow, backoff proto.Backoff, blocking int, topology *Topology) proto.Forwarder {
+	if topology == nil {
+		topology = &Topology{}
+	}
 	c := &cluster{
 		name:      name,
 		servers:   servers,
@@ -71,22 +76,35 @@ func NewForwarder(name, listen string, servers []string, conns int32, pipeCount
 		hashTag:   hashTag,
 		action:    make(chan struct{}),
 		pipeCount: pipeCount,
+		coalesce:  coalesce,
+		overflow:  overflow,
+		backoff:   backoff,
+		blocking:  blocking,
+		topology:  topology,
 	}
+	c.host, c.port = localAddr(listen)
 	if !c.tryFetch() {
 		_ = c.Close()
 		log.Warnf("fail to init fetch cluster all seeds nodes cluster down but continue")
 		return c
 	}
-	c.fake(listen)
-	go c.fetchproc()
+	c.start()
 	return c
 }
 
+func (c *cluster) start() {
+	c.fake()
+	go c.fetchproc()
+}
+
 func (c *cluster) Forward(msgs []*proto.Message) error {
 	if state := atomic.LoadInt32(&c.state); state == closed {
 		return ErrClusterClosed
 	}
 	for _, m := range msgs {
+		if c.broadcast(m) {
+			continue
+		}
 		if m.IsBatch() {
 			for _, subm := range m.Batch() {
 				ncp := c.getPipe(subm.Request().Key())
@@ -94,6 +112,10 @@ func (c *cluster) Forward(msgs []*proto.Message) error {
 				ncp.Push(subm)
 			}
 		} else {
+			if err := c.crossSlot(m.Request()); err != nil {
+				m.WithError(err)
+				continue
+			}
 			ncp := c.getPipe(m.Request().Key())
 			m.MarkStartPipe()
 			ncp.Push(m)
@@ -102,11 +124,70 @@ func (c *cluster) Forward(msgs []*proto.Message) error {
 	return nil
 }
 
-// Don't support update backend server list now
-func (c *cluster) Update([]string) error {
+// broadcast pushes m to all masters if it is SCRIPT LOAD, EXISTS or FLUSH.
+func (c *cluster) broadcast(m *proto.Message) bool {
+	if req, ok := m.Request().(*redis.Request); !ok || !req.IsBroadcast() {
+		return false
+	}
+	sn := c.slotNode.Load().(*slotNode)
+	ncps := make([]*proto.NodeConnPipe, 0, len(sn.nodePipe))
+	for _, ncp := range sn.nodePipe {
+		ncps = append(ncps, ncp)
+	}
+	return redis.Broadcast(m, ncps)
+}
+
+// Update replaces the seed nodes and fetches the slots from them,
+// the pipes of remaining masters are reused and the ones of gone masters are closed.
+// The cluster down due to the init fetch failed is started if success.
+func (c *cluster) Update(servers []string) error {
+	if len(servers) == 0 {
+		return ErrClusterSeeds
+	}
+	down := c.slotNode.Load() == nil
+	c.fetchLock.Lock()
+	old := c.servers
+	c.servers = servers
+	ok := c.fetch()
+	if !ok {
+		c.servers = old
+	}
+	c.fetchLock.Unlock()
+	if !ok {
+		return ErrClusterFetch
+	}
+	log.Infof("Redis Cluster %s update seed nodes %v", c.name, servers)
+	if down && atomic.CompareAndSwapInt32(&c.state, closed, opening) {
+		c.start()
+	}
 	return nil
 }
 
+// UpdateTimeout replaces the timeouts of connections to nodes,
+// the pipes of all masters are renewed by the next fetch if changed.
+func (c *cluster) UpdateTimeout(dto, rto, wto time.Duration) {
+	c.tlock.Lock()
+	if c.dto != dto || c.rto != rto || c.wto != wto {
+		c.dto, c.rto, c.wto = dto, rto, wto
+		c.tgen++
+	}
+	c.tlock.Unlock()
+}
+
+func (c *cluster) timeouts() (dto, rto, wto time.Duration) {
+	c.tlock.RLock()
+	dto, rto, wto = c.dto, c.rto, c.wto
+	c.tlock.RUnlock()
+	return
+}
+
+func (c *cluster) timeoutGen() (gen uint64) {
+	c.tlock.RLock()
+	gen = c.tgen
+	c.tlock.RUnlock()
+	return
+}
+
 func (c *cluster) Close() error {
 	if !atomic.CompareAndSwapInt32(&c.state, opening, closed) {
 		sn := c.slotNode.Load()
@@ -122,28 +203,65 @@ func (c *cluster) Close() error {
 	return nil
 }
 
+// crossSlot return the error naming the keys of request in different slots.
+func (c *cluster) crossSlot(req proto.Request) error {
+	rreq, ok := req.(*redis.Request)
+	if !ok {
+		return nil
+	}
+	keys := rreq.Keys()
+	if len(keys) < 2 {
+		return nil
+	}
+	first := c.slot(keys[0])
+	for _, key := range keys[1:] {
+		if slot := c.slot(key); slot != first {
+			return errors.Errorf("CROSSSLOT Keys in request don't hash to the same slot: %s(slot %d) %s(slot %d)", keys[0], first, key, slot)
+		}
+	}
+	return nil
+}
+
+func (c *cluster) slot(key []byte) int {
+	return int(hashkit.Crc16(hashkit.HashTag(c.hashTag, key)) & musk)
+}
+
 func (c *cluster) getPipe(key []byte) (ncp *proto.NodeConnPipe) {
-	realKey := c.trimHashTag(key)
-	crc := hashkit.Crc16(realKey) & musk
 	sn := c.slotNode.Load().(*slotNode)
-	addr := sn.nSlots.slots[crc]
+	addr := sn.nSlots.slots[c.slot(key)]
 	ncp = sn.nodePipe[addr]
 	return
 }
 
-func (c *cluster) trimHashTag(key []byte) []byte {
-	if len(c.hashTag) != 2 {
-		return key
+// pipe return the pipe of master addr, nil if unknown.
+func (c *cluster) pipe(addr string) *proto.NodeConnPipe {
+	sn, ok := c.slotNode.Load().(*slotNode)
+	if !ok {
+		return nil
 	}
-	bidx := bytes.IndexByte(key, c.hashTag[0])
-	if bidx == -1 {
-		return key
+	return sn.nodePipe[addr]
+}
+
+// moved points slot to the known master addr at once, and fetches all slots in background.
+func (c *cluster) moved(slot int, addr string) {
+	c.toFetch()
+	if slot < 0 || slot >= slotsCount {
+		return
 	}
-	eidx := bytes.IndexByte(key[bidx+1:], c.hashTag[1])
-	if eidx == -1 {
-		return key
+	c.snLock.Lock()
+	defer c.snLock.Unlock()
+	sn, ok := c.slotNode.Load().(*slotNode)
+	if !ok || sn.nSlots.slots[slot] == addr {
+		return
 	}
-	return key[bidx+1 : bidx+1+eidx]
+	if _, ok := sn.nodePipe[addr]; !ok {
+		return
+	}
+	slots := make([]string, len(sn.nSlots.slots))
+	copy(slots, sn.nSlots.slots)
+	slots[slot] = addr
+	ns := &nodeSlots{nodes: sn.nSlots.nodes, slots: slots, slaveSlots: sn.nSlots.slaveSlots}
+	c.slotNode.Store(&slotNode{nSlots: ns, nodePipe: sn.nodePipe, tgen: sn.tgen})
 }
 
 func (c *cluster) fetchproc() {
@@ -165,13 +283,22 @@ func (c *cluster) toFetch() {
 }
 
 func (c *cluster) tryFetch() bool {
+	c.fetchLock.Lock()
+	defer c.fetchLock.Unlock()
+	return c.fetch()
+}
+
+// fetch fetches the slots from servers.
+// NOTE: must be called with fetchLock.
+func (c *cluster) fetch() bool {
 	// for map's access is random in golang.
 	shuffleMap := make(map[string]struct{})
 	for _, server := range c.servers {
 		shuffleMap[server] = struct{}{}
 	}
+	dto, rto, wto := c.timeouts()
 	for server := range shuffleMap {
-		conn := libnet.DialWithTimeout(server, c.dto, c.rto, c.wto)
+		conn := libnet.DialWithTimeout(server, dto, rto, wto)
 		f := newFetcher(conn)
 		nSlots, err := f.fetch()
 		if err != nil {
@@ -200,17 +327,27 @@ func (c *cluster) initSlotNode(nSlots *nodeSlots) {
 			oncp[addr] = ncp // COPY
 		}
 	}
-	sn := &slotNode{nSlots: nSlots}
+	sn := &slotNode{nSlots: nSlots, tgen: c.timeoutGen()}
 	sn.nodePipe = make(map[string]*proto.NodeConnPipe)
+	// NOTE: renew all pipes to dial by the changed timeouts.
+	renew := osn != nil && osn.tgen != sn.tgen
+	var created []*proto.NodeConnPipe
 	masters := nSlots.getMasters()
 	for _, addr := range masters {
 		ncp, ok := oncp[addr]
-		if !ok {
+		if !ok || renew {
 			toAddr := addr // NOTE: avoid closure
 			ncp = proto.NewNodeConnPipe(c.conns, c.pipeCount, func() proto.NodeConn {
 				return newNodeConn(c, toAddr)
 			})
+			ncp.WithOverflow(c.overflow)
+			ncp.WithBackoff(c.backoff)
+			ncp.EnableBlocking(c.blocking)
+			if c.coalesce {
+				ncp.EnableCoalesce(c.name, toAddr)
+			}
 			go c.pipeEvent(ncp.ErrorEvent())
+			created = append(created, ncp)
 			if log.V(4) {
 				log.Infof("Redis Cluster renew slot node and add addr:%s", toAddr)
 			}
@@ -223,14 +360,30 @@ func (c *cluster) initSlotNode(nSlots *nodeSlots) {
 	for slot, addr := range sn.nSlots.slots {
 		if np, ok := sn.nodePipe[addr]; addr == "" || !ok || np == nil {
 			log.Warnf("fail to find addr:%s in sn.nodePipe for slot:%d of cluster:%s detail masters:%+v nSlots.slots:%+v", addr, slot, c.name, masters, nSlots.slots)
+			for _, ncp := range created {
+				ncp.Close()
+			}
 			c.toFetch()
 			return
 		}
 	}
 	c.servers = masters
+	c.snLock.Lock()
 	c.slotNode.Store(sn)
+	c.snLock.Unlock()
+	if c.topology.Passthrough != nil {
+		c.passthrough(nSlots)
+	}
+	if prom.On {
+		for _, addr := range masters {
+			prom.RingNode(c.name, addr, true)
+		}
+	}
 	for addr, ncp := range oncp {
 		ncp.Close()
+		if prom.On {
+			prom.RingNode(c.name, addr, false)
+		}
 		if log.V(4) {
 			log.Infof("Redis Cluster renew slot node and close addr:%s", addr)
 		}
@@ -250,45 +403,48 @@ func (c *cluster) pipeEvent(errCh <-chan error) {
 	}
 }
 
-func (c *cluster) fake(listen string) {
+func (c *cluster) fake() {
 	c.once.Do(func() {
-		_, port, err := net.SplitHostPort(listen)
-		if err != nil {
-			panic(err)
+		if c.host == "" || c.topology.Passthrough != nil {
+			return
+		}
+		self := net.JoinHostPort(c.host, c.port)
+		c.setTopology(newTopology(append([]string{self}, c.topology.Peers...)))
+		if c.topology.Registry != nil {
+			go c.discover(self)
+		}
+	})
+}
+
+// localAddr return the first non-loopback ipv4 of interfaces and the port of listen addr.
+func localAddr(listen string) (host, port string) {
+	_, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		panic(err)
+	}
+	inters, err := net.Interfaces()
+	if err != nil {
+		panic(err)
+	}
+	for _, inter := range inters {
+		if strings.HasPrefix(inter.Name, "lo") {
+			continue
 		}
-		inters, err := net.Interfaces()
+		addrs, err := inter.Addrs()
 		if err != nil {
-			panic(err)
+			continue
 		}
-		for _, inter := range inters {
-			if strings.HasPrefix(inter.Name, "lo") {
-				continue
-			}
-			addrs, err := inter.Addrs()
-			if err != nil {
-				continue
-			}
-			for _, addr := range addrs {
-				if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() && ipnet.IP.To4() != nil {
-					ipStr := ipnet.IP.String()
-
-					ipStrLen := len(ipStr)
-					slotsStr := strings.Replace(fakeClusterSlots, "{IPLEN}", strconv.Itoa(ipStrLen), -1)
-					slotsStr = strings.Replace(slotsStr, "{IP}", ipStr, -1)
-					slotsStr = strings.Replace(slotsStr, "{PORT}", port, -1)
-					c.fakeSlotsBytes = []byte(slotsStr)
-
-					nodesStr := strings.Replace(fakeClusterNodes, "{ADDR}", net.JoinHostPort(ipStr, port), -1)
-					nodesLen := len(nodesStr)
-					c.fakeNodesBytes = []byte("$" + strconv.Itoa(nodesLen) + "\r\n" + nodesStr + "\r\n")
-					return
-				}
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() && ipnet.IP.To4() != nil {
+				return ipnet.IP.String(), port
 			}
 		}
-	})
+	}
+	return "", port
 }
 
 type slotNode struct {
 	nSlots   *nodeSlots
 	nodePipe map[string]*proto.NodeConnPipe
+	tgen     uint64
 }
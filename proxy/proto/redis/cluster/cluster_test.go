@@ -0,0 +1,433 @@
+package cluster
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"overlord/pkg/hashkit"
+	"overlord/pkg/mockconn"
+	libnet "overlord/pkg/net"
+	"overlord/proxy/proto"
+	"overlord/proxy/proto/redis"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// _nodesServer replies CLUSTER NODES of nodes, the "%s" in nodes is replaced by the addr of itself.
+// The commands are replied by reply first if not nil and the result is not empty,
+// asking is true if ASKING is sent before.
+func _nodesServer(t *testing.T, nodes string, reply func(asking bool, args []string) string) (addr string, closeFn func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr = l.Addr().String()
+	data := strings.Replace(nodes, "%s", addr, -1)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				var (
+					br     = bufio.NewReader(conn)
+					asking bool
+				)
+				for {
+					line, err := br.ReadString('\n')
+					if err != nil {
+						return
+					}
+					n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+					args := make([]string, 0, n)
+					for i := 0; i < n; i++ {
+						_, _ = br.ReadString('\n')
+						arg, _ := br.ReadString('\n')
+						args = append(args, strings.TrimSpace(arg))
+					}
+					if reply != nil {
+						if r := reply(asking, args); r != "" {
+							fmt.Fprint(conn, r)
+							asking = false
+							continue
+						}
+					}
+					switch {
+					case len(args) == 2 && args[0] == "CLUSTER" && args[1] == "NODES":
+						fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(data), data)
+					case len(args) == 1 && args[0] == "ASKING":
+						asking = true
+						fmt.Fprint(conn, "+OK\r\n")
+					}
+				}
+			}(conn)
+		}
+	}()
+	return addr, func() { l.Close() }
+}
+
+func TestClusterUpdate(t *testing.T) {
+	a, closeA := _nodesServer(t, "0000000000000000000000000000000000000001 %s master - 0 0 1 connected 0-16383\n", nil)
+	defer closeA()
+	b, closeB := _nodesServer(t, "0000000000000000000000000000000000000001 "+a+" master - 0 0 1 connected 0-8191\n"+
+		"0000000000000000000000000000000000000002 %s master - 0 0 2 connected 8192-16383\n", nil)
+	defer closeB()
+	c, closeC := _nodesServer(t, "0000000000000000000000000000000000000002 "+b+" master - 0 0 2 connected 0-16383\n", nil)
+	defer closeC()
+
+	f := NewForwarder("update", "127.0.0.1:0", []string{a}, 1, 1, time.Second, time.Second, time.Second, nil, false, proto.Overflow{}, proto.Backoff{}, 8, nil)
+	defer f.Close()
+	clstr := f.(*cluster)
+	sn := clstr.slotNode.Load().(*slotNode)
+	assert.Len(t, sn.nodePipe, 1)
+	pipeA := sn.nodePipe[a]
+
+	assert.NoError(t, f.Update([]string{b}))
+	sn = clstr.slotNode.Load().(*slotNode)
+	assert.Len(t, sn.nodePipe, 2)
+	assert.True(t, pipeA == sn.nodePipe[a], "the pipe of remaining master is reused")
+	assert.Equal(t, a, sn.nSlots.slots[0])
+	assert.Equal(t, b, sn.nSlots.slots[16383])
+	pipeB := sn.nodePipe[b]
+
+	assert.Equal(t, ErrClusterFetch, f.Update([]string{"127.0.0.1:1"}))
+	assert.Equal(t, ErrClusterSeeds, f.Update(nil))
+	assert.True(t, sn == clstr.slotNode.Load().(*slotNode), "unchanged if fail to fetch")
+
+	assert.NoError(t, f.Update([]string{c}))
+	sn = clstr.slotNode.Load().(*slotNode)
+	assert.Len(t, sn.nodePipe, 1)
+	assert.True(t, pipeB == sn.nodePipe[b])
+	assert.Equal(t, b, sn.nSlots.slots[0])
+
+	clstr.UpdateTimeout(time.Second, time.Second, time.Second)
+	assert.NoError(t, f.Update([]string{c}))
+	sn = clstr.slotNode.Load().(*slotNode)
+	assert.True(t, pipeB == sn.nodePipe[b], "reused if timeouts are not changed")
+	clstr.UpdateTimeout(time.Second, 2*time.Second, time.Second)
+	assert.NoError(t, f.Update([]string{c}))
+	sn = clstr.slotNode.Load().(*slotNode)
+	assert.True(t, pipeB != sn.nodePipe[b], "renewed by the changed timeouts")
+	assert.Len(t, proto.NodeStats("update"), 1, "the old pipe is closed")
+
+	// NOTE: the new pipes are closed if any slot is uncovered.
+	d, closeD := _nodesServer(t, "", nil)
+	defer closeD()
+	ns, err := parseSlots([]byte("0000000000000000000000000000000000000003 " + d + " master - 0 0 3 connected 0-16383\n"))
+	assert.NoError(t, err)
+	ns.slots[100] = ""
+	clstr.initSlotNode(ns)
+	assert.True(t, sn == clstr.slotNode.Load().(*slotNode))
+	for _, st := range proto.NodeStats("update") {
+		assert.NotEqual(t, d, st.Addr)
+	}
+}
+
+func TestClusterUpdateDown(t *testing.T) {
+	f := NewForwarder("down", "127.0.0.1:0", []string{"127.0.0.1:1"}, 1, 1, 100*time.Millisecond, time.Second, time.Second, nil, false, proto.Overflow{}, proto.Backoff{}, 8, nil)
+	defer f.Close()
+	assert.Equal(t, ErrClusterClosed, f.Forward(nil))
+
+	a, closeA := _nodesServer(t, "0000000000000000000000000000000000000001 %s master - 0 0 1 connected 0-16383\n", nil)
+	defer closeA()
+	f.(*cluster).UpdateTimeout(time.Second, time.Second, time.Second)
+	assert.NoError(t, f.Update([]string{a}))
+	assert.NoError(t, f.Forward(nil))
+}
+
+func TestClusterRedirect(t *testing.T) {
+	b, closeB := _nodesServer(t, "", func(asking bool, args []string) string {
+		if args[0] != "GET" {
+			return ""
+		}
+		if args[1] == "ask" && !asking {
+			return "-MOVED 1 127.0.0.1:1\r\n"
+		}
+		return "$1\r\nb\r\n"
+	})
+	defer closeB()
+	var (
+		slot  = hashkit.Crc16([]byte("moved")) & musk
+		moved int32
+		nodes = fmt.Sprintf("0000000000000000000000000000000000000001 127.0.0.1:1 master - 0 0 1 connected 0-%d %d-16383\n"+
+			"0000000000000000000000000000000000000002 %s master - 0 0 2 connected %d\n", slot-1, slot+1, b, slot)
+	)
+	a, closeA := _nodesServer(t, "0000000000000000000000000000000000000001 %s master - 0 0 1 connected 0-16383\n"+
+		"0000000000000000000000000000000000000002 "+b+" master - 0 0 2 connected\n", func(_ bool, args []string) string {
+		switch {
+		case args[0] == "CLUSTER" && atomic.LoadInt32(&moved) == 1:
+			// NOTE: the slot of moved is migrated to b.
+			return fmt.Sprintf("$%d\r\n%s\r\n", len(nodes), nodes)
+		case args[0] != "GET":
+			return ""
+		case args[1] == "ask":
+			return fmt.Sprintf("-ASK %d %s\r\n", hashkit.Crc16([]byte(args[1]))&musk, b)
+		}
+		atomic.StoreInt32(&moved, 1)
+		return fmt.Sprintf("-MOVED %d %s\r\n", slot, b)
+	})
+	defer closeA()
+	nodes = strings.Replace(nodes, "127.0.0.1:1", a, 1)
+
+	f := NewForwarder("redirect", "127.0.0.1:0", []string{a}, 1, 1, time.Second, time.Second, time.Second, nil, false, proto.Overflow{}, proto.Backoff{}, 8, nil)
+	defer f.Close()
+	clstr := f.(*cluster)
+	assert.True(t, clstr.getPipe([]byte("moved")) == clstr.pipe(a))
+	assert.NotNil(t, clstr.pipe(b))
+
+	conn := libnet.NewConn(mockconn.CreateConn([]byte("*2\r\n$3\r\nGET\r\n$5\r\nmoved\r\n*2\r\n$3\r\nGET\r\n$3\r\nask\r\n"), 1), time.Second, time.Second)
+	wg := &sync.WaitGroup{}
+	msgs := proto.GetMsgs(2)
+	for _, m := range msgs {
+		m.WithWaitGroup(wg)
+	}
+	msgs, err := redis.NewProxyConn(conn, true).Decode(msgs)
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 2)
+	assert.NoError(t, f.Forward(msgs))
+	wg.Wait()
+
+	for _, m := range msgs {
+		assert.NoError(t, m.Err())
+		assert.Equal(t, "1\r\nb", string(m.Request().(*redis.Request).Reply().Data()))
+	}
+	assert.Equal(t, 1, msgs[0].Redirects())
+	assert.False(t, msgs[0].Asking())
+	assert.Equal(t, 1, msgs[1].Redirects())
+	assert.True(t, msgs[1].Asking())
+	assert.True(t, clstr.getPipe([]byte("moved")) == clstr.pipe(b), "MOVED updates the slot at once")
+	assert.True(t, clstr.getPipe([]byte("ask")) == clstr.pipe(a), "ASK keeps the slot")
+}
+
+func TestClusterCrossSlot(t *testing.T) {
+	a, closeA := _nodesServer(t, "0000000000000000000000000000000000000001 %s master - 0 0 1 connected 0-16383\n", func(_ bool, args []string) string {
+		switch args[0] {
+		case "SMEMBERS":
+			if args[1] == "{a}1" {
+				return "*2\r\n$1\r\nx\r\n$1\r\ny\r\n"
+			}
+			return "*2\r\n$1\r\ny\r\n$1\r\nz\r\n"
+		case "SMOVE":
+			return ":1\r\n"
+		}
+		return ""
+	})
+	defer closeA()
+	f := NewForwarder("crossslot", "127.0.0.1:0", []string{a}, 1, 1, time.Second, time.Second, time.Second, []byte("{}"), false, proto.Overflow{}, proto.Backoff{}, 8, nil)
+	defer f.Close()
+
+	data := "*4\r\n$5\r\nSMOVE\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nx\r\n" +
+		"*4\r\n$5\r\nSMOVE\r\n$4\r\n{a}1\r\n$4\r\n{a}2\r\n$1\r\nx\r\n" +
+		"*3\r\n$6\r\nSINTER\r\n$4\r\n{a}1\r\n$1\r\nb\r\n"
+	conn := mockconn.CreateConn([]byte(data), 1)
+	pc := NewProxyConn(libnet.NewConn(conn, time.Second, time.Second), f)
+	wg := &sync.WaitGroup{}
+	msgs := proto.GetMsgs(3)
+	for _, m := range msgs {
+		m.WithWaitGroup(wg)
+	}
+	msgs, err := pc.Decode(msgs)
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 3)
+	assert.NoError(t, f.Forward(msgs))
+	wg.Wait()
+	for _, m := range msgs {
+		_ = pc.Encode(m)
+	}
+	assert.NoError(t, pc.Flush())
+	assert.Equal(t, "-CROSSSLOT Keys in request don't hash to the same slot: a(slot 15495) b(slot 3300)\r\n"+
+		":1\r\n"+
+		"*1\r\n$1\r\ny\r\n", conn.(*mockconn.MockConn).Wbuf.String())
+}
+
+func TestClusterSlot(t *testing.T) {
+	c := &cluster{hashTag: []byte("{}")}
+	for key, hashed := range map[string]string{
+		"abc":     "abc",
+		"{a}1":    "a",
+		"x{a}{b}": "a",
+		"{}a":     "{}a",
+		"{}{a}":   "{}{a}",
+		"{a":      "{a",
+	} {
+		assert.Equal(t, int(hashkit.Crc16([]byte(hashed))&musk), c.slot([]byte(key)), key)
+	}
+}
+
+func TestClusterScript(t *testing.T) {
+	const sha = "e0e1f9fabfc9d4800c877a703b823ac0578ff8db" // NOTE: sha1 of "return 1"
+	var loads, evals int32
+	script := func(failover bool) func(bool, []string) string {
+		return func(_ bool, args []string) string {
+			switch args[0] {
+			case "SCRIPT":
+				atomic.AddInt32(&loads, 1)
+				return fmt.Sprintf("$40\r\n%s\r\n", sha)
+			case "EVALSHA":
+				if failover {
+					return "-NOSCRIPT No matching script. Please use EVAL.\r\n"
+				}
+				return ":1\r\n"
+			case "EVAL":
+				atomic.AddInt32(&evals, 1)
+				return ":1\r\n"
+			}
+			return ""
+		}
+	}
+	b, closeB := _nodesServer(t, "", script(true))
+	defer closeB()
+	a, closeA := _nodesServer(t, "0000000000000000000000000000000000000001 %s master - 0 0 1 connected 0-8191\n"+
+		"0000000000000000000000000000000000000002 "+b+" master - 0 0 2 connected 8192-16383\n", script(false))
+	defer closeA()
+	f := NewForwarder("script", "127.0.0.1:0", []string{a}, 1, 1, time.Second, time.Second, time.Second, nil, false, proto.Overflow{}, proto.Backoff{}, 8, nil)
+	defer f.Close()
+
+	data := "*3\r\n$6\r\nSCRIPT\r\n$4\r\nLOAD\r\n$8\r\nreturn 1\r\n" +
+		"*4\r\n$7\r\nEVALSHA\r\n$40\r\n" + sha + "\r\n$1\r\n1\r\n$1\r\nb\r\n" +
+		"*4\r\n$7\r\nEVALSHA\r\n$40\r\n" + sha + "\r\n$1\r\n1\r\n$1\r\na\r\n"
+	conn := mockconn.CreateConn([]byte(data), 1)
+	pc := NewProxyConn(libnet.NewConn(conn, time.Second, time.Second), f)
+	pc.(*proxyConn).WithScripts(redis.Scripts("script"))
+	wg := &sync.WaitGroup{}
+	msgs := proto.GetMsgs(3)
+	for _, m := range msgs {
+		m.WithWaitGroup(wg)
+	}
+	msgs, err := pc.Decode(msgs)
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 3)
+	assert.NoError(t, f.Forward(msgs[:1]))
+	wg.Wait()
+	// NOTE: the script is cached after replied by all masters.
+	_ = pc.Encode(msgs[0])
+	assert.NoError(t, f.Forward(msgs[1:]))
+	wg.Wait()
+	for _, m := range msgs[1:] {
+		_ = pc.Encode(m)
+	}
+	assert.NoError(t, pc.Flush())
+	assert.Equal(t, fmt.Sprintf("$40\r\n%s\r\n:1\r\n:1\r\n", sha), conn.(*mockconn.MockConn).Wbuf.String())
+	assert.Equal(t, int32(2), atomic.LoadInt32(&loads), "SCRIPT LOAD is sent to all masters")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&evals), "EVALSHA is retried as EVAL on NOSCRIPT")
+}
+
+func TestClusterBlocking(t *testing.T) {
+	a, closeA := _nodesServer(t, "0000000000000000000000000000000000000001 %s master - 0 0 1 connected 0-16383\n", func(_ bool, args []string) string {
+		switch args[0] {
+		case "BLPOP":
+			// NOTE: longer than the read timeout of node.
+			time.Sleep(100 * time.Millisecond)
+			return "*2\r\n$1\r\nq\r\n$1\r\nj\r\n"
+		case "GET":
+			return "$1\r\nv\r\n"
+		}
+		return ""
+	})
+	defer closeA()
+	f := NewForwarder("blocking", "127.0.0.1:0", []string{a}, 1, 1, time.Second, 50*time.Millisecond, time.Second, nil, false, proto.Overflow{}, proto.Backoff{}, 8, nil)
+	defer f.Close()
+
+	data := "*3\r\n$5\r\nBLPOP\r\n$1\r\nq\r\n$1\r\n1\r\n*2\r\n$3\r\nGET\r\n$1\r\nk\r\n"
+	conn := mockconn.CreateConn([]byte(data), 1)
+	pc := NewProxyConn(libnet.NewConn(conn, time.Second, time.Second), f)
+	wg := &sync.WaitGroup{}
+	msgs := proto.GetMsgs(2)
+	for _, m := range msgs {
+		m.WithWaitGroup(wg)
+	}
+	msgs, err := pc.Decode(msgs)
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 2)
+	assert.NoError(t, f.Forward(msgs))
+	wg.Wait()
+	for _, m := range msgs {
+		assert.NoError(t, m.Err())
+		_ = pc.Encode(m)
+	}
+	assert.NoError(t, pc.Flush())
+	assert.Equal(t, "*2\r\n$1\r\nq\r\n$1\r\nj\r\n$1\r\nv\r\n", conn.(*mockconn.MockConn).Wbuf.String())
+	assert.True(t, msgs[1].RemoteDur() < 50*time.Millisecond, "GET is not blocked by BLPOP")
+}
+
+func TestClusterStreams(t *testing.T) {
+	a, closeA := _nodesServer(t, "0000000000000000000000000000000000000001 %s master - 0 0 1 connected 0-16383\n", func(_ bool, args []string) string {
+		if args[0] != "XREAD" {
+			return ""
+		}
+		if len(args) == 4 && args[2] == "a" {
+			return "*1\r\n*2\r\n$1\r\na\r\n*1\r\n*2\r\n$3\r\n1-0\r\n*2\r\n$1\r\nf\r\n$1\r\nv\r\n"
+		}
+		return "*-1\r\n"
+	})
+	defer closeA()
+	f := NewForwarder("streams", "127.0.0.1:0", []string{a}, 1, 1, time.Second, time.Second, time.Second, []byte("{}"), false, proto.Overflow{}, proto.Backoff{}, 8, nil)
+	defer f.Close()
+
+	data := "*6\r\n$5\r\nXREAD\r\n$7\r\nSTREAMS\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\n0\r\n$1\r\n0\r\n" +
+		"*6\r\n$5\r\nXREAD\r\n$7\r\nSTREAMS\r\n$4\r\n{a}1\r\n$4\r\n{a}2\r\n$1\r\n0\r\n$1\r\n0\r\n" +
+		"*8\r\n$5\r\nXREAD\r\n$5\r\nBLOCK\r\n$1\r\n0\r\n$7\r\nSTREAMS\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\n$\r\n$1\r\n$\r\n"
+	conn := mockconn.CreateConn([]byte(data), 1)
+	pc := NewProxyConn(libnet.NewConn(conn, time.Second, time.Second), f)
+	wg := &sync.WaitGroup{}
+	msgs := proto.GetMsgs(3)
+	for _, m := range msgs {
+		m.WithWaitGroup(wg)
+	}
+	msgs, err := pc.Decode(msgs)
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 3)
+	assert.True(t, msgs[0].IsBatch(), "split keys in different slots")
+	assert.False(t, msgs[1].IsBatch())
+	assert.NoError(t, f.Forward(msgs))
+	wg.Wait()
+	for _, m := range msgs {
+		_ = pc.Encode(m)
+	}
+	assert.NoError(t, pc.Flush())
+	assert.Equal(t, "*1\r\n*2\r\n$1\r\na\r\n*1\r\n*2\r\n$3\r\n1-0\r\n*2\r\n$1\r\nf\r\n$1\r\nv\r\n"+
+		"*-1\r\n"+
+		"-CROSSSLOT Keys in request don't hash to the same slot: a(slot 15495) b(slot 3300)\r\n", conn.(*mockconn.MockConn).Wbuf.String())
+}
+
+func TestClusterStore(t *testing.T) {
+	a, closeA := _nodesServer(t, "0000000000000000000000000000000000000001 %s master - 0 0 1 connected 0-16383\n", func(_ bool, args []string) string {
+		switch args[0] {
+		case "GEORADIUS", "COPY":
+			return ":1\r\n"
+		}
+		return ""
+	})
+	defer closeA()
+	f := NewForwarder("store", "127.0.0.1:0", []string{a}, 1, 1, time.Second, time.Second, time.Second, []byte("{}"), false, proto.Overflow{}, proto.Backoff{}, 8, nil)
+	defer f.Close()
+
+	data := "*8\r\n$9\r\nGEORADIUS\r\n$1\r\na\r\n$1\r\n1\r\n$1\r\n2\r\n$1\r\n3\r\n$2\r\nkm\r\n$5\r\nSTORE\r\n$1\r\nb\r\n" +
+		"*8\r\n$9\r\nGEORADIUS\r\n$4\r\n{a}1\r\n$1\r\n1\r\n$1\r\n2\r\n$1\r\n3\r\n$2\r\nkm\r\n$5\r\nSTORE\r\n$4\r\n{a}2\r\n" +
+		"*3\r\n$4\r\nCOPY\r\n$1\r\na\r\n$1\r\nb\r\n"
+	conn := mockconn.CreateConn([]byte(data), 1)
+	pc := NewProxyConn(libnet.NewConn(conn, time.Second, time.Second), f)
+	wg := &sync.WaitGroup{}
+	msgs := proto.GetMsgs(3)
+	for _, m := range msgs {
+		m.WithWaitGroup(wg)
+	}
+	msgs, err := pc.Decode(msgs)
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 3)
+	assert.NoError(t, f.Forward(msgs))
+	wg.Wait()
+	for _, m := range msgs {
+		_ = pc.Encode(m)
+	}
+	assert.NoError(t, pc.Flush())
+	crossSlot := "-CROSSSLOT Keys in request don't hash to the same slot: a(slot 15495) b(slot 3300)\r\n"
+	assert.Equal(t, crossSlot+":1\r\n"+crossSlot, conn.(*mockconn.MockConn).Wbuf.String())
+}
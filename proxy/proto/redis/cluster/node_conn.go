@@ -2,11 +2,12 @@ package cluster
 
 import (
 	"bytes"
-	"strings"
 	"sync/atomic"
+	"time"
 
 	"overlord/pkg/conv"
 	"overlord/pkg/log"
+	"overlord/pkg/prom"
 	"overlord/proxy/proto"
 	"overlord/proxy/proto/redis"
 
@@ -30,18 +31,15 @@ type nodeConn struct {
 	addr string
 	nc   proto.NodeConn
 
-	sb strings.Builder
-
-	redirects int
-
 	state int32
 }
 
 func newNodeConn(c *cluster, addr string) (nc proto.NodeConn) {
+	dto, rto, wto := c.timeouts()
 	nc = &nodeConn{
 		c:    c,
 		addr: addr,
-		nc:   redis.NewNodeConn(c.name, addr, c.dto, c.rto, c.wto),
+		nc:   redis.NewNodeConn(c.name, addr, dto, rto, wto),
 	}
 	return
 }
@@ -55,6 +53,12 @@ func (nc *nodeConn) Cluster() string {
 }
 
 func (nc *nodeConn) Write(m *proto.Message) (err error) {
+	if m.Asking() {
+		if err = nc.nc.(*redis.NodeConn).Bw().Write(askingResp); err != nil {
+			err = errors.WithStack(err)
+			return
+		}
+	}
 	if err = nc.nc.Write(m); err != nil {
 		err = errors.WithStack(err)
 	}
@@ -66,6 +70,13 @@ func (nc *nodeConn) Flush() error {
 }
 
 func (nc *nodeConn) Read(m *proto.Message) (err error) {
+	if m.Asking() {
+		// NOTE: the reply of ASKING is overwritten by the reply of request.
+		if err = nc.nc.Read(m); err != nil {
+			err = errors.WithStack(err)
+			return
+		}
+	}
 	if err = nc.nc.Read(m); err != nil {
 		err = errors.WithStack(err)
 		return
@@ -79,44 +90,53 @@ func (nc *nodeConn) Read(m *proto.Message) (err error) {
 	if reply.Type() != respRedirect {
 		return
 	}
-	if nc.redirects >= maxRedirects { // NOTE: check max redirects
+	data := reply.Data()
+	if !bytes.HasPrefix(data, askBytes) && !bytes.HasPrefix(data, movedBytes) {
+		return
+	}
+	if m.Redirects() >= maxRedirects { // NOTE: check max redirects
 		if log.V(4) {
 			log.Infof("Redis Cluster NodeConn key(%s) already max redirects", req.Key())
 		}
+		if prom.On {
+			prom.RedirectIncr(nc.c.name, nc.addr, "max")
+		}
 		return
 	}
-	data := reply.Data()
-	if !bytes.HasPrefix(data, askBytes) && !bytes.HasPrefix(data, movedBytes) {
-		return
+	addrBs, slot, isAsk, _ := parseRedirect(data)
+	addr := string(addrBs)
+	if isAsk {
+		if prom.On {
+			prom.RedirectIncr(nc.c.name, nc.addr, "ask")
+		}
+	} else {
+		nc.c.moved(slot, addr)
+		if prom.On {
+			prom.RedirectIncr(nc.c.name, nc.addr, "moved")
+		}
 	}
-
-	addrBs, _, isAsk, _ := parseRedirect(data)
-	if !isAsk {
-		// tryFetch when key moved
-		nc.c.toFetch()
+	if ncp := nc.c.pipe(addr); ncp != nil {
+		// NOTE: requeued into the pipe of addr after this batch.
+		m.Redirect(isAsk, ncp.Push)
+		return
 	}
-	nc.sb.Reset()
-	nc.sb.Write(addrBs)
-	addr := nc.sb.String()
 	// redirect process
 	if err = nc.redirectProcess(m, req, addr, isAsk); err != nil && log.V(2) {
 		log.Errorf("Redis Cluster NodeConn redirectProcess addr:%s error:%v", addr, err)
 	}
-	nc.redirects = 0
 	return
 }
 
+// redirectProcess redirects m to the unknown addr by a temporary connection.
 func (nc *nodeConn) redirectProcess(m *proto.Message, req *redis.Request, addr string, isAsk bool) (err error) {
 	// next redirect
-	nc.redirects++
+	m.Redirect(isAsk, nil)
 	if log.V(5) {
-		log.Infof("Redis Cluster NodeConn key(%s) redirect count(%d)", req.Key(), nc.redirects)
+		log.Infof("Redis Cluster NodeConn key(%s) redirect count(%d)", req.Key(), m.Redirects())
 	}
 	// start redirect
 	nnc := newNodeConn(nc.c, addr)
-	tmp := nnc.(*nodeConn)
-	tmp.redirects = nc.redirects // NOTE: for check max redirects
-	rnc := tmp.nc.(*redis.NodeConn)
+	rnc := nnc.(*nodeConn).nc.(*redis.NodeConn)
 	defer nnc.Close()
 	if isAsk {
 		if err = rnc.Bw().Write(askingResp); err != nil {
@@ -140,6 +160,13 @@ func (nc *nodeConn) redirectProcess(m *proto.Message, req *redis.Request, addr s
 	return
 }
 
+// Block impl the proto.BlockNodeConn.
+func (nc *nodeConn) Block(timeout time.Duration) {
+	if bnc, ok := nc.nc.(proto.BlockNodeConn); ok {
+		bnc.Block(timeout)
+	}
+}
+
 func (nc *nodeConn) Close() (err error) {
 	if atomic.CompareAndSwapInt32(&nc.state, opening, closed) {
 		return nc.nc.Close()
@@ -15,6 +15,7 @@ var (
 	cmdClusterBytes = []byte("7\r\nCLUSTER")
 	cmdNodesBytes   = []byte("5\r\nNODES")
 	cmdSlotsBytes   = []byte("5\r\nSLOTS")
+	cmdShardsBytes  = []byte("6\r\nSHARDS")
 	notSupportBytes = []byte("-Error: command not support\r\n")
 )
 
@@ -38,9 +39,22 @@ func NewProxyConn(conn *libnet.Conn, fer proto.Forwarder) proto.ProxyConn {
 		c:  c,
 		pc: redis.NewProxyConn(conn, false),
 	}
+	if c != nil {
+		r.pc.(*redis.ProxyConn).WithSlot(c.slot)
+	}
 	return r
 }
 
+// WithClienter sets the handler of CLIENT command.
+func (pc *proxyConn) WithClienter(c redis.Clienter) {
+	pc.pc.(*redis.ProxyConn).WithClienter(c)
+}
+
+// WithScripts sets the script cache of cluster.
+func (pc *proxyConn) WithScripts(sc *redis.ScriptCache) {
+	pc.pc.(*redis.ProxyConn).WithScripts(sc)
+}
+
 func (pc *proxyConn) Decode(msgs []*proto.Message) ([]*proto.Message, error) {
 	return pc.pc.Decode(msgs)
 }
@@ -58,11 +72,15 @@ func (pc *proxyConn) Encode(m *proto.Message) (err error) {
 					pcc := pc.pc.(*redis.ProxyConn)
 					if bytes.Equal(arr[1].Data(), cmdNodesBytes) {
 						// CLUSTER NODES
-						err = pcc.Bw().Write(pc.c.fakeNodesBytes)
+						err = pcc.Bw().Write(pc.c.fakeNodes())
 						return
 					} else if bytes.Equal(arr[1].Data(), cmdSlotsBytes) {
 						// CLUSTER SLOTS
-						err = pcc.Bw().Write(pc.c.fakeSlotsBytes)
+						err = pcc.Bw().Write(pc.c.fakeSlots())
+						return
+					} else if bytes.Equal(arr[1].Data(), cmdShardsBytes) {
+						// CLUSTER SHARDS
+						err = pcc.Bw().Write(pc.c.fakeShards())
 						return
 					}
 					err = pcc.Bw().Write(notSupportBytes)
@@ -18,8 +18,7 @@ func TestProxyConn(t *testing.T) {
 		c:  &cluster{},
 		pc: &redis.ProxyConn{},
 	}
-	pc.c.fakeNodesBytes = []byte("fake nodes bytes")
-	pc.c.fakeSlotsBytes = []byte("fake slots bytes")
+	pc.c.topo.Store(&topology{nodes: []byte("fake nodes bytes"), slots: []byte("fake slots bytes")})
 	bw := &bufio.Writer{}
 	msgs := proto.GetMsgs(1)
 	msg := msgs[0]
@@ -64,7 +63,7 @@ func TestProxyConn(t *testing.T) {
 	})
 	// bw stub
 	monkey.PatchInstanceMethod(reflect.TypeOf(bw), "Write", func(_ *bufio.Writer, bs []byte) error {
-		assert.Equal(t, pc.c.fakeNodesBytes, bs)
+		assert.Equal(t, pc.c.fakeNodes(), bs)
 		return nil
 	})
 	err := pc.Encode(msg)
@@ -81,7 +80,7 @@ func TestProxyConn(t *testing.T) {
 	})
 	// bw stub
 	monkey.PatchInstanceMethod(reflect.TypeOf(bw), "Write", func(_ *bufio.Writer, bs []byte) error {
-		assert.Equal(t, pc.c.fakeSlotsBytes, bs)
+		assert.Equal(t, pc.c.fakeSlots(), bs)
 		return nil
 	})
 	err = pc.Encode(msg)
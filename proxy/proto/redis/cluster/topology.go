@@ -0,0 +1,278 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"overlord/pkg/etcd"
+	"overlord/pkg/log"
+)
+
+const (
+	// fakeSingleMasters is the count of masters which the slots of single proxy are split into.
+	fakeSingleMasters = 3
+
+	etcdProxyDir       = "/overlord/proxies/%s"
+	defaultTopologyTTL = 10 * time.Second
+)
+
+// Topology is the config of proxies advertised by the faked CLUSTER NODES and CLUSTER SLOTS,
+// the slots are spread across the proxy itself and peers.
+type Topology struct {
+	// Peers are the static addrs of peer proxies.
+	Peers []string
+	// Registry discovers the alive proxies if not nil.
+	Registry Registry
+	// TTL of the heartbeat into Registry.
+	TTL time.Duration
+	// Passthrough listens one port for each of masters and return the ports by master,
+	// the topology mirrors the slots of real masters if not nil and Peers are ignored.
+	Passthrough func(masters []string) map[string]string
+}
+
+// Registry is where proxies of the same cluster heartbeat and discover each other.
+type Registry interface {
+	// Heartbeat registers addr as alive for ttl.
+	Heartbeat(addr string, ttl time.Duration) error
+	// Alive return the addrs which are heartbeating.
+	Alive() ([]string, error)
+}
+
+// etcdRegistry registers proxies by keys with ttl under the dir of cluster.
+type etcdRegistry struct {
+	e   *etcd.Etcd
+	dir string
+}
+
+// NewEtcdRegistry new a Registry of cluster by etcd endpoint.
+func NewEtcdRegistry(endpoint, cluster string) (Registry, error) {
+	e, err := etcd.New(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return &etcdRegistry{e: e, dir: fmt.Sprintf(etcdProxyDir, cluster)}, nil
+}
+
+func (r *etcdRegistry) Heartbeat(addr string, ttl time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), ttl)
+	defer cancel()
+	return r.e.SetTTL(ctx, path.Join(r.dir, addr), addr, ttl)
+}
+
+func (r *etcdRegistry) Alive() ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	nodes, err := r.e.LS(ctx, r.dir)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		addrs = append(addrs, node.Value)
+	}
+	return addrs, nil
+}
+
+// topology is the faked replies of CLUSTER NODES, CLUSTER SLOTS and CLUSTER SHARDS.
+type topology struct {
+	addrs  []string
+	nodes  []byte
+	slots  []byte
+	shards []byte
+}
+
+// fakeMaster is the master in faked topology which owns the slot ranges.
+type fakeMaster struct {
+	id     string
+	addr   string
+	epoch  int
+	ranges [][2]int
+}
+
+// newTopology spreads the slots across addrs in order of addr,
+// so all proxies of the same addrs reply the same topology.
+func newTopology(addrs []string) *topology {
+	set := make(map[string]struct{}, len(addrs))
+	uniq := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		if _, ok := set[addr]; ok || addr == "" {
+			continue
+		}
+		set[addr] = struct{}{}
+		uniq = append(uniq, addr)
+	}
+	sort.Strings(uniq)
+	if len(uniq) == 0 {
+		return &topology{}
+	}
+	addrs = uniq
+	if len(uniq) == 1 {
+		addrs = make([]string, fakeSingleMasters)
+		for i := range addrs {
+			addrs[i] = uniq[0]
+		}
+	}
+	var (
+		n       = len(addrs)
+		masters = make([]*fakeMaster, n)
+		start   = 0
+	)
+	for i, addr := range addrs {
+		// NOTE: the ends are rounded to split slots as redis-trib does.
+		end := (2*(i+1)*slotsCount+n)/(2*n) - 1
+		masters[i] = &fakeMaster{id: fmt.Sprintf("%040x", i+1), addr: addr, epoch: i + 1, ranges: [][2]int{{start, end}}}
+		start = end + 1
+	}
+	t := buildTopology(masters)
+	t.addrs = uniq
+	return t
+}
+
+// passthroughTopology mirrors the slots of real masters, each master is replaced by the proxy addr of it.
+func passthroughTopology(ns *nodeSlots, addrs map[string]string) *topology {
+	var (
+		masters = map[string]*fakeMaster{}
+		last    *fakeMaster
+	)
+	for slot, addr := range ns.slots {
+		m, ok := masters[addr]
+		if !ok {
+			n := ns.nodes[addr]
+			m = &fakeMaster{id: n.ID, addr: addrs[addr], epoch: n.configEpoch}
+			masters[addr] = m
+		}
+		if m == last {
+			m.ranges[len(m.ranges)-1][1] = slot
+		} else {
+			m.ranges = append(m.ranges, [2]int{slot, slot})
+		}
+		last = m
+	}
+	ms := make([]*fakeMaster, 0, len(masters))
+	for _, m := range masters {
+		ms = append(ms, m)
+	}
+	sort.Slice(ms, func(i, j int) bool { return ms[i].ranges[0][0] < ms[j].ranges[0][0] })
+	t := buildTopology(ms)
+	for _, m := range ms {
+		t.addrs = append(t.addrs, m.addr)
+	}
+	return t
+}
+
+// buildTopology encodes the replies of masters.
+func buildTopology(masters []*fakeMaster) *topology {
+	var (
+		nodes  = &bytes.Buffer{}
+		slots  = &bytes.Buffer{}
+		shards = &bytes.Buffer{}
+		count  int
+	)
+	for _, m := range masters {
+		count += len(m.ranges)
+	}
+	fmt.Fprintf(slots, "*%d\r\n", count)
+	fmt.Fprintf(shards, "*%d\r\n", len(masters))
+	for _, m := range masters {
+		host, port, _ := net.SplitHostPort(m.addr)
+		fmt.Fprintf(nodes, "%s %s master - 0 0 %d connected", m.id, m.addr, m.epoch)
+		fmt.Fprintf(shards, "*4\r\n$5\r\nslots\r\n*%d\r\n", len(m.ranges)*2)
+		for _, r := range m.ranges {
+			if r[0] == r[1] {
+				fmt.Fprintf(nodes, " %d", r[0])
+			} else {
+				fmt.Fprintf(nodes, " %d-%d", r[0], r[1])
+			}
+			fmt.Fprintf(slots, "*3\r\n:%d\r\n:%d\r\n*2\r\n$%d\r\n%s\r\n:%s\r\n", r[0], r[1], len(host), host, port)
+			fmt.Fprintf(shards, ":%d\r\n:%d\r\n", r[0], r[1])
+		}
+		nodes.WriteByte('\n')
+		fmt.Fprintf(shards, "$5\r\nnodes\r\n*1\r\n*12\r\n"+
+			"$2\r\nid\r\n$%d\r\n%s\r\n$4\r\nport\r\n:%s\r\n$2\r\nip\r\n$%d\r\n%s\r\n"+
+			"$4\r\nrole\r\n$6\r\nmaster\r\n$18\r\nreplication-offset\r\n:0\r\n$6\r\nhealth\r\n$6\r\nonline\r\n",
+			len(m.id), m.id, port, len(host), host)
+	}
+	return &topology{
+		nodes:  []byte("$" + strconv.Itoa(nodes.Len()) + "\r\n" + nodes.String() + "\r\n"),
+		slots:  slots.Bytes(),
+		shards: shards.Bytes(),
+	}
+}
+
+func (c *cluster) fakeNodes() []byte {
+	t, _ := c.topo.Load().(*topology)
+	if t == nil {
+		return nil
+	}
+	return t.nodes
+}
+
+func (c *cluster) fakeSlots() []byte {
+	t, _ := c.topo.Load().(*topology)
+	if t == nil {
+		return nil
+	}
+	return t.slots
+}
+
+func (c *cluster) fakeShards() []byte {
+	t, _ := c.topo.Load().(*topology)
+	if t == nil {
+		return nil
+	}
+	return t.shards
+}
+
+// setTopology stores the topology if changed.
+func (c *cluster) setTopology(t *topology) {
+	if old, ok := c.topo.Load().(*topology); ok && bytes.Equal(old.nodes, t.nodes) {
+		return
+	}
+	c.topo.Store(t)
+	log.Infof("Redis Cluster %s fake topology with proxies %v", c.name, t.addrs)
+}
+
+// passthrough listens one proxy addr for each master and mirrors the real slots.
+func (c *cluster) passthrough(ns *nodeSlots) {
+	if c.host == "" {
+		return
+	}
+	ports := c.topology.Passthrough(ns.getMasters())
+	addrs := make(map[string]string, len(ports))
+	for _, master := range ns.getMasters() {
+		port, ok := ports[master]
+		if !ok {
+			// NOTE: the proxy routes by slot, so the seed addr serves the master too.
+			port = c.port
+		}
+		addrs[master] = net.JoinHostPort(c.host, port)
+	}
+	c.setTopology(passthroughTopology(ns, addrs))
+}
+
+// discover heartbeats self into registry and refreshes the topology by alive proxies until closed.
+func (c *cluster) discover(self string) {
+	ttl := c.topology.TTL
+	if ttl <= 0 {
+		ttl = defaultTopologyTTL
+	}
+	for atomic.LoadInt32(&c.state) != closed {
+		if err := c.topology.Registry.Heartbeat(self, ttl); err != nil {
+			log.Warnf("Redis Cluster %s fail to heartbeat %s into registry error:%v", c.name, self, err)
+		}
+		alive, err := c.topology.Registry.Alive()
+		if err != nil {
+			log.Warnf("Redis Cluster %s fail to discover proxies error:%v", c.name, err)
+		} else {
+			c.setTopology(newTopology(append(append(alive, self), c.topology.Peers...)))
+		}
+		time.Sleep(ttl / 3)
+	}
+}
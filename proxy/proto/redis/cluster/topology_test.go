@@ -0,0 +1,100 @@
+package cluster
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mockRegistry struct {
+	lock  sync.Mutex
+	alive map[string]time.Time
+}
+
+func (r *mockRegistry) Heartbeat(addr string, ttl time.Duration) error {
+	r.lock.Lock()
+	r.alive[addr] = time.Now().Add(ttl)
+	r.lock.Unlock()
+	return nil
+}
+
+func (r *mockRegistry) Alive() (addrs []string, err error) {
+	r.lock.Lock()
+	for addr, expire := range r.alive {
+		if time.Now().Before(expire) {
+			addrs = append(addrs, addr)
+		}
+	}
+	r.lock.Unlock()
+	return
+}
+
+func TestTopologySingle(t *testing.T) {
+	topo := newTopology([]string{"10.0.0.1:7000"})
+	nodes := "" +
+		"0000000000000000000000000000000000000001 10.0.0.1:7000 master - 0 0 1 connected 0-5460\n" +
+		"0000000000000000000000000000000000000002 10.0.0.1:7000 master - 0 0 2 connected 5461-10922\n" +
+		"0000000000000000000000000000000000000003 10.0.0.1:7000 master - 0 0 3 connected 10923-16383\n"
+	assert.Equal(t, "$"+strconv.Itoa(len(nodes))+"\r\n"+nodes+"\r\n", string(topo.nodes))
+	slots := "" +
+		"*3\r\n" +
+		"*3\r\n:0\r\n:5460\r\n*2\r\n$8\r\n10.0.0.1\r\n:7000\r\n" +
+		"*3\r\n:5461\r\n:10922\r\n*2\r\n$8\r\n10.0.0.1\r\n:7000\r\n" +
+		"*3\r\n:10923\r\n:16383\r\n*2\r\n$8\r\n10.0.0.1\r\n:7000\r\n"
+	assert.Equal(t, slots, string(topo.slots))
+}
+
+func TestTopologyPeers(t *testing.T) {
+	topo := newTopology([]string{"10.0.0.2:7000", "10.0.0.1:7000", "10.0.0.2:7000", "10.0.0.3:7000", "10.0.0.4:7000"})
+	assert.Equal(t, []string{"10.0.0.1:7000", "10.0.0.2:7000", "10.0.0.3:7000", "10.0.0.4:7000"}, topo.addrs)
+	lines := strings.Split(strings.TrimSpace(string(topo.nodes)), "\n")
+	assert.Len(t, lines, 5)
+	assert.Equal(t, "0000000000000000000000000000000000000001 10.0.0.1:7000 master - 0 0 1 connected 0-4095", lines[1])
+	assert.Equal(t, "0000000000000000000000000000000000000004 10.0.0.4:7000 master - 0 0 4 connected 12288-16383", lines[4])
+	assert.True(t, strings.HasPrefix(string(topo.slots), "*4\r\n*3\r\n:0\r\n:4095\r\n"))
+}
+
+func TestTopologyDiscover(t *testing.T) {
+	registry := &mockRegistry{alive: map[string]time.Time{}}
+	c := &cluster{name: "test", topology: &Topology{
+		Peers:    []string{"10.0.0.9:7000"},
+		Registry: registry,
+		TTL:      60 * time.Millisecond,
+	}}
+	registry.Heartbeat("10.0.0.2:7000", 60*time.Millisecond)
+	go c.discover("10.0.0.1:7000")
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, []string{"10.0.0.1:7000", "10.0.0.2:7000", "10.0.0.9:7000"}, c.topo.Load().(*topology).addrs)
+
+	// NOTE: the peer stops heartbeating and disappears after ttl.
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, []string{"10.0.0.1:7000", "10.0.0.9:7000"}, c.topo.Load().(*topology).addrs)
+	assert.Contains(t, string(c.fakeNodes()), "10.0.0.9:7000 master - 0 0 2 connected 8192-16383")
+	atomic.StoreInt32(&c.state, closed)
+}
+
+func TestTopologyPassthrough(t *testing.T) {
+	data := strings.Replace(_slotDemo, "connected 10923-16383", "connected 10923-12000 12002-16383", 1)
+	data = strings.Replace(data, "connected 0-5460", "connected 0-5460 12001", 1)
+	ns, err := parseSlots([]byte(data))
+	assert.NoError(t, err)
+	topo := passthroughTopology(ns, map[string]string{
+		"172.17.0.2:7000": "10.0.0.1:27100",
+		"172.17.0.2:7001": "10.0.0.1:27101",
+		"172.17.0.2:7002": "10.0.0.1:27102",
+	})
+	assert.Equal(t, []string{"10.0.0.1:27100", "10.0.0.1:27101", "10.0.0.1:27102"}, topo.addrs)
+	lines := strings.Split(strings.TrimSpace(string(topo.nodes)), "\n")
+	assert.Len(t, lines, 4)
+	assert.Equal(t, "b1798ba2171a4bd765846ddb5d5bdc9f3ca6fdf3 10.0.0.1:27100 master - 0 0 1 connected 0-5460 12001", lines[1])
+	assert.Equal(t, "828c400ea2b55c43e5af67af94bec4943b7b3d93 10.0.0.1:27102 master - 0 0 3 connected 10923-12000 12002-16383", lines[3])
+	assert.True(t, strings.HasPrefix(string(topo.slots), "*5\r\n*3\r\n:0\r\n:5460\r\n*2\r\n$8\r\n10.0.0.1\r\n:27100\r\n"))
+	assert.True(t, strings.HasPrefix(string(topo.shards), "*3\r\n*4\r\n$5\r\nslots\r\n*4\r\n:0\r\n:5460\r\n:12001\r\n:12001\r\n$5\r\nnodes\r\n*1\r\n*12\r\n"+
+		"$2\r\nid\r\n$40\r\nb1798ba2171a4bd765846ddb5d5bdc9f3ca6fdf3\r\n$4\r\nport\r\n:27100\r\n"))
+	assert.Contains(t, string(topo.shards), "*4\r\n:10923\r\n:12000\r\n:12002\r\n:16383\r\n")
+}
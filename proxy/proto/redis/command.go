@@ -0,0 +1,451 @@
+package redis
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"overlord/pkg/conv"
+	"overlord/pkg/prom"
+)
+
+var (
+	cmdCommandBytes = []byte("7\r\nCOMMAND")
+
+	storeBytes     = []byte("STORE")
+	storeDistBytes = []byte("STOREDIST")
+	sortByBytes    = []byte("BY")
+	sortLimitBytes = []byte("LIMIT")
+	sortGetBytes   = []byte("GET")
+
+	commandCountBytes = []byte("COUNT")
+	commandInfoBytes  = []byte("INFO")
+
+	cmdCopyBytes = []byte("4\r\nCOPY")
+	copyDBBytes  = []byte("DB")
+)
+
+// cmdFlag is the flags of command.
+type cmdFlag uint8
+
+// command flags
+const (
+	flagReadonly cmdFlag = 1 << iota
+	flagWrite
+	flagAdmin
+	flagBlocking
+	flagRandom
+	// NOTE: replied by proxy and not reported by COMMAND.
+	flagControl
+)
+
+var flagNames = []struct {
+	flag cmdFlag
+	name string
+}{
+	{flagReadonly, "readonly"},
+	{flagWrite, "write"},
+	{flagAdmin, "admin"},
+	{flagBlocking, "blocking"},
+	{flagRandom, "random"},
+}
+
+// command is the spec of command modelled on the reply of COMMAND INFO.
+type command struct {
+	name string
+	// arity is the number of arguments including the name, at least -arity if negative.
+	arity int
+	flags cmdFlag
+	// keys are in [first, last] by step if first > 0, the last is counted from the end if negative.
+	first, last, step int
+	// numkeys is the index of the argument of the number of keys, which follow it.
+	numkeys int
+	// keyFunc returns the keys located by options instead of positions, like STREAMS of XREAD.
+	keyFunc func(args []*resp) []*resp
+
+	info []byte
+}
+
+// commandTable is the commands supported by proxy, the admin ones are known but rejected.
+//
+// NOTE: scripts may write any key, so EVAL, EVALSHA and SCRIPT are write commands.
+var commandTable = []*command{
+	{name: "get", arity: 2, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "getbit", arity: 3, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "getrange", arity: 4, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "mget", arity: -2, flags: flagReadonly, first: 1, last: -1, step: 1},
+	{name: "strlen", arity: 2, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "bitcount", arity: -2, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "bitpos", arity: -3, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "bitfield_ro", arity: -2, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "set", arity: -3, flags: flagWrite, first: 1, last: 1, step: 1},
+	{name: "setbit", arity: 4, flags: flagWrite, first: 1, last: 1, step: 1},
+	{name: "setex", arity: 4, flags: flagWrite, first: 1, last: 1, step: 1},
+	{name: "setnx", arity: 3, flags: flagWrite, first: 1, last: 1, step: 1},
+	{name: "setrange", arity: 4, flags: flagWrite, first: 1, last: 1, step: 1},
+	{name: "psetex", arity: 4, flags: flagWrite, first: 1, last: 1, step: 1},
+	{name: "mset", arity: -3, flags: flagWrite, first: 1, last: -1, step: 2},
+	{name: "getset", arity: 3, flags: flagWrite, first: 1, last: 1, step: 1},
+	{name: "append", arity: 3, flags: flagWrite, first: 1, last: 1, step: 1},
+	{name: "incr", arity: 2, flags: flagWrite, first: 1, last: 1, step: 1},
+	{name: "incrby", arity: 3, flags: flagWrite, first: 1, last: 1, step: 1},
+	{name: "incrbyfloat", arity: 3, flags: flagWrite, first: 1, last: 1, step: 1},
+	{name: "decr", arity: 2, flags: flagWrite, first: 1, last: 1, step: 1},
+	{name: "decrby", arity: 3, flags: flagWrite, first: 1, last: 1, step: 1},
+	{name: "getex", arity: -2, flags: flagWrite, first: 1, last: 1, step: 1},
+	{name: "getdel", arity: 2, flags: flagWrite, first: 1, last: 1, step: 1},
+	{name: "bitfield", arity: -2, flags: flagWrite, first: 1, last: 1, step: 1},
+	{name: "bitop", arity: -4, flags: flagWrite, first: 2, last: -1, step: 1},
+
+	{name: "exists", arity: -2, flags: flagReadonly, first: 1, last: -1, step: 1},
+	{name: "type", arity: 2, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "ttl", arity: 2, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "pttl", arity: 2, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "dump", arity: 2, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "object", arity: -2, flags: flagReadonly, first: 2, last: 2, step: 1},
+	{name: "del", arity: -2, flags: flagWrite, first: 1, last: -1, step: 1},
+	{name: "expire", arity: -3, flags: flagWrite, first: 1, last: 1, step: 1},
+	{name: "expireat", arity: -3, flags: flagWrite, first: 1, last: 1, step: 1},
+	{name: "pexpire", arity: -3, flags: flagWrite, first: 1, last: 1, step: 1},
+	{name: "pexpireat", arity: -3, flags: flagWrite, first: 1, last: 1, step: 1},
+	{name: "persist", arity: 2, flags: flagWrite, first: 1, last: 1, step: 1},
+	{name: "restore", arity: -4, flags: flagWrite, first: 1, last: 1, step: 1},
+	{name: "sort_ro", arity: -2, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "sort", arity: -2, flags: flagWrite, first: 1, last: 1, step: 1, keyFunc: sortKeys},
+	{name: "copy", arity: -3, flags: flagWrite, first: 1, last: 2, step: 1},
+	{name: "migrate", arity: -6, flags: flagWrite | flagAdmin},
+
+	{name: "hget", arity: 3, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "hmget", arity: -3, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "hgetall", arity: 2, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "hexists", arity: 3, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "hkeys", arity: 2, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "hvals", arity: 2, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "hlen", arity: 2, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "hstrlen", arity: 3, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "hscan", arity: -3, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "hrandfield", arity: -2, flags: flagReadonly | flagRandom, first: 1, last: 1, step: 1},
+	{name: "hset", arity: -4, flags: flagWrite, first: 1, last: 1, step: 1},
+	{name: "hsetnx", arity: 4, flags: flagWrite, first: 1, last: 1, step: 1},
+	{name: "hmset", arity: -4, flags: flagWrite, first: 1, last: 1, step: 1},
+	{name: "hdel", arity: -3, flags: flagWrite, first: 1, last: 1, step: 1},
+	{name: "hincrby", arity: 4, flags: flagWrite, first: 1, last: 1, step: 1},
+	{name: "hincrbyfloat", arity: 4, flags: flagWrite, first: 1, last: 1, step: 1},
+
+	{name: "lindex", arity: 3, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "llen", arity: 2, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "lrange", arity: 4, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "lpos", arity: -3, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "linsert", arity: 5, flags: flagWrite, first: 1, last: 1, step: 1},
+	{name: "lpop", arity: -2, flags: flagWrite, first: 1, last: 1, step: 1},
+	{name: "lpush", arity: -3, flags: flagWrite, first: 1, last: 1, step: 1},
+	{name: "lpushx", arity: -3, flags: flagWrite, first: 1, last: 1, step: 1},
+	{name: "lrem", arity: 4, flags: flagWrite, first: 1, last: 1, step: 1},
+	{name: "lset", arity: 4, flags: flagWrite, first: 1, last: 1, step: 1},
+	{name: "ltrim", arity: 4, flags: flagWrite, first: 1, last: 1, step: 1},
+	{name: "rpop", arity: -2, flags: flagWrite, first: 1, last: 1, step: 1},
+	{name: "rpush", arity: -3, flags: flagWrite, first: 1, last: 1, step: 1},
+	{name: "rpushx", arity: -3, flags: flagWrite, first: 1, last: 1, step: 1},
+	{name: "rpoplpush", arity: 3, flags: flagWrite, first: 1, last: 2, step: 1},
+	{name: "lmove", arity: 5, flags: flagWrite, first: 1, last: 2, step: 1},
+	{name: "blpop", arity: -3, flags: flagWrite | flagBlocking, first: 1, last: -2, step: 1},
+	{name: "brpop", arity: -3, flags: flagWrite | flagBlocking, first: 1, last: -2, step: 1},
+	{name: "brpoplpush", arity: 4, flags: flagWrite | flagBlocking, first: 1, last: 2, step: 1},
+	{name: "blmove", arity: 6, flags: flagWrite | flagBlocking, first: 1, last: 2, step: 1},
+
+	{name: "scard", arity: 2, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "sismember", arity: 3, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "smismember", arity: -3, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "smembers", arity: 2, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "srandmember", arity: -2, flags: flagReadonly | flagRandom, first: 1, last: 1, step: 1},
+	{name: "sscan", arity: -3, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "sunion", arity: -2, flags: flagReadonly, first: 1, last: -1, step: 1},
+	{name: "sinter", arity: -2, flags: flagReadonly, first: 1, last: -1, step: 1},
+	{name: "sdiff", arity: -2, flags: flagReadonly, first: 1, last: -1, step: 1},
+	{name: "sadd", arity: -3, flags: flagWrite, first: 1, last: 1, step: 1},
+	{name: "srem", arity: -3, flags: flagWrite, first: 1, last: 1, step: 1},
+	{name: "spop", arity: -2, flags: flagWrite | flagRandom, first: 1, last: 1, step: 1},
+	{name: "smove", arity: 4, flags: flagWrite, first: 1, last: 2, step: 1},
+	{name: "sunionstore", arity: -3, flags: flagWrite, first: 1, last: -1, step: 1},
+
+	{name: "zcard", arity: 2, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "zcount", arity: 4, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "zlexcount", arity: 4, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "zrange", arity: -4, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "zrangebylex", arity: -4, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "zrangebyscore", arity: -4, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "zrevrange", arity: -4, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "zrevrangebylex", arity: -4, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "zrevrangebyscore", arity: -4, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "zrank", arity: -3, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "zrevrank", arity: -3, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "zscore", arity: 3, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "zmscore", arity: -3, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "zrandmember", arity: -2, flags: flagReadonly | flagRandom, first: 1, last: 1, step: 1},
+	{name: "zunion", arity: -3, flags: flagReadonly, numkeys: 1},
+	{name: "zinter", arity: -3, flags: flagReadonly, numkeys: 1},
+	{name: "zdiff", arity: -3, flags: flagReadonly, numkeys: 1},
+	{name: "zscan", arity: -3, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "zadd", arity: -4, flags: flagWrite, first: 1, last: 1, step: 1},
+	{name: "zincrby", arity: 4, flags: flagWrite, first: 1, last: 1, step: 1},
+	{name: "zrem", arity: -3, flags: flagWrite, first: 1, last: 1, step: 1},
+	{name: "zremrangebylex", arity: 4, flags: flagWrite, first: 1, last: 1, step: 1},
+	{name: "zremrangebyrank", arity: 4, flags: flagWrite, first: 1, last: 1, step: 1},
+	{name: "zremrangebyscore", arity: 4, flags: flagWrite, first: 1, last: 1, step: 1},
+	{name: "zunionstore", arity: -4, flags: flagWrite, first: 1, last: 1, step: 1, numkeys: 2},
+	{name: "zinterstore", arity: -4, flags: flagWrite, first: 1, last: 1, step: 1, numkeys: 2},
+	{name: "zdiffstore", arity: -4, flags: flagWrite, first: 1, last: 1, step: 1, numkeys: 2},
+	{name: "zrangestore", arity: -5, flags: flagWrite, first: 1, last: 2, step: 1},
+	{name: "bzpopmin", arity: -3, flags: flagWrite | flagBlocking, first: 1, last: -2, step: 1},
+	{name: "bzpopmax", arity: -3, flags: flagWrite | flagBlocking, first: 1, last: -2, step: 1},
+
+	{name: "pfcount", arity: -2, flags: flagReadonly, first: 1, last: -1, step: 1},
+	{name: "pfadd", arity: -2, flags: flagWrite, first: 1, last: 1, step: 1},
+	{name: "pfmerge", arity: -2, flags: flagWrite, first: 1, last: -1, step: 1},
+
+	{name: "geopos", arity: -2, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "geohash", arity: -2, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "geodist", arity: -4, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "georadius_ro", arity: -6, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "georadiusbymember_ro", arity: -5, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "geosearch", arity: -7, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "geoadd", arity: -5, flags: flagWrite, first: 1, last: 1, step: 1},
+	{name: "georadius", arity: -6, flags: flagWrite, first: 1, last: 1, step: 1, keyFunc: geoRadiusKeys(6)},
+	{name: "georadiusbymember", arity: -5, flags: flagWrite, first: 1, last: 1, step: 1, keyFunc: geoRadiusKeys(5)},
+	{name: "geosearchstore", arity: -8, flags: flagWrite, first: 1, last: 2, step: 1},
+
+	{name: "xlen", arity: 2, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "xrange", arity: -4, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "xrevrange", arity: -4, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "xpending", arity: -3, flags: flagReadonly, first: 1, last: 1, step: 1},
+	{name: "xinfo", arity: -2, flags: flagReadonly, first: 2, last: 2, step: 1},
+	{name: "xread", arity: -4, flags: flagReadonly | flagBlocking, keyFunc: xreadKeys},
+	{name: "xreadgroup", arity: -7, flags: flagWrite | flagBlocking, keyFunc: xreadKeys},
+	{name: "xadd", arity: -5, flags: flagWrite, first: 1, last: 1, step: 1},
+	{name: "xdel", arity: -3, flags: flagWrite, first: 1, last: 1, step: 1},
+	{name: "xtrim", arity: -4, flags: flagWrite, first: 1, last: 1, step: 1},
+	{name: "xgroup", arity: -2, flags: flagWrite, first: 2, last: 2, step: 1},
+	{name: "xack", arity: -4, flags: flagWrite, first: 1, last: 1, step: 1},
+	{name: "xclaim", arity: -6, flags: flagWrite, first: 1, last: 1, step: 1},
+	{name: "xautoclaim", arity: -6, flags: flagWrite, first: 1, last: 1, step: 1},
+	{name: "xsetid", arity: -3, flags: flagWrite, first: 1, last: 1, step: 1},
+
+	{name: "eval", arity: -3, flags: flagWrite, numkeys: 2},
+	{name: "evalsha", arity: -3, flags: flagWrite, numkeys: 2},
+	{name: "script", arity: -2, flags: flagWrite},
+
+	{name: "ping", arity: -1, flags: flagControl},
+	{name: "quit", arity: -1, flags: flagControl},
+	{name: "client", arity: -2, flags: flagControl},
+	{name: "command", arity: -1, flags: flagControl},
+}
+
+var (
+	// NOTE: use the bulk data of command as key, like "3\r\nGET".
+	commands = map[string]*command{}
+
+	// commandsInfo is the reply of COMMAND.
+	commandsInfo  []byte
+	commandsCount int
+)
+
+func init() {
+	var infos []byte
+	for _, c := range commandTable {
+		name := strings.ToUpper(c.name)
+		commands[strconv.Itoa(len(name))+"\r\n"+name] = c
+		prom.RegisterCmds(name)
+		c.info = c.encodeInfo()
+		if c.flags&flagAdmin == 0 {
+			infos = append(infos, c.info...)
+			commandsCount++
+		}
+	}
+	commandsInfo = append([]byte(fmt.Sprintf("*%d\r\n", commandsCount)), infos...)
+}
+
+// lookupCommand returns the command by the bulk data of name, nil if unknown.
+func lookupCommand(name []byte) *command {
+	return commands[string(name)]
+}
+
+// validArity checks the number of arguments including the name.
+func (c *command) validArity(n int) bool {
+	if c.arity < 0 {
+		return n >= -c.arity
+	}
+	return n == c.arity
+}
+
+// movable returns true if the keys are not only at fixed positions.
+func (c *command) movable() bool {
+	return c.keyFunc != nil || c.numkeys > 0
+}
+
+// multiKey returns true if the command may have more than one keys.
+func (c *command) multiKey() bool {
+	return c.movable() || c.last != c.first
+}
+
+// firstKey returns the first key of args, nil if none.
+func (c *command) firstKey(args []*resp) *resp {
+	if c.keyFunc != nil {
+		if keys := c.keyFunc(args); len(keys) > 0 {
+			return keys[0]
+		}
+		return nil
+	}
+	if c.first > 0 && c.first < len(args) {
+		last := c.last
+		if last < 0 {
+			last += len(args)
+		}
+		if c.first <= last {
+			return args[c.first]
+		}
+	}
+	if c.numkeys > 0 && c.numkeys+1 < len(args) {
+		if n, err := conv.Btoi(argData(args[c.numkeys])); err == nil && n > 0 {
+			return args[c.numkeys+1]
+		}
+	}
+	return nil
+}
+
+// keys returns all the keys of args.
+func (c *command) keys(args []*resp) (keys []*resp) {
+	if c.keyFunc != nil {
+		return c.keyFunc(args)
+	}
+	if c.first > 0 {
+		last := c.last
+		if last < 0 {
+			last += len(args)
+		}
+		for i := c.first; i <= last && i < len(args); i += c.step {
+			keys = append(keys, args[i])
+		}
+	}
+	if c.numkeys > 0 && c.numkeys < len(args) {
+		n, err := conv.Btoi(argData(args[c.numkeys]))
+		if err != nil {
+			return
+		}
+		for i := c.numkeys + 1; i <= c.numkeys+int(n) && i < len(args); i++ {
+			keys = append(keys, args[i])
+		}
+	}
+	return
+}
+
+// encodeInfo encodes the command like the reply of COMMAND INFO: name, arity, flags, first, last and step.
+func (c *command) encodeInfo() []byte {
+	var flags []string
+	for _, f := range flagNames {
+		if c.flags&f.flag != 0 {
+			flags = append(flags, f.name)
+		}
+	}
+	if c.movable() {
+		flags = append(flags, "movablekeys")
+	}
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "*6\r\n$%d\r\n%s\r\n:%d\r\n*%d\r\n", len(c.name), c.name, c.arity, len(flags))
+	for _, flag := range flags {
+		fmt.Fprintf(buf, "+%s\r\n", flag)
+	}
+	fmt.Fprintf(buf, ":%d\r\n:%d\r\n:%d\r\n", c.first, c.last, c.step)
+	return buf.Bytes()
+}
+
+// xreadKeys returns the keys after STREAMS of XREAD and XREADGROUP.
+func xreadKeys(args []*resp) []*resp {
+	idx, _, _ := streams(args)
+	if idx == 0 {
+		return nil
+	}
+	return streamKeys(args, idx)
+}
+
+// sortKeys returns the key of SORT and the destination of STORE option.
+func sortKeys(args []*resp) []*resp {
+	if len(args) < 2 {
+		return nil
+	}
+	keys := args[1:2]
+	for i := 2; i < len(args); {
+		opt := bytes.ToUpper(argData(args[i]))
+		switch {
+		case bytes.Equal(opt, sortByBytes), bytes.Equal(opt, sortGetBytes):
+			i += 2
+		case bytes.Equal(opt, sortLimitBytes):
+			i += 3
+		case bytes.Equal(opt, storeBytes) && i+1 < len(args):
+			return []*resp{args[1], args[i+1]}
+		default:
+			i++
+		}
+	}
+	return keys
+}
+
+// geoRadiusKeys returns the keys func of GEORADIUS and GEORADIUSBYMEMBER whose options start at opts,
+// the keys are the source and the destinations of STORE and STOREDIST options.
+func geoRadiusKeys(opts int) func(args []*resp) []*resp {
+	return func(args []*resp) []*resp {
+		if len(args) < 2 {
+			return nil
+		}
+		keys := args[1:2]
+		for i := opts; i+1 < len(args); i++ {
+			opt := bytes.ToUpper(argData(args[i]))
+			if bytes.Equal(opt, storeBytes) || bytes.Equal(opt, storeDistBytes) {
+				keys = append(keys[:len(keys):len(keys)], args[i+1])
+				i++
+			}
+		}
+		return keys
+	}
+}
+
+// command replies COMMAND, COMMAND COUNT and COMMAND INFO by the command table.
+func (pc *proxyConn) command(req *Request) error {
+	args := req.resp.array[1:req.resp.arraySize]
+	if len(args) == 0 {
+		return pc.bw.Write(commandsInfo)
+	}
+	sub := bytes.ToUpper(argData(args[0]))
+	switch {
+	case bytes.Equal(sub, commandCountBytes):
+		_ = pc.bw.Write(respIntBytes)
+		_ = pc.bw.Write([]byte(strconv.Itoa(commandsCount)))
+		return pc.bw.Write(crlfBytes)
+	case bytes.Equal(sub, commandInfoBytes):
+		_ = pc.bw.Write(respArrayBytes)
+		_ = pc.bw.Write([]byte(strconv.Itoa(len(args) - 1)))
+		_ = pc.bw.Write(crlfBytes)
+		for _, arg := range args[1:] {
+			name := bytes.ToUpper(argData(arg))
+			c := lookupCommand([]byte(strconv.Itoa(len(name)) + "\r\n" + string(name)))
+			if c == nil || c.flags&flagAdmin != 0 {
+				_ = pc.bw.Write(respArrayBytes)
+				_ = pc.bw.Write(nullBytes)
+				continue
+			}
+			_ = pc.bw.Write(c.info)
+		}
+		return nil
+	}
+	return pc.bw.Write([]byte(fmt.Sprintf("-ERR unknown subcommand '%s'. Try COMMAND HELP.\r\n", argData(args[0]))))
+}
+
+// copyDB checks if COPY copies into another db by the DB option, which is not supported.
+func copyDB(args []*resp) bool {
+	for _, arg := range args[3:] {
+		if bytes.EqualFold(argData(arg), copyDBBytes) {
+			return true
+		}
+	}
+	return false
+}
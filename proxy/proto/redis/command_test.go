@@ -0,0 +1,127 @@
+package redis
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"overlord/pkg/bufio"
+	"overlord/pkg/mockconn"
+	libnet "overlord/pkg/net"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandKeys(t *testing.T) {
+	for _, tc := range []struct {
+		req  string
+		key  string
+		keys []string
+	}{
+		{"*5\r\n$5\r\nBITOP\r\n$3\r\nAND\r\n$1\r\nd\r\n$1\r\na\r\n$1\r\nb\r\n", "d", []string{"d", "a", "b"}},
+		{"*3\r\n$6\r\nOBJECT\r\n$8\r\nENCODING\r\n$1\r\na\r\n", "a", nil},
+		{"*4\r\n$4\r\nMSET\r\n$1\r\na\r\n$1\r\n1\r\n$1\r\nb\r\n", "a", []string{"a", "b"}},
+		{"*5\r\n$11\r\nZINTERSTORE\r\n$1\r\nd\r\n$1\r\n2\r\n$1\r\na\r\n$1\r\nb\r\n", "d", []string{"d", "a", "b"}},
+		{"*4\r\n$4\r\nEVAL\r\n$1\r\ns\r\n$1\r\n0\r\n$1\r\nv\r\n", "s", nil},
+		{"*2\r\n$6\r\nSCRIPT\r\n$5\r\nFLUSH\r\n", "FLUSH", nil},
+		{"*3\r\n$4\r\nCOPY\r\n$1\r\ns\r\n$1\r\nd\r\n", "s", []string{"s", "d"}},
+		{"*5\r\n$5\r\nLMOVE\r\n$1\r\ns\r\n$1\r\nd\r\n$4\r\nLEFT\r\n$5\r\nRIGHT\r\n", "s", []string{"s", "d"}},
+		{"*4\r\n$6\r\nZUNION\r\n$1\r\n2\r\n$1\r\na\r\n$1\r\nb\r\n", "a", []string{"a", "b"}},
+		{"*7\r\n$9\r\nGEORADIUS\r\n$1\r\ng\r\n$1\r\n1\r\n$1\r\n2\r\n$1\r\n3\r\n$2\r\nkm\r\n$9\r\nWITHCOORD\r\n", "g", []string{"g"}},
+		{"*10\r\n$17\r\nGEORADIUSBYMEMBER\r\n$1\r\ng\r\n$5\r\nstore\r\n$1\r\n3\r\n$2\r\nkm\r\n$5\r\nstore\r\n$1\r\nd\r\n$9\r\nSTOREDIST\r\n$1\r\ne\r\n$3\r\nASC\r\n", "g", []string{"g", "d", "e"}},
+		{"*9\r\n$14\r\nGEOSEARCHSTORE\r\n$1\r\nd\r\n$1\r\ng\r\n$10\r\nFROMMEMBER\r\n$1\r\nm\r\n$8\r\nBYRADIUS\r\n$1\r\n1\r\n$2\r\nkm\r\n$9\r\nSTOREDIST\r\n", "d", []string{"d", "g"}},
+		{"*7\r\n$4\r\nSORT\r\n$1\r\nl\r\n$3\r\nGET\r\n$5\r\nstore\r\n$5\r\nSTORE\r\n$1\r\nd\r\n$5\r\nALPHA\r\n", "l", []string{"l", "d"}},
+		{"*3\r\n$4\r\nSORT\r\n$1\r\nl\r\n$5\r\nALPHA\r\n", "l", []string{"l"}},
+	} {
+		req := newReq()
+		br := bufio.NewReader(libnet.NewConn(mockconn.CreateConn([]byte(tc.req), 1), time.Second, time.Second), bufio.Get(1024))
+		br.Read()
+		assert.NoError(t, req.resp.decode(br))
+		assert.Equal(t, tc.key, string(req.Key()), tc.req)
+		var keys []string
+		for _, key := range req.Keys() {
+			keys = append(keys, string(key))
+		}
+		assert.Equal(t, tc.keys, keys, tc.req)
+	}
+}
+
+func TestCommandFlags(t *testing.T) {
+	msgs := _decodeMessage(t, "*2\r\n$3\r\nGET\r\n$1\r\na\r\n"+
+		"*2\r\n$11\r\nSRANDMEMBER\r\n$1\r\na\r\n"+
+		"*3\r\n$4\r\nEVAL\r\n$1\r\ns\r\n$1\r\n0\r\n"+
+		"*6\r\n$7\r\nMIGRATE\r\n$1\r\nh\r\n$1\r\np\r\n$1\r\na\r\n$1\r\n0\r\n$1\r\n5\r\n"+
+		"*1\r\n$7\r\nCOMMAND\r\n"+
+		"*6\r\n$4\r\nCOPY\r\n$1\r\ns\r\n$1\r\nd\r\n$2\r\ndb\r\n$1\r\n1\r\n$7\r\nREPLACE\r\n"+
+		"*4\r\n$4\r\nCOPY\r\n$1\r\ns\r\n$1\r\nd\r\n$7\r\nREPLACE\r\n")
+	assert.Len(t, msgs, 7)
+	get := msgs[0].Request().(*Request)
+	assert.True(t, get.IsSupport())
+	assert.False(t, get.IsWrite())
+	assert.NotNil(t, get.CoalesceKey())
+	assert.Nil(t, msgs[1].Request().(*Request).CoalesceKey(), "random")
+	eval := msgs[2].Request().(*Request)
+	assert.True(t, eval.IsWrite())
+	assert.Nil(t, eval.CoalesceKey())
+	assert.False(t, msgs[3].Request().(*Request).IsSupport(), "admin")
+	command := msgs[4].Request().(*Request)
+	assert.True(t, command.IsSupport())
+	assert.True(t, command.IsCtl())
+	assert.False(t, msgs[5].Request().(*Request).IsSupport(), "copy into another db")
+	assert.True(t, msgs[6].Request().(*Request).IsSupport())
+}
+
+func TestEncodeCommand(t *testing.T) {
+	getInfo := "*6\r\n$3\r\nget\r\n:2\r\n*1\r\n+readonly\r\n:1\r\n:1\r\n:1\r\n"
+	xreadInfo := "*6\r\n$5\r\nxread\r\n:-4\r\n*3\r\n+readonly\r\n+blocking\r\n+movablekeys\r\n:0\r\n:0\r\n:0\r\n"
+	ts := []struct {
+		Name   string
+		Req    string
+		Expect string
+	}{
+		{
+			Name:   "count",
+			Req:    "*2\r\n$7\r\nCOMMAND\r\n$5\r\ncount\r\n",
+			Expect: fmt.Sprintf(":%d\r\n", commandsCount),
+		},
+		{
+			Name:   "info",
+			Req:    "*5\r\n$7\r\nCOMMAND\r\n$4\r\nINFO\r\n$3\r\nget\r\n$5\r\nXREAD\r\n$7\r\nmigrate\r\n",
+			Expect: "*3\r\n" + getInfo + xreadInfo + "*-1\r\n",
+		},
+		{
+			Name:   "unknown",
+			Req:    "*2\r\n$7\r\nCOMMAND\r\n$4\r\nDOCS\r\n",
+			Expect: "-ERR unknown subcommand 'DOCS'. Try COMMAND HELP.\r\n",
+		},
+		{
+			Name:   "wrong arity",
+			Req:    "*1\r\n$4\r\nMGET\r\n",
+			Expect: "-ERR wrong number of arguments for 'mget' command\r\n",
+		},
+		{
+			Name:   "not support",
+			Req:    "*2\r\n$4\r\nKEYS\r\n$1\r\n*\r\n",
+			Expect: "-Error: command not support\r\n",
+		},
+	}
+	for _, tt := range ts {
+		t.Run(tt.Name, func(t *testing.T) {
+			msg := _decodeMessage(t, tt.Req)[0]
+			conn, buf := mockconn.CreateDownStreamConn()
+			pc := NewProxyConn(libnet.NewConn(conn, time.Second, time.Second), true)
+			assert.NoError(t, pc.Encode(msg))
+			assert.NoError(t, pc.Flush())
+			assert.Equal(t, tt.Expect, buf.String())
+		})
+	}
+
+	msg := _decodeMessage(t, "*1\r\n$7\r\nCOMMAND\r\n")[0]
+	conn, buf := mockconn.CreateDownStreamConn()
+	pc := NewProxyConn(libnet.NewConn(conn, time.Second, time.Second), true)
+	assert.NoError(t, pc.Encode(msg))
+	assert.NoError(t, pc.Flush())
+	assert.Contains(t, buf.String(), fmt.Sprintf("*%d\r\n", commandsCount))
+	assert.Contains(t, buf.String(), xreadInfo)
+	assert.NotContains(t, buf.String(), "migrate")
+}
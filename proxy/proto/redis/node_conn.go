@@ -38,6 +38,8 @@ type nodeConn struct {
 	conn    *libnet.Conn
 	bw      *bufio.Writer
 	br      *bufio.Reader
+	// readTimeout is the read timeout of conn, extended by the timeout of blocking command.
+	readTimeout time.Duration
 
 	state int32
 }
@@ -45,7 +47,10 @@ type nodeConn struct {
 // NewNodeConn create the node conn from proxy to redis
 func NewNodeConn(cluster, addr string, dialTimeout, readTimeout, writeTimeout time.Duration) (nc proto.NodeConn) {
 	conn := libnet.DialWithTimeout(addr, dialTimeout, readTimeout, writeTimeout)
-	return newNodeConn(cluster, addr, conn)
+	proto.StatNodeConn(cluster, addr, conn)
+	nc = newNodeConn(cluster, addr, conn)
+	nc.(*nodeConn).readTimeout = readTimeout
+	return
 }
 
 func newNodeConn(cluster, addr string, conn *libnet.Conn) proto.NodeConn {
@@ -116,8 +121,21 @@ func (nc *nodeConn) Read(m *proto.Message) (err error) {
 			err = errors.WithStack(err)
 			return
 		}
+		if req.retryScript() {
+			// NOTE: NOSCRIPT after failover or new node joins, retried as EVAL.
+			m.Retry()
+		}
+		return
+	}
+}
+
+// Block impl the proto.BlockNodeConn and waits the reply at most the read timeout after the timeout of blocking command.
+func (nc *nodeConn) Block(timeout time.Duration) {
+	if timeout == 0 || nc.readTimeout == 0 {
+		nc.conn.SetReadTimeout(0)
 		return
 	}
+	nc.conn.SetReadTimeout(nc.readTimeout + timeout)
 }
 
 func (nc *nodeConn) Close() (err error) {
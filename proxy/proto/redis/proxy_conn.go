@@ -2,6 +2,7 @@ package redis
 
 import (
 	"bytes"
+	"fmt"
 	"strconv"
 
 	"overlord/pkg/bufio"
@@ -22,7 +23,11 @@ var (
 	okBytes             = []byte("OK\r\n")
 	pongDataBytes       = []byte("PONG")
 	justOkBytes         = []byte("OK")
+	zeroBytes           = []byte("0")
+	oneBytes            = []byte("1")
 	notSupportDataBytes = []byte("Error: command not support")
+
+	wrongArityFormat = "ERR wrong number of arguments for '%s' command"
 )
 
 // ProxyConn is export for redis cluster.
@@ -33,12 +38,37 @@ func (pc *ProxyConn) Bw() *bufio.Writer {
 	return pc.bw
 }
 
+// Clienter handles the CLIENT command of proxy connection.
+type Clienter interface {
+	// Client returns the reply of CLIENT command in RESP, args are the arguments after CLIENT.
+	Client(args [][]byte) []byte
+}
+
+// WithClienter sets the handler of CLIENT command, which is not supported if not set.
+func (pc *ProxyConn) WithClienter(c Clienter) {
+	pc.clienter = c
+}
+
+// WithSlot sets the slot of key, SUNION, SINTER and SDIFF of keys in different slots
+// are split into SMEMBERS of each key and computed by proxy if set.
+func (pc *ProxyConn) WithSlot(slot func(key []byte) int) {
+	pc.slot = slot
+}
+
+// WithScripts sets the script cache of cluster, the scripts are not cached if not set.
+func (pc *ProxyConn) WithScripts(sc *ScriptCache) {
+	pc.scripts = sc
+}
+
 type proxyConn struct {
 	br        *bufio.Reader
 	bw        *bufio.Writer
 	completed bool
 
-	resp *resp
+	resp     *resp
+	clienter Clienter
+	slot     func(key []byte) int
+	scripts  *ScriptCache
 
 	mgetCmd []byte
 	msetCmd []byte
@@ -107,6 +137,12 @@ func (pc *proxyConn) decode(msg *proto.Message) (err error) {
 	}
 	conv.UpdateToUpper(pc.resp.array[0].data)
 	cmd := pc.resp.array[0].data // NOTE: when array, first is command
+	if c := lookupCommand(cmd); c != nil && !c.validArity(pc.resp.arraySize) {
+		r := nextReq(msg)
+		r.wrongArity = true
+		r.resp.copy(pc.resp)
+		return
+	}
 
 	if bytes.Equal(cmd, cmdMSetBytes) {
 		if pc.resp.arraySize < 3 || pc.resp.arraySize%2 == 0 {
@@ -173,13 +209,62 @@ func (pc *proxyConn) decode(msg *proto.Message) (err error) {
 			nre2 := r.resp.next() // NOTE: $klen\r\nkey\r\n
 			nre2.copy(pc.resp.array[i])
 		}
-	} else {
+	} else if mType := setMergeType(cmd); mType != mergeTypeNo && pc.crossSlot(pc.resp.array[1:pc.resp.arraySize]) {
+		for i := 1; i < pc.resp.arraySize; i++ {
+			r := nextReq(msg)
+			r.mType = mType
+			r.batchOpCount = 1
+			r.resp.reset() // NOTE: *2\r\n
+			r.resp.respType = respArray
+			r.resp.data = append(r.resp.data, arrayLenTwo...)
+			// array resp: smembers
+			nre1 := r.resp.next() // NOTE: $8\r\nSMEMBERS\r\n
+			nre1.reset()
+			nre1.respType = respBulk
+			nre1.data = append(nre1.data, cmdSMembersBytes...)
+			// array resp: key
+			nre2 := r.resp.next() // NOTE: $klen\r\nkey\r\n
+			nre2.copy(pc.resp.array[i])
+		}
+	} else if bytes.Equal(cmd, cmdScriptBytes) {
 		r := nextReq(msg)
+		r.mType = scriptMergeType(pc.resp.array[:pc.resp.arraySize])
 		r.resp.copy(pc.resp)
+	} else if !isXRead(cmd) || !pc.splitXRead(msg) {
+		r := nextReq(msg)
+		r.resp.copy(pc.resp)
+		r.scripts = pc.scripts
 	}
 	return
 }
 
+// setMergeType return the merge type of SUNION, SINTER and SDIFF.
+func setMergeType(cmd []byte) mergeType {
+	switch {
+	case bytes.Equal(cmd, cmdSUnionBytes):
+		return mergeTypeUnion
+	case bytes.Equal(cmd, cmdSInterBytes):
+		return mergeTypeInter
+	case bytes.Equal(cmd, cmdSDiffBytes):
+		return mergeTypeDiff
+	}
+	return mergeTypeNo
+}
+
+// crossSlot checks if keys are in different slots.
+func (pc *proxyConn) crossSlot(keys []*resp) bool {
+	if pc.slot == nil || len(keys) < 2 {
+		return false
+	}
+	first := pc.slot(argData(keys[0]))
+	for _, key := range keys[1:] {
+		if pc.slot(argData(key)) != first {
+			return true
+		}
+	}
+	return false
+}
+
 func nextReq(m *proto.Message) *Request {
 	req := m.NextReq()
 	if req == nil {
@@ -189,6 +274,9 @@ func nextReq(m *proto.Message) *Request {
 	}
 	r := req.(*Request)
 	r.mType = mergeTypeNo
+	r.merged = false
+	r.wrongArity = false
+	r.scripts = nil
 	return r
 }
 
@@ -211,11 +299,23 @@ func (pc *proxyConn) Encode(m *proto.Message) (err error) {
 		err = pc.mergeJoin(m)
 	case mergeTypeCount:
 		err = pc.mergeCount(m)
+	case mergeTypeUnion, mergeTypeInter, mergeTypeDiff:
+		err = pc.mergeSet(m, req.mType)
+	case mergeTypeAll:
+		err = pc.mergeAll(m)
+	case mergeTypeExists:
+		err = pc.mergeExists(m)
+	case mergeTypeXRead:
+		err = pc.mergeXRead(m)
 	default:
 		if !req.IsSupport() {
 			req.reply.respType = respError
 			req.reply.data = req.reply.data[:0]
-			req.reply.data = append(req.reply.data, notSupportDataBytes...)
+			if req.wrongArity {
+				req.reply.data = append(req.reply.data, fmt.Sprintf(wrongArityFormat, req.command().name)...)
+			} else {
+				req.reply.data = append(req.reply.data, notSupportDataBytes...)
+			}
 		} else if req.IsCtl() {
 			reqData := req.resp.array[0].data
 			if bytes.Equal(reqData, cmdPingBytes) {
@@ -226,6 +326,16 @@ func (pc *proxyConn) Encode(m *proto.Message) (err error) {
 				req.reply.respType = respString
 				req.reply.data = req.reply.data[:0]
 				req.reply.data = append(req.reply.data, justOkBytes...)
+			} else if bytes.Equal(reqData, cmdClientBytes) && pc.clienter != nil {
+				err = pc.client(req)
+				break
+			} else if bytes.Equal(reqData, cmdCommandBytes) {
+				err = pc.command(req)
+				break
+			} else {
+				req.reply.respType = respError
+				req.reply.data = req.reply.data[:0]
+				req.reply.data = append(req.reply.data, notSupportDataBytes...)
 			}
 		}
 		err = req.reply.encode(pc.bw)
@@ -313,6 +423,152 @@ func (pc *proxyConn) mergeJoin(m *proto.Message) (err error) {
 	return
 }
 
+// mergeSet computes the union, intersection or difference of SMEMBERS replies in order.
+func (pc *proxyConn) mergeSet(m *proto.Message, mType mergeType) (err error) {
+	var (
+		reqs    = m.Requests()
+		members []*resp
+		counts  = map[string]int{}
+	)
+	for i, mreq := range reqs {
+		req, ok := mreq.(*Request)
+		if !ok {
+			return ErrBadAssert
+		}
+		if req.reply.respType != respArray {
+			// NOTE: the error of any key, like WRONGTYPE.
+			return req.reply.encode(pc.bw)
+		}
+		for _, mb := range req.reply.array[:req.reply.arraySize] {
+			member := string(mb.data)
+			n, ok := counts[member]
+			switch {
+			case i == 0:
+				members = append(members, mb)
+				counts[member] = 1
+			case mType == mergeTypeUnion && !ok:
+				members = append(members, mb)
+				counts[member] = 1
+			case mType == mergeTypeInter && n == i:
+				counts[member]++
+			case mType == mergeTypeDiff && ok:
+				counts[member] = 0
+			}
+		}
+	}
+	n := 0
+	for _, mb := range members {
+		if keepMember(mType, counts[string(mb.data)], len(reqs)) {
+			n++
+		}
+	}
+	_ = pc.bw.Write(respArrayBytes)
+	_ = pc.bw.Write([]byte(strconv.Itoa(n)))
+	if err = pc.bw.Write(crlfBytes); err != nil {
+		return
+	}
+	for _, mb := range members {
+		if !keepMember(mType, counts[string(mb.data)], len(reqs)) {
+			continue
+		}
+		if err = mb.encode(pc.bw); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// mergeAll writes the first error of the replies of all nodes, or the first reply if all succeed.
+func (pc *proxyConn) mergeAll(m *proto.Message) (err error) {
+	var first *Request
+	for _, mreq := range m.Requests() {
+		req, ok := mreq.(*Request)
+		if !ok {
+			return ErrBadAssert
+		}
+		if req.reply.respType == respError {
+			return req.reply.encode(pc.bw)
+		}
+		if first == nil {
+			first = req
+		}
+	}
+	if first == nil {
+		return ErrBadRequest
+	}
+	if bytes.Equal(first.resp.array[0].data, cmdScriptBytes) {
+		pc.scripts.update(first.resp.array[:first.resp.arraySize])
+	}
+	return first.reply.encode(pc.bw)
+}
+
+// mergeExists writes 1 for the script which is cached by proxy or exists in all nodes.
+func (pc *proxyConn) mergeExists(m *proto.Message) (err error) {
+	var missing []bool
+	for _, mreq := range m.Requests() {
+		req, ok := mreq.(*Request)
+		if !ok {
+			return ErrBadAssert
+		}
+		if req.reply.respType != respArray {
+			return req.reply.encode(pc.bw)
+		}
+		if missing == nil {
+			missing = make([]bool, req.reply.arraySize)
+		}
+		for i, ex := range req.reply.array[:req.reply.arraySize] {
+			if i < len(missing) && !bytes.Equal(ex.data, oneBytes) {
+				missing[i] = true
+			}
+		}
+	}
+	req := m.Request().(*Request)
+	_ = pc.bw.Write(respArrayBytes)
+	_ = pc.bw.Write([]byte(strconv.Itoa(len(missing))))
+	if err = pc.bw.Write(crlfBytes); err != nil {
+		return
+	}
+	for i := range missing {
+		if missing[i] && i+2 < req.resp.arraySize {
+			_, ok := pc.scripts.get(argData(req.resp.array[i+2]))
+			missing[i] = !ok
+		}
+		_ = pc.bw.Write(respIntBytes)
+		if missing[i] {
+			_ = pc.bw.Write(zeroBytes)
+		} else {
+			_ = pc.bw.Write(oneBytes)
+		}
+		if err = pc.bw.Write(crlfBytes); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// keepMember checks if the member of count is in the result of merge type.
+func keepMember(mType mergeType, count, keys int) bool {
+	switch mType {
+	case mergeTypeInter:
+		return count == keys
+	case mergeTypeDiff:
+		return count > 0
+	}
+	return true
+}
+
+func (pc *proxyConn) client(req *Request) error {
+	args := make([][]byte, 0, req.resp.arraySize-1)
+	for _, arg := range req.resp.array[1:req.resp.arraySize] {
+		data := arg.data
+		if arg.respType == respBulk {
+			data = data[bytes.Index(data, crlfBytes)+2:]
+		}
+		args = append(args, data)
+	}
+	return pc.bw.Write(pc.clienter.Client(args))
+}
+
 func (pc *proxyConn) Flush() (err error) {
 	return pc.bw.Flush()
 }
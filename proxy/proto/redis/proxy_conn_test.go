@@ -2,6 +2,7 @@ package redis
 
 import (
 	"errors"
+	"strconv"
 	"testing"
 	"time"
 
@@ -353,3 +354,113 @@ func TestEncodeWithPing(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, "+PONG\r\n", string(data[:size]))
 }
+
+type mockClienter struct {
+	args [][]byte
+}
+
+func (c *mockClienter) Client(args [][]byte) []byte {
+	c.args = args
+	return []byte("+OK\r\n")
+}
+
+func TestEncodeWithClient(t *testing.T) {
+	msgs := _decodeMessage(t, "*3\r\n$6\r\nclient\r\n$7\r\nSETNAME\r\n$3\r\nsvc\r\n")
+	assert.Len(t, msgs, 1)
+	req := msgs[0].Request().(*Request)
+	assert.True(t, req.IsCtl())
+
+	conn, buf := mockconn.CreateDownStreamConn()
+	pc := NewProxyConn(libnet.NewConn(conn, time.Second, time.Second), true)
+	assert.NoError(t, pc.Encode(msgs[0]))
+	assert.NoError(t, pc.Flush())
+	assert.Equal(t, "-Error: command not support\r\n", buf.String())
+
+	buf.Reset()
+	c := &mockClienter{}
+	pc.(*ProxyConn).WithClienter(c)
+	assert.NoError(t, pc.Encode(msgs[0]))
+	assert.NoError(t, pc.Flush())
+	assert.Equal(t, "+OK\r\n", buf.String())
+	assert.Equal(t, [][]byte{[]byte("SETNAME"), []byte("svc")}, c.args)
+}
+
+func TestDecodeCrossSlotSet(t *testing.T) {
+	data := "*3\r\n$6\r\nSUNION\r\n$1\r\na\r\n$1\r\nb\r\n*3\r\n$6\r\nSINTER\r\n$1\r\na\r\n$2\r\nab\r\n*3\r\n$5\r\nSDIFF\r\n$1\r\na\r\n$1\r\nb\r\n"
+	conn := libnet.NewConn(mockconn.CreateConn([]byte(data), 1), time.Second, time.Second)
+	pc := NewProxyConn(conn, true)
+	pc.(*ProxyConn).WithSlot(func(key []byte) int { return int(key[0]) })
+	nmsgs, err := pc.Decode(proto.GetMsgs(16))
+	assert.NoError(t, err)
+	assert.Len(t, nmsgs, 3)
+	// SUNION a b
+	assert.Len(t, nmsgs[0].Batch(), 2)
+	req := nmsgs[0].Requests()[1].(*Request)
+	assert.Equal(t, mergeTypeUnion, req.mType)
+	assert.Equal(t, "SMEMBERS", req.CmdString())
+	assert.Equal(t, "b", string(req.Key()))
+	// SINTER a ab in the same slot
+	assert.False(t, nmsgs[1].IsBatch())
+	assert.Equal(t, "SINTER", nmsgs[1].Request().CmdString())
+	// SDIFF a b
+	assert.Len(t, nmsgs[2].Batch(), 2)
+	assert.Equal(t, mergeTypeDiff, nmsgs[2].Request().(*Request).mType)
+}
+
+func TestEncodeMergeSet(t *testing.T) {
+	members := func(ms ...string) *resp {
+		r := &resp{respType: respArray, data: []byte(strconv.Itoa(len(ms)))}
+		for _, m := range ms {
+			r.next().copy(&resp{respType: respBulk, data: []byte(strconv.Itoa(len(m)) + "\r\n" + m)})
+		}
+		return r
+	}
+	ts := []struct {
+		Name   string
+		MType  mergeType
+		Reply  []*resp
+		Expect string
+	}{
+		{
+			Name:   "union",
+			MType:  mergeTypeUnion,
+			Reply:  []*resp{members("a", "b"), members("c", "a"), members()},
+			Expect: "*3\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n",
+		},
+		{
+			Name:   "inter",
+			MType:  mergeTypeInter,
+			Reply:  []*resp{members("a", "b", "c"), members("c", "a"), members("d", "a", "c")},
+			Expect: "*2\r\n$1\r\na\r\n$1\r\nc\r\n",
+		},
+		{
+			Name:   "diff",
+			MType:  mergeTypeDiff,
+			Reply:  []*resp{members("a", "b", "c"), members("b"), members("d", "c")},
+			Expect: "*1\r\n$1\r\na\r\n",
+		},
+		{
+			Name:   "wrongtype",
+			MType:  mergeTypeUnion,
+			Reply:  []*resp{members("a"), &resp{respType: respError, data: []byte("WRONGTYPE")}},
+			Expect: "-WRONGTYPE\r\n",
+		},
+	}
+	for _, tt := range ts {
+		t.Run(tt.Name, func(t *testing.T) {
+			msg := proto.NewMessage()
+			for _, rpl := range tt.Reply {
+				req := getReq()
+				req.mType = tt.MType
+				req.reply = rpl
+				msg.WithRequest(req)
+			}
+			msg.Batch()
+			conn, buf := mockconn.CreateDownStreamConn()
+			pc := NewProxyConn(libnet.NewConn(conn, time.Second, time.Second), true)
+			assert.NoError(t, pc.Encode(msg))
+			assert.NoError(t, pc.Flush())
+			assert.Equal(t, tt.Expect, buf.String())
+		})
+	}
+}
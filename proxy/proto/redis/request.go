@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"strconv"
 	"sync"
+	"time"
 
 	"overlord/pkg/types"
 	"overlord/proxy/proto"
@@ -21,28 +22,23 @@ var (
 	cmdEvalBytes   = []byte("4\r\nEVAL")
 	cmdQuitBytes   = []byte("4\r\nQUIT")
 	cmdPingBytes   = []byte("4\r\nPING")
+	cmdClientBytes = []byte("6\r\nCLIENT")
 	cmdMSetBytes   = []byte("4\r\nMSET")
 	cmdMGetBytes   = []byte("4\r\nMGET")
 	cmdSetBytes    = []byte("3\r\nSET")
 	cmdGetBytes    = []byte("3\r\nGET")
+	cmdGetExBytes  = []byte("5\r\nGETEX")
+	cmdGetDelBytes = []byte("6\r\nGETDEL")
+	cmdHGetBytes   = []byte("4\r\nHGET")
 	cmdDelBytes    = []byte("3\r\nDEL")
 	cmdExistsBytes = []byte("6\r\nEXISTS")
+	cmdSUnionBytes = []byte("6\r\nSUNION")
+	cmdSInterBytes = []byte("6\r\nSINTER")
+	cmdSDiffBytes  = []byte("5\r\nSDIFF")
 
-	reqSupportCmdMap = map[string]struct{}{}
-	reqControlCmdMap = map[string]struct{}{}
+	cmdSMembersBytes = []byte("8\r\nSMEMBERS")
 )
 
-func init() {
-	supports := append(readCmds, writeCmds...)
-	supports = append(supports, controlCmds...)
-	for _, key := range supports {
-		reqSupportCmdMap[key] = struct{}{}
-	}
-	for _, key := range controlCmds {
-		reqControlCmdMap[key] = struct{}{}
-	}
-}
-
 // errors
 var (
 	ErrBadAssert       = errs.New("bad assert for redis")
@@ -61,6 +57,12 @@ const (
 	mergeTypeCount
 	mergeTypeOK
 	mergeTypeJoin
+	mergeTypeUnion
+	mergeTypeInter
+	mergeTypeDiff
+	mergeTypeAll
+	mergeTypeExists
+	mergeTypeXRead
 )
 
 // Request is the type of a complete redis command
@@ -70,6 +72,12 @@ type Request struct {
 	mType        mergeType
 	merged       bool
 	batchOpCount int
+	// wrongArity is true if the number of arguments is invalid, replied by proxy.
+	wrongArity bool
+	// scripts is the script cache of cluster which EVALSHA is retried by.
+	scripts *ScriptCache
+
+	ckey []byte
 }
 
 var reqPool = &sync.Pool{
@@ -119,6 +127,14 @@ func (r *Request) Cmd() []byte {
 	return cmd.data[pos:]
 }
 
+// command returns the spec of command, nil if unknown.
+func (r *Request) command() *command {
+	if r.resp.arraySize < 1 {
+		return nil
+	}
+	return lookupCommand(r.resp.array[0].data)
+}
+
 // Key impl the proto.protoRequest and get the Key of redis
 func (r *Request) Key() []byte {
 	if r.resp.arraySize < 1 {
@@ -127,22 +143,34 @@ func (r *Request) Key() []byte {
 	if r.resp.arraySize == 1 {
 		return r.resp.array[0].data
 	}
-
 	k := r.resp.array[1]
-	// SUPPORT EVAL command
-	const evalArgsMinCount int = 4
-	if r.resp.arraySize >= evalArgsMinCount {
-		if bytes.Equal(r.resp.array[0].data, cmdEvalBytes) {
-			// find the 4th key with index 3
-			k = r.resp.array[3]
+	if c := r.command(); c != nil {
+		// NOTE: the keyless ones like SCRIPT still route by the first argument.
+		if fk := c.firstKey(r.resp.array[:r.resp.arraySize]); fk != nil {
+			k = fk
 		}
 	}
+	return argData(k)
+}
 
-	var pos int
-	if k.respType == respBulk {
-		pos = bytes.Index(k.data, crlfBytes) + 2
+// Keys return all the keys of the command with more than one keys, nil if not.
+func (r *Request) Keys() (keys [][]byte) {
+	c := r.command()
+	if c == nil || !c.multiKey() {
+		return
+	}
+	for _, key := range c.keys(r.resp.array[:r.resp.arraySize]) {
+		keys = append(keys, argData(key))
+	}
+	return
+}
+
+// argData return the data of bulk argument without the length.
+func argData(arg *resp) []byte {
+	if arg.respType != respBulk {
+		return arg.data
 	}
-	return k.data[pos:]
+	return arg.data[bytes.Index(arg.data, crlfBytes)+2:]
 }
 
 // Put the resource back to pool
@@ -152,15 +180,24 @@ func (r *Request) Put() {
 	r.mType = mergeTypeNo
 	r.merged = false
 	r.batchOpCount = 0
+	r.wrongArity = false
+	r.scripts = nil
 	reqPool.Put(r)
 }
 
 func (r *Request) Merge(reqs []proto.Request) (err error) {
+	if r.mType == mergeTypeXRead {
+		// NOTE: the split XREAD are sent one by one.
+		return ErrIgnoreMerged
+	}
+	// NOTE: check all before merging, so r is unchanged and forwarded alone if any is wrong.
 	for i := range reqs {
-		req := reqs[i].(*Request)
-		if (req.resp.arraySize-1)%r.batchOpCount != 0 {
+		if req := reqs[i].(*Request); (req.resp.arraySize-1)%r.batchOpCount != 0 {
 			return ErrWrongParamCount
 		}
+	}
+	for i := range reqs {
+		req := reqs[i].(*Request)
 		req.merged = true
 		for i := 1; i < req.resp.arraySize; i++ {
 			nr := r.resp.next()
@@ -181,28 +218,130 @@ func (r *Request) Reply() *RESP {
 	return r.reply
 }
 
-// IsSupport check command support.
-//
-// NOTE: use string([]byte) as a map key, it is very specific!!!
-// https://dave.cheney.net/high-performance-go-workshop/dotgo-paris.html#using_byte_as_a_map_key
+// IsSupport check command support, the commands of wrong number of arguments are not supported.
 func (r *Request) IsSupport() bool {
-	if r.resp.arraySize < 1 {
+	c := r.command()
+	if c == nil || c.flags&flagAdmin != 0 || r.wrongArity {
 		return false
 	}
-	_, ok := reqSupportCmdMap[string(r.resp.array[0].data)]
-	return ok
+	if bytes.Equal(r.resp.array[0].data, cmdScriptBytes) {
+		// NOTE: only SCRIPT LOAD, EXISTS and FLUSH sent to all nodes.
+		return r.IsBroadcast()
+	}
+	if bytes.Equal(r.resp.array[0].data, cmdCopyBytes) {
+		return !copyDB(r.resp.array[:r.resp.arraySize])
+	}
+	return true
 }
 
 // IsCtl is control command.
-//
-// NOTE: use string([]byte) as a map key, it is very specific!!!
-// https://dave.cheney.net/high-performance-go-workshop/dotgo-paris.html#using_byte_as_a_map_key
 func (r *Request) IsCtl() bool {
+	c := r.command()
+	return c != nil && c.flags&flagControl != 0
+}
+
+// IsWrite check command modify the data of key.
+func (r *Request) IsWrite() bool {
+	c := r.command()
+	return c != nil && c.flags&flagWrite != 0
+}
+
+// CoalesceKey impl the proto.Coalescer and return the whole request for read command.
+func (r *Request) CoalesceKey() []byte {
+	if !r.IsSupport() {
+		return nil
+	}
+	if c := r.command(); c.flags&flagReadonly == 0 || c.flags&flagRandom != 0 {
+		// NOTE: the replies of random ones differ between identical requests.
+		return nil
+	}
+	r.ckey = r.ckey[:0]
+	for _, arg := range r.resp.array[:r.resp.arraySize] {
+		r.ckey = append(r.ckey, arg.data...)
+		r.ckey = append(r.ckey, crlfBytes...)
+	}
+	return r.ckey
+}
+
+// CopyReply impl the proto.Coalescer and copy reply of the identical request.
+func (r *Request) CopyReply(req proto.Request) error {
+	src, ok := req.(*Request)
+	if !ok {
+		return ErrBadAssert
+	}
+	r.reply.copy(src.reply)
+	return nil
+}
+
+// Blocking impl the proto.Blocker and return the timeout of blocking command, zero waits forever.
+func (r *Request) Blocking() (timeout time.Duration, ok bool) {
+	if r.resp.arraySize < 3 {
+		return
+	}
+	if c := r.command(); c == nil || c.flags&flagBlocking == 0 {
+		return
+	}
+	if isXRead(r.resp.array[0].data) {
+		// NOTE: XREAD and XREADGROUP block with BLOCK option in millisecond.
+		_, timeout, ok = streams(r.resp.array[:r.resp.arraySize])
+		return
+	}
+	ok = true
+	// NOTE: the invalid timeout is replied by node at once.
+	sec, err := strconv.ParseFloat(string(argData(r.resp.array[r.resp.arraySize-1])), 64)
+	if err == nil && sec > 0 {
+		timeout = time.Duration(sec * float64(time.Second))
+	}
+	return
+}
+
+// Hits impl the proto.Hitter and count the nil bulk replies of GET, GETEX, GETDEL, HGET and MGET as missed.
+func (r *Request) Hits() (hit, miss int) {
 	if r.resp.arraySize < 1 {
-		return false
+		return
+	}
+	switch cmd := r.resp.array[0].data; {
+	case bytes.Equal(cmd, cmdGetBytes) || bytes.Equal(cmd, cmdHGetBytes) ||
+		bytes.Equal(cmd, cmdGetExBytes) || bytes.Equal(cmd, cmdGetDelBytes):
+		return countHits(r.reply)
+	case bytes.Equal(cmd, cmdMGetBytes):
+		if r.reply.respType != respArray {
+			return
+		}
+		for _, rp := range r.reply.array[:r.reply.arraySize] {
+			h, m := countHits(rp)
+			hit, miss = hit+h, miss+m
+		}
 	}
-	_, ok := reqControlCmdMap[string(r.resp.array[0].data)]
-	return ok
+	return
+}
+
+func countHits(rp *resp) (hit, miss int) {
+	if rp.respType != respBulk {
+		return
+	}
+	if len(rp.data) == 0 {
+		return 0, 1
+	}
+	return 1, 0
+}
+
+// RequestSize impl the proto.Sizer and return the bytes of request encoded.
+func (r *Request) RequestSize() int {
+	return r.resp.size()
+}
+
+// ReplySize impl the proto.Sizer and return the bytes of reply encoded, replies of merged requests are counted by the main one.
+func (r *Request) ReplySize() int {
+	if r.merged {
+		return 0
+	}
+	return r.reply.size()
+}
+
+// Merged return whether the request is merged into another request.
+func (r *Request) Merged() bool {
+	return r.merged
 }
 
 const maxArray = 32
@@ -228,143 +367,3 @@ func collapseArray(rs []*resp) (collapsed []string) {
 	collapsed[15] = fmt.Sprintf("...collapsed %d...", collapsedCount)
 	return
 }
-
-var (
-	readCmds = []string{
-		"4\r\nDUMP",
-		"6\r\nEXISTS",
-		"4\r\nPTTL",
-		"3\r\nTTL",
-		"4\r\nTYPE",
-		"8\r\nBITCOUNT",
-		"6\r\nBITPOS",
-		"3\r\nGET",
-		"6\r\nGETBIT",
-		"8\r\nGETRANGE",
-		"4\r\nMGET",
-		"6\r\nSTRLEN",
-		"7\r\nHEXISTS",
-		"4\r\nHGET",
-		"7\r\nHGETALL",
-		"5\r\nHKEYS",
-		"4\r\nHLEN",
-		"5\r\nHMGET",
-		"7\r\nHSTRLEN",
-		"5\r\nHVALS",
-		"5\r\nHSCAN",
-		"5\r\nSCARD",
-		"5\r\nSDIFF",
-		"6\r\nSINTER",
-		"9\r\nSISMEMBER",
-		"8\r\nSMEMBERS",
-		"11\r\nSRANDMEMBER",
-		"6\r\nSUNION",
-		"5\r\nSSCAN",
-		"5\r\nZCARD",
-		"6\r\nZCOUNT",
-		"9\r\nZLEXCOUNT",
-		"6\r\nZRANGE",
-		"11\r\nZRANGEBYLEX",
-		"13\r\nZRANGEBYSCORE",
-		"5\r\nZRANK",
-		"9\r\nZREVRANGE",
-		"14\r\nZREVRANGEBYLEX",
-		"16\r\nZREVRANGEBYSCORE",
-		"8\r\nZREVRANK",
-		"6\r\nZSCORE",
-		"5\r\nZSCAN",
-		"6\r\nLINDEX",
-		"4\r\nLLEN",
-		"6\r\nLRANGE",
-		"7\r\nPFCOUNT",
-	}
-	writeCmds = []string{
-		"3\r\nDEL",
-		"6\r\nEXPIRE",
-		"8\r\nEXPIREAT",
-		"7\r\nPERSIST",
-		"7\r\nPEXPIRE",
-		"9\r\nPEXPIREAT",
-		"7\r\nRESTORE",
-		"4\r\nSORT",
-		"6\r\nAPPEND",
-		"4\r\nDECR",
-		"6\r\nDECRBY",
-		"6\r\nGETSET",
-		"4\r\nINCR",
-		"6\r\nINCRBY",
-		"11\r\nINCRBYFLOAT",
-		"4\r\nMSET",
-		"6\r\nPSETEX",
-		"3\r\nSET",
-		"6\r\nSETBIT",
-		"5\r\nSETEX",
-		"5\r\nSETNX",
-		"8\r\nSETRANGE",
-		"4\r\nHDEL",
-		"7\r\nHINCRBY",
-		"12\r\nHINCRBYFLOAT",
-		"5\r\nHMSET",
-		"4\r\nHSET",
-		"6\r\nHSETNX",
-		"7\r\nLINSERT",
-		"4\r\nLPOP",
-		"5\r\nLPUSH",
-		"6\r\nLPUSHX",
-		"4\r\nLREM",
-		"4\r\nLSET",
-		"5\r\nLTRIM",
-		"4\r\nRPOP",
-		"9\r\nRPOPLPUSH",
-		"5\r\nRPUSH",
-		"6\r\nRPUSHX",
-		"4\r\nSADD",
-		"5\r\nSMOVE",
-		"4\r\nSPOP",
-		"4\r\nSREM",
-		"4\r\nZADD",
-		"7\r\nZINCRBY",
-		"11\r\nZINTERSTORE",
-		"4\r\nZREM",
-		"14\r\nZREMRANGEBYLEX",
-		"15\r\nZREMRANGEBYRANK",
-		"16\r\nZREMRANGEBYSCORE",
-		"5\r\nPFADD",
-		"7\r\nPFMERGE",
-		"4\r\nEVAL",
-		"11\r\nSUNIONSTORE",
-		"11\r\nZUNIONSTORE",
-	}
-	notSupportCmds = []string{
-		"6\r\nMSETNX",
-		"10\r\nSDIFFSTORE",
-		"11\r\nSINTERSTORE",
-		"5\r\nBLPOP",
-		"5\r\nBRPOP",
-		"10\r\nBRPOPLPUSH",
-		"4\r\nKEYS",
-		"7\r\nMIGRATE",
-		"4\r\nMOVE",
-		"6\r\nOBJECT",
-		"9\r\nRANDOMKEY",
-		"6\r\nRENAME",
-		"8\r\nRENAMENX",
-		"4\r\nSCAN",
-		"4\r\nWAIT",
-		"5\r\nBITOP",
-		"7\r\nEVALSHA",
-		"4\r\nAUTH",
-		"4\r\nECHO",
-		"4\r\nINFO",
-		"5\r\nPROXY",
-		"7\r\nSLOWLOG",
-		"6\r\nSELECT",
-		"4\r\nTIME",
-		"6\r\nCONFIG",
-		"8\r\nCOMMANDS",
-	}
-	controlCmds = []string{
-		"4\r\nQUIT",
-		"4\r\nPING",
-	}
-)
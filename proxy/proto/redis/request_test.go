@@ -61,6 +61,21 @@ func TestMergeRequest(t *testing.T) {
 	assert.Equal(t, []byte("2\r\nv3"), mainReq.resp.array[6].data)
 }
 
+func TestMergeRequestWrongParamCount(t *testing.T) {
+	bs := []byte("*7\r\n$4\r\nMSET\r\n$2\r\nk1\r\n$2\r\nv1\r\n$2\r\nk2\r\n$2\r\nv2\r\n$2\r\nk3\r\n$2\r\nv3\r\n")
+	conn := libnet.NewConn(mockconn.CreateConn(bs, 1), time.Second, time.Second)
+	pc := NewProxyConn(conn, true)
+	msgs, err := pc.Decode(proto.GetMsgs(1))
+	assert.NoError(t, err)
+	reqs := msgs[0].Requests()
+	mainReq := reqs[0].(*Request)
+	// NOTE: the last one is wrong after the first is checked.
+	reqs[2].(*Request).resp.arraySize = 2
+	assert.Equal(t, ErrWrongParamCount, mainReq.Merge(reqs[1:]))
+	assert.Equal(t, 3, mainReq.resp.arraySize, "main request is unchanged")
+	assert.False(t, reqs[1].(*Request).Merged())
+}
+
 func BenchmarkCmdTypeCheck(b *testing.B) {
 	req := getReq()
 	req.resp.array = append(req.resp.array, &resp{
@@ -70,3 +85,98 @@ func BenchmarkCmdTypeCheck(b *testing.B) {
 		req.IsSupport()
 	}
 }
+
+func TestRequestHits(t *testing.T) {
+	for _, tc := range []struct {
+		req, reply string
+		hit, miss  int
+	}{
+		{"*2\r\n$3\r\nGET\r\n$1\r\na\r\n", "$1\r\n1\r\n", 1, 0},
+		{"*2\r\n$3\r\nGET\r\n$1\r\na\r\n", "$0\r\n\r\n", 1, 0},
+		{"*2\r\n$3\r\nGET\r\n$1\r\na\r\n", "$-1\r\n", 0, 1},
+		{"*3\r\n$4\r\nHGET\r\n$1\r\na\r\n$1\r\nb\r\n", "$-1\r\n", 0, 1},
+		{"*2\r\n$6\r\nGETDEL\r\n$1\r\na\r\n", "$-1\r\n", 0, 1},
+		{"*4\r\n$5\r\nGETEX\r\n$1\r\na\r\n$2\r\nEX\r\n$1\r\n1\r\n", "$1\r\n1\r\n", 1, 0},
+		{"*3\r\n$4\r\nMGET\r\n$1\r\na\r\n$1\r\nb\r\n", "*2\r\n$1\r\n1\r\n$-1\r\n", 1, 1},
+		{"*2\r\n$3\r\nGET\r\n$1\r\na\r\n", "-ERR wrong type\r\n", 0, 0},
+		{"*2\r\n$4\r\nLLEN\r\n$1\r\na\r\n", ":0\r\n", 0, 0},
+	} {
+		req := newReq()
+		br := bufio.NewReader(libnet.NewConn(mockconn.CreateConn([]byte(tc.req), 1), time.Second, time.Second), bufio.Get(1024))
+		br.Read()
+		assert.NoError(t, req.resp.decode(br))
+		br = bufio.NewReader(libnet.NewConn(mockconn.CreateConn([]byte(tc.reply), 1), time.Second, time.Second), bufio.Get(1024))
+		br.Read()
+		assert.NoError(t, req.reply.decode(br))
+		hit, miss := req.Hits()
+		assert.Equal(t, tc.hit, hit, tc.reply)
+		assert.Equal(t, tc.miss, miss, tc.reply)
+	}
+}
+
+func TestRequestSize(t *testing.T) {
+	for _, tc := range []struct {
+		req, reply string
+	}{
+		{"*2\r\n$3\r\nGET\r\n$1\r\na\r\n", "$-1\r\n"},
+		{"*3\r\n$4\r\nMGET\r\n$1\r\na\r\n$1\r\nb\r\n", "*2\r\n$1\r\n1\r\n$-1\r\n"},
+		{"*1\r\n$4\r\nPING\r\n", "+PONG\r\n"},
+		{"*2\r\n$4\r\nLLEN\r\n$1\r\na\r\n", ":10\r\n"},
+	} {
+		req := newReq()
+		br := bufio.NewReader(libnet.NewConn(mockconn.CreateConn([]byte(tc.req), 1), time.Second, time.Second), bufio.Get(1024))
+		br.Read()
+		assert.NoError(t, req.resp.decode(br))
+		br = bufio.NewReader(libnet.NewConn(mockconn.CreateConn([]byte(tc.reply), 1), time.Second, time.Second), bufio.Get(1024))
+		br.Read()
+		assert.NoError(t, req.reply.decode(br))
+		assert.Equal(t, len(tc.req), req.RequestSize())
+		assert.Equal(t, len(tc.reply), req.ReplySize())
+	}
+}
+
+func TestRequestKeys(t *testing.T) {
+	for _, tc := range []struct {
+		req  string
+		keys []string
+	}{
+		{"*2\r\n$3\r\nGET\r\n$1\r\na\r\n", nil},
+		{"*4\r\n$6\r\nSUNION\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n", []string{"a", "b", "c"}},
+		{"*4\r\n$5\r\nSMOVE\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nm\r\n", []string{"a", "b"}},
+		{"*5\r\n$11\r\nZUNIONSTORE\r\n$1\r\nd\r\n$1\r\n2\r\n$1\r\na\r\n$1\r\nb\r\n", []string{"d", "a", "b"}},
+		{"*6\r\n$4\r\nEVAL\r\n$1\r\ns\r\n$1\r\n2\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nv\r\n", []string{"a", "b"}},
+		{"*4\r\n$5\r\nBLPOP\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\n0\r\n", []string{"a", "b"}},
+	} {
+		req := newReq()
+		br := bufio.NewReader(libnet.NewConn(mockconn.CreateConn([]byte(tc.req), 1), time.Second, time.Second), bufio.Get(1024))
+		br.Read()
+		assert.NoError(t, req.resp.decode(br))
+		var keys []string
+		for _, key := range req.Keys() {
+			keys = append(keys, string(key))
+		}
+		assert.Equal(t, tc.keys, keys)
+	}
+}
+
+func TestRequestBlocking(t *testing.T) {
+	for _, tc := range []struct {
+		req      string
+		timeout  time.Duration
+		blocking bool
+	}{
+		{"*2\r\n$3\r\nGET\r\n$1\r\na\r\n", 0, false},
+		{"*2\r\n$5\r\nBLPOP\r\n$1\r\na\r\n", 0, false},
+		{"*4\r\n$5\r\nBRPOP\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\n0\r\n", 0, true},
+		{"*3\r\n$8\r\nBZPOPMIN\r\n$1\r\na\r\n$3\r\n1.5\r\n", 1500 * time.Millisecond, true},
+		{"*4\r\n$10\r\nBRPOPLPUSH\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nx\r\n", 0, true},
+	} {
+		req := newReq()
+		br := bufio.NewReader(libnet.NewConn(mockconn.CreateConn([]byte(tc.req), 1), time.Second, time.Second), bufio.Get(1024))
+		br.Read()
+		assert.NoError(t, req.resp.decode(br))
+		timeout, ok := req.Blocking()
+		assert.Equal(t, tc.blocking, ok)
+		assert.Equal(t, tc.timeout, timeout)
+	}
+}
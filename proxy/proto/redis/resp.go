@@ -49,11 +49,21 @@ func (r *RESP) Array() []*RESP {
 	return r.array[:r.arraySize]
 }
 
+// ArgData return the data of bulk argument without the length.
+func (r *RESP) ArgData() []byte {
+	return argData(r)
+}
+
 // Decode decode by Reader.
 func (r *RESP) Decode(br *bufio.Reader) (err error) {
 	return r.decode(br)
 }
 
+// Copy copy the content of re into r.
+func (r *RESP) Copy(re *RESP) {
+	r.copy(re)
+}
+
 // Encode encode into Writer.
 func (r *RESP) Encode(w *bufio.Writer) (err error) {
 	return r.encode(w)
@@ -192,6 +202,25 @@ func (r *resp) decodeArray(line []byte, br *bufio.Reader) (err error) {
 	return
 }
 
+// size returns the bytes of resp encoded.
+func (r *resp) size() (n int) {
+	switch r.respType {
+	case respInt, respString, respError:
+		n = len(respIntBytes) + len(r.data) + len(crlfBytes)
+	case respBulk, respArray:
+		n = len(respBulkBytes) + len(r.data) + len(crlfBytes)
+		if len(r.data) == 0 {
+			n += len(nullDataBytes)
+		}
+		if r.respType == respArray {
+			for _, sub := range r.array[:r.arraySize] {
+				n += sub.size()
+			}
+		}
+	}
+	return
+}
+
 func (r *resp) encode(w *bufio.Writer) (err error) {
 	switch r.respType {
 	case respInt, respString, respError:
@@ -0,0 +1,180 @@
+package redis
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha1"
+	"encoding/hex"
+	"strconv"
+	"sync"
+
+	"overlord/proxy/proto"
+)
+
+// maxScripts is the max count of scripts cached for each cluster, the least recently used is evicted.
+const maxScripts = 1024
+
+var (
+	cmdScriptBytes  = []byte("6\r\nSCRIPT")
+	cmdEvalShaBytes = []byte("7\r\nEVALSHA")
+
+	scriptLoadBytes   = []byte("LOAD")
+	scriptExistsBytes = []byte("EXISTS")
+	scriptFlushBytes  = []byte("FLUSH")
+
+	noScriptBytes = []byte("NOSCRIPT")
+
+	scriptsMap  = map[string]*ScriptCache{}
+	scriptsLock sync.Mutex
+)
+
+// Scripts return the script cache of cluster, created if not exists.
+func Scripts(cluster string) *ScriptCache {
+	scriptsLock.Lock()
+	defer scriptsLock.Unlock()
+	sc, ok := scriptsMap[cluster]
+	if !ok {
+		sc = newScriptCache(maxScripts)
+		scriptsMap[cluster] = sc
+	}
+	return sc
+}
+
+// ScriptCache maps the sha1 of scripts loaded by proxy to the bodies, EVALSHA is retried as EVAL by it on NOSCRIPT.
+// NOTE: nil ScriptCache caches nothing.
+type ScriptCache struct {
+	lock   sync.Mutex
+	max    int
+	lru    *list.List
+	bodies map[string]*list.Element
+}
+
+type script struct {
+	sha  string
+	body []byte
+}
+
+func newScriptCache(max int) *ScriptCache {
+	return &ScriptCache{max: max, lru: list.New(), bodies: map[string]*list.Element{}}
+}
+
+func (sc *ScriptCache) load(body []byte) {
+	if sc == nil {
+		return
+	}
+	sum := sha1.Sum(body)
+	sha := hex.EncodeToString(sum[:])
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+	if e, ok := sc.bodies[sha]; ok {
+		sc.lru.MoveToFront(e)
+		return
+	}
+	sc.bodies[sha] = sc.lru.PushFront(&script{sha: sha, body: append([]byte{}, body...)})
+	for sc.lru.Len() > sc.max {
+		e := sc.lru.Back()
+		sc.lru.Remove(e)
+		delete(sc.bodies, e.Value.(*script).sha)
+	}
+}
+
+func (sc *ScriptCache) get(sha []byte) (body []byte, ok bool) {
+	if sc == nil {
+		return
+	}
+	sc.lock.Lock()
+	e, ok := sc.bodies[string(bytes.ToLower(sha))]
+	if ok {
+		sc.lru.MoveToFront(e)
+		body = e.Value.(*script).body
+	}
+	sc.lock.Unlock()
+	return
+}
+
+func (sc *ScriptCache) flush() {
+	if sc == nil {
+		return
+	}
+	sc.lock.Lock()
+	sc.lru.Init()
+	sc.bodies = map[string]*list.Element{}
+	sc.lock.Unlock()
+}
+
+// update caches the loaded script or removes all flushed after SCRIPT succeeds in all nodes.
+func (sc *ScriptCache) update(args []*resp) {
+	if len(args) < 2 {
+		return
+	}
+	sub := bytes.ToUpper(argData(args[1]))
+	switch {
+	case bytes.Equal(sub, scriptLoadBytes) && len(args) == 3:
+		sc.load(argData(args[2]))
+	case bytes.Equal(sub, scriptFlushBytes):
+		sc.flush()
+	}
+}
+
+// scriptMergeType return the merge type of SCRIPT LOAD, EXISTS and FLUSH which are sent to all nodes.
+func scriptMergeType(args []*resp) mergeType {
+	if len(args) < 2 {
+		return mergeTypeNo
+	}
+	sub := bytes.ToUpper(argData(args[1]))
+	switch {
+	case bytes.Equal(sub, scriptLoadBytes), bytes.Equal(sub, scriptFlushBytes):
+		return mergeTypeAll
+	case bytes.Equal(sub, scriptExistsBytes):
+		return mergeTypeExists
+	}
+	return mergeTypeNo
+}
+
+// IsBroadcast checks if the request is sent to all nodes.
+func (r *Request) IsBroadcast() bool {
+	return r.mType == mergeTypeAll || r.mType == mergeTypeExists
+}
+
+// Broadcast pushes the copies of m into all the pipes, false if the request is not sent to all nodes.
+func Broadcast(m *proto.Message, ncps []*proto.NodeConnPipe) bool {
+	req, ok := m.Request().(*Request)
+	if !ok || !req.IsBroadcast() || len(ncps) == 0 {
+		return false
+	}
+	if len(ncps) == 1 {
+		m.MarkStartPipe()
+		ncps[0].Push(m)
+		return true
+	}
+	for i := 1; i < len(ncps); i++ {
+		r := nextReq(m)
+		r.mType = req.mType
+		r.resp.copy(req.resp)
+	}
+	for i, subm := range m.Batch() {
+		subm.MarkStartPipe()
+		ncps[i].Push(subm)
+	}
+	return true
+}
+
+// retryScript rewrites EVALSHA replied NOSCRIPT into EVAL of the cached script, false if not.
+func (r *Request) retryScript() bool {
+	if r.reply.respType != respError || !bytes.HasPrefix(r.reply.data, noScriptBytes) ||
+		r.resp.arraySize < 2 || !bytes.Equal(r.resp.array[0].data, cmdEvalShaBytes) {
+		return false
+	}
+	body, ok := r.scripts.get(argData(r.resp.array[1]))
+	if !ok {
+		return false
+	}
+	cmd := r.resp.array[0]
+	cmd.data = append(cmd.data[:0], cmdEvalBytes...)
+	arg := r.resp.array[1]
+	arg.respType = respBulk
+	arg.data = strconv.AppendInt(arg.data[:0], int64(len(body)), 10)
+	arg.data = append(arg.data, crlfBytes...)
+	arg.data = append(arg.data, body...)
+	return true
+}
@@ -0,0 +1,165 @@
+package redis
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"overlord/pkg/mockconn"
+	libnet "overlord/pkg/net"
+	"overlord/proxy/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const scriptSha = "e0e1f9fabfc9d4800c877a703b823ac0578ff8db" // NOTE: sha1 of "return 1"
+
+func TestDecodeScript(t *testing.T) {
+	conn := libnet.NewConn(mockconn.CreateConn([]byte("*3\r\n$6\r\nSCRIPT\r\n$4\r\nload\r\n$8\r\nreturn 1\r\n"+
+		"*3\r\n$6\r\nSCRIPT\r\n$6\r\nEXISTS\r\n$40\r\n"+scriptSha+"\r\n"+
+		"*2\r\n$6\r\nSCRIPT\r\n$4\r\nKILL\r\n"+
+		"*3\r\n$7\r\nEVALSHA\r\n$40\r\n"+scriptSha+"\r\n$1\r\n0\r\n"), 1), time.Second, time.Second)
+	sc := newScriptCache(maxScripts)
+	pc := NewProxyConn(conn, true)
+	pc.(*ProxyConn).WithScripts(sc)
+	msgs, err := pc.Decode(proto.GetMsgs(16))
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 4)
+	for i, mType := range []mergeType{mergeTypeAll, mergeTypeExists, mergeTypeNo} {
+		req := msgs[i].Request().(*Request)
+		assert.Equal(t, mType, req.mType)
+		assert.Equal(t, mType != mergeTypeNo, req.IsSupport())
+	}
+	_, ok := sc.get([]byte(scriptSha))
+	assert.False(t, ok, "not cached before replied")
+
+	req := msgs[3].Request().(*Request)
+	assert.True(t, req.IsSupport())
+	assert.True(t, req.scripts == sc)
+	req.reply.respType = respError
+	req.reply.data = []byte("NOSCRIPT No matching script. Please use EVAL.")
+	assert.False(t, req.retryScript(), "not cached")
+	sc.load([]byte("return 1"))
+	req.reply.respType = respString
+	assert.False(t, req.retryScript(), "not NOSCRIPT")
+	req.reply.respType = respError
+	assert.True(t, req.retryScript())
+	assert.Equal(t, "EVAL", req.CmdString())
+	assert.Equal(t, "8\r\nreturn 1", string(req.resp.array[1].data))
+	assert.False(t, req.retryScript(), "EVAL is not retried")
+}
+
+func TestScriptCacheEvict(t *testing.T) {
+	sc := newScriptCache(2)
+	sum := func(body string) []byte {
+		s := sha1.Sum([]byte(body))
+		return []byte(hex.EncodeToString(s[:]))
+	}
+	sc.load([]byte("return 1"))
+	sc.load([]byte("return 2"))
+	_, ok := sc.get(sum("return 1"))
+	assert.True(t, ok)
+	sc.load([]byte("return 3"))
+	_, ok = sc.get(sum("return 2"))
+	assert.False(t, ok, "least recently used is evicted")
+	body, ok := sc.get(bytes.ToUpper(sum("return 1")))
+	assert.True(t, ok)
+	assert.Equal(t, "return 1", string(body))
+	assert.Equal(t, 2, sc.lru.Len())
+
+	var nilsc *ScriptCache
+	nilsc.load([]byte("return 1"))
+	_, ok = nilsc.get(sum("return 1"))
+	assert.False(t, ok)
+	assert.True(t, Scripts("a") == Scripts("a"))
+	assert.False(t, Scripts("a") == Scripts("b"))
+}
+
+func TestEncodeMergeScript(t *testing.T) {
+	sc := newScriptCache(maxScripts)
+	ints := func(is ...string) *resp {
+		r := &resp{respType: respArray}
+		for _, i := range is {
+			r.next().copy(&resp{respType: respInt, data: []byte(i)})
+		}
+		return r
+	}
+	ts := []struct {
+		Name   string
+		Req    string
+		Reply  []*resp
+		Expect string
+		Cached bool
+	}{
+		{
+			Name:   "load error",
+			Req:    "*3\r\n$6\r\nSCRIPT\r\n$4\r\nLOAD\r\n$8\r\nreturn 1\r\n",
+			Reply:  []*resp{{respType: respBulk, data: []byte("40\r\n" + scriptSha)}, {respType: respError, data: []byte("ERR oom")}},
+			Expect: "-ERR oom\r\n",
+		},
+		{
+			Name:   "exists not cached",
+			Req:    "*3\r\n$6\r\nSCRIPT\r\n$6\r\nEXISTS\r\n$40\r\n" + scriptSha + "\r\n",
+			Reply:  []*resp{ints("1"), ints("0")},
+			Expect: "*1\r\n:0\r\n",
+		},
+		{
+			Name:   "load",
+			Req:    "*3\r\n$6\r\nSCRIPT\r\n$4\r\nLOAD\r\n$8\r\nreturn 1\r\n",
+			Reply:  []*resp{{respType: respBulk, data: []byte("40\r\n" + scriptSha)}, {respType: respBulk, data: []byte("40\r\n" + scriptSha)}},
+			Expect: "$40\r\n" + scriptSha + "\r\n",
+			Cached: true,
+		},
+		{
+			Name:   "exists",
+			Req:    "*5\r\n$6\r\nSCRIPT\r\n$6\r\nEXISTS\r\n$1\r\na\r\n$40\r\n" + scriptSha + "\r\n$1\r\nb\r\n",
+			Reply:  []*resp{ints("1", "0", "1"), ints("1", "0", "0")},
+			Expect: "*3\r\n:1\r\n:1\r\n:0\r\n",
+			Cached: true,
+		},
+		{
+			Name:   "flush error",
+			Req:    "*2\r\n$6\r\nSCRIPT\r\n$5\r\nFLUSH\r\n",
+			Reply:  []*resp{{respType: respString, data: []byte("OK")}, {respType: respError, data: []byte("ERR busy")}},
+			Expect: "-ERR busy\r\n",
+			Cached: true,
+		},
+		{
+			Name:   "flush",
+			Req:    "*2\r\n$6\r\nSCRIPT\r\n$5\r\nFLUSH\r\n",
+			Reply:  []*resp{{respType: respString, data: []byte("OK")}, {respType: respString, data: []byte("OK")}},
+			Expect: "+OK\r\n",
+		},
+	}
+	for _, tt := range ts {
+		t.Run(tt.Name, func(t *testing.T) {
+			msg := _decodeMessage(t, tt.Req)[0]
+			req := msg.Request().(*Request)
+			for i, rpl := range tt.Reply {
+				if i > 0 {
+					req = nextReq(msg)
+					req.mType = msg.Request().(*Request).mType
+					req.resp.copy(msg.Request().(*Request).resp)
+				}
+				req.reply = rpl
+			}
+			msg.Batch()
+			conn, buf := mockconn.CreateDownStreamConn()
+			pc := NewProxyConn(libnet.NewConn(conn, time.Second, time.Second), true)
+			pc.(*ProxyConn).WithScripts(sc)
+			assert.NoError(t, pc.Encode(msg))
+			assert.NoError(t, pc.Flush())
+			assert.Equal(t, tt.Expect, buf.String())
+			_, ok := sc.get([]byte(scriptSha))
+			assert.Equal(t, tt.Cached, ok)
+		})
+	}
+}
+
+func TestBroadcastNotScript(t *testing.T) {
+	msg := _decodeMessage(t, "*2\r\n$3\r\nGET\r\n$1\r\na\r\n")[0]
+	assert.False(t, Broadcast(msg, []*proto.NodeConnPipe{nil}))
+	assert.False(t, msg.IsBatch())
+}
@@ -0,0 +1,129 @@
+package redis
+
+import (
+	"bytes"
+	"strconv"
+	"time"
+
+	"overlord/pkg/conv"
+	"overlord/proxy/proto"
+)
+
+var (
+	cmdXReadBytes      = []byte("5\r\nXREAD")
+	cmdXReadGroupBytes = []byte("10\r\nXREADGROUP")
+
+	streamsBytes = []byte("STREAMS")
+	groupBytes   = []byte("GROUP")
+	countBytes   = []byte("COUNT")
+	blockBytes   = []byte("BLOCK")
+	noAckBytes   = []byte("NOACK")
+)
+
+// isXRead checks if cmd is XREAD or XREADGROUP.
+func isXRead(cmd []byte) bool {
+	return bytes.Equal(cmd, cmdXReadBytes) || bytes.Equal(cmd, cmdXReadGroupBytes)
+}
+
+// streams parses the options of XREAD and XREADGROUP in args, and return the index of STREAMS argument
+// or zero if invalid, the timeout of BLOCK option and true if blocking.
+func streams(args []*resp) (idx int, timeout time.Duration, blocking bool) {
+	for i := 1; i < len(args); {
+		opt := bytes.ToUpper(argData(args[i]))
+		switch {
+		case bytes.Equal(opt, streamsBytes):
+			if (len(args)-i-1)%2 != 0 {
+				return 0, timeout, blocking
+			}
+			return i, timeout, blocking
+		case bytes.Equal(opt, groupBytes):
+			i += 3
+		case bytes.Equal(opt, countBytes):
+			i += 2
+		case bytes.Equal(opt, blockBytes):
+			if i+1 >= len(args) {
+				return 0, timeout, blocking
+			}
+			// NOTE: the invalid timeout is replied by node at once.
+			if ms, err := conv.Btoi(argData(args[i+1])); err == nil && ms > 0 {
+				timeout = time.Duration(ms) * time.Millisecond
+			}
+			blocking = true
+			i += 2
+		case bytes.Equal(opt, noAckBytes):
+			i++
+		default:
+			return 0, timeout, blocking
+		}
+	}
+	return 0, timeout, blocking
+}
+
+// streamKeys return the keys after STREAMS argument at idx.
+func streamKeys(args []*resp, idx int) []*resp {
+	n := (len(args) - idx - 1) / 2
+	return args[idx+1 : idx+1+n]
+}
+
+// splitXRead splits XREAD and XREADGROUP of keys in different nodes into one request for each key, false if not split.
+func (pc *proxyConn) splitXRead(msg *proto.Message) bool {
+	args := pc.resp.array[:pc.resp.arraySize]
+	idx, _, blocking := streams(args)
+	if idx == 0 || blocking {
+		// NOTE: blocking ones of keys in different slots are rejected by redis_cluster.
+		return false
+	}
+	keys := streamKeys(args, idx)
+	if len(keys) < 2 || (pc.slot != nil && !pc.crossSlot(keys)) {
+		// NOTE: not knowing the nodes of keys, always split if not redis_cluster.
+		return false
+	}
+	for i := range keys {
+		r := nextReq(msg)
+		r.mType = mergeTypeXRead
+		r.resp.reset() // NOTE: *n\r\n
+		r.resp.respType = respArray
+		r.resp.data = strconv.AppendInt(r.resp.data, int64(idx+3), 10)
+		// array resp: command, options and STREAMS
+		for _, arg := range args[:idx+1] {
+			r.resp.next().copy(arg)
+		}
+		// array resp: key and id
+		r.resp.next().copy(keys[i])
+		r.resp.next().copy(args[idx+1+len(keys)+i])
+	}
+	return true
+}
+
+// mergeXRead writes the replies of streams in order, null if none of streams has entries.
+func (pc *proxyConn) mergeXRead(m *proto.Message) (err error) {
+	var (
+		reqs = m.Requests()
+		n    = 0
+	)
+	for _, mreq := range reqs {
+		req, ok := mreq.(*Request)
+		if !ok {
+			return ErrBadAssert
+		}
+		if req.reply.respType != respArray {
+			// NOTE: the error of any key, like NOGROUP.
+			return req.reply.encode(pc.bw)
+		}
+		n += req.reply.arraySize
+	}
+	_ = pc.bw.Write(respArrayBytes)
+	if n == 0 {
+		return pc.bw.Write(nullBytes)
+	}
+	_ = pc.bw.Write([]byte(strconv.Itoa(n)))
+	if err = pc.bw.Write(crlfBytes); err != nil {
+		return
+	}
+	for _, mreq := range reqs {
+		if err = mreq.(*Request).reply.encodeArrayData(pc.bw); err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,121 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"overlord/pkg/bufio"
+	"overlord/pkg/mockconn"
+	libnet "overlord/pkg/net"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestStreamKeys(t *testing.T) {
+	for _, tc := range []struct {
+		req      string
+		key      string
+		keys     []string
+		timeout  time.Duration
+		blocking bool
+	}{
+		{"*3\r\n$4\r\nXLEN\r\n$1\r\ns\r\n$1\r\nx\r\n", "s", nil, 0, false},
+		{"*5\r\n$6\r\nXGROUP\r\n$6\r\nCREATE\r\n$1\r\ns\r\n$1\r\ng\r\n$1\r\n$\r\n", "s", nil, 0, false},
+		{"*3\r\n$5\r\nXINFO\r\n$6\r\nSTREAM\r\n$1\r\ns\r\n", "s", nil, 0, false},
+		{"*8\r\n$5\r\nXREAD\r\n$5\r\nCOUNT\r\n$1\r\n2\r\n$7\r\nstreams\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\n0\r\n$1\r\n0\r\n", "a", []string{"a", "b"}, 0, false},
+		{"*6\r\n$5\r\nXREAD\r\n$5\r\nBLOCK\r\n$3\r\n100\r\n$7\r\nSTREAMS\r\n$1\r\na\r\n$1\r\n$\r\n", "a", []string{"a"}, 100 * time.Millisecond, true},
+		{"*10\r\n$10\r\nXREADGROUP\r\n$5\r\nGROUP\r\n$1\r\ng\r\n$1\r\nc\r\n$5\r\nBLOCK\r\n$1\r\n0\r\n$5\r\nNOACK\r\n$7\r\nSTREAMS\r\n$1\r\na\r\n$1\r\n>\r\n", "a", []string{"a"}, 0, true},
+		{"*4\r\n$5\r\nXREAD\r\n$7\r\nSTREAMS\r\n$1\r\na\r\n$1\r\nb\r\n", "a", []string{"a"}, 0, false},
+		{"*3\r\n$5\r\nXREAD\r\n$7\r\nSTREAMS\r\n$1\r\na\r\n", "STREAMS", nil, 0, false},
+	} {
+		req := newReq()
+		br := bufio.NewReader(libnet.NewConn(mockconn.CreateConn([]byte(tc.req), 1), time.Second, time.Second), bufio.Get(1024))
+		br.Read()
+		assert.NoError(t, req.resp.decode(br))
+		assert.Equal(t, tc.key, string(req.Key()), tc.req)
+		var keys []string
+		for _, key := range req.Keys() {
+			keys = append(keys, string(key))
+		}
+		assert.Equal(t, tc.keys, keys, tc.req)
+		timeout, ok := req.Blocking()
+		assert.Equal(t, tc.blocking, ok, tc.req)
+		assert.Equal(t, tc.timeout, timeout, tc.req)
+	}
+}
+
+func TestDecodeSplitXRead(t *testing.T) {
+	msgs := _decodeMessage(t, "*9\r\n$10\r\nXREADGROUP\r\n$5\r\nGROUP\r\n$1\r\ng\r\n$1\r\nc\r\n$7\r\nSTREAMS\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\n>\r\n$1\r\n0\r\n"+
+		"*8\r\n$5\r\nXREAD\r\n$5\r\nBLOCK\r\n$1\r\n0\r\n$7\r\nSTREAMS\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\n$\r\n$1\r\n$\r\n")
+	assert.Len(t, msgs, 2)
+	assert.True(t, msgs[0].IsBatch(), "split as nodes of keys are unknown")
+	expect := []string{
+		"*7\r\n$10\r\nXREADGROUP\r\n$5\r\nGROUP\r\n$1\r\ng\r\n$1\r\nc\r\n$7\r\nSTREAMS\r\n$1\r\na\r\n$1\r\n>\r\n",
+		"*7\r\n$10\r\nXREADGROUP\r\n$5\r\nGROUP\r\n$1\r\ng\r\n$1\r\nc\r\n$7\r\nSTREAMS\r\n$1\r\nb\r\n$1\r\n0\r\n",
+	}
+	for i, req := range msgs[0].Requests() {
+		r := req.(*Request)
+		assert.Equal(t, mergeTypeXRead, r.mType)
+		assert.Equal(t, ErrIgnoreMerged, r.Merge(nil))
+		conn, buf := mockconn.CreateDownStreamConn()
+		bw := bufio.NewWriter(libnet.NewConn(conn, time.Second, time.Second))
+		assert.NoError(t, r.resp.encode(bw))
+		assert.NoError(t, bw.Flush())
+		assert.Equal(t, expect[i], buf.String())
+	}
+	assert.False(t, msgs[1].IsBatch(), "blocking is not split")
+}
+
+func TestEncodeMergeXRead(t *testing.T) {
+	entries := func(key string) *resp {
+		r := &resp{respType: respArray, data: []byte("1")}
+		stream := r.next()
+		stream.copy(&resp{respType: respArray, data: []byte("2")})
+		stream.next().copy(&resp{respType: respBulk, data: []byte("1\r\n" + key)})
+		stream.next().copy(&resp{respType: respArray, data: []byte("0")})
+		return r
+	}
+	ts := []struct {
+		Name   string
+		Reply  []*resp
+		Expect string
+	}{
+		{
+			Name:   "some",
+			Reply:  []*resp{entries("a"), {respType: respArray}, entries("c")},
+			Expect: "*2\r\n*2\r\n$1\r\na\r\n*0\r\n*2\r\n$1\r\nc\r\n*0\r\n",
+		},
+		{
+			Name:   "none",
+			Reply:  []*resp{{respType: respArray}, {respType: respArray}},
+			Expect: "*-1\r\n",
+		},
+		{
+			Name:   "nogroup",
+			Reply:  []*resp{entries("a"), {respType: respError, data: []byte("NOGROUP")}},
+			Expect: "-NOGROUP\r\n",
+		},
+	}
+	for _, tt := range ts {
+		t.Run(tt.Name, func(t *testing.T) {
+			msg := _decodeMessage(t, "*6\r\n$5\r\nXREAD\r\n$7\r\nSTREAMS\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\n0\r\n$1\r\n0\r\n")[0]
+			reqs := msg.Requests()
+			for i, rpl := range tt.Reply {
+				var req *Request
+				if i < len(reqs) {
+					req = reqs[i].(*Request)
+				} else {
+					req = nextReq(msg)
+				}
+				req.mType = mergeTypeXRead
+				req.reply = rpl
+			}
+			msg.Batch()
+			conn, buf := mockconn.CreateDownStreamConn()
+			pc := NewProxyConn(libnet.NewConn(conn, time.Second, time.Second), true)
+			assert.NoError(t, pc.Encode(msg))
+			assert.NoError(t, pc.Flush())
+			assert.Equal(t, tt.Expect, buf.String())
+		})
+	}
+}
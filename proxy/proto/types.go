@@ -25,6 +25,26 @@ type Request interface {
 	Slowlogger
 }
 
+// Coalescer is the request whose identical in-flight requests can share one backend round trip.
+type Coalescer interface {
+	// CoalesceKey returns the identity of request, empty means it can't be coalesced.
+	CoalesceKey() []byte
+	// CopyReply copies reply from the identical request.
+	CopyReply(Request) error
+}
+
+// Hitter is the request of get-style command which can count the keys hit or missed by reply.
+type Hitter interface {
+	// Hits returns the count of keys hit and missed, both zero if the command is not get-style.
+	Hits() (hit, miss int)
+}
+
+// Sizer is the request which can report the bytes of request and reply in protocol.
+type Sizer interface {
+	RequestSize() int
+	ReplySize() int
+}
+
 // ProxyConn decode bytes from client and encode write to conn.
 type ProxyConn interface {
 	Decode([]*Message) ([]*Message, error)
@@ -13,21 +13,34 @@ import (
 	libnet "overlord/pkg/net"
 	"overlord/pkg/prom"
 	"overlord/pkg/types"
+	"overlord/proxy/accesslog"
+	"overlord/proxy/nearcache"
 	"overlord/proxy/proto"
 	"overlord/proxy/proto/memcache"
 	mcbin "overlord/proxy/proto/memcache/binary"
 	"overlord/proxy/proto/redis"
 	rclstr "overlord/proxy/proto/redis/cluster"
+	"overlord/proxy/slowlog"
+	"overlord/proxy/trace"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/pkg/errors"
 )
 
+const (
+	// DefaultShutdownTimeout is the default timeout of waiting busy clients in shutdown.
+	DefaultShutdownTimeout = 10 * time.Second
+	shutdownCheckInterval  = 10 * time.Millisecond
+)
+
 // proxy errors
 var (
 	ErrProxyMoreMaxConns = errs.New("Proxy accept more than max connextions")
 	ErrProxyReloadIgnore = errs.New("Proxy reload cluster config is ignored")
 	ErrProxyReloadFail   = errs.New("Proxy reload cluster config is failed")
+	ErrProxyClientKilled = errs.New("Proxy client is killed")
+	// ErrProxyShutdown is replied to the commands received in shutdown, which is retryable for redis clients.
+	ErrProxyShutdown = errs.New("TRYAGAIN Proxy is shutting down")
 )
 
 // Proxy is proxy.
@@ -37,11 +50,17 @@ type Proxy struct {
 	ccs []*ClusterConfig
 
 	forwarders map[string]proto.Forwarder
+	listeners  map[string]net.Listener
 	lock       sync.Mutex
 
-	conns int32
+	conns   int32
+	connID  uint64
+	clients *clients
+
+	tracer *trace.Tracer
 
-	closed bool
+	draining int32
+	closed   bool
 }
 
 // New new a proxy by config.
@@ -52,6 +71,19 @@ func New(c *Config) (p *Proxy, err error) {
 	}
 	p = &Proxy{}
 	p.c = c
+	p.clients = newClients()
+	if c.Proxy.TraceEndpoint != "" {
+		if p.tracer, err = trace.New(&trace.Config{
+			Endpoint:    c.Proxy.TraceEndpoint,
+			ServiceName: c.Proxy.TraceServiceName,
+			Sampler:     c.Proxy.TraceSampler,
+			Ratio:       c.Proxy.TraceSampleRatio,
+			SlowerThan:  time.Duration(c.Proxy.TraceSlowerThan) * time.Microsecond,
+		}); err != nil {
+			return nil, err
+		}
+		log.Infof("overlord start tracing to [%s] with sampler [%s]", c.Proxy.TraceEndpoint, c.Proxy.TraceSampler)
+	}
 	return
 }
 
@@ -63,6 +95,7 @@ func (p *Proxy) Serve(ccs []*ClusterConfig) {
 	}
 	p.lock.Lock()
 	p.forwarders = map[string]proto.Forwarder{}
+	p.listeners = map[string]net.Listener{}
 	p.lock.Unlock()
 	for _, cc := range ccs {
 		log.Infof("start to serve cluster[%s] with configs %v", cc.Name, *cc)
@@ -71,17 +104,46 @@ func (p *Proxy) Serve(ccs []*ClusterConfig) {
 }
 
 func (p *Proxy) serve(cc *ClusterConfig) {
-	forwarder := NewForwarder(cc)
+	var forwarder proto.Forwarder
+	if cc.Passthrough {
+		pt := newPassthrough(p, cc)
+		forwarder = newClusterForwarder(cc, pt.Map)
+		pt.bind(forwarder)
+	} else {
+		forwarder = NewForwarder(cc)
+	}
 	p.forwarders[cc.Name] = forwarder
 	// listen
 	l, err := Listen(cc.ListenProto, cc.ListenAddr)
 	if err != nil {
 		panic(err)
 	}
+	p.listeners[cc.Name] = l
 	log.Infof("overlord proxy cluster[%s] addr(%s) start listening", cc.Name, cc.ListenAddr)
 	if cc.SlowlogSlowerThan != 0 {
+		slowlog.Register(cc.Name, cc.SlowlogMaxEntries)
+		if err := slowlog.Bind(cc.Name, cc.SlowlogSinks); err != nil {
+			log.Errorf("fail to bind slowlog sinks of cluster %s due %v", cc.Name, err)
+		}
 		log.Infof("overlord start slowlog to [%s] with threshold [%d]us", cc.Name, cc.SlowlogSlowerThan)
 	}
+	if cc.NearCacheTTL > 0 {
+		nearcache.Register(cc.Name, &nearcache.Config{
+			TTL:          time.Duration(cc.NearCacheTTL) * time.Millisecond,
+			MaxKeys:      cc.NearCacheMaxKeys,
+			MaxBytes:     cc.NearCacheMaxBytes,
+			Patterns:     cc.NearCacheKeys,
+			HotThreshold: cc.NearCacheHotThreshold,
+		})
+		log.Infof("overlord start near cache to [%s] with ttl [%d]ms", cc.Name, cc.NearCacheTTL)
+	}
+	if cc.AccessLogSampleRate > 0 {
+		accesslog.Register(cc.Name, &accesslog.Config{
+			SampleRate: cc.AccessLogSampleRate,
+			Key:        cc.AccessLogKey,
+		})
+		log.Infof("overlord start access log to [%s] with sample rate [%v]", cc.Name, cc.AccessLogSampleRate)
+	}
 	go p.accept(cc, l, forwarder)
 }
 
@@ -96,37 +158,46 @@ func (p *Proxy) accept(cc *ClusterConfig, l net.Listener, forwarder proto.Forwar
 			if conn != nil {
 				_ = conn.Close()
 			}
+			if p.isDraining() {
+				log.Infof("overlord proxy cluster[%s] addr(%s) stop listen due to shutdown", cc.Name, cc.ListenAddr)
+				return
+			}
 			log.Errorf("cluster(%s) addr(%s) accept connection error:%+v", cc.Name, cc.ListenAddr, err)
 			continue
 		}
-		if p.c.Proxy.MaxConnections > 0 {
-			if conns := atomic.LoadInt32(&p.conns); conns > p.c.Proxy.MaxConnections {
-				// cache type
-				var encoder proto.ProxyConn
-				switch cc.CacheType {
-				case types.CacheTypeMemcache:
-					encoder = memcache.NewProxyConn(libnet.NewConn(conn, time.Second, time.Second))
-				case types.CacheTypeMemcacheBinary:
-					encoder = mcbin.NewProxyConn(libnet.NewConn(conn, time.Second, time.Second))
-				case types.CacheTypeRedis:
-					encoder = redis.NewProxyConn(libnet.NewConn(conn, time.Second, time.Second), true)
-				case types.CacheTypeRedisCluster:
-					encoder = rclstr.NewProxyConn(libnet.NewConn(conn, time.Second, time.Second), nil)
-				}
-				if encoder != nil {
-					_ = encoder.Encode(proto.ErrMessage(ErrProxyMoreMaxConns))
-					_ = encoder.Flush()
-				}
-				_ = conn.Close()
-				if log.V(4) {
-					log.Warnf("proxy reject connection count(%d) due to more than max(%d)", conns, p.c.Proxy.MaxConnections)
-				}
-				continue
+		p.handle(cc, conn, forwarder)
+	}
+}
+
+// handle serves the accepted conn, it's rejected if more than max connections.
+func (p *Proxy) handle(cc *ClusterConfig, conn net.Conn, forwarder proto.Forwarder) {
+	if p.c.Proxy.MaxConnections > 0 {
+		if conns := atomic.LoadInt32(&p.conns); conns > p.c.Proxy.MaxConnections {
+			// cache type
+			var encoder proto.ProxyConn
+			switch cc.CacheType {
+			case types.CacheTypeMemcache:
+				encoder = memcache.NewProxyConn(libnet.NewConn(conn, time.Second, time.Second))
+			case types.CacheTypeMemcacheBinary:
+				encoder = mcbin.NewProxyConn(libnet.NewConn(conn, time.Second, time.Second))
+			case types.CacheTypeRedis:
+				encoder = redis.NewProxyConn(libnet.NewConn(conn, time.Second, time.Second), true)
+			case types.CacheTypeRedisCluster:
+				encoder = rclstr.NewProxyConn(libnet.NewConn(conn, time.Second, time.Second), nil)
+			}
+			if encoder != nil {
+				_ = encoder.Encode(proto.ErrMessage(ErrProxyMoreMaxConns))
+				_ = encoder.Flush()
+			}
+			_ = conn.Close()
+			if log.V(4) {
+				log.Warnf("proxy reject connection count(%d) due to more than max(%d)", conns, p.c.Proxy.MaxConnections)
 			}
+			return
 		}
-		atomic.AddInt32(&p.conns, 1)
-		NewHandler(p, cc, conn, forwarder).Handle()
 	}
+	atomic.AddInt32(&p.conns, 1)
+	NewHandler(p, cc, conn, forwarder).Handle()
 }
 
 // Close close proxy resource.
@@ -137,10 +208,41 @@ func (p *Proxy) Close() error {
 	for _, forwarder := range p.forwarders {
 		forwarder.Close()
 	}
+	if p.tracer != nil {
+		p.tracer.Close()
+	}
 	p.closed = true
 	return nil
 }
 
+// Shutdown stops accepting and closes the idle clients, the busy ones are closed after
+// the current batch finished or killed after timeout, then the proxy is closed.
+func (p *Proxy) Shutdown(timeout time.Duration) {
+	atomic.StoreInt32(&p.draining, 1)
+	p.lock.Lock()
+	for name, l := range p.listeners {
+		if err := l.Close(); err != nil {
+			log.Errorf("overlord proxy cluster[%s] fail to close listener due %v", name, err)
+		}
+	}
+	p.lock.Unlock()
+	for _, h := range p.clients.find(&clientFilter{}) {
+		h.closeIdle()
+	}
+	deadline := time.Now().Add(timeout)
+	for p.clients.count() > 0 && time.Now().Before(deadline) {
+		time.Sleep(shutdownCheckInterval)
+	}
+	if n := p.clients.kill(&clientFilter{}); n > 0 {
+		log.Warnf("overlord proxy kill %d clients which are still busy after shutdown timeout %v", n, timeout)
+	}
+	_ = p.Close()
+}
+
+func (p *Proxy) isDraining() bool {
+	return atomic.LoadInt32(&p.draining) == 1
+}
+
 // MonitorConfChange reload servers.
 func (p *Proxy) MonitorConfChange(ccf string) {
 	p.ccf = ccf
@@ -200,22 +302,33 @@ func (p *Proxy) MonitorConfChange(ccf string) {
 
 func (p *Proxy) updateConfig(conf *ClusterConfig) (err error) {
 	p.lock.Lock()
-	defer p.lock.Unlock()
 	f, ok := p.forwarders[conf.Name]
+	p.lock.Unlock()
 	if !ok {
 		err = errors.Wrapf(ErrProxyReloadIgnore, "cluster:%s", conf.Name)
 		return
 	}
+	// NOTE: update without lock, the redis cluster forwarder may listen new ports by passthrough.
+	if tu, ok := f.(timeoutUpdater); ok {
+		tu.UpdateTimeout(time.Duration(conf.DialTimeout)*time.Millisecond,
+			time.Duration(conf.ReadTimeout)*time.Millisecond,
+			time.Duration(conf.WriteTimeout)*time.Millisecond)
+	}
 	if err = f.Update(conf.Servers); err != nil {
 		err = errors.Wrapf(ErrProxyReloadFail, "cluster:%s error:%v", conf.Name, err)
 		return
 	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
 	for _, oldConf := range p.ccs {
 		if oldConf.Name != conf.Name {
 			continue
 		}
 		oldConf.Servers = make([]string, len(conf.Servers), cap(conf.Servers))
 		copy(oldConf.Servers, conf.Servers)
+		if _, ok := f.(timeoutUpdater); ok {
+			oldConf.DialTimeout, oldConf.ReadTimeout, oldConf.WriteTimeout = conf.DialTimeout, conf.ReadTimeout, conf.WriteTimeout
+		}
 		return
 	}
 	return
@@ -240,6 +353,10 @@ func parseChanged(newConfs, oldConfs []*ClusterConfig) (changed []*ClusterConfig
 
 			if !deepEqualOrderedStringSlice(newConf.Servers, oldConf.Servers) {
 				changed = append(changed, newConf)
+			} else if newConf.CacheType == types.CacheTypeRedisCluster && (newConf.DialTimeout != oldConf.DialTimeout ||
+				newConf.ReadTimeout != oldConf.ReadTimeout || newConf.WriteTimeout != oldConf.WriteTimeout) {
+				// NOTE: the timeouts of redis cluster are reloaded too.
+				changed = append(changed, newConf)
 			}
 			break
 		}
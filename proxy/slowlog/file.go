@@ -27,8 +27,7 @@ type fileHandler struct {
 	backupCount int
 }
 
-func (f *fileHandler) save(cluster string, entry *proto.SlowlogEntry) {
-	entry.Cluster = cluster
+func (f *fileHandler) save(entry *proto.SlowlogEntry) {
 	select {
 	case f.exchange <- entry:
 	default:
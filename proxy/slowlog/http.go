@@ -4,23 +4,130 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
 
 	"overlord/proxy/proto"
+
+	"github.com/pkg/errors"
 )
 
-// showlog will show slowlog to http
-func showlog(w http.ResponseWriter, _req *http.Request) {
+// showlog will show slowlog to http, entries are filtered by query parameters:
+// cluster, cmd, node, min_dur(us), start and end(unix seconds or RFC3339), limit.
+func showlog(w http.ResponseWriter, req *http.Request) {
+	q, err := parseQuery(req.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, query(q))
+}
+
+// groupLog will show slowlog grouped by cmd or node, entries are filtered just as showlog.
+func groupLog(w http.ResponseWriter, req *http.Request) {
+	params := req.URL.Query()
+	by := params.Get("by")
+	if by == "" {
+		by = GroupByCmd
+	}
+	if by != GroupByCmd && by != GroupByNode {
+		http.Error(w, fmt.Sprintf("group by %s is not supported", by), http.StatusBadRequest)
+		return
+	}
+	q, err := parseQuery(params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	slogs := query(q)
+	groups := make([]*Groups, len(slogs))
+	for i, ses := range slogs {
+		groups[i] = GroupBy(ses, by)
+	}
+	writeJSON(w, groups)
+}
+
+// resetLog will drop slowlog of cluster or all clusters if cluster is not specified.
+func resetLog(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	cluster := req.URL.Query().Get("cluster")
+	storeLock.RLock()
+	for name, s := range storeMap {
+		if cluster == "" || cluster == name {
+			s.Reset()
+		}
+	}
+	storeLock.RUnlock()
+	w.WriteHeader(http.StatusOK)
+}
+
+// sinkStats will show the counters of remote sinks.
+func sinkStats(w http.ResponseWriter, req *http.Request) {
+	writeJSON(w, SinkStats())
+}
+
+func query(q *Query) []*proto.SlowlogEntries {
 	storeLock.RLock()
-	var slogs = make([]*proto.SlowlogEntries, len(storeMap))
-	idx := 0
-	for _, s := range storeMap {
-		slogs[idx] = s.Reply()
-		idx++
+	var slogs = make([]*proto.SlowlogEntries, 0, len(storeMap))
+	for name, s := range storeMap {
+		if q.Cluster == "" || q.Cluster == name {
+			slogs = append(slogs, s.Query(q))
+		}
 	}
 	storeLock.RUnlock()
+	sort.Slice(slogs, func(i, j int) bool { return slogs[i].Cluster < slogs[j].Cluster })
+	return slogs
+}
+
+func parseQuery(params url.Values) (q *Query, err error) {
+	q = &Query{
+		Cluster: params.Get("cluster"),
+		Cmd:     params.Get("cmd"),
+		Node:    params.Get("node"),
+	}
+	if v := params.Get("min_dur"); v != "" {
+		var us int64
+		if us, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return nil, errors.Errorf("min_dur %s is invalid", v)
+		}
+		q.MinDur = time.Duration(us) * time.Microsecond
+	}
+	if v := params.Get("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil {
+			return nil, errors.Errorf("limit %s is invalid", v)
+		}
+	}
+	if q.Start, err = parseTime(params.Get("start")); err != nil {
+		return nil, err
+	}
+	if q.End, err = parseTime(params.Get("end")); err != nil {
+		return nil, err
+	}
+	return
+}
+
+func parseTime(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if sec, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return t, errors.Errorf("time %s is neither unix seconds nor RFC3339", v)
+	}
+	return t, nil
+}
 
+func writeJSON(w http.ResponseWriter, v interface{}) {
 	encoder := json.NewEncoder(w)
-	err := encoder.Encode(slogs)
+	err := encoder.Encode(v)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("%s", err), http.StatusInternalServerError)
 	}
@@ -29,4 +136,7 @@ func showlog(w http.ResponseWriter, _req *http.Request) {
 // registerSlowlogHTTP will register slowlog by /slowlog
 func registerSlowlogHTTP() {
 	http.HandleFunc("/slowlog", showlog)
+	http.HandleFunc("/slowlog/group", groupLog)
+	http.HandleFunc("/slowlog/reset", resetLog)
+	http.HandleFunc("/slowlog/sinks", sinkStats)
 }
@@ -0,0 +1,259 @@
+package slowlog
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"overlord/proxy/proto"
+
+	"github.com/pkg/errors"
+)
+
+// kafka protocol of Produce v3 with RecordBatch v2, which is the lowest version
+// supported by all brokers since kafka 4.0.
+const (
+	kafkaAPIProduce     = 0
+	kafkaProduceVersion = 3
+	kafkaRecordMagic    = 2
+	kafkaClientID       = "overlord"
+	// kafkaAcks waits the leader only.
+	kafkaAcks = 1
+	// kafkaMaxResponse is the max size of produce response read from broker.
+	kafkaMaxResponse = 1 << 20
+)
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// kafkaSink produces entries as json values keyed by cluster into the partition of topic,
+// the brokers are tried in turn if the current one fails.
+type kafkaSink struct {
+	brokers   []string
+	cur       int
+	topic     string
+	partition int32
+	timeout   time.Duration
+
+	conn          net.Conn
+	br            *bufio.Reader
+	correlationID int32
+
+	buf     []byte
+	records []byte
+}
+
+func newKafkaSink(c *SinkConfig) (Sink, error) {
+	s := &kafkaSink{
+		topic:     c.Topic,
+		partition: c.Partition,
+		timeout:   c.timeout(),
+	}
+	for _, broker := range strings.Split(c.Addr, ",") {
+		if broker = strings.TrimSpace(broker); broker != "" {
+			s.brokers = append(s.brokers, broker)
+		}
+	}
+	if len(s.brokers) == 0 {
+		return nil, errors.Errorf("slowlog sink %s brokers is empty", c.Name)
+	}
+	return s, nil
+}
+
+func (s *kafkaSink) Write(entries []*proto.SlowlogEntry) (err error) {
+	if s.conn == nil {
+		broker := s.brokers[s.cur]
+		if s.conn, err = net.DialTimeout("tcp", broker, s.timeout); err != nil {
+			s.conn = nil
+			s.cur = (s.cur + 1) % len(s.brokers)
+			return errors.Wrapf(err, "dial kafka broker %s", broker)
+		}
+		s.br = bufio.NewReader(s.conn)
+	}
+	if err = s.produce(entries); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		s.cur = (s.cur + 1) % len(s.brokers)
+	}
+	return
+}
+
+func (s *kafkaSink) produce(entries []*proto.SlowlogEntry) error {
+	req, err := s.encodeProduce(entries, time.Now())
+	if err != nil {
+		return err
+	}
+	_ = s.conn.SetDeadline(time.Now().Add(s.timeout))
+	if _, err = s.conn.Write(req); err != nil {
+		return errors.WithStack(err)
+	}
+	return s.readResponse()
+}
+
+// encodeProduce encodes the produce request of entries with the size prefix.
+func (s *kafkaSink) encodeProduce(entries []*proto.SlowlogEntry, now time.Time) ([]byte, error) {
+	ts := now.UnixNano() / int64(time.Millisecond)
+	s.records = s.records[:0]
+	for i, entry := range entries {
+		value, err := json.Marshal(entry)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		s.records = appendRecord(s.records, int64(i), []byte(entry.Cluster), value)
+	}
+
+	s.correlationID++
+	b := s.buf[:0]
+	b = appendInt32(b, 0) // NOTE: size is filled at last
+	b = appendInt16(b, kafkaAPIProduce)
+	b = appendInt16(b, kafkaProduceVersion)
+	b = appendInt32(b, s.correlationID)
+	b = appendString(b, kafkaClientID)
+	b = appendInt16(b, -1) // transactional_id is null
+	b = appendInt16(b, kafkaAcks)
+	b = appendInt32(b, int32(s.timeout/time.Millisecond))
+	b = appendInt32(b, 1) // topics
+	b = appendString(b, s.topic)
+	b = appendInt32(b, 1) // partitions
+	b = appendInt32(b, s.partition)
+
+	// record batch
+	const batchHeader = 61
+	b = appendInt32(b, int32(batchHeader+len(s.records)))
+	b = appendInt64(b, 0)                                    // base offset
+	b = appendInt32(b, int32(batchHeader-12+len(s.records))) // batch length
+	b = appendInt32(b, -1)                                   // partition leader epoch
+	b = append(b, kafkaRecordMagic)
+	crcAt := len(b)
+	b = appendInt32(b, 0) // NOTE: crc is filled after attributes and records encoded
+	b = appendInt16(b, 0) // attributes
+	b = appendInt32(b, int32(len(entries)-1))
+	b = appendInt64(b, ts) // base timestamp
+	b = appendInt64(b, ts) // max timestamp
+	b = appendInt64(b, -1) // producer id
+	b = appendInt16(b, -1) // producer epoch
+	b = appendInt32(b, -1) // base sequence
+	b = appendInt32(b, int32(len(entries)))
+	b = append(b, s.records...)
+	binary.BigEndian.PutUint32(b[crcAt:], crc32.Checksum(b[crcAt+4:], crc32c))
+
+	binary.BigEndian.PutUint32(b, uint32(len(b)-4))
+	s.buf = b
+	return b, nil
+}
+
+// readResponse reads the produce response and checks the error code of partition.
+func (s *kafkaSink) readResponse() error {
+	var size int32
+	if err := binary.Read(s.br, binary.BigEndian, &size); err != nil {
+		return errors.WithStack(err)
+	}
+	if size < 4 || size > kafkaMaxResponse {
+		return errors.Errorf("kafka produce response size %d is invalid", size)
+	}
+	resp := make([]byte, size)
+	if _, err := io.ReadFull(s.br, resp); err != nil {
+		return errors.WithStack(err)
+	}
+	d := &kafkaDecoder{b: resp}
+	if id := d.int32(); id != s.correlationID {
+		return errors.Errorf("kafka produce response correlation id %d is not %d", id, s.correlationID)
+	}
+	for topics := d.int32(); topics > 0; topics-- {
+		d.string()
+		for partitions := d.int32(); partitions > 0; partitions-- {
+			d.int32() // partition
+			code := d.int16()
+			d.int64() // base offset
+			d.int64() // log append time
+			if code != 0 {
+				return errors.Errorf("kafka produce to %s-%d got error code %d", s.topic, s.partition, code)
+			}
+		}
+	}
+	return d.err
+}
+
+func (s *kafkaSink) Close() error {
+	if s.conn != nil {
+		return s.conn.Close()
+	}
+	return nil
+}
+
+// appendRecord appends the record of RecordBatch v2 with no header.
+func appendRecord(b []byte, offsetDelta int64, key, value []byte) []byte {
+	var body []byte
+	body = append(body, 0)                 // attributes
+	body = appendVarint(body, 0)           // timestamp delta
+	body = appendVarint(body, offsetDelta) // offset delta
+	body = appendVarint(body, int64(len(key)))
+	body = append(body, key...)
+	body = appendVarint(body, int64(len(value)))
+	body = append(body, value...)
+	body = appendVarint(body, 0) // headers
+	b = appendVarint(b, int64(len(body)))
+	return append(b, body...)
+}
+
+func appendVarint(b []byte, v int64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
+
+func appendInt16(b []byte, v int16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendInt32(b []byte, v int32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendInt64(b []byte, v int64) []byte {
+	return appendInt32(appendInt32(b, int32(v>>32)), int32(v))
+}
+
+func appendString(b []byte, s string) []byte {
+	return append(appendInt16(b, int16(len(s))), s...)
+}
+
+// kafkaDecoder decodes the big endian fields, err is set if the buffer is short.
+type kafkaDecoder struct {
+	b   []byte
+	err error
+}
+
+func (d *kafkaDecoder) next(n int) []byte {
+	if d.err != nil || len(d.b) < n {
+		d.err = errors.New("kafka response is short")
+		return make([]byte, n)
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *kafkaDecoder) int16() int16 {
+	return int16(binary.BigEndian.Uint16(d.next(2)))
+}
+
+func (d *kafkaDecoder) int32() int32 {
+	return int32(binary.BigEndian.Uint32(d.next(4)))
+}
+
+func (d *kafkaDecoder) int64() int64 {
+	return int64(binary.BigEndian.Uint64(d.next(8)))
+}
+
+func (d *kafkaDecoder) string() string {
+	n := d.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(d.next(int(n)))
+}
@@ -0,0 +1,148 @@
+package slowlog
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"overlord/proxy/proto"
+)
+
+// Query is the filter of slowlog entries, zero fields match all.
+type Query struct {
+	Cluster string
+	// Start and End is the range of entry start time.
+	Start, End time.Time
+	Cmd        string
+	MinDur     time.Duration
+	Node       string
+	// Limit is the max count of entries per cluster.
+	Limit int
+}
+
+func (q *Query) match(e *proto.SlowlogEntry) bool {
+	if !q.Start.IsZero() && e.StartTime.Before(q.Start) {
+		return false
+	}
+	if !q.End.IsZero() && e.StartTime.After(q.End) {
+		return false
+	}
+	if q.MinDur > 0 && e.TotalDur < q.MinDur {
+		return false
+	}
+	if q.Cmd != "" && !strings.EqualFold(q.Cmd, entryCmd(e)) {
+		return false
+	}
+	if q.Node != "" && !hasNode(e, q.Node) {
+		return false
+	}
+	return true
+}
+
+// entryCmd return the command of entry, the command of first sub entry if batch.
+func entryCmd(e *proto.SlowlogEntry) string {
+	if len(e.Cmd) == 0 && len(e.Subs) > 0 {
+		e = e.Subs[0]
+	}
+	if len(e.Cmd) == 0 {
+		return ""
+	}
+	cmd := e.Cmd[0]
+	// NOTE: command of redis is recorded with the bulk length like "3\r\nGET"
+	if idx := strings.Index(cmd, "\r\n"); idx >= 0 {
+		cmd = cmd[idx+2:]
+	}
+	return strings.ToUpper(cmd)
+}
+
+func hasNode(e *proto.SlowlogEntry, node string) bool {
+	if e.Addr == node {
+		return true
+	}
+	for _, sub := range e.Subs {
+		if sub.Addr == node {
+			return true
+		}
+	}
+	return false
+}
+
+// entryNodes return the distinct nodes of entry.
+func entryNodes(e *proto.SlowlogEntry) []string {
+	if len(e.Subs) == 0 {
+		return []string{e.Addr}
+	}
+	var nodes []string
+	for _, sub := range e.Subs {
+		found := false
+		for _, node := range nodes {
+			if node == sub.Addr {
+				found = true
+				break
+			}
+		}
+		if !found {
+			nodes = append(nodes, sub.Addr)
+		}
+	}
+	return nodes
+}
+
+// group by
+const (
+	GroupByCmd  = "cmd"
+	GroupByNode = "node"
+)
+
+// Group is the aggregation of slowlog entries.
+type Group struct {
+	Key      string        `json:"key"`
+	Count    int           `json:"count"`
+	TotalDur time.Duration `json:"total_dur"`
+	MaxDur   time.Duration `json:"max_dur"`
+	AvgDur   time.Duration `json:"avg_dur"`
+}
+
+// Groups is the aggregation of slowlog entries of cluster.
+type Groups struct {
+	Cluster string   `json:"cluster"`
+	Groups  []*Group `json:"groups"`
+}
+
+// GroupBy aggregates entries by command or node, groups are sorted by count desc.
+func GroupBy(ses *proto.SlowlogEntries, by string) *Groups {
+	gm := make(map[string]*Group)
+	add := func(key string, e *proto.SlowlogEntry) {
+		g, ok := gm[key]
+		if !ok {
+			g = &Group{Key: key}
+			gm[key] = g
+		}
+		g.Count++
+		g.TotalDur += e.TotalDur
+		if e.TotalDur > g.MaxDur {
+			g.MaxDur = e.TotalDur
+		}
+	}
+	for _, e := range ses.Entries {
+		if by == GroupByNode {
+			for _, node := range entryNodes(e) {
+				add(node, e)
+			}
+		} else {
+			add(entryCmd(e), e)
+		}
+	}
+	gs := &Groups{Cluster: ses.Cluster, Groups: make([]*Group, 0, len(gm))}
+	for _, g := range gm {
+		g.AvgDur = g.TotalDur / time.Duration(g.Count)
+		gs.Groups = append(gs.Groups, g)
+	}
+	sort.Slice(gs.Groups, func(i, j int) bool {
+		if gs.Groups[i].Count != gs.Groups[j].Count {
+			return gs.Groups[i].Count > gs.Groups[j].Count
+		}
+		return gs.Groups[i].Key < gs.Groups[j].Key
+	})
+	return gs
+}
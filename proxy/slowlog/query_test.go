@@ -0,0 +1,103 @@
+package slowlog
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"overlord/proxy/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func _entry(cmd, addr string, dur time.Duration, start time.Time) *proto.SlowlogEntry {
+	return &proto.SlowlogEntry{Cmd: []string{cmd, "key"}, Addr: addr, TotalDur: dur, StartTime: start}
+}
+
+func TestStoreRingNewestFirst(t *testing.T) {
+	s := newStore("ring", 4)
+	now := time.Now()
+	for i := 0; i < 6; i++ {
+		s.Record(_entry("3\r\nGET", "node1", time.Duration(i), now))
+	}
+	ses := s.Reply()
+	assert.Equal(t, "ring", ses.Cluster)
+	assert.Len(t, ses.Entries, 4)
+	for i, e := range ses.Entries {
+		assert.Equal(t, time.Duration(5-i), e.TotalDur)
+	}
+	s.Reset()
+	assert.Len(t, s.Reply().Entries, 0)
+	assert.Equal(t, 4, s.Size())
+}
+
+func TestStoreQuery(t *testing.T) {
+	s := newStore("query", 0)
+	now := time.Now()
+	s.Record(_entry("3\r\nGET", "node1", 10*time.Millisecond, now.Add(-time.Hour)))
+	s.Record(_entry("3\r\nSET", "node2", 20*time.Millisecond, now))
+	s.Record(_entry("get", "node2", 30*time.Millisecond, now))
+	s.Record(&proto.SlowlogEntry{TotalDur: 40 * time.Millisecond, StartTime: now, Subs: []*proto.SlowlogEntry{
+		_entry("4\r\nMGET", "node1", 0, now),
+		_entry("4\r\nMGET", "node3", 0, now),
+	}})
+
+	assert.Len(t, s.Query(&Query{Cmd: "get"}).Entries, 2)
+	assert.Len(t, s.Query(&Query{Node: "node1"}).Entries, 2)
+	assert.Len(t, s.Query(&Query{MinDur: 25 * time.Millisecond}).Entries, 2)
+	assert.Len(t, s.Query(&Query{Start: now.Add(-time.Minute)}).Entries, 3)
+	assert.Len(t, s.Query(&Query{End: now.Add(-time.Minute)}).Entries, 1)
+	es := s.Query(&Query{Limit: 2}).Entries
+	assert.Len(t, es, 2)
+	assert.Equal(t, 40*time.Millisecond, es[0].TotalDur)
+
+	gs := GroupBy(s.Reply(), GroupByCmd)
+	assert.Len(t, gs.Groups, 3)
+	assert.Equal(t, "GET", gs.Groups[0].Key)
+	assert.Equal(t, 2, gs.Groups[0].Count)
+	assert.Equal(t, 30*time.Millisecond, gs.Groups[0].MaxDur)
+	assert.Equal(t, 20*time.Millisecond, gs.Groups[0].AvgDur)
+	gs = GroupBy(s.Reply(), GroupByNode)
+	assert.Len(t, gs.Groups, 3)
+	assert.Equal(t, "node1", gs.Groups[0].Key)
+	assert.Equal(t, 2, gs.Groups[0].Count)
+}
+
+func TestSlowlogHTTP(t *testing.T) {
+	s := Register("http-test", 8).(*Store)
+	assert.Equal(t, 8, s.Size())
+	now := time.Now()
+	s.Record(_entry("3\r\nGET", "node1", 10*time.Millisecond, now))
+	s.Record(_entry("3\r\nSET", "node2", 20*time.Millisecond, now))
+
+	rec := httptest.NewRecorder()
+	showlog(rec, httptest.NewRequest(http.MethodGet, "/slowlog?cluster=http-test&cmd=SET&min_dur=1000", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var slogs []*proto.SlowlogEntries
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &slogs))
+	assert.Len(t, slogs, 1)
+	assert.Len(t, slogs[0].Entries, 1)
+	assert.Equal(t, "node2", slogs[0].Entries[0].Addr)
+
+	rec = httptest.NewRecorder()
+	showlog(rec, httptest.NewRequest(http.MethodGet, "/slowlog?start=yesterday", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	groupLog(rec, httptest.NewRequest(http.MethodGet, "/slowlog/group?cluster=http-test&by=node", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var groups []*Groups
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &groups))
+	assert.Len(t, groups, 1)
+	assert.Len(t, groups[0].Groups, 2)
+
+	rec = httptest.NewRecorder()
+	resetLog(rec, httptest.NewRequest(http.MethodGet, "/slowlog/reset", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	rec = httptest.NewRecorder()
+	resetLog(rec, httptest.NewRequest(http.MethodPost, "/slowlog/reset?cluster=http-test", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Len(t, s.Reply().Entries, 0)
+}
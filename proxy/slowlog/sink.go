@@ -0,0 +1,329 @@
+package slowlog
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"overlord/pkg/log"
+	"overlord/pkg/prom"
+	"overlord/proxy/proto"
+
+	"github.com/pkg/errors"
+)
+
+// sink types
+const (
+	SinkSyslog = "syslog"
+	SinkHTTP   = "http"
+	SinkKafka  = "kafka"
+)
+
+const (
+	defaultSinkQueueSize     = 4096
+	defaultSinkBatchSize     = 128
+	defaultSinkFlushInterval = time.Second
+	defaultSinkTimeout       = 3 * time.Second
+	defaultSinkMaxRetries    = 3
+	defaultSinkMinBackoff    = 100 * time.Millisecond
+	defaultSinkMaxBackoff    = 10 * time.Second
+)
+
+// SinkConfig is the config of remote sink, clusters select sinks by name.
+type SinkConfig struct {
+	Name string `toml:"name"`
+	// Type is one of syslog, http and kafka.
+	Type string `toml:"type"`
+	// Addr is "udp://host:port" or "tcp://host:port" of syslog, the url of http
+	// and the brokers separated by comma of kafka.
+	Addr string `toml:"addr"`
+	// Tag is the app name of syslog messages.
+	Tag string `toml:"tag"`
+	// Topic and Partition of kafka, the broker must be the leader of partition.
+	Topic     string `toml:"topic"`
+	Partition int32  `toml:"partition"`
+	// QueueSize is the max count of entries waiting to be written, the others are dropped.
+	QueueSize int `toml:"queue_size"`
+	BatchSize int `toml:"batch_size"`
+	// FlushInterval, Timeout and MaxBackoff are in millisecond.
+	FlushInterval int `toml:"flush_interval"`
+	Timeout       int `toml:"timeout"`
+	MaxRetries    int `toml:"max_retries"`
+	MaxBackoff    int `toml:"max_backoff"`
+}
+
+// Validate validates the config of sink.
+func (c *SinkConfig) Validate() error {
+	if c.Name == "" {
+		return errors.New("slowlog sink name is empty")
+	}
+	if _, ok := sinkTypes[c.Type]; !ok {
+		return errors.Errorf("slowlog sink %s type %s is not supported", c.Name, c.Type)
+	}
+	if c.Addr == "" {
+		return errors.Errorf("slowlog sink %s addr is empty", c.Name)
+	}
+	if c.Type == SinkKafka && c.Topic == "" {
+		return errors.Errorf("slowlog sink %s topic is empty", c.Name)
+	}
+	return nil
+}
+
+func (c *SinkConfig) timeout() time.Duration {
+	if c.Timeout <= 0 {
+		return defaultSinkTimeout
+	}
+	return time.Duration(c.Timeout) * time.Millisecond
+}
+
+// Sink is the remote output of slowlog, entries are written in batch.
+// NOTE: it is called by one goroutine only.
+type Sink interface {
+	Write(entries []*proto.SlowlogEntry) error
+	Close() error
+}
+
+var sinkTypes = map[string]func(c *SinkConfig) (Sink, error){
+	SinkSyslog: newSyslogSink,
+	SinkHTTP:   newHTTPSink,
+	SinkKafka:  newKafkaSink,
+}
+
+// SinkStat is the counters of sink.
+type SinkStat struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Queued  int    `json:"queued"`
+	Sent    uint64 `json:"sent"`
+	Dropped uint64 `json:"dropped"`
+	Errors  uint64 `json:"errors"`
+}
+
+// exporter writes entries into sink asynchronously in batch,
+// the failed batch is retried with backoff and dropped at last.
+type exporter struct {
+	name, typ     string
+	sink          Sink
+	entries       chan *proto.SlowlogEntry
+	batchSize     int
+	flushInterval time.Duration
+	maxRetries    int
+	backoff       *backoff
+
+	sent    uint64
+	dropped uint64
+	errors  uint64
+
+	quit chan struct{}
+	done chan struct{}
+}
+
+func newExporter(c *SinkConfig, sink Sink) *exporter {
+	e := &exporter{
+		name:          c.Name,
+		typ:           c.Type,
+		sink:          sink,
+		entries:       make(chan *proto.SlowlogEntry, defaultSinkQueueSize),
+		batchSize:     defaultSinkBatchSize,
+		flushInterval: defaultSinkFlushInterval,
+		maxRetries:    defaultSinkMaxRetries,
+		backoff:       &backoff{min: defaultSinkMinBackoff, max: defaultSinkMaxBackoff},
+		quit:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	if c.QueueSize > 0 {
+		e.entries = make(chan *proto.SlowlogEntry, c.QueueSize)
+	}
+	if c.BatchSize > 0 {
+		e.batchSize = c.BatchSize
+	}
+	if c.FlushInterval > 0 {
+		e.flushInterval = time.Duration(c.FlushInterval) * time.Millisecond
+	}
+	if c.MaxRetries > 0 {
+		e.maxRetries = c.MaxRetries
+	}
+	if c.MaxBackoff > 0 {
+		e.backoff.max = time.Duration(c.MaxBackoff) * time.Millisecond
+	}
+	return e
+}
+
+func (e *exporter) save(entry *proto.SlowlogEntry) {
+	select {
+	case e.entries <- entry:
+	default:
+		e.drop(1)
+	}
+}
+
+func (e *exporter) drop(n int) {
+	atomic.AddUint64(&e.dropped, uint64(n))
+	prom.SlowlogSinkIncr(e.name, "dropped", n)
+}
+
+func (e *exporter) stat() *SinkStat {
+	return &SinkStat{
+		Name:    e.name,
+		Type:    e.typ,
+		Queued:  len(e.entries),
+		Sent:    atomic.LoadUint64(&e.sent),
+		Dropped: atomic.LoadUint64(&e.dropped),
+		Errors:  atomic.LoadUint64(&e.errors),
+	}
+}
+
+func (e *exporter) close() {
+	close(e.quit)
+	<-e.done
+}
+
+func (e *exporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(e.flushInterval)
+	defer ticker.Stop()
+	batch := make([]*proto.SlowlogEntry, 0, e.batchSize)
+	for {
+		select {
+		case entry := <-e.entries:
+			if batch = append(batch, entry); len(batch) < e.batchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		case <-e.quit:
+			// NOTE: write the queued entries once without retry.
+			for len(e.entries) > 0 {
+				if batch = append(batch, <-e.entries); len(batch) >= e.batchSize {
+					batch = e.write(batch, 0)
+				}
+			}
+			if len(batch) > 0 {
+				e.write(batch, 0)
+			}
+			_ = e.sink.Close()
+			return
+		}
+		batch = e.write(batch, e.maxRetries)
+	}
+}
+
+// write writes the batch into sink, retries with backoff on failure and return the batch reset.
+func (e *exporter) write(batch []*proto.SlowlogEntry, retries int) []*proto.SlowlogEntry {
+	for i := 0; ; i++ {
+		err := e.sink.Write(batch)
+		if err == nil {
+			e.backoff.reset()
+			atomic.AddUint64(&e.sent, uint64(len(batch)))
+			prom.SlowlogSinkIncr(e.name, "sent", len(batch))
+			return batch[:0]
+		}
+		atomic.AddUint64(&e.errors, 1)
+		prom.SlowlogSinkIncr(e.name, "error", 1)
+		if log.V(2) {
+			log.Errorf("fail to write slowlog into sink %s due %s", e.name, err)
+		}
+		if i >= retries {
+			e.drop(len(batch))
+			return batch[:0]
+		}
+		select {
+		case <-time.After(e.backoff.next()):
+		case <-e.quit:
+			e.drop(len(batch))
+			return batch[:0]
+		}
+	}
+}
+
+// backoff is the exponential backoff with jitter.
+type backoff struct {
+	min, max time.Duration
+	cur      time.Duration
+}
+
+func (b *backoff) next() time.Duration {
+	if b.cur < b.min {
+		b.cur = b.min
+	} else if b.cur *= 2; b.cur > b.max {
+		b.cur = b.max
+	}
+	// NOTE: jitter in [cur/2, cur] to avoid all proxies retrying at the same time.
+	half := int64(b.cur / 2)
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+func (b *backoff) reset() {
+	b.cur = 0
+}
+
+var (
+	exporters    = map[string]*exporter{}
+	exporterLock sync.RWMutex
+)
+
+// InitSinks init the remote sinks which are selected by clusters by name.
+func InitSinks(confs []*SinkConfig) error {
+	exporterLock.Lock()
+	defer exporterLock.Unlock()
+	for _, c := range confs {
+		if err := c.Validate(); err != nil {
+			return err
+		}
+		if _, ok := exporters[c.Name]; ok {
+			return errors.Errorf("slowlog sink %s is duplicate", c.Name)
+		}
+		sink, err := sinkTypes[c.Type](c)
+		if err != nil {
+			return err
+		}
+		e := newExporter(c, sink)
+		exporters[c.Name] = e
+		go e.run()
+		log.Infof("setup slowlog sink [%s] of %s to [%s]", c.Name, c.Type, c.Addr)
+	}
+	return nil
+}
+
+// Bind sets the sinks which the slowlog of cluster is written into.
+func Bind(cluster string, sinks []string) error {
+	es := make([]*exporter, 0, len(sinks))
+	exporterLock.RLock()
+	for _, name := range sinks {
+		e, ok := exporters[name]
+		if !ok {
+			exporterLock.RUnlock()
+			return errors.Errorf("slowlog sink %s of cluster %s is not found", name, cluster)
+		}
+		es = append(es, e)
+	}
+	exporterLock.RUnlock()
+	getStore(cluster, 0).exporters.Store(es)
+	return nil
+}
+
+// SinkStats return the counters of all sinks sorted by name.
+func SinkStats() []*SinkStat {
+	exporterLock.RLock()
+	stats := make([]*SinkStat, 0, len(exporters))
+	for _, e := range exporters {
+		stats = append(stats, e.stat())
+	}
+	exporterLock.RUnlock()
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
+
+// Close writes the queued entries and closes all sinks.
+func Close() {
+	exporterLock.Lock()
+	defer exporterLock.Unlock()
+	for name, e := range exporters {
+		e.close()
+		delete(exporters, name)
+	}
+}
@@ -0,0 +1,366 @@
+package slowlog
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"overlord/proxy/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func _entries(cluster string, n int) []*proto.SlowlogEntry {
+	entries := make([]*proto.SlowlogEntry, n)
+	for i := range entries {
+		entries[i] = _entry("GET", "127.0.0.1:6379", time.Millisecond, time.Now())
+		entries[i].Cluster = cluster
+	}
+	return entries
+}
+
+func TestSyslogSinkUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer pc.Close()
+
+	sink, err := newSyslogSink(&SinkConfig{Name: "syslog", Addr: "udp://" + pc.LocalAddr().String(), Tag: "test"})
+	assert.NoError(t, err)
+	defer sink.Close()
+	assert.NoError(t, sink.Write(_entries("udp", 2)))
+
+	buf := make([]byte, 4096)
+	for i := 0; i < 2; i++ {
+		_ = pc.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := pc.ReadFrom(buf)
+		assert.NoError(t, err)
+		msg := string(buf[:n])
+		assert.True(t, strings.HasPrefix(msg, "<132>1 "), msg)
+		assert.Contains(t, msg, " test ")
+		assert.Contains(t, msg, " slowlog - {")
+		assert.Contains(t, msg, `"cluster":"udp"`)
+	}
+}
+
+func TestSyslogSinkTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer l.Close()
+	msgs := make(chan string, 3)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		br := bufio.NewReader(conn)
+		for {
+			// NOTE: octet counting framing
+			ls, err := br.ReadString(' ')
+			if err != nil {
+				return
+			}
+			n, _ := strconv.Atoi(strings.TrimSpace(ls))
+			msg := make([]byte, n)
+			if _, err = io.ReadFull(br, msg); err != nil {
+				return
+			}
+			msgs <- string(msg)
+		}
+	}()
+
+	sink, err := newSyslogSink(&SinkConfig{Name: "syslog", Addr: "tcp://" + l.Addr().String()})
+	assert.NoError(t, err)
+	defer sink.Close()
+	assert.NoError(t, sink.Write(_entries("tcp", 3)))
+	for i := 0; i < 3; i++ {
+		select {
+		case msg := <-msgs:
+			assert.Contains(t, msg, " overlord ")
+			assert.True(t, strings.HasSuffix(msg, "}"), msg)
+		case <-time.After(time.Second):
+			t.Fatal("syslog message is not received")
+		}
+	}
+
+	_, err = newSyslogSink(&SinkConfig{Name: "syslog", Addr: "127.0.0.1:514"})
+	assert.Error(t, err)
+}
+
+func TestHTTPSink(t *testing.T) {
+	var (
+		lock    sync.Mutex
+		batches [][]*proto.SlowlogEntry
+		fail    int32
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&fail) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var entries []*proto.SlowlogEntry
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&entries))
+		lock.Lock()
+		batches = append(batches, entries)
+		lock.Unlock()
+	}))
+	defer srv.Close()
+
+	sink, err := newHTTPSink(&SinkConfig{Name: "http", Addr: srv.URL})
+	assert.NoError(t, err)
+	defer sink.Close()
+	assert.NoError(t, sink.Write(_entries("http", 3)))
+	lock.Lock()
+	assert.Len(t, batches, 1)
+	assert.Len(t, batches[0], 3)
+	assert.Equal(t, "http", batches[0][0].Cluster)
+	lock.Unlock()
+
+	atomic.StoreInt32(&fail, 1)
+	assert.Error(t, sink.Write(_entries("http", 1)))
+}
+
+// kafkaBroker is the stand-in broker which decodes produce requests.
+type kafkaBroker struct {
+	l    net.Listener
+	code int16
+	recs chan [2]string
+}
+
+func newKafkaBroker(t *testing.T, code int16) *kafkaBroker {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	b := &kafkaBroker{l: l, code: code, recs: make(chan [2]string, 16)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(t, conn)
+		}
+	}()
+	return b
+}
+
+func (b *kafkaBroker) serve(t *testing.T, conn net.Conn) {
+	defer conn.Close()
+	for {
+		var size int32
+		if err := binary.Read(conn, binary.BigEndian, &size); err != nil {
+			return
+		}
+		req := make([]byte, size)
+		if _, err := io.ReadFull(conn, req); err != nil {
+			return
+		}
+		d := &kafkaDecoder{b: req}
+		assert.Equal(t, int16(kafkaAPIProduce), d.int16())
+		assert.Equal(t, int16(kafkaProduceVersion), d.int16())
+		id := d.int32()
+		assert.Equal(t, kafkaClientID, d.string())
+		assert.Equal(t, int16(-1), d.int16())
+		assert.Equal(t, int16(kafkaAcks), d.int16())
+		d.int32()
+		assert.Equal(t, int32(1), d.int32())
+		topic := d.string()
+		assert.Equal(t, int32(1), d.int32())
+		partition := d.int32()
+		records := d.next(int(d.int32()))
+		assert.NoError(t, d.err)
+
+		rd := &kafkaDecoder{b: records}
+		rd.int64()
+		assert.Equal(t, int32(len(records)-12), rd.int32())
+		rd.int32()
+		assert.Equal(t, []byte{kafkaRecordMagic}, rd.next(1))
+		crc := uint32(rd.int32())
+		assert.Equal(t, crc32.Checksum(rd.b, crc32c), crc)
+		rd.next(2 + 4 + 8 + 8 + 8 + 2 + 4)
+		for n := rd.int32(); n > 0; n-- {
+			body := rd.next(int(varint(&rd.b)))
+			body = body[1:] // attributes
+			varint(&body)   // timestamp delta
+			varint(&body)   // offset delta
+			key := string(body[:varint(&body)])
+			body = body[len(key):]
+			b.recs <- [2]string{key, string(body[:varint(&body)])}
+		}
+
+		resp := appendInt32(nil, id)
+		resp = appendInt32(resp, 1)
+		resp = appendString(resp, topic)
+		resp = appendInt32(resp, 1)
+		resp = appendInt32(resp, partition)
+		resp = appendInt16(resp, b.code)
+		resp = appendInt64(resp, 0)
+		resp = appendInt64(resp, -1)
+		resp = appendInt32(resp, 0)
+		if _, err := conn.Write(append(appendInt32(nil, int32(len(resp))), resp...)); err != nil {
+			return
+		}
+	}
+}
+
+// varint reads the varint and advances b.
+func varint(b *[]byte) int64 {
+	v, n := binary.Varint(*b)
+	*b = (*b)[n:]
+	return v
+}
+
+func TestKafkaSink(t *testing.T) {
+	b := newKafkaBroker(t, 0)
+	defer b.l.Close()
+	// NOTE: the first broker is down and the second one is tried.
+	down, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	down.Close()
+
+	sink, err := newKafkaSink(&SinkConfig{Name: "kafka", Addr: down.Addr().String() + "," + b.l.Addr().String(), Topic: "slowlog"})
+	assert.NoError(t, err)
+	defer sink.Close()
+	assert.Error(t, sink.Write(_entries("kafka", 2)))
+	assert.NoError(t, sink.Write(_entries("kafka", 2)))
+	assert.NoError(t, sink.Write(_entries("kafka", 1)))
+	for i := 0; i < 3; i++ {
+		rec := <-b.recs
+		assert.Equal(t, "kafka", rec[0])
+		entry := &proto.SlowlogEntry{}
+		assert.NoError(t, json.Unmarshal([]byte(rec[1]), entry))
+		assert.Equal(t, "kafka", entry.Cluster)
+		assert.Equal(t, "127.0.0.1:6379", entry.Addr)
+	}
+
+	eb := newKafkaBroker(t, 6) // NOTE: NOT_LEADER_OR_FOLLOWER
+	defer eb.l.Close()
+	sink, err = newKafkaSink(&SinkConfig{Name: "kafka", Addr: eb.l.Addr().String(), Topic: "slowlog"})
+	assert.NoError(t, err)
+	defer sink.Close()
+	err = sink.Write(_entries("kafka", 1))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "error code 6")
+}
+
+type mockSink struct {
+	lock    sync.Mutex
+	fails   int
+	writes  int
+	entries int
+	block   chan struct{}
+}
+
+func (s *mockSink) Write(entries []*proto.SlowlogEntry) error {
+	if s.block != nil {
+		<-s.block
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.writes++
+	if s.fails > 0 {
+		s.fails--
+		return io.ErrClosedPipe
+	}
+	s.entries += len(entries)
+	return nil
+}
+
+func (s *mockSink) Close() error { return nil }
+
+func TestExporterRetry(t *testing.T) {
+	sink := &mockSink{fails: 2}
+	e := newExporter(&SinkConfig{Name: "retry", Type: SinkHTTP, BatchSize: 4, MaxRetries: 2}, sink)
+	e.backoff.min = time.Millisecond
+	go e.run()
+	for _, entry := range _entries("retry", 4) {
+		e.save(entry)
+	}
+	time.Sleep(100 * time.Millisecond)
+	e.close()
+	st := e.stat()
+	assert.Equal(t, uint64(4), st.Sent)
+	assert.Equal(t, uint64(2), st.Errors)
+	assert.Equal(t, uint64(0), st.Dropped)
+	assert.Equal(t, 3, sink.writes)
+
+	// NOTE: the batch is dropped after retries.
+	sink = &mockSink{fails: 10}
+	e = newExporter(&SinkConfig{Name: "drop", Type: SinkHTTP, BatchSize: 2, MaxRetries: 1}, sink)
+	e.backoff.min = time.Millisecond
+	go e.run()
+	for _, entry := range _entries("drop", 2) {
+		e.save(entry)
+	}
+	time.Sleep(100 * time.Millisecond)
+	e.close()
+	st = e.stat()
+	assert.Equal(t, uint64(0), st.Sent)
+	assert.Equal(t, uint64(2), st.Errors)
+	assert.Equal(t, uint64(2), st.Dropped)
+}
+
+func TestExporterQueueFull(t *testing.T) {
+	sink := &mockSink{block: make(chan struct{})}
+	e := newExporter(&SinkConfig{Name: "full", Type: SinkHTTP, QueueSize: 2, BatchSize: 1}, sink)
+	go e.run()
+	for _, entry := range _entries("full", 10) {
+		e.save(entry)
+	}
+	close(sink.block)
+	e.close()
+	st := e.stat()
+	assert.True(t, st.Dropped >= 7, "dropped %d", st.Dropped)
+	assert.Equal(t, uint64(10), st.Sent+st.Dropped)
+}
+
+func TestBackoff(t *testing.T) {
+	b := &backoff{min: 10 * time.Millisecond, max: 40 * time.Millisecond}
+	for _, cur := range []time.Duration{10, 20, 40, 40} {
+		d := b.next()
+		assert.True(t, d >= cur*time.Millisecond/2 && d <= cur*time.Millisecond, "%v", d)
+	}
+	b.reset()
+	assert.True(t, b.next() <= 10*time.Millisecond)
+}
+
+func TestBindSinks(t *testing.T) {
+	var received int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var entries []*proto.SlowlogEntry
+		body, _ := ioutil.ReadAll(r.Body)
+		assert.NoError(t, json.Unmarshal(body, &entries))
+		atomic.AddInt32(&received, int32(len(entries)))
+	}))
+	defer srv.Close()
+
+	assert.Error(t, InitSinks([]*SinkConfig{{Name: "bad", Type: "unknown", Addr: srv.URL}}))
+	assert.NoError(t, InitSinks([]*SinkConfig{{Name: "hook", Type: SinkHTTP, Addr: srv.URL, FlushInterval: 10}}))
+	defer Close()
+	assert.Error(t, InitSinks([]*SinkConfig{{Name: "hook", Type: SinkHTTP, Addr: srv.URL}}))
+	assert.Error(t, Bind("bind", []string{"missing"}))
+	assert.NoError(t, Bind("bind", []string{"hook"}))
+
+	s := Register("bind", 8)
+	for _, entry := range _entries("", 3) {
+		s.Record(entry)
+	}
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&received))
+	stats := SinkStats()
+	assert.Len(t, stats, 1)
+	assert.Equal(t, "hook", stats[0].Name)
+	assert.Equal(t, uint64(3), stats[0].Sent)
+}
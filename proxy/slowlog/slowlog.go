@@ -10,19 +10,30 @@ import (
 
 const slowlogMaxCount = 1024
 
-func newStore(name string) *Store {
-	return &Store{
-		name:   name,
-		cursor: 0,
-		msgs:   make([]atomic.Value, slowlogMaxCount),
+// ring is the ring buffer of slowlog, cursor is the count of entries recorded.
+type ring struct {
+	cursor uint64
+	msgs   []atomic.Value
+}
+
+func newRing(size int) *ring {
+	return &ring{msgs: make([]atomic.Value, size)}
+}
+
+func newStore(name string, size int) *Store {
+	if size <= 0 {
+		size = slowlogMaxCount
 	}
+	s := &Store{name: name}
+	s.ring.Store(newRing(size))
+	return s
 }
 
 // Store is the collector of slowlog
 type Store struct {
-	name   string
-	cursor uint32
-	msgs   []atomic.Value
+	name      string
+	ring      atomic.Value // *ring
+	exporters atomic.Value // []*exporter
 }
 
 // Record impl the Handler
@@ -30,36 +41,62 @@ func (s *Store) Record(msg *proto.SlowlogEntry) {
 	if msg == nil {
 		return
 	}
-
-	for {
-		if atomic.CompareAndSwapUint32(&s.cursor, s.cursor, s.cursor+1) {
-			idx := (s.cursor - 1) % slowlogMaxCount
-			s.msgs[idx].Store(msg)
-			if fh != nil {
-				fh.save(s.name, msg)
-			}
-			break
+	msg.Cluster = s.name
+	r := s.ring.Load().(*ring)
+	cursor := atomic.AddUint64(&r.cursor, 1)
+	r.msgs[(cursor-1)%uint64(len(r.msgs))].Store(msg)
+	if fh != nil {
+		fh.save(msg)
+	}
+	if es, ok := s.exporters.Load().([]*exporter); ok {
+		for _, e := range es {
+			e.save(msg)
 		}
 	}
 }
 
-// Reply impl the Replyer
+// Reply impl the Replyer and return all entries newest first.
 func (s *Store) Reply() *proto.SlowlogEntries {
+	return s.Query(&Query{})
+}
+
+// Query return the entries matched by query newest first.
+func (s *Store) Query(q *Query) *proto.SlowlogEntries {
+	r := s.ring.Load().(*ring)
+	cursor := atomic.LoadUint64(&r.cursor)
+	size := uint64(len(r.msgs))
+	n := cursor
+	if n > size {
+		n = size
+	}
 	entries := make([]*proto.SlowlogEntry, 0)
-	for _, msg := range s.msgs {
-		m := msg.Load()
-		if m == nil {
+	for i := uint64(0); i < n; i++ {
+		if q.Limit > 0 && len(entries) >= q.Limit {
 			break
 		}
-		sentry := m.(*proto.SlowlogEntry)
-		entries = append(entries, sentry)
+		m := r.msgs[(cursor-1-i)%size].Load()
+		if m == nil {
+			// NOTE: slot is not stored yet by concurrent Record
+			continue
+		}
+		if entry := m.(*proto.SlowlogEntry); q.match(entry) {
+			entries = append(entries, entry)
+		}
 	}
-
-	ses := &proto.SlowlogEntries{
+	return &proto.SlowlogEntries{
 		Cluster: s.name,
 		Entries: entries,
 	}
-	return ses
+}
+
+// Reset drops all entries.
+func (s *Store) Reset() {
+	s.ring.Store(newRing(s.Size()))
+}
+
+// Size return the max count of entries.
+func (s *Store) Size() int {
+	return len(s.ring.Load().(*ring).msgs)
 }
 
 var (
@@ -71,10 +108,29 @@ var (
 type Handler interface {
 	Record(msg *proto.SlowlogEntry)
 	Reply() *proto.SlowlogEntries
+	Query(q *Query) *proto.SlowlogEntries
+	Reset()
 }
 
 // Get create the message Handler or get the exists one
 func Get(name string) Handler {
+	return getStore(name, 0)
+}
+
+// Register create the message Handler with the max count of entries or get the exists one,
+// the entries of exists one are dropped if resized.
+func Register(name string, size int) Handler {
+	if size <= 0 {
+		size = slowlogMaxCount
+	}
+	s := getStore(name, size)
+	if s.Size() != size {
+		s.ring.Store(newRing(size))
+	}
+	return s
+}
+
+func getStore(name string, size int) *Store {
 	storeLock.RLock()
 	if s, ok := storeMap[name]; ok {
 		storeLock.RUnlock()
@@ -84,7 +140,10 @@ func Get(name string) Handler {
 
 	storeLock.Lock()
 	defer storeLock.Unlock()
-	s := newStore(name)
+	if s, ok := storeMap[name]; ok {
+		return s
+	}
+	s := newStore(name, size)
 	storeMap[name] = s
 	return s
 }
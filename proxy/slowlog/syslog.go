@@ -0,0 +1,102 @@
+package slowlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"overlord/proxy/proto"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// syslogPriority is facility local0 with severity warning.
+	syslogPriority = 16*8 + 4
+	syslogMsgID    = "slowlog"
+	defaultTag     = "overlord"
+)
+
+// syslogSink writes entries as RFC 5424 messages with json body,
+// one datagram per entry over udp and octet counting framed over tcp.
+type syslogSink struct {
+	network, addr string
+	tag, host     string
+	pid           string
+	timeout       time.Duration
+
+	conn net.Conn
+	buf  bytes.Buffer
+}
+
+func newSyslogSink(c *SinkConfig) (Sink, error) {
+	u, err := url.Parse(c.Addr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "slowlog sink %s addr %s", c.Name, c.Addr)
+	}
+	if u.Scheme != "udp" && u.Scheme != "tcp" {
+		return nil, errors.Errorf("slowlog sink %s addr %s must be udp:// or tcp://", c.Name, c.Addr)
+	}
+	s := &syslogSink{
+		network: u.Scheme,
+		addr:    u.Host,
+		tag:     c.Tag,
+		pid:     strconv.Itoa(os.Getpid()),
+		timeout: c.timeout(),
+	}
+	if s.tag == "" {
+		s.tag = defaultTag
+	}
+	if s.host, err = os.Hostname(); err != nil || s.host == "" {
+		s.host = "-"
+	}
+	return s, nil
+}
+
+func (s *syslogSink) Write(entries []*proto.SlowlogEntry) (err error) {
+	if s.conn == nil {
+		if s.conn, err = net.DialTimeout(s.network, s.addr, s.timeout); err != nil {
+			s.conn = nil
+			return errors.WithStack(err)
+		}
+	}
+	_ = s.conn.SetWriteDeadline(time.Now().Add(s.timeout))
+	for _, entry := range entries {
+		if err = s.write(entry); err != nil {
+			s.conn.Close()
+			s.conn = nil
+			return
+		}
+	}
+	return
+}
+
+func (s *syslogSink) write(entry *proto.SlowlogEntry) error {
+	body, err := json.Marshal(entry)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	s.buf.Reset()
+	msg := fmt.Sprintf("<%d>1 %s %s %s %s %s - %s", syslogPriority,
+		entry.StartTime.Format(time.RFC3339Nano), s.host, s.tag, s.pid, syslogMsgID, body)
+	if s.network == "tcp" {
+		// NOTE: octet counting framing of RFC 6587.
+		s.buf.WriteString(strconv.Itoa(len(msg)))
+		s.buf.WriteByte(byteSpace)
+	}
+	s.buf.WriteString(msg)
+	_, err = s.conn.Write(s.buf.Bytes())
+	return errors.WithStack(err)
+}
+
+func (s *syslogSink) Close() error {
+	if s.conn != nil {
+		return s.conn.Close()
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package slowlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"overlord/proxy/proto"
+
+	"github.com/pkg/errors"
+)
+
+// httpSink posts each batch of entries as json array to the url.
+type httpSink struct {
+	url    string
+	client *http.Client
+	buf    bytes.Buffer
+}
+
+func newHTTPSink(c *SinkConfig) (Sink, error) {
+	return &httpSink{
+		url:    c.Addr,
+		client: &http.Client{Timeout: c.timeout()},
+	}, nil
+}
+
+func (s *httpSink) Write(entries []*proto.SlowlogEntry) error {
+	s.buf.Reset()
+	if err := json.NewEncoder(&s.buf).Encode(entries); err != nil {
+		return errors.WithStack(err)
+	}
+	resp, err := s.client.Post(s.url, "application/json", &s.buf)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	// NOTE: drain the body to reuse the connection.
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("post slowlog to %s got status %s", s.url, resp.Status)
+	}
+	return nil
+}
+
+func (s *httpSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
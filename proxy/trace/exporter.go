@@ -0,0 +1,244 @@
+package trace
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"overlord/pkg/log"
+
+	"github.com/pkg/errors"
+)
+
+const scopeName = "overlord/proxy"
+
+// exporter batches spans and posts them to the OTLP/HTTP receiver in JSON encoding.
+type exporter struct {
+	endpoint      string
+	client        *http.Client
+	resource      otlpResource
+	spans         chan *Span
+	batchSize     int
+	flushInterval time.Duration
+
+	dropped uint64
+	quit    chan struct{}
+	done    chan struct{}
+}
+
+func newExporter(c *Config) *exporter {
+	e := &exporter{
+		endpoint:      c.Endpoint,
+		client:        &http.Client{Timeout: c.Timeout},
+		batchSize:     c.BatchSize,
+		flushInterval: c.FlushInterval,
+		quit:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	if e.client.Timeout <= 0 {
+		e.client.Timeout = defaultTimeout
+	}
+	if e.batchSize <= 0 {
+		e.batchSize = defaultBatchSize
+	}
+	if e.flushInterval <= 0 {
+		e.flushInterval = defaultFlushInterval
+	}
+	queueSize := c.QueueSize
+	if queueSize <= 0 {
+		queueSize = defaultQueueSize
+	}
+	e.spans = make(chan *Span, queueSize)
+	service := c.ServiceName
+	if service == "" {
+		service = defaultServiceName
+	}
+	e.resource = otlpResource{Attributes: otlpAttrs([]Attr{String("service.name", service)})}
+	return e
+}
+
+func (e *exporter) export(s *Span) {
+	select {
+	case e.spans <- s:
+	default:
+		atomic.AddUint64(&e.dropped, 1)
+	}
+}
+
+func (e *exporter) droppedCount() uint64 {
+	return atomic.LoadUint64(&e.dropped)
+}
+
+func (e *exporter) close() {
+	close(e.quit)
+	<-e.done
+}
+
+func (e *exporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(e.flushInterval)
+	defer ticker.Stop()
+	batch := make([]*Span, 0, e.batchSize)
+	for {
+		select {
+		case s := <-e.spans:
+			if batch = append(batch, s); len(batch) >= e.batchSize {
+				batch = e.flush(batch)
+			}
+		case <-ticker.C:
+			batch = e.flush(batch)
+		case <-e.quit:
+			for len(e.spans) > 0 {
+				batch = append(batch, <-e.spans)
+			}
+			e.flush(batch)
+			return
+		}
+	}
+}
+
+func (e *exporter) flush(batch []*Span) []*Span {
+	if len(batch) == 0 {
+		return batch
+	}
+	if err := e.post(batch); err != nil {
+		atomic.AddUint64(&e.dropped, uint64(len(batch)))
+		if log.V(2) {
+			log.Warnf("fail to export %d spans to %s due %v", len(batch), e.endpoint, err)
+		}
+	}
+	for i := range batch {
+		batch[i] = nil
+	}
+	return batch[:0]
+}
+
+func (e *exporter) post(batch []*Span) error {
+	body, err := json.Marshal(e.encode(batch))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	resp, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return errors.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+func (e *exporter) encode(batch []*Span) *otlpRequest {
+	spans := make([]*otlpSpan, 0, len(batch))
+	for _, s := range batch {
+		os := &otlpSpan{
+			TraceID:           hex.EncodeToString(s.TraceID[:]),
+			SpanID:            hex.EncodeToString(s.SpanID[:]),
+			Name:              s.Name,
+			Kind:              s.Kind,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        otlpAttrs(s.Attrs),
+		}
+		if !s.ParentID.IsZero() {
+			os.ParentSpanID = hex.EncodeToString(s.ParentID[:])
+		}
+		if s.Err != "" {
+			os.Status = &otlpStatus{Code: otlpStatusError, Message: s.Err}
+		}
+		spans = append(spans, os)
+	}
+	return &otlpRequest{
+		ResourceSpans: []*otlpResourceSpans{{
+			Resource: e.resource,
+			ScopeSpans: []*otlpScopeSpans{{
+				Scope: otlpScope{Name: scopeName},
+				Spans: spans,
+			}},
+		}},
+	}
+}
+
+// NOTE: the JSON encoding of OTLP ExportTraceServiceRequest, ids are hex and int64 are decimal strings.
+type otlpRequest struct {
+	ResourceSpans []*otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource      `json:"resource"`
+	ScopeSpans []*otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []*otlpAttr `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope   `json:"scope"`
+	Spans []*otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+const otlpStatusError = 2
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string      `json:"traceId"`
+	SpanID            string      `json:"spanId"`
+	ParentSpanID      string      `json:"parentSpanId,omitempty"`
+	Name              string      `json:"name"`
+	Kind              int         `json:"kind"`
+	StartTimeUnixNano string      `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string      `json:"endTimeUnixNano"`
+	Attributes        []*otlpAttr `json:"attributes,omitempty"`
+	Status            *otlpStatus `json:"status,omitempty"`
+}
+
+type otlpAttr struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+	BoolValue   *bool   `json:"boolValue,omitempty"`
+}
+
+func otlpAttrs(attrs []Attr) []*otlpAttr {
+	oas := make([]*otlpAttr, 0, len(attrs))
+	for _, a := range attrs {
+		oa := &otlpAttr{Key: a.Key}
+		switch v := a.Value.(type) {
+		case string:
+			oa.Value.StringValue = &v
+		case int64:
+			iv := strconv.FormatInt(v, 10)
+			oa.Value.IntValue = &iv
+		case int:
+			iv := strconv.Itoa(v)
+			oa.Value.IntValue = &iv
+		case bool:
+			oa.Value.BoolValue = &v
+		default:
+			continue
+		}
+		oas = append(oas, oa)
+	}
+	return oas
+}
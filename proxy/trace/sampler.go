@@ -0,0 +1,70 @@
+package trace
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// samplers
+const (
+	SamplerAlwaysOn  = "always_on"
+	SamplerAlwaysOff = "always_off"
+	SamplerRatio     = "traceidratio"
+)
+
+// Sampler decides whether the trace is sampled.
+type Sampler interface {
+	Sample(id TraceID, dur time.Duration) bool
+}
+
+// NewSampler new a sampler by name, ratio is used by traceidratio sampler,
+// traces slower than slowerThan are always sampled if it is not zero.
+func NewSampler(name string, ratio float64, slowerThan time.Duration) (s Sampler, err error) {
+	switch name {
+	case SamplerAlwaysOn:
+		s = alwaysSampler(true)
+	case "", SamplerAlwaysOff:
+		s = alwaysSampler(false)
+	case SamplerRatio:
+		if ratio < 0 || ratio > 1 {
+			return nil, errors.Errorf("trace sample ratio %v must be in [0, 1]", ratio)
+		}
+		s = newRatioSampler(ratio)
+	default:
+		return nil, errors.Errorf("trace sampler %s is not supported", name)
+	}
+	if slowerThan > 0 {
+		s = &slowSampler{Sampler: s, slowerThan: slowerThan}
+	}
+	return
+}
+
+type alwaysSampler bool
+
+func (s alwaysSampler) Sample(TraceID, time.Duration) bool {
+	return bool(s)
+}
+
+// ratioSampler samples by the lower 8 bytes of trace id just as the TraceIdRatioBased sampler of OpenTelemetry.
+type ratioSampler struct {
+	bound uint64
+}
+
+func newRatioSampler(ratio float64) *ratioSampler {
+	return &ratioSampler{bound: uint64(ratio * (1 << 63))}
+}
+
+func (s *ratioSampler) Sample(id TraceID, _ time.Duration) bool {
+	return binary.BigEndian.Uint64(id[8:])>>1 < s.bound
+}
+
+type slowSampler struct {
+	Sampler
+	slowerThan time.Duration
+}
+
+func (s *slowSampler) Sample(id TraceID, dur time.Duration) bool {
+	return dur >= s.slowerThan || s.Sampler.Sample(id, dur)
+}
@@ -0,0 +1,147 @@
+// Package trace records the sampled requests through the proxy as spans and exports them by OTLP/HTTP.
+package trace
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultServiceName   = "overlord-proxy"
+	defaultQueueSize     = 4096
+	defaultBatchSize     = 256
+	defaultFlushInterval = time.Second
+	defaultTimeout       = 3 * time.Second
+)
+
+// span kinds of OTLP.
+const (
+	KindServer = 2
+	KindClient = 3
+)
+
+// TraceID is the id of trace.
+type TraceID [16]byte
+
+// SpanID is the id of span.
+type SpanID [8]byte
+
+// IsZero returns whether the span id is not set.
+func (id SpanID) IsZero() bool {
+	return id == SpanID{}
+}
+
+// Attr is the attribute of span, value must be string, int, int64 or bool.
+type Attr struct {
+	Key   string
+	Value interface{}
+}
+
+// String returns string attribute.
+func String(key, value string) Attr {
+	return Attr{Key: key, Value: value}
+}
+
+// Int returns int attribute.
+func Int(key string, value int64) Attr {
+	return Attr{Key: key, Value: value}
+}
+
+// Span is the timed operation of trace.
+type Span struct {
+	TraceID  TraceID
+	SpanID   SpanID
+	ParentID SpanID
+	Name     string
+	Kind     int
+	Start    time.Time
+	End      time.Time
+	Attrs    []Attr
+	// Err is the status message of span if not empty.
+	Err string
+}
+
+// Config is the config of tracer.
+type Config struct {
+	// Endpoint is the url of OTLP/HTTP traces receiver, eg: http://127.0.0.1:4318/v1/traces.
+	Endpoint    string
+	ServiceName string
+	Sampler     string
+	Ratio       float64
+	// SlowerThan samples the traces slower than it whatever the sampler is, zero means disabled.
+	SlowerThan time.Duration
+
+	QueueSize     int
+	BatchSize     int
+	FlushInterval time.Duration
+	Timeout       time.Duration
+}
+
+// Tracer samples traces and exports spans.
+type Tracer struct {
+	sampler Sampler
+	exp     *exporter
+
+	lock sync.Mutex
+	rand *rand.Rand
+}
+
+// New new a tracer and start exporting.
+func New(c *Config) (*Tracer, error) {
+	if c.Endpoint == "" {
+		return nil, errors.New("trace endpoint is required")
+	}
+	sampler, err := NewSampler(c.Sampler, c.Ratio, c.SlowerThan)
+	if err != nil {
+		return nil, err
+	}
+	var seed [8]byte
+	if _, err = crand.Read(seed[:]); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	t := &Tracer{
+		sampler: sampler,
+		exp:     newExporter(c),
+		rand:    rand.New(rand.NewSource(int64(binary.LittleEndian.Uint64(seed[:])))),
+	}
+	go t.exp.run()
+	return t, nil
+}
+
+// Sample generates a trace id and returns whether the trace of duration is sampled.
+func (t *Tracer) Sample(dur time.Duration) (id TraceID, ok bool) {
+	t.lock.Lock()
+	t.rand.Read(id[:])
+	t.lock.Unlock()
+	return id, t.sampler.Sample(id, dur)
+}
+
+// NewSpanID generates a span id.
+func (t *Tracer) NewSpanID() (id SpanID) {
+	t.lock.Lock()
+	t.rand.Read(id[:])
+	t.lock.Unlock()
+	return
+}
+
+// Export exports the spans, spans are dropped if the queue is full.
+func (t *Tracer) Export(spans ...*Span) {
+	for _, s := range spans {
+		t.exp.export(s)
+	}
+}
+
+// Dropped returns the count of spans dropped.
+func (t *Tracer) Dropped() uint64 {
+	return t.exp.droppedCount()
+}
+
+// Close flushes the queued spans and stops exporting.
+func (t *Tracer) Close() {
+	t.exp.close()
+}
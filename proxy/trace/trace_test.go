@@ -0,0 +1,115 @@
+package trace
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSampler(t *testing.T) {
+	_, err := NewSampler("unknown", 0, 0)
+	assert.Error(t, err)
+	_, err = NewSampler(SamplerRatio, 1.5, 0)
+	assert.Error(t, err)
+
+	on, err := NewSampler(SamplerAlwaysOn, 0, 0)
+	assert.NoError(t, err)
+	off, err := NewSampler("", 0, 0)
+	assert.NoError(t, err)
+	assert.True(t, on.Sample(TraceID{}, 0))
+	assert.False(t, off.Sample(TraceID{}, 0))
+
+	slow, err := NewSampler(SamplerAlwaysOff, 0, time.Millisecond)
+	assert.NoError(t, err)
+	assert.False(t, slow.Sample(TraceID{}, time.Microsecond))
+	assert.True(t, slow.Sample(TraceID{}, time.Millisecond))
+}
+
+func TestRatioSampler(t *testing.T) {
+	tr, err := New(&Config{Endpoint: "http://127.0.0.1:0", Sampler: SamplerRatio, Ratio: 0.1})
+	assert.NoError(t, err)
+	defer tr.Close()
+	var sampled int
+	for i := 0; i < 100000; i++ {
+		if _, ok := tr.Sample(0); ok {
+			sampled++
+		}
+	}
+	assert.InDelta(t, 10000, sampled, 1000)
+
+	all := newRatioSampler(1)
+	none := newRatioSampler(0)
+	id := TraceID{8: 0xff, 9: 0xff, 10: 0xff, 11: 0xff, 12: 0xff, 13: 0xff, 14: 0xff, 15: 0xff}
+	assert.True(t, all.Sample(id, 0))
+	assert.False(t, none.Sample(TraceID{}, 0))
+}
+
+func TestExportOTLP(t *testing.T) {
+	var (
+		lock sync.Mutex
+		reqs []map[string]interface{}
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		body, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		req := make(map[string]interface{})
+		assert.NoError(t, json.Unmarshal(body, &req))
+		lock.Lock()
+		reqs = append(reqs, req)
+		lock.Unlock()
+	}))
+	defer srv.Close()
+
+	tr, err := New(&Config{Endpoint: srv.URL, Sampler: SamplerAlwaysOn, ServiceName: "test"})
+	assert.NoError(t, err)
+	tid, ok := tr.Sample(0)
+	assert.True(t, ok)
+	now := time.Unix(1, 500)
+	root := &Span{TraceID: tid, SpanID: tr.NewSpanID(), Name: "root", Kind: KindServer, Start: now, End: now.Add(time.Millisecond),
+		Attrs: []Attr{String("overlord.cluster", "test"), Int("overlord.conn_id", 7)}}
+	child := &Span{TraceID: tid, SpanID: tr.NewSpanID(), ParentID: root.SpanID, Name: "child", Kind: KindClient, Start: now, End: now, Err: "timeout"}
+	tr.Export(root, child)
+	tr.Close()
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Len(t, reqs, 1)
+	rs := reqs[0]["resourceSpans"].([]interface{})[0].(map[string]interface{})
+	res := rs["resource"].(map[string]interface{})["attributes"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "service.name", res["key"])
+	assert.Equal(t, "test", res["value"].(map[string]interface{})["stringValue"])
+	spans := rs["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})
+	assert.Len(t, spans, 2)
+	s0, s1 := spans[0].(map[string]interface{}), spans[1].(map[string]interface{})
+	assert.Len(t, s0["traceId"], 32)
+	assert.Len(t, s0["spanId"], 16)
+	assert.Nil(t, s0["parentSpanId"])
+	assert.Equal(t, "1000000500", s0["startTimeUnixNano"])
+	assert.Equal(t, "1001000500", s0["endTimeUnixNano"])
+	assert.Equal(t, float64(KindServer), s0["kind"])
+	attr := s0["attributes"].([]interface{})[1].(map[string]interface{})
+	assert.Equal(t, "7", attr["value"].(map[string]interface{})["intValue"])
+	assert.Equal(t, s0["spanId"], s1["parentSpanId"])
+	assert.Equal(t, s0["traceId"], s1["traceId"])
+	assert.Equal(t, "timeout", s1["status"].(map[string]interface{})["message"])
+	assert.Zero(t, tr.Dropped())
+}
+
+func TestExportDropped(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	tr, err := New(&Config{Endpoint: srv.URL, Sampler: SamplerAlwaysOn})
+	assert.NoError(t, err)
+	tr.Export(&Span{Name: "a"}, &Span{Name: "b"})
+	tr.Close()
+	assert.Equal(t, uint64(2), tr.Dropped())
+}
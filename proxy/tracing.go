@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"time"
+
+	"overlord/proxy/proto"
+	"overlord/proxy/trace"
+)
+
+const (
+	spanBatch = "overlord.batch"
+	spanNode  = "overlord.node"
+)
+
+// nodeSpan is the span of messages written into and read from one backend node.
+type nodeSpan struct {
+	span      *trace.Span
+	reqs      int
+	inputWait time.Duration
+}
+
+// trace records the messages of one client batch as the batch span with a child span per backend node.
+// NOTE: clients can't carry trace context, spans are correlated by the cluster and connection id.
+func (h *Handler) trace(msgs []*proto.Message) {
+	if len(msgs) == 0 {
+		return
+	}
+	start, end := msgs[0].Timeline().Start, msgs[0].Timeline().End
+	for _, msg := range msgs[1:] {
+		tl := msg.Timeline()
+		if tl.Start.Before(start) {
+			start = tl.Start
+		}
+		if tl.End.After(end) {
+			end = tl.End
+		}
+	}
+	tid, ok := h.tracer.Sample(end.Sub(start))
+	if !ok {
+		return
+	}
+	root := &trace.Span{
+		TraceID: tid,
+		SpanID:  h.tracer.NewSpanID(),
+		Name:    spanBatch,
+		Kind:    trace.KindServer,
+		Start:   start,
+		End:     end,
+		Attrs: []trace.Attr{
+			trace.String("overlord.cluster", h.cc.Name),
+			trace.Int("overlord.conn_id", int64(h.id)),
+			trace.String("net.peer.name", h.conn.RemoteAddr().String()),
+			trace.String("db.system", string(h.cc.CacheType)),
+			trace.Int("overlord.msgs", int64(len(msgs))),
+		},
+	}
+	h.spans = append(h.spans[:0], root)
+	nodes := make(map[string]*nodeSpan)
+	for _, msg := range msgs {
+		if err := msg.Err(); err != nil && root.Err == "" {
+			root.Err = err.Error()
+		}
+		if subs := msg.Subs(); subs != nil {
+			for _, sub := range subs {
+				h.traceNode(nodes, root, sub)
+			}
+		} else {
+			h.traceNode(nodes, root, msg)
+		}
+	}
+	for _, ns := range nodes {
+		ns.span.Attrs = append(ns.span.Attrs,
+			trace.Int("overlord.reqs", int64(ns.reqs)),
+			trace.Int("overlord.input_wait_us", int64(ns.inputWait/time.Microsecond)),
+		)
+	}
+	h.tracer.Export(h.spans...)
+	for i := range h.spans {
+		h.spans[i] = nil
+	}
+}
+
+func (h *Handler) traceNode(nodes map[string]*nodeSpan, root *trace.Span, m *proto.Message) {
+	addr := m.Addr()
+	if addr == "" {
+		// NOTE: not forwarded, eg: hit near cache or merged into other sub message.
+		return
+	}
+	tl := m.Timeline()
+	if tl.Read.Before(tl.Write) {
+		// NOTE: failed to read reply
+		tl.Read = tl.Write
+	}
+	ns, ok := nodes[addr]
+	if !ok {
+		ns = &nodeSpan{span: &trace.Span{
+			TraceID:  root.TraceID,
+			SpanID:   h.tracer.NewSpanID(),
+			ParentID: root.SpanID,
+			Name:     spanNode,
+			Kind:     trace.KindClient,
+			Start:    tl.Write,
+			End:      tl.Read,
+			Attrs: []trace.Attr{
+				trace.String("overlord.cluster", h.cc.Name),
+				trace.Int("overlord.conn_id", int64(h.id)),
+				trace.String("net.peer.name", addr),
+			},
+		}}
+		nodes[addr] = ns
+		h.spans = append(h.spans, ns.span)
+	}
+	if tl.Write.Before(ns.span.Start) {
+		ns.span.Start = tl.Write
+	}
+	if tl.Read.After(ns.span.End) {
+		ns.span.End = tl.Read
+	}
+	if d := tl.EndInput.Sub(tl.StartInput); d > ns.inputWait {
+		ns.inputWait = d
+	}
+	ns.reqs++
+	if err := m.Err(); err != nil && ns.span.Err == "" {
+		ns.span.Err = err.Error()
+	}
+}
@@ -0,0 +1,266 @@
+// Package twemproxy converts twemproxy(nutcracker) pools into overlord cluster configs.
+package twemproxy
+
+import (
+	errs "errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"overlord/pkg/hashkit"
+	"overlord/pkg/types"
+	"overlord/proxy"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// twemproxy uses the host only as ketama name when the port is 11211.
+	defaultKetamaPort = "11211"
+	// twemproxy defaults
+	defaultHash               = hashkit.HashMethodFnv1a64
+	defaultDistribution       = hashkit.HashDistributionKetama
+	defaultServerConnections  = 1
+	defaultServerFailureLimit = 2
+)
+
+// errors
+var (
+	ErrPoolInvalid    = errs.New("twemproxy pool is invalid")
+	ErrPoolNotSupport = errs.New("twemproxy pool is not supported")
+)
+
+var hashMethods = map[string]string{
+	"one_at_a_time": hashkit.HashMethodOneOnTime,
+	"md5":           hashkit.HashMethodMD5,
+	"crc16":         hashkit.HashMethodCRC16,
+	"crc32":         hashkit.HashMethodCRC32,
+	"crc32a":        hashkit.HashMethodCRC32a,
+	"fnv1_64":       hashkit.HashMethodFnv164,
+	"fnv1a_64":      hashkit.HashMethodFnv1a64,
+	"fnv1_32":       hashkit.HashMethodFnv132,
+	"fnv1a_32":      hashkit.HashMethodFnv1a32,
+	"hsieh":         hashkit.HashMethodHsieh,
+	"murmur":        hashkit.HashMethodMurmur,
+}
+
+// Pool is the server pool of twemproxy yaml config.
+type Pool struct {
+	Listen             string   `yaml:"listen"`
+	Hash               string   `yaml:"hash"`
+	HashTag            string   `yaml:"hash_tag"`
+	Distribution       string   `yaml:"distribution"`
+	Timeout            int      `yaml:"timeout"`
+	Backlog            int      `yaml:"backlog"`
+	Preconnect         bool     `yaml:"preconnect"`
+	Redis              bool     `yaml:"redis"`
+	RedisAuth          string   `yaml:"redis_auth"`
+	RedisDB            int      `yaml:"redis_db"`
+	ServerConnections  int      `yaml:"server_connections"`
+	AutoEjectHosts     bool     `yaml:"auto_eject_hosts"`
+	ServerRetryTimeout int      `yaml:"server_retry_timeout"`
+	ServerFailureLimit int      `yaml:"server_failure_limit"`
+	ClientConnections  int      `yaml:"client_connections"`
+	TCPKeepalive       bool     `yaml:"tcpkeepalive"`
+	Servers            []string `yaml:"servers"`
+}
+
+// Server is the parsed server of twemproxy pool.
+type Server struct {
+	Addr   string
+	Weight int
+	// Name is the name used by twemproxy ketama.
+	Name string
+}
+
+// Pools is the pools of twemproxy yaml config by name.
+type Pools map[string]*Pool
+
+// LoadPools load twemproxy pools from yaml file.
+func LoadPools(path string) (Pools, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Load From File:%s", path)
+	}
+	return ParsePools(data)
+}
+
+// ParsePools parse twemproxy pools from yaml content.
+func ParsePools(data []byte) (Pools, error) {
+	pools := Pools{}
+	if err := yaml.UnmarshalStrict(data, &pools); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return pools, nil
+}
+
+// Names return the sorted pool names.
+func (ps Pools) Names() []string {
+	names := make([]string, 0, len(ps))
+	for name := range ps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Convert convert all pools into cluster configs sorted by name,
+// warns are the twemproxy options which overlord ignores.
+func (ps Pools) Convert() (ccs []*proxy.ClusterConfig, warns []string, err error) {
+	for _, name := range ps.Names() {
+		cc, ws, cerr := ps[name].Convert(name)
+		if cerr != nil {
+			err = cerr
+			return
+		}
+		ccs = append(ccs, cc)
+		warns = append(warns, ws...)
+	}
+	return
+}
+
+// ParseServers parse the servers of pool as "host:port:weight [name]".
+func (p *Pool) ParseServers() (svrs []*Server, err error) {
+	for _, s := range p.Servers {
+		fields := strings.Fields(s)
+		if len(fields) == 0 || len(fields) > 2 {
+			err = errors.Wrapf(ErrPoolInvalid, "server:%s", s)
+			return
+		}
+		idx := strings.LastIndexByte(fields[0], ':')
+		if idx == -1 {
+			err = errors.Wrapf(ErrPoolInvalid, "server:%s", s)
+			return
+		}
+		weight, werr := strconv.Atoi(fields[0][idx+1:])
+		if werr != nil || weight <= 0 {
+			err = errors.Wrapf(ErrPoolInvalid, "server:%s weight must be positive", s)
+			return
+		}
+		host, port, serr := net.SplitHostPort(fields[0][:idx])
+		if serr != nil {
+			err = errors.Wrapf(ErrPoolInvalid, "server:%s", s)
+			return
+		}
+		svr := &Server{Addr: net.JoinHostPort(host, port), Weight: weight}
+		if len(fields) == 2 {
+			svr.Name = fields[1]
+		} else if port == defaultKetamaPort {
+			svr.Name = host
+		} else {
+			svr.Name = svr.Addr
+		}
+		svrs = append(svrs, svr)
+	}
+	return
+}
+
+// Convert convert pool into cluster config.
+func (p *Pool) Convert(name string) (cc *proxy.ClusterConfig, warns []string, err error) {
+	warnf := func(format string, args ...interface{}) {
+		warns = append(warns, fmt.Sprintf("pool(%s) ", name)+fmt.Sprintf(format, args...))
+	}
+	cc = &proxy.ClusterConfig{
+		Name:             name,
+		HashTag:          p.HashTag,
+		HashCompat:       hashkit.HashCompatTwemproxy,
+		RedisAuth:        p.RedisAuth,
+		DialTimeout:      p.Timeout,
+		ReadTimeout:      p.Timeout,
+		WriteTimeout:     p.Timeout,
+		NodeConnections:  defaultServerConnections,
+		PingFailLimit:    defaultServerFailureLimit,
+		PingAutoEject:    p.AutoEjectHosts,
+		CacheType:        types.CacheTypeMemcache,
+		HashMethod:       defaultHash,
+		HashDistribution: defaultDistribution,
+		ListenProto:      "tcp",
+		ListenAddr:       p.Listen,
+	}
+	if p.Redis {
+		cc.CacheType = types.CacheTypeRedis
+	}
+	if p.RedisDB != 0 {
+		err = errors.Wrapf(ErrPoolNotSupport, "pool(%s) redis_db:%d only db 0 is supported", name, p.RedisDB)
+		return
+	}
+	if p.Hash != "" {
+		method, ok := hashMethods[p.Hash]
+		if !ok {
+			err = errors.Wrapf(ErrPoolNotSupport, "pool(%s) hash:%s", name, p.Hash)
+			return
+		}
+		cc.HashMethod = method
+	}
+	switch p.Distribution {
+	case "":
+	case hashkit.HashDistributionKetama, hashkit.HashDistributionModula, hashkit.HashDistributionRandom:
+		cc.HashDistribution = p.Distribution
+	default:
+		err = errors.Wrapf(ErrPoolNotSupport, "pool(%s) distribution:%s", name, p.Distribution)
+		return
+	}
+	if p.HashTag != "" && len(p.HashTag) != 2 {
+		err = errors.Wrapf(ErrPoolInvalid, "pool(%s) hash_tag:%s must be two characters", name, p.HashTag)
+		return
+	}
+	if p.HashTag == "" {
+		warnf("hash_tag is empty but overlord uses \"{}\" as default")
+	}
+	if fields := strings.Fields(p.Listen); len(fields) > 0 && strings.HasPrefix(fields[0], "/") {
+		cc.ListenProto = "unix"
+		cc.ListenAddr = fields[0]
+		if len(fields) > 1 {
+			warnf("listen permission %s is ignored", fields[1])
+		}
+	}
+	if p.ServerConnections > 0 {
+		cc.NodeConnections = int32(p.ServerConnections)
+	}
+	if p.ServerFailureLimit > 0 {
+		cc.PingFailLimit = p.ServerFailureLimit
+	}
+	if p.ServerRetryTimeout > 0 {
+		warnf("server_retry_timeout is ignored, ejected server is re-added once ping succeeds")
+	}
+	if p.Backlog > 0 || p.Preconnect || p.ClientConnections > 0 || p.TCPKeepalive {
+		warnf("backlog, preconnect, client_connections and tcpkeepalive are ignored")
+	}
+	svrs, err := p.ParseServers()
+	if err != nil {
+		err = errors.Wrapf(err, "pool(%s)", name)
+		return
+	}
+	alias := false
+	for _, svr := range svrs {
+		if svr.Name != svr.Addr {
+			alias = true
+		}
+		if len(svr.Name) > 80 {
+			warnf("server name %s is too long to keep ketama compatible", svr.Name)
+		}
+	}
+	for _, svr := range svrs {
+		// NOTE: overlord hashes the alias, so that the alias must be the name used by twemproxy ketama.
+		server := fmt.Sprintf("%s:%d", svr.Addr, svr.Weight)
+		if alias {
+			server += " " + svr.Name
+		}
+		cc.Servers = append(cc.Servers, server)
+	}
+	if err = cc.Validate(); err != nil {
+		err = errors.Wrapf(err, "pool(%s)", name)
+	}
+	return
+}
+
+// EncodeClusterConfigs encode cluster configs as toml which can be loaded by overlord proxy.
+func EncodeClusterConfigs(w io.Writer, ccs []*proxy.ClusterConfig) error {
+	return errors.WithStack(toml.NewEncoder(w).Encode(&proxy.ClusterConfigs{Clusters: ccs}))
+}
@@ -0,0 +1,130 @@
+package twemproxy
+
+import (
+	"bytes"
+	"testing"
+
+	"overlord/pkg/types"
+	"overlord/proxy"
+
+	"github.com/BurntSushi/toml"
+	"github.com/stretchr/testify/assert"
+)
+
+const nutcrackerYml = `
+alpha:
+  listen: 127.0.0.1:22121
+  hash: fnv1a_64
+  distribution: ketama
+  auto_eject_hosts: true
+  redis: true
+  server_retry_timeout: 2000
+  server_failure_limit: 1
+  servers:
+   - 127.0.0.1:6379:1
+
+beta:
+  listen: 127.0.0.1:22122
+  hash: fnv1a_64
+  hash_tag: "{}"
+  distribution: ketama
+  auto_eject_hosts: false
+  timeout: 400
+  redis: true
+  servers:
+   - 127.0.0.1:6380:1 server1
+   - 127.0.0.1:6381:1 server2
+   - 127.0.0.1:6382:1 server3
+   - 127.0.0.1:6383:1 server4
+
+delta:
+  listen: 127.0.0.1:22124
+  hash: one_at_a_time
+  distribution: modula
+  timeout: 100
+  server_connections: 4
+  servers:
+   - 127.0.0.1:11214:1
+   - 127.0.0.1:11215:1
+
+gamma:
+  listen: /tmp/gamma 0666
+  hash: fnv1a_64
+  distribution: ketama
+  timeout: 400
+  backlog: 1024
+  preconnect: true
+  servers:
+   - 127.0.0.1:11211:1
+   - 127.0.0.1:11212:2
+`
+
+func TestConvertPools(t *testing.T) {
+	pools, err := ParsePools([]byte(nutcrackerYml))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"alpha", "beta", "delta", "gamma"}, pools.Names())
+
+	ccs, warns, err := pools.Convert()
+	assert.NoError(t, err)
+	assert.Len(t, ccs, 4)
+	assert.NotEmpty(t, warns)
+
+	alpha := ccs[0]
+	assert.Equal(t, types.CacheTypeRedis, alpha.CacheType)
+	assert.Equal(t, "127.0.0.1:22121", alpha.ListenAddr)
+	assert.True(t, alpha.PingAutoEject)
+	assert.Equal(t, 1, alpha.PingFailLimit)
+	assert.Equal(t, []string{"127.0.0.1:6379:1"}, alpha.Servers)
+
+	beta := ccs[1]
+	assert.Equal(t, "{}", beta.HashTag)
+	assert.Equal(t, 400, beta.ReadTimeout)
+	assert.Equal(t, 400, beta.WriteTimeout)
+	assert.Equal(t, 400, beta.DialTimeout)
+	assert.Equal(t, "127.0.0.1:6380:1 server1", beta.Servers[0])
+
+	delta := ccs[2]
+	assert.Equal(t, types.CacheTypeMemcache, delta.CacheType)
+	assert.Equal(t, "one_on_time", delta.HashMethod)
+	assert.Equal(t, "modula", delta.HashDistribution)
+	assert.Equal(t, int32(4), delta.NodeConnections)
+
+	gamma := ccs[3]
+	assert.Equal(t, "unix", gamma.ListenProto)
+	assert.Equal(t, "/tmp/gamma", gamma.ListenAddr)
+	// NOTE: twemproxy hashes the host only when port is 11211
+	assert.Equal(t, []string{"127.0.0.1:11211:1 127.0.0.1", "127.0.0.1:11212:2 127.0.0.1:11212"}, gamma.Servers)
+}
+
+func TestEncodeClusterConfigs(t *testing.T) {
+	pools, err := ParsePools([]byte(nutcrackerYml))
+	assert.NoError(t, err)
+	ccs, _, err := pools.Convert()
+	assert.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	assert.NoError(t, EncodeClusterConfigs(buf, ccs))
+	loaded := &proxy.ClusterConfigs{}
+	_, err = toml.Decode(buf.String(), loaded)
+	assert.NoError(t, err)
+	assert.Equal(t, ccs, loaded.Clusters)
+}
+
+func TestConvertInvalid(t *testing.T) {
+	for _, yml := range []string{
+		"a:\n  hash: jenkins\n  servers:\n   - 127.0.0.1:6379:1\n",
+		"a:\n  distribution: jump\n  servers:\n   - 127.0.0.1:6379:1\n",
+		"a:\n  redis: true\n  redis_db: 1\n  servers:\n   - 127.0.0.1:6379:1\n",
+		"a:\n  hash_tag: \"{\"\n  servers:\n   - 127.0.0.1:6379:1\n",
+		"a:\n  servers:\n   - 127.0.0.1:6379:0\n",
+		"a:\n  servers:\n   - 127.0.0.1:6379\n",
+		"a:\n  servers: []\n",
+	} {
+		pools, err := ParsePools([]byte(yml))
+		assert.NoError(t, err)
+		_, _, err = pools.Convert()
+		assert.Error(t, err, yml)
+	}
+	_, err := ParsePools([]byte("a:\n  unknown_option: 1\n"))
+	assert.Error(t, err)
+}
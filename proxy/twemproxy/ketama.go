@@ -0,0 +1,147 @@
+package twemproxy
+
+import (
+	"crypto/md5"
+	"fmt"
+	"math"
+	"sort"
+
+	"overlord/pkg/hashkit"
+	"overlord/proxy"
+
+	"github.com/pkg/errors"
+)
+
+// port of nc_ketama.c
+const (
+	ketamaPointsPerServer = 160
+	ketamaPointsPerHash   = 4
+	ketamaMaxHostLen      = 86
+)
+
+// Mismatch is the key located into different servers by overlord and twemproxy.
+type Mismatch struct {
+	Key       string
+	Overlord  string
+	Twemproxy string
+}
+
+func (m *Mismatch) String() string {
+	return fmt.Sprintf("key:%q overlord:%s twemproxy:%s", m.Key, m.Overlord, m.Twemproxy)
+}
+
+type point struct {
+	index int
+	value uint32
+}
+
+// continuum is the port of twemproxy ketama and modula dispatch,
+// which is used to verify that hashkit ring locates keys just as twemproxy.
+type continuum struct {
+	svrs   []*Server
+	tag    string
+	hash   func([]byte) uint
+	modula bool
+	points []point
+}
+
+func newContinuum(p *Pool) (*continuum, error) {
+	svrs, err := p.ParseServers()
+	if err != nil {
+		return nil, err
+	}
+	method := defaultHash
+	if p.Hash != "" {
+		method = hashMethods[p.Hash]
+	}
+	c := &continuum{svrs: svrs, tag: p.HashTag, hash: hashkit.NewHash(method)}
+	switch p.Distribution {
+	case "", hashkit.HashDistributionKetama:
+		c.ketama()
+	case hashkit.HashDistributionModula:
+		c.modula = true
+		for idx, svr := range svrs {
+			for i := 0; i < svr.Weight; i++ {
+				c.points = append(c.points, point{index: idx})
+			}
+		}
+	default:
+		return nil, errors.Wrapf(ErrPoolNotSupport, "distribution:%s is not verifiable", p.Distribution)
+	}
+	return c, nil
+}
+
+func (c *continuum) ketama() {
+	var total int
+	for _, svr := range c.svrs {
+		total += svr.Weight
+	}
+	for idx, svr := range c.svrs {
+		// NOTE: twemproxy computes in float and adds a double epsilon.
+		pct := float32(svr.Weight) / float32(total)
+		pps := float32(float32(float32(pct*ketamaPointsPerServer)/4) * float32(len(c.svrs)))
+		pointerPerServer := uint32(float32(math.Floor(float64(float32(float64(pps)+0.0000000001)))) * 4)
+		for pidx := uint32(1); pidx <= pointerPerServer/ketamaPointsPerHash; pidx++ {
+			// NOTE: snprintf into host[KETAMA_MAX_HOSTLEN], twemproxy hashes the bytes past host
+			// for the longer ones, which is undefined and truncated here.
+			host := fmt.Sprintf("%s-%d", svr.Name, pidx-1)
+			if len(host) > ketamaMaxHostLen-1 {
+				host = host[:ketamaMaxHostLen-1]
+			}
+			digest := md5.Sum([]byte(host))
+			for x := 0; x < ketamaPointsPerHash; x++ {
+				value := uint32(digest[3+x*4])<<24 | uint32(digest[2+x*4])<<16 | uint32(digest[1+x*4])<<8 | uint32(digest[x*4])
+				c.points = append(c.points, point{index: idx, value: value})
+			}
+		}
+	}
+	sort.Slice(c.points, func(i, j int) bool { return c.points[i].value < c.points[j].value })
+}
+
+func (c *continuum) dispatch(key []byte) (*Server, bool) {
+	if len(c.points) == 0 {
+		return nil, false
+	}
+	hash := uint32(c.hash(hashTagKey(c.tag, key)))
+	if c.modula {
+		return c.svrs[c.points[hash%uint32(len(c.points))].index], true
+	}
+	i := sort.Search(len(c.points), func(i int) bool { return c.points[i].value >= hash })
+	if i == len(c.points) {
+		i = 0
+	}
+	return c.svrs[c.points[i].index], true
+}
+
+// hashTagKey return the part of key used for hashing as twemproxy.
+func hashTagKey(tag string, key []byte) []byte {
+	return hashkit.HashTag([]byte(tag), key)
+}
+
+// Verify locates keys by both the hashkit ring of overlord cluster config and the
+// port of twemproxy dispatch, returns the keys located into different servers.
+func Verify(p *Pool, cc *proxy.ClusterConfig, keys [][]byte) ([]*Mismatch, error) {
+	c, err := newContinuum(p)
+	if err != nil {
+		return nil, err
+	}
+	// NOTE: locate keys by the cluster config just as overlord loaded it.
+	occ := *cc
+	occ.SetDefault()
+	l, err := proxy.NewLocator(&occ, nil)
+	if err != nil {
+		return nil, err
+	}
+	var mismatches []*Mismatch
+	for _, key := range keys {
+		var taddr string
+		oaddr, _ := l.Locate(key)
+		if svr, ok := c.dispatch(key); ok {
+			taddr = svr.Addr
+		}
+		if oaddr != taddr {
+			mismatches = append(mismatches, &Mismatch{Key: string(key), Overlord: oaddr, Twemproxy: taddr})
+		}
+	}
+	return mismatches, nil
+}
@@ -0,0 +1,147 @@
+package twemproxy
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"overlord/proxy"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func _keys(n int) [][]byte {
+	keys := make([][]byte, n)
+	for i := range keys {
+		keys[i] = []byte("user:" + strconv.Itoa(i))
+		if i%10 == 0 {
+			keys[i] = append(keys[i], ":{profile"+strconv.Itoa(i)+"}"...)
+		}
+	}
+	return keys
+}
+
+func _servers(n int, weight func(int) int, named bool) []string {
+	svrs := make([]string, n)
+	for i := range svrs {
+		svrs[i] = fmt.Sprintf("10.0.%d.%d:%d:%d", i/200, i%200, 7000+i, weight(i))
+		if named {
+			svrs[i] += fmt.Sprintf(" cache-server-%d", i)
+		}
+	}
+	return svrs
+}
+
+func TestVerifyKetama(t *testing.T) {
+	even := func(int) int { return 1 }
+	uneven := func(i int) int { return i%7 + 1 }
+	for _, p := range []*Pool{
+		{HashTag: "{}", Servers: _servers(4, even, false)},
+		{HashTag: "{}", Servers: _servers(4, uneven, true)},
+		// NOTE: float points differ from double ones with 25 even servers
+		{HashTag: "{}", Servers: _servers(25, even, false)},
+		{HashTag: "{}", Servers: _servers(47, even, true)},
+		{HashTag: "{}", Servers: _servers(9, uneven, false)},
+		{HashTag: "{}", Hash: "murmur", Servers: _servers(16, uneven, true)},
+		{HashTag: "{}", Hash: "md5", Servers: _servers(16, even, true)},
+		{HashTag: "{}", Hash: "crc32a", Servers: _servers(16, even, true)},
+		{HashTag: "{}", Hash: "one_at_a_time", Servers: _servers(16, even, false)},
+		{HashTag: "{}", Hash: "hsieh", Servers: _servers(16, even, false)},
+		{HashTag: "{}", Distribution: "modula", Servers: _servers(6, uneven, false)},
+		{HashTag: "{}", Servers: []string{"127.0.0.1:11211:1", "127.0.0.2:11211:1", "127.0.0.3:11212:2"}},
+		{HashTag: "{}", Servers: []string{
+			"127.0.0.1:6379:1 " + strings.Repeat("a", 78),
+			"127.0.0.1:6380:1 " + strings.Repeat("b", 78),
+			"127.0.0.1:6381:1 " + strings.Repeat("c", 60),
+		}},
+	} {
+		cc, _, err := p.Convert("test")
+		assert.NoError(t, err)
+		mismatches, err := Verify(p, cc, _keys(20000))
+		assert.NoError(t, err)
+		assert.Empty(t, mismatches, "servers:%v", p.Servers)
+	}
+}
+
+type golden struct {
+	pool *Pool
+	keys [][2]string
+}
+
+// loadGolden loads testdata/ketama_golden.txt generated by testdata/nc_ketama.c,
+// which dispatches keys by the code of twemproxy instead of the port in ketama.go.
+func loadGolden(t *testing.T) (gs []*golden) {
+	f, err := os.Open("testdata/ketama_golden.txt")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		switch fields[0] {
+		case "pool":
+			gs = append(gs, &golden{pool: &Pool{Distribution: fields[1], Hash: fields[2], HashTag: fields[3]}})
+		case "server":
+			g := gs[len(gs)-1]
+			g.pool.Servers = append(g.pool.Servers, strings.TrimPrefix(s.Text(), "server "))
+		case "key":
+			g := gs[len(gs)-1]
+			g.keys = append(g.keys, [2]string{fields[1], fields[2]})
+		}
+	}
+	assert.NoError(t, s.Err())
+	return
+}
+
+func TestKetamaGolden(t *testing.T) {
+	gs := loadGolden(t)
+	assert.NotEmpty(t, gs)
+	for _, g := range gs {
+		cc, _, err := g.pool.Convert("test")
+		assert.NoError(t, err)
+		cc.SetDefault()
+		l, err := proxy.NewLocator(cc, nil)
+		assert.NoError(t, err)
+		c, err := newContinuum(g.pool)
+		assert.NoError(t, err)
+		for _, k := range g.keys {
+			addr, _ := l.Locate([]byte(k[0]))
+			assert.Equal(t, k[1], addr, "overlord key:%s servers:%v", k[0], g.pool.Servers)
+			svr, _ := c.dispatch([]byte(k[0]))
+			assert.Equal(t, k[1], svr.Addr, "port key:%s servers:%v", k[0], g.pool.Servers)
+		}
+	}
+}
+
+func TestVerifyDefaultHashTag(t *testing.T) {
+	p := &Pool{Servers: _servers(8, func(int) int { return 1 }, false)}
+	cc, warns, err := p.Convert("test")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, warns)
+	// NOTE: overlord uses "{}" by default, the keys with tag may move
+	mismatches, err := Verify(p, cc, _keys(1000))
+	assert.NoError(t, err)
+	assert.NotEmpty(t, mismatches)
+	for _, m := range mismatches {
+		assert.Contains(t, m.Key, "{")
+	}
+}
+
+func TestVerifyRandom(t *testing.T) {
+	p := &Pool{Distribution: "random", Servers: _servers(2, func(int) int { return 1 }, false)}
+	cc, _, err := p.Convert("test")
+	assert.NoError(t, err)
+	_, err = Verify(p, cc, _keys(1))
+	assert.Error(t, err)
+}
+
+func TestHashTagKey(t *testing.T) {
+	assert.Equal(t, "abc", string(hashTagKey("{}", []byte("a{abc}b"))))
+	assert.Equal(t, "a{}b", string(hashTagKey("{}", []byte("a{}b"))))
+	assert.Equal(t, "a{b", string(hashTagKey("{}", []byte("a{b"))))
+	assert.Equal(t, "a{b}", string(hashTagKey("", []byte("a{b}"))))
+}
@@ -0,0 +1,347 @@
+/*
+ * nc_ketama.c generates the golden key to server vectors of ketama_golden.txt.
+ *
+ * The continuum, dispatch, hash tag and hash functions are copied from
+ * twemproxy v0.5.0 (src/hashkit/nc_ketama.c, nc_modula.c, nc_fnv.c,
+ * nc_murmur.c, nc_md5.c and src/nc_server.c) with only the nutcracker
+ * structs replaced by plain arrays, md5 is the one of openssl.
+ *
+ *   gcc -O2 -Wno-deprecated-declarations -o nc_ketama nc_ketama.c -lm -lcrypto
+ *   ./nc_ketama < ketama_cases.txt > ketama_golden.txt
+ *
+ * Input lines:
+ *   pool <ketama|modula> <fnv1a_64|md5|murmur> <hash_tag|->
+ *   server <host:port:weight> [name]
+ *   keys <n>
+ */
+#include <math.h>
+#include <stdint.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+#include <openssl/md5.h>
+
+#define KETAMA_CONTINUUM_ADDITION 10
+#define KETAMA_POINTS_PER_SERVER  160
+#define KETAMA_MAX_HOSTLEN        86
+#define CONF_DEFAULT_KETAMA_PORT  11211
+
+#define MAX_SERVERS 256
+#define MAX_LINE    1024
+
+struct continuum {
+    uint32_t index;
+    uint32_t value;
+};
+
+struct server {
+    char     pname[MAX_LINE];
+    char     addr[MAX_LINE];
+    char     name[MAX_LINE];
+    uint32_t weight;
+};
+
+static void
+md5_signature(const unsigned char *key, unsigned long length, unsigned char *result)
+{
+    MD5(key, length, result);
+}
+
+static uint32_t
+hash_md5(const char *key, size_t key_length)
+{
+    unsigned char results[16];
+
+    md5_signature((unsigned char*)key, (unsigned long)key_length, results);
+
+    return ((uint32_t) (results[3] & 0xFF) << 24) |
+           ((uint32_t) (results[2] & 0xFF) << 16) |
+           ((uint32_t) (results[1] & 0xFF) << 8) |
+           (results[0] & 0xFF);
+}
+
+static uint64_t FNV_64_INIT = UINT64_C(0xcbf29ce484222325);
+static uint64_t FNV_64_PRIME = UINT64_C(0x100000001b3);
+
+static uint32_t
+hash_fnv1a_64(const char *key, size_t key_length)
+{
+    uint32_t hash = (uint32_t) FNV_64_INIT;
+    size_t x;
+
+    for (x = 0; x < key_length; x++) {
+        uint32_t val = (uint32_t)key[x];
+        hash ^= val;
+        hash *= (uint32_t) FNV_64_PRIME;
+    }
+
+    return hash;
+}
+
+static uint32_t
+hash_murmur(const char *key, size_t length)
+{
+    const unsigned int m = 0x5bd1e995;
+    const uint32_t seed = (0xdeadbeef * (uint32_t)length);
+    const int r = 24;
+
+    uint32_t h = seed ^ (uint32_t)length;
+
+    const unsigned char * data = (const unsigned char *)key;
+
+    while (length >= 4) {
+        unsigned int k = *(unsigned int *)data;
+
+        k *= m;
+        k ^= k >> r;
+        k *= m;
+
+        h *= m;
+        h ^= k;
+
+        data += 4;
+        length -= 4;
+    }
+
+    switch(length) {
+    case 3:
+        h ^= ((uint32_t)data[2]) << 16;
+        /* fall through */
+    case 2:
+        h ^= ((uint32_t)data[1]) << 8;
+        /* fall through */
+    case 1:
+        h ^= data[0];
+        h *= m;
+        /* fall through */
+    default:
+        break;
+    };
+
+    h ^= h >> 13;
+    h *= m;
+    h ^= h >> 15;
+
+    return h;
+}
+
+static uint32_t
+ketama_hash(const char *key, size_t key_length, uint32_t alignment)
+{
+    unsigned char results[16];
+
+    md5_signature((unsigned char*)key, key_length, results);
+
+    return ((uint32_t) (results[3 + alignment * 4] & 0xFF) << 24)
+        | ((uint32_t) (results[2 + alignment * 4] & 0xFF) << 16)
+        | ((uint32_t) (results[1 + alignment * 4] & 0xFF) << 8)
+        | (results[0 + alignment * 4] & 0xFF);
+}
+
+static int
+ketama_item_cmp(const void *t1, const void *t2)
+{
+    const struct continuum *ct1 = t1, *ct2 = t2;
+
+    if (ct1->value == ct2->value) {
+        return 0;
+    } else if (ct1->value > ct2->value) {
+        return 1;
+    } else {
+        return -1;
+    }
+}
+
+static uint32_t
+ketama_update(struct server *servers, uint32_t nserver, struct continuum *continuum)
+{
+    uint32_t total_weight = 0, server_index, continuum_index = 0;
+    uint32_t pointer_per_server, pointer_per_hash, pointer_index, value;
+    uint32_t nlive_server = nserver;
+
+    for (server_index = 0; server_index < nserver; server_index++) {
+        total_weight += servers[server_index].weight;
+    }
+
+    for (server_index = 0; server_index < nserver; server_index++) {
+        struct server *server = &servers[server_index];
+        float pct;
+
+        pct = (float)server->weight / (float)total_weight;
+        pointer_per_server = (uint32_t) ((floorf((float) (pct * KETAMA_POINTS_PER_SERVER / 4 * (float)nlive_server + 0.0000000001))) * 4);
+        pointer_per_hash = 4;
+
+        for (pointer_index = 1;
+             pointer_index <= pointer_per_server / pointer_per_hash;
+             pointer_index++) {
+
+            char host[KETAMA_MAX_HOSTLEN]= "";
+            size_t hostlen;
+            uint32_t x;
+
+            hostlen = snprintf(host, KETAMA_MAX_HOSTLEN, "%.*s-%u",
+                               (int)strlen(server->name), server->name,
+                               pointer_index - 1);
+            /* NOTE: twemproxy hashes hostlen bytes past host[] here, which is undefined. */
+            if (hostlen >= KETAMA_MAX_HOSTLEN) {
+                fprintf(stderr, "server name %s is too long to be defined\n", server->name);
+                exit(1);
+            }
+
+            for (x = 0; x < pointer_per_hash; x++) {
+                value = ketama_hash(host, hostlen, x);
+                continuum[continuum_index].index = server_index;
+                continuum[continuum_index++].value = value;
+            }
+        }
+    }
+
+    qsort(continuum, continuum_index, sizeof(*continuum), ketama_item_cmp);
+    return continuum_index;
+}
+
+static uint32_t
+ketama_dispatch(struct continuum *continuum, uint32_t ncontinuum, uint32_t hash)
+{
+    struct continuum *begin, *end, *left, *right, *middle;
+
+    begin = left = continuum;
+    end = right = continuum + ncontinuum;
+
+    while (left < right) {
+        middle = left + (right - left) / 2;
+        if (middle->value < hash) {
+          left = middle + 1;
+        } else {
+          right = middle;
+        }
+    }
+
+    if (right == end) {
+        right = begin;
+    }
+
+    return right->index;
+}
+
+static uint32_t
+modula_update(struct server *servers, uint32_t nserver, struct continuum *continuum)
+{
+    uint32_t server_index, continuum_index = 0, weight_index;
+
+    for (server_index = 0; server_index < nserver; server_index++) {
+        for (weight_index = 0; weight_index < servers[server_index].weight; weight_index++) {
+            continuum[continuum_index].index = server_index;
+            continuum[continuum_index++].value = 0;
+        }
+    }
+    return continuum_index;
+}
+
+static uint32_t
+modula_dispatch(struct continuum *continuum, uint32_t ncontinuum, uint32_t hash)
+{
+    struct continuum *c;
+
+    c = continuum + hash % ncontinuum;
+
+    return c->index;
+}
+
+/* server_pool_idx of nc_server.c */
+static uint32_t
+server_pool_hash(const char *tag, const char *key, uint32_t keylen,
+                 uint32_t (*hash)(const char *, size_t))
+{
+    if (tag != NULL) {
+        const char *tag_start, *tag_end;
+
+        tag_start = memchr(key, tag[0], keylen);
+        if (tag_start != NULL) {
+            tag_end = memchr(tag_start + 1, tag[1], key + keylen - (tag_start + 1));
+            if ((tag_end != NULL) && (tag_end - tag_start > 1)) {
+                key = tag_start + 1;
+                keylen = (uint32_t)(tag_end - key);
+            }
+        }
+    }
+    return hash(key, keylen);
+}
+
+/* conf_add_server of nc_conf.c */
+static void
+parse_server(const char *pname, const char *name, struct server *server)
+{
+    char *colon;
+
+    strcpy(server->pname, pname);
+    strcpy(server->addr, pname);
+    colon = strrchr(server->addr, ':');
+    server->weight = (uint32_t)atoi(colon + 1);
+    *colon = '\0';
+    if (name != NULL) {
+        strcpy(server->name, name);
+    } else if (atoi(strrchr(server->addr, ':') + 1) == CONF_DEFAULT_KETAMA_PORT) {
+        strcpy(server->name, server->addr);
+        *strrchr(server->name, ':') = '\0';
+    } else {
+        strcpy(server->name, server->addr);
+    }
+}
+
+static char dist[MAX_LINE], method[MAX_LINE], tag[MAX_LINE];
+static struct server servers[MAX_SERVERS];
+static uint32_t nserver;
+static struct continuum continuum[MAX_SERVERS * KETAMA_POINTS_PER_SERVER * 8];
+
+static void
+dispatch_keys(int n)
+{
+    uint32_t (*hash)(const char *, size_t) = hash_fnv1a_64;
+    uint32_t ncontinuum, i;
+    int modula = strcmp(dist, "modula") == 0;
+    char key[MAX_LINE];
+
+    if (strcmp(method, "md5") == 0) {
+        hash = hash_md5;
+    } else if (strcmp(method, "murmur") == 0) {
+        hash = hash_murmur;
+    }
+    ncontinuum = modula ? modula_update(servers, nserver, continuum) : ketama_update(servers, nserver, continuum);
+    for (i = 0; i < (uint32_t)n; i++) {
+        uint32_t h, idx;
+
+        /* NOTE: the same keys as _keys of ketama_test.go */
+        if (i % 10 == 0) {
+            snprintf(key, sizeof(key), "user:%u:{profile%u}", i, i);
+        } else {
+            snprintf(key, sizeof(key), "user:%u", i);
+        }
+        h = server_pool_hash(strcmp(tag, "-") == 0 ? NULL : tag, key, strlen(key), hash);
+        idx = modula ? modula_dispatch(continuum, ncontinuum, h) : ketama_dispatch(continuum, ncontinuum, h);
+        printf("key %s %s\n", key, servers[idx].addr);
+    }
+}
+
+int
+main(void)
+{
+    char line[MAX_LINE], pname[MAX_LINE], name[MAX_LINE];
+    int n;
+
+    while (fgets(line, sizeof(line), stdin) != NULL) {
+        if (sscanf(line, "pool %s %s %s", dist, method, tag) == 3) {
+            nserver = 0;
+            fputs(line, stdout);
+        } else if (strncmp(line, "server ", 7) == 0) {
+            int fields = sscanf(line, "server %s %s", pname, name);
+
+            parse_server(pname, fields == 2 ? name : NULL, &servers[nserver++]);
+            fputs(line, stdout);
+        } else if (sscanf(line, "keys %d", &n) == 1) {
+            dispatch_keys(n);
+        }
+    }
+    return 0;
+}